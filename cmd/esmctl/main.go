@@ -0,0 +1,202 @@
+// esmctl is a command line tool to manage the builds of an esm.sh server. The
+// commands are parsed by the flag package of the standard library, so the tool
+// doesn't add any dependency to the module.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: esmctl [-server URL] [-token TOKEN] <command> [arguments]
+
+Commands:
+  build <pkg>@<version> [-target es2021] [-bundle] [-dev]   build the package
+  cache inspect <id>                                        show the build record of the id
+  cache evict <pkg>@<version>                               evict all builds of the package
+  queue list                                                list the build tasks in the queue
+  warm-cache [-list FILE] [-cache-folder DIR] <pkg>@<version>...
+                                                            add the packages to the local yarn cache
+                                                            for the server in offline mode
+`
+
+var (
+	server string
+	token  string
+	client = &http.Client{Timeout: 2 * time.Minute}
+)
+
+func main() {
+	flag.StringVar(&server, "server", "http://localhost:80", "esm.sh server URL")
+	flag.StringVar(&token, "token", os.Getenv("ESM_ADMIN_TOKEN"), "the admin token of the server")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	server = strings.TrimRight(server, "/")
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] {
+	case "build":
+		err = build(args[1:])
+	case "cache":
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		switch args[1] {
+		case "inspect":
+			err = request("GET", "/_cache?id="+url.QueryEscape(args[2]))
+		case "evict":
+			err = request("DELETE", "/_cache?pkg="+url.QueryEscape(args[2]))
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+	case "queue":
+		if len(args) < 2 || args[1] != "list" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = request("GET", "/status.json")
+	case "warm-cache":
+		err = warmCache(args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func build(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	target := fs.String("target", "", "build target")
+	bundle := fs.Bool("bundle", false, "bundle mode")
+	dev := fs.Bool("dev", false, "development mode")
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("missing package, usage: esmctl build <pkg>@<version>")
+	}
+	pkg := args[0]
+	fs.Parse(args[1:])
+
+	query := url.Values{}
+	if *target != "" {
+		query.Set("target", *target)
+	}
+	if *bundle {
+		query.Set("bundle", "")
+	}
+	if *dev {
+		query.Set("dev", "")
+	}
+	pathname := "/" + strings.TrimPrefix(pkg, "/")
+	if len(query) > 0 {
+		pathname += "?" + query.Encode()
+	}
+	return request("GET", pathname)
+}
+
+// warmCache installs the packages by yarn in a temporary directory to pre-populate
+// the yarn cache, which is used by the server running with the `--offline` option.
+// Unlike the other commands, it runs on the local host instead of the server.
+func warmCache(args []string) error {
+	fs := flag.NewFlagSet("warm-cache", flag.ExitOnError)
+	list := fs.String("list", "", "file of the packages to add, one per line")
+	cacheFolder := fs.String("cache-folder", os.Getenv("YARN_CACHE_DIR"), "yarn cache folder, default is the YARN_CACHE_DIR environment variable or the yarn default")
+	fs.Parse(args)
+
+	packages := fs.Args()
+	if *list != "" {
+		data, err := ioutil.ReadFile(*list)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				packages = append(packages, line)
+			}
+		}
+	}
+	if len(packages) == 0 {
+		return fmt.Errorf("missing packages, usage: esmctl warm-cache [-list FILE] <pkg>@<version>...")
+	}
+
+	wd, err := ioutil.TempDir("", "esmctl-warm-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(wd)
+
+	yarnArgs := []string{
+		"add",
+		"--non-interactive",
+		"--no-progress",
+		"--no-bin-links",
+		"--ignore-scripts",
+		"--ignore-platform",
+		"--ignore-engines",
+	}
+	if *cacheFolder != "" {
+		yarnArgs = append(yarnArgs, "--cache-folder", *cacheFolder)
+	}
+	for _, pkg := range packages {
+		// install the packages one by one since the versions of the same package may conflict
+		cmd := exec.Command("yarn", append(yarnArgs, pkg)...)
+		cmd.Dir = wd
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("yarn add %s: %s", pkg, strings.TrimSpace(string(output)))
+		}
+		fmt.Println("cached", pkg)
+	}
+	return nil
+}
+
+func request(method string, pathname string) error {
+	req, err := http.NewRequest(method, server+pathname, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var v interface{}
+	if json.Unmarshal(data, &v) == nil {
+		data, _ = json.MarshalIndent(v, "", "  ")
+	}
+	fmt.Println(strings.TrimSpace(string(data)))
+	return nil
+}
@@ -3,6 +3,7 @@ module esm.sh
 go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.0.3
 	github.com/aws/aws-sdk-go v1.40.45
 	github.com/dgraph-io/ristretto v0.1.0
 	github.com/evanw/esbuild v0.13.12
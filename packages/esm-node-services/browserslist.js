@@ -0,0 +1,46 @@
+const browserslist = require('browserslist')
+
+// the browserslist names of the engines supported by esbuild
+const engines = {
+  chrome: 'chrome',
+  and_chr: 'chrome',
+  edge: 'edge',
+  firefox: 'firefox',
+  and_ff: 'firefox',
+  safari: 'safari',
+  ios_saf: 'ios',
+  node: 'node'
+}
+
+function compareVersion(a, b) {
+  const pa = a.split('.').map(Number)
+  const pb = b.split('.').map(Number)
+  for (let i = 0; i < Math.max(pa.length, pb.length); i++) {
+    const d = (pa[i] || 0) - (pb[i] || 0)
+    if (d !== 0) {
+      return d
+    }
+  }
+  return 0
+}
+
+// returns the esbuild targets like ['chrome96', 'safari15.1'] of the browserslist
+// query, only the lowest version of each engine is kept.
+exports.browserslistToTargets = async input => {
+  const { query } = input
+  const versions = {}
+  for (const item of browserslist(query)) {
+    const [name, version] = item.split(' ')
+    const engine = engines[name]
+    if (engine) {
+      // the version may be a range like '15.2-15.3'
+      const v = version.split('-')[0]
+      if (!versions[engine] || compareVersion(v, versions[engine]) < 0) {
+        versions[engine] = v
+      }
+    }
+  }
+  return {
+    targets: Object.keys(versions).sort().map(engine => engine + versions[engine])
+  }
+}
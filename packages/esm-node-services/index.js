@@ -1,5 +1,9 @@
 const { parseCjsExports } = require('./cjs-esm-exports')
+const { browserslistToTargets } = require('./browserslist')
+const { localeModule } = require('./locale')
 
 module.exports = {
-  parseCjsExports
+  parseCjsExports,
+  browserslistToTargets,
+  localeModule
 }
@@ -0,0 +1,33 @@
+const { promisify } = require('util')
+const enhancedResolve = require('enhanced-resolve')
+
+const resolve = promisify(enhancedResolve.create({
+  mainFields: ['browser', 'module', 'main']
+}))
+
+function camelCase(locale) {
+  return locale.replace(/-([a-z0-9])/gi, (_, c) => c.toUpperCase())
+}
+
+// returns the code of the locale data module that only includes the data of the
+// given locale, the `file` is the pattern of the locale file like
+// 'date-fns/locale/{locale}/index.js'. the locale is matched in the order of the
+// exact name, the lowercase name and the language, e.g. 'en-GB', 'en-gb', 'en'.
+exports.localeModule = async input => {
+  const { buildDir, locale, file, export: exportType } = input
+  const [lang] = locale.split('-')
+  const candidates = Array.from(new Set([locale, locale.toLowerCase(), lang]))
+  for (const candidate of candidates) {
+    const specifier = file.replace('{locale}', candidate)
+    try {
+      await resolve(buildDir, specifier)
+    } catch (e) {
+      continue
+    }
+    if (exportType === 'named') {
+      return { code: `export { default as ${camelCase(candidate)} } from ${JSON.stringify(specifier)}`, loader: 'js' }
+    }
+    return { code: `import ${JSON.stringify(specifier)}`, loader: 'js' }
+  }
+  return { error: `locale '${locale}' not found` }
+}
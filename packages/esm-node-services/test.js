@@ -2,7 +2,7 @@ const ns = require('.')
 
 ns.parseCjsExports({ buildDir: __dirname, importPath: '.' }).then(ret => {
   const { exports } = ret
-  if (exports.join(',') !== 'parseCjsExports') {
+  if (exports.join(',') !== 'parseCjsExports,browserslistToTargets,localeModule') {
     console.error('unexpected exports of index.js:', exports)
     process.exit(1)
   }
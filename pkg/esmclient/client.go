@@ -0,0 +1,215 @@
+// Package esmclient provides a client for the build API of the esm.sh server.
+package esmclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"esm.sh/pkg/esmmeta"
+)
+
+var regExportStar = regexp.MustCompile(`export \* from "([^"]+)"`)
+
+// ESM defines the ES Module meta that is stored by the server
+type ESM = esmmeta.ESM
+
+// BuildRequest defines the options of a build
+type BuildRequest struct {
+	// Pkg is the package specifier like `react@17.0.2` or `react-dom@17/server`
+	Pkg    string
+	Target string
+	Bundle bool
+	Dev    bool
+	Deps   []string
+	Alias  map[string]string
+}
+
+// StatusError is returned when the server responds with an error status
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("esm.sh: %d %s", e.StatusCode, e.Message)
+}
+
+// Client is a client of the esm.sh server
+type Client struct {
+	// BaseURL is the server URL like `https://esm.sh`
+	BaseURL string
+	// HTTPClient is used to send requests, default is `http.DefaultClient`
+	HTTPClient *http.Client
+	// MaxRetries is the number of retries for failed requests, default is 3
+	MaxRetries int
+	// RetryDelay is the initial delay of the exponential backoff, default is 200ms
+	RetryDelay time.Duration
+	// AdminToken is sent as the bearer token, it's required by the `Build`, `GetESM`
+	// and `InvalidateBuild` methods that read or evict the build records
+	AdminToken string
+}
+
+// Build builds the package and returns the ES Module meta
+func (c *Client) Build(ctx context.Context, req BuildRequest) (*ESM, error) {
+	if req.Pkg == "" {
+		return nil, errors.New("esmclient: missing pkg")
+	}
+	query := url.Values{}
+	if req.Target != "" {
+		query.Set("target", req.Target)
+	}
+	if req.Bundle {
+		query.Set("bundle", "")
+	}
+	if req.Dev {
+		query.Set("dev", "")
+	}
+	if len(req.Deps) > 0 {
+		query.Set("deps", strings.Join(req.Deps, ","))
+	}
+	if len(req.Alias) > 0 {
+		alias := make([]string, 0, len(req.Alias))
+		for name, to := range req.Alias {
+			alias = append(alias, name+":"+to)
+		}
+		sort.Strings(alias)
+		query.Set("alias", strings.Join(alias, ","))
+	}
+	pathname := "/" + strings.TrimPrefix(req.Pkg, "/")
+	if len(query) > 0 {
+		pathname += "?" + query.Encode()
+	}
+
+	data, err := c.do(ctx, "GET", pathname)
+	if err != nil {
+		return nil, err
+	}
+	m := regExportStar.FindSubmatch(data)
+	if m == nil {
+		return nil, fmt.Errorf("esmclient: unexpected response: %s", strings.TrimSpace(string(data)))
+	}
+	id := string(m[1])
+	if i := strings.Index(id, "/v"); i >= 0 {
+		id = id[i+1:]
+	}
+	return c.GetESM(ctx, id)
+}
+
+// GetESM returns the ES Module meta of the build id like `v58/react@17.0.2/es2021/react.js`
+func (c *Client) GetESM(ctx context.Context, id string) (*ESM, error) {
+	data, err := c.do(ctx, "GET", "/_cache?id="+url.QueryEscape(strings.TrimPrefix(id, "/")))
+	if err != nil {
+		return nil, err
+	}
+	var ret struct {
+		ESM *ESM `json:"esm"`
+	}
+	err = json.Unmarshal(data, &ret)
+	if err != nil {
+		return nil, err
+	}
+	if ret.ESM == nil {
+		return nil, errors.New("esmclient: missing esm")
+	}
+	return ret.ESM, nil
+}
+
+// ListSubmodules returns the importable JS submodules of the package like `react-dom@17.0.2`
+func (c *Client) ListSubmodules(ctx context.Context, pkg string) ([]string, error) {
+	data, err := c.do(ctx, "GET", fmt.Sprintf("/v%d/%s/+files", esmmeta.VERSION, strings.TrimPrefix(pkg, "/")))
+	if err != nil {
+		return nil, err
+	}
+	var ret struct {
+		Files []struct {
+			Path string `json:"path"`
+		} `json:"files"`
+	}
+	err = json.Unmarshal(data, &ret)
+	if err != nil {
+		return nil, err
+	}
+	submodules := []string{}
+	for _, f := range ret.Files {
+		for _, ext := range []string{".js", ".mjs"} {
+			if strings.HasSuffix(f.Path, ext) && !strings.HasSuffix(f.Path, ".d"+ext) {
+				submodules = append(submodules, strings.TrimSuffix(f.Path, ext))
+				break
+			}
+		}
+	}
+	sort.Strings(submodules)
+	return submodules, nil
+}
+
+// InvalidateBuild removes the build record of the id to trigger a rebuild
+func (c *Client) InvalidateBuild(ctx context.Context, id string) error {
+	_, err := c.do(ctx, "DELETE", "/_cache?id="+url.QueryEscape(strings.TrimPrefix(id, "/")))
+	return err
+}
+
+func (c *Client) do(ctx context.Context, method string, pathname string) (data []byte, err error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	maxRetries := c.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = 200 * time.Millisecond
+	}
+
+	for i := 0; ; i++ {
+		var retryable bool
+		data, retryable, err = c.doOnce(ctx, httpClient, method, pathname)
+		if err == nil || !retryable || i >= maxRetries {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, httpClient *http.Client, method string, pathname string) (data []byte, retryable bool, err error) {
+	req, err := http.NewRequest(method, strings.TrimRight(c.BaseURL, "/")+pathname, nil)
+	if err != nil {
+		return
+	}
+	if c.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AdminToken)
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		retryable = ctx.Err() == nil
+		return
+	}
+	defer resp.Body.Close()
+
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		retryable = true
+		return
+	}
+	if resp.StatusCode >= 400 {
+		err = &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		retryable = resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+		data = nil
+	}
+	return
+}
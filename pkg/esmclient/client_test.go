@@ -0,0 +1,135 @@
+package esmclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testESM = `{"id":"v58/react@17.0.2/es2021/react.js","esm":{"name":"react","version":"17.0.2","exportDefault":true,"exports":["Component","useState"],"dts":"/v58/@types/react@17.0.38/index.d.ts"}}`
+
+func newTestServer(t *testing.T) (*httptest.Server, *int32) {
+	var failures int32 = 2
+	mux := http.NewServeMux()
+	mux.HandleFunc("/react@17.0.2", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("target") != "es2021" {
+			t.Errorf("unexpected query: %s", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, "/* esm.sh - react@17.0.2 */\nexport * from \"https://esm.sh/v58/react@17.0.2/es2021/react.js\";\n")
+	})
+	mux.HandleFunc("/_cache", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Invalid admin token", 401)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			if r.URL.Query().Get("id") != "v58/react@17.0.2/es2021/react.js" {
+				http.Error(w, "record not found", 404)
+				return
+			}
+			fmt.Fprint(w, testESM)
+		case "DELETE":
+			// fails twice to test the retry logic
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				http.Error(w, "unavailable", 503)
+				return
+			}
+			fmt.Fprint(w, `{"evicted":["v58/react@17.0.2/es2021/react.js"]}`)
+		}
+	})
+	mux.HandleFunc("/v58/react-dom@17.0.2/+files", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"files":[{"path":"server.js","size":1},{"path":"index.js","size":1},{"path":"index.d.js","size":1},{"path":"package.json","size":1},{"path":"esm/client.mjs","size":1}]}`)
+	})
+	return httptest.NewServer(mux), &failures
+}
+
+func TestBuild(t *testing.T) {
+	ts, _ := newTestServer(t)
+	defer ts.Close()
+
+	c := &Client{BaseURL: ts.URL, AdminToken: "secret"}
+	esm, err := c.Build(context.Background(), BuildRequest{Pkg: "react@17.0.2", Target: "es2021"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esm.Name != "react" || esm.Version != "17.0.2" || !esm.ExportDefault {
+		t.Fatalf("unexpected esm: %+v", esm)
+	}
+	if !reflect.DeepEqual(esm.Exports, []string{"Component", "useState"}) {
+		t.Fatalf("unexpected exports: %v", esm.Exports)
+	}
+
+	_, err = c.Build(context.Background(), BuildRequest{})
+	if err == nil {
+		t.Fatal("should fail without pkg")
+	}
+}
+
+func TestGetESM(t *testing.T) {
+	ts, _ := newTestServer(t)
+	defer ts.Close()
+
+	c := &Client{BaseURL: ts.URL, AdminToken: "secret"}
+	esm, err := c.GetESM(context.Background(), "/v58/react@17.0.2/es2021/react.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esm.Dts != "/v58/@types/react@17.0.38/index.d.ts" {
+		t.Fatalf("unexpected dts: %s", esm.Dts)
+	}
+
+	_, err = c.GetESM(context.Background(), "v58/react@0.0.0/es2021/react.js")
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != 404 {
+		t.Fatalf("should be a 404 error, but %v", err)
+	}
+}
+
+func TestListSubmodules(t *testing.T) {
+	ts, _ := newTestServer(t)
+	defer ts.Close()
+
+	c := &Client{BaseURL: ts.URL}
+	submodules, err := c.ListSubmodules(context.Background(), "react-dom@17.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(submodules, []string{"esm/client", "index", "server"}) {
+		t.Fatalf("unexpected submodules: %v", submodules)
+	}
+}
+
+func TestInvalidateBuildRetry(t *testing.T) {
+	ts, failures := newTestServer(t)
+	defer ts.Close()
+
+	c := &Client{BaseURL: ts.URL, RetryDelay: time.Millisecond}
+	err := c.InvalidateBuild(context.Background(), "v58/react@17.0.2/es2021/react.js")
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != 401 {
+		t.Fatalf("should be a 401 error without the admin token, but %v", err)
+	}
+
+	c.AdminToken = "secret"
+	err = c.InvalidateBuild(context.Background(), "v58/react@17.0.2/es2021/react.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(failures) != -1 {
+		t.Fatal("should retry twice")
+	}
+
+	atomic.StoreInt32(failures, 10)
+	c.MaxRetries = 1
+	err = c.InvalidateBuild(context.Background(), "v58/react@17.0.2/es2021/react.js")
+	if !errors.As(err, &se) || se.StatusCode != 503 {
+		t.Fatalf("should be a 503 error, but %v", err)
+	}
+}
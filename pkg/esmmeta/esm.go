@@ -0,0 +1,36 @@
+// Package esmmeta defines the meta of the esm.sh builds that is shared by the server
+// and the clients.
+package esmmeta
+
+// VERSION is the build version of esm.sh, the build files are served under the
+// `/v{VERSION}/` path.
+const VERSION = 58
+
+// ESM defines the ES Module meta
+type ESM struct {
+	*NpmPackage
+	ExportDefault bool     `json:"exportDefault"`
+	Exports       []string `json:"exports"`
+	Dts           string   `json:"dts"`
+	// DtsFormat is the extension of the declaration file: ".d.ts", ".d.mts" or ".d.cts"
+	DtsFormat     string   `json:"dtsFormat,omitempty"`
+	TypesZip      string   `json:"typesZip,omitempty"`
+	PackageCSS    bool     `json:"packageCSS"`
+	WasmFiles     []string `json:"wasmFiles,omitempty"`
+	NativeAddon   bool     `json:"nativeAddon,omitempty"`
+	EngineWarning string   `json:"engineWarning,omitempty"`
+	// WasmURL is the URL path of the wasm file fetched by the build with the
+	// `?wasm-streaming` query, the first one if there are multiple wasm files
+	WasmURL string `json:"wasmUrl,omitempty"`
+	// TypesResolution indicates how the types are resolved: "package", "atypes" or "none"
+	TypesResolution string `json:"typesResolution,omitempty"`
+	// Externals records the import paths of the external modules, only for the
+	// builds with the `debug-externals` query
+	Externals map[string]string `json:"externals,omitempty"`
+	// NodeBuiltIns records the built-in node modules imported by the build, which are
+	// polyfilled for the browsers
+	NodeBuiltIns []string `json:"nodeBuiltIns,omitempty"`
+	// CircularDep marks a placeholder returned for a task that is already
+	// being built up the current chain
+	CircularDep bool `json:"-"`
+}
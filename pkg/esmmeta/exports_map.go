@@ -0,0 +1,211 @@
+package esmmeta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ExportsKind defines the kind of an `exports` value in package.json
+type ExportsKind int
+
+const (
+	ExportsNull ExportsKind = iota
+	ExportsPath
+	ExportsArray
+	ExportsObject
+)
+
+// ExportsMap defines the `exports` field of package.json, the value can be a
+// path string, an array of fallbacks, an object of conditions or subpaths (may be
+// nested), or `null` to block the subpath.
+// see https://nodejs.org/api/packages.html#package-entry-points
+type ExportsMap struct {
+	Kind      ExportsKind
+	Path      string
+	Fallbacks []ExportsMap
+	// the keys of the object in the defined order, the order of the conditions matters
+	Keys   []string
+	Values map[string]ExportsMap
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (m *ExportsMap) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return fmt.Errorf("invalid exports")
+	}
+	*m = ExportsMap{}
+	switch data[0] {
+	case 'n':
+		m.Kind = ExportsNull
+		return nil
+	case '"':
+		m.Kind = ExportsPath
+		return json.Unmarshal(data, &m.Path)
+	case '[':
+		m.Kind = ExportsArray
+		return json.Unmarshal(data, &m.Fallbacks)
+	case '{':
+		m.Kind = ExportsObject
+		m.Values = map[string]ExportsMap{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		// skip the `{` token
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := t.(string)
+			if !ok {
+				return fmt.Errorf("invalid exports key %v", t)
+			}
+			var value ExportsMap
+			if err := dec.Decode(&value); err != nil {
+				return err
+			}
+			if _, ok := m.Values[key]; !ok {
+				m.Keys = append(m.Keys, key)
+			}
+			m.Values[key] = value
+		}
+		return nil
+	default:
+		// booleans and numbers are invalid in the spec, treat them as `null`
+		m.Kind = ExportsNull
+		return nil
+	}
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (m ExportsMap) MarshalJSON() ([]byte, error) {
+	switch m.Kind {
+	case ExportsPath:
+		return json.Marshal(m.Path)
+	case ExportsArray:
+		if m.Fallbacks == nil {
+			return []byte("[]"), nil
+		}
+		return json.Marshal(m.Fallbacks)
+	case ExportsObject:
+		buf := bytes.NewBufferString("{")
+		for i, key := range m.Keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, err := json.Marshal(key)
+			if err != nil {
+				return nil, err
+			}
+			v, err := json.Marshal(m.Values[key])
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(k)
+			buf.WriteByte(':')
+			buf.Write(v)
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
+	default:
+		return []byte("null"), nil
+	}
+}
+
+// IsNull returns true if the value is `null`
+func (m ExportsMap) IsNull() bool {
+	return m.Kind == ExportsNull
+}
+
+// Get returns the value of the key if the value is an object
+func (m ExportsMap) Get(key string) (ExportsMap, bool) {
+	if m.Kind != ExportsObject {
+		return ExportsMap{}, false
+	}
+	value, ok := m.Values[key]
+	return value, ok
+}
+
+// IsSubpaths returns true if the value is an object of subpaths like `{ "./foo": "./foo.js" }`
+func (m ExportsMap) IsSubpaths() bool {
+	return m.Kind == ExportsObject && len(m.Keys) > 0 && strings.HasPrefix(m.Keys[0], ".")
+}
+
+// FirstPath returns the first path of the value, it picks the first resolvable
+// path in the fallbacks if the value is an array.
+func (m ExportsMap) FirstPath() string {
+	switch m.Kind {
+	case ExportsPath:
+		return m.Path
+	case ExportsArray:
+		for _, e := range m.Fallbacks {
+			if s := e.FirstPath(); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// ReplaceAll returns a copy of the value that replaces all `old` in the paths with `new`
+func (m ExportsMap) ReplaceAll(old string, new string) ExportsMap {
+	switch m.Kind {
+	case ExportsPath:
+		return ExportsMap{Kind: ExportsPath, Path: strings.ReplaceAll(m.Path, old, new)}
+	case ExportsArray:
+		fallbacks := make([]ExportsMap, len(m.Fallbacks))
+		for i, e := range m.Fallbacks {
+			fallbacks[i] = e.ReplaceAll(old, new)
+		}
+		return ExportsMap{Kind: ExportsArray, Fallbacks: fallbacks}
+	case ExportsObject:
+		values := make(map[string]ExportsMap, len(m.Values))
+		for key, value := range m.Values {
+			values[key] = value.ReplaceAll(old, new)
+		}
+		return ExportsMap{Kind: ExportsObject, Keys: m.Keys, Values: values}
+	}
+	return m
+}
+
+// Resolve resolves the path of the value with the conditions by the order of the
+// object keys, the `default` condition is always matched. It returns false if the
+// value can't be resolved or it's `null`.
+// see https://nodejs.org/api/packages.html#conditional-exports
+func (m ExportsMap) Resolve(conditions []string) (string, bool) {
+	switch m.Kind {
+	case ExportsPath:
+		return m.Path, true
+	case ExportsArray:
+		for _, e := range m.Fallbacks {
+			if s, ok := e.Resolve(conditions); ok {
+				return s, true
+			}
+		}
+	case ExportsObject:
+		for _, key := range m.Keys {
+			matched := key == "default"
+			for _, c := range conditions {
+				if c == key {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				// a nested `null` blocks the rest conditions
+				value := m.Values[key]
+				if value.IsNull() {
+					return "", false
+				}
+				if s, ok := value.Resolve(conditions); ok {
+					return s, true
+				}
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,78 @@
+package esmmeta
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExportsMap(t *testing.T) {
+	var p struct {
+		Exports *ExportsMap `json:"exports,omitempty"`
+	}
+
+	err := json.Unmarshal([]byte(`{"exports": "./index.js"}`), &p)
+	if err != nil || p.Exports.Kind != ExportsPath || p.Exports.Path != "./index.js" {
+		t.Fatalf("unexpected string exports: %v %v", p.Exports, err)
+	}
+
+	err = json.Unmarshal([]byte(`{"exports": [{"import": "./index.mjs"}, "./index.js"]}`), &p)
+	if err != nil || p.Exports.Kind != ExportsArray || len(p.Exports.Fallbacks) != 2 {
+		t.Fatalf("unexpected array exports: %v %v", p.Exports, err)
+	}
+	if p.Exports.FirstPath() != "./index.js" {
+		t.Fatalf("unexpected first path: %s", p.Exports.FirstPath())
+	}
+
+	data := `{".":{"node":{"import":"./node.mjs","require":"./node.cjs"},"default":"./index.js"},"./internal":null,"./lib/*":["./lib/*.js"]}`
+	err = json.Unmarshal([]byte(`{"exports":`+data+`}`), &p)
+	if err != nil || !p.Exports.IsSubpaths() {
+		t.Fatalf("unexpected subpath exports: %v %v", p.Exports, err)
+	}
+	if len(p.Exports.Keys) != 3 || p.Exports.Keys[0] != "." || p.Exports.Keys[2] != "./lib/*" {
+		t.Fatalf("unexpected keys order: %v", p.Exports.Keys)
+	}
+	if v, ok := p.Exports.Get("./internal"); !ok || !v.IsNull() {
+		t.Fatal("the `./internal` should be null")
+	}
+	if _, ok := p.Exports.Get("./missing"); ok {
+		t.Fatal("the `./missing` should not be found")
+	}
+	root, _ := p.Exports.Get(".")
+	node, _ := root.Get("node")
+	if v, _ := node.Get("require"); v.Path != "./node.cjs" {
+		t.Fatalf("unexpected nested condition: %v", v)
+	}
+	lib, _ := p.Exports.Get("./lib/*")
+	if s := lib.ReplaceAll("*", "foo").FirstPath(); s != "./lib/foo.js" || lib.FirstPath() != "./lib/*.js" {
+		t.Fatalf("unexpected replaced path: %s", s)
+	}
+
+	ret, err := json.Marshal(p.Exports)
+	if err != nil || string(ret) != data {
+		t.Fatalf("unexpected marshaled exports: %s %v", ret, err)
+	}
+
+	root, _ = p.Exports.Get(".")
+	for _, c := range []struct {
+		conditions []string
+		path       string
+		ok         bool
+	}{
+		{[]string{"node", "import"}, "./node.mjs", true},
+		{[]string{"node", "require"}, "./node.cjs", true},
+		{[]string{"browser", "import"}, "./index.js", true},
+	} {
+		if s, ok := root.Resolve(c.conditions); s != c.path || ok != c.ok {
+			t.Fatalf("unexpected resolved path with %v: %s %v", c.conditions, s, ok)
+		}
+	}
+	internal, _ := p.Exports.Get("./internal")
+	if _, ok := internal.Resolve([]string{"import"}); ok {
+		t.Fatal("the `./internal` should not be resolved")
+	}
+
+	err = json.Unmarshal([]byte(`{"exports": null}`), &p)
+	if err != nil || p.Exports != nil {
+		t.Fatalf("the null exports should be nil: %v %v", p.Exports, err)
+	}
+}
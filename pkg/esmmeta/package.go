@@ -0,0 +1,80 @@
+package esmmeta
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// NpmPackage defines the package.json of npm
+type NpmPackage struct {
+	Name                 string                           `json:"name"`
+	Version              string                           `json:"version"`
+	Main                 string                           `json:"main,omitempty"`
+	Module               string                           `json:"module,omitempty"`
+	Type                 string                           `json:"type,omitempty"`
+	Types                string                           `json:"types,omitempty"`
+	Typings              string                           `json:"typings,omitempty"`
+	TypesVersions        map[string]map[string][]string   `json:"typesVersions,omitempty"`
+	Dependencies         map[string]string                `json:"dependencies,omitempty"`
+	PeerDependencies     map[string]string                `json:"peerDependencies,omitempty"`
+	PeerDependenciesMeta map[string]NpmPeerDependencyMeta `json:"peerDependenciesMeta,omitempty"`
+	DefinedExports       *ExportsMap                      `json:"exports,omitempty"`
+	Engines              NpmEngines                       `json:"engines,omitempty"`
+	Workspaces           NpmWorkspaces                    `json:"workspaces,omitempty"`
+	Browser              json.RawMessage                  `json:"browser,omitempty"`
+	// BrowserString and BrowserMap are parsed from the `browser` field by `fixNpmPackage`,
+	// the field is either a replacement of `main` or a map of module replacements.
+	BrowserString string            `json:"-"`
+	BrowserMap    map[string]string `json:"-"`
+}
+
+// NpmPeerDependencyMeta defines the entry of the `peerDependenciesMeta` field of package.json
+type NpmPeerDependencyMeta struct {
+	Optional bool `json:"optional,omitempty"`
+}
+
+// NpmEngines defines the `engines` field of package.json, the non-string values
+// like `"browser": false` are converted to strings.
+type NpmEngines map[string]string
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (e *NpmEngines) UnmarshalJSON(data []byte) error {
+	var m map[string]interface{}
+	if json.Unmarshal(data, &m) != nil {
+		// ignore the invalid engines like `["node >= 0.4"]` of some legacy packages
+		*e = nil
+		return nil
+	}
+	*e = make(NpmEngines, len(m))
+	for key, value := range m {
+		if s, ok := value.(string); ok {
+			(*e)[key] = s
+		} else {
+			(*e)[key] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+// NpmWorkspaces defines the `workspaces` field of package.json, it supports both
+// the array of globs and the yarn style `{ "packages": [...] }`.
+type NpmWorkspaces []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (w *NpmWorkspaces) UnmarshalJSON(data []byte) error {
+	var globs []string
+	if json.Unmarshal(data, &globs) == nil {
+		*w = globs
+		return nil
+	}
+	var v struct {
+		Packages []string `json:"packages"`
+	}
+	if json.Unmarshal(data, &v) == nil {
+		*w = v.Packages
+		return nil
+	}
+	// ignore the invalid workspaces
+	*w = nil
+	return nil
+}
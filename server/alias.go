@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+type pkgAlias struct {
+	Alias string `json:"alias"`
+	Pkg   string `json:"pkg"`
+}
+
+// serveAliasesAdmin serves the `/admin/aliases` requests, only available in dev mode
+// or with the admin token.
+//
+//	GET  /admin/aliases                                             lists the aliases
+//	POST /admin/aliases {"alias":"react-compat","pkg":"@compat/react"}  registers an alias
+func serveAliasesAdmin(ctx *rex.Context, devMode bool) interface{} {
+	if err := checkAdminRequest(ctx, devMode); err != nil {
+		return err
+	}
+
+	switch ctx.R.Method {
+	case "GET":
+		list, err := db.List("alias")
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		aliases := make([]pkgAlias, len(list))
+		for i, item := range list {
+			aliases[i] = pkgAlias{Alias: item.Store["alias"], Pkg: item.Store["pkg"]}
+		}
+		return map[string]interface{}{
+			"aliases": aliases,
+		}
+
+	case "POST":
+		var a pkgAlias
+		err := json.NewDecoder(ctx.R.Body).Decode(&a)
+		if isBodyTooLarge(err) {
+			return throwPayloadTooLargeError(ctx, maxRequestBodySize)
+		}
+		if err != nil {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Invalid body"})
+		}
+		if a.Alias == "" || len(a.Alias) > 214 || !npmNaming.Is(a.Alias) {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid alias '%s'", a.Alias)})
+		}
+		if validatePkgName(a.Pkg) != nil {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid pkg '%s'", a.Pkg)})
+		}
+		err = db.Put("alias:"+a.Alias, "alias", storage.Store{"alias": a.Alias, "pkg": a.Pkg})
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		return a
+	}
+
+	ctx.SetHeader("Allow", "GET, POST")
+	return throwAPIError(ctx, http.StatusMethodNotAllowed, APIError{Code: errCodeMethodNotAllowed, Message: "Method Not Allowed"})
+}
+
+// serveAlias redirects the `/alias/<alias>@<version>/<rest>` requests to the
+// canonical URL of the aliased package.
+func serveAlias(ctx *rex.Context, pathname string) interface{} {
+	aliasName, rest := utils.SplitByFirstByte(strings.TrimPrefix(pathname, "/alias/"), '/')
+	name, version := utils.SplitByLastByte(aliasName, '@')
+	if name == "" {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Invalid alias"})
+	}
+	store, _, err := db.Get("alias:" + name)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("Alias '%s' not found", name)})
+		}
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	url := "/" + store["pkg"]
+	if version != "" {
+		url += "@" + version
+	}
+	if rest != "" {
+		url += "/" + rest
+	}
+	if ctx.R.URL.RawQuery != "" {
+		url += "?" + ctx.R.URL.RawQuery
+	}
+	return rex.Redirect(url, http.StatusFound)
+}
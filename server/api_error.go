@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+// the codes of the API errors for the clients to handle the errors programmatically
+const (
+	errCodeBadRequest       = "bad-request"
+	errCodeBuildFailed      = "build-failed"
+	errCodeForbidden        = "forbidden"
+	errCodeInternal         = "internal-error"
+	errCodeInvalidPackage   = "invalid-package"
+	errCodeMethodNotAllowed = "method-not-allowed"
+	errCodeNativeAddon      = "native-addon"
+	errCodeNotFound         = "not-found"
+	errCodeOffline          = "offline"
+	errCodePayloadTooLarge  = "payload-too-large"
+	errCodeQueueFull        = "queue-full"
+	errCodeRateLimited      = "rate-limited"
+	errCodeTimeout          = "timeout"
+	errCodeUnauthorized     = "unauthorized"
+)
+
+// APIError defines the JSON body of the error responses
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func (e APIError) Error() string {
+	return e.Message
+}
+
+// writeError writes the error as JSON to the response writer directly, it's used
+// by the handlers that write the response without rex.
+func writeError(w http.ResponseWriter, status int, err APIError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(err)
+}
+
+// throwAPIError responds the error as JSON with the status code
+func throwAPIError(ctx *rex.Context, status int, err APIError) interface{} {
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return rex.Status(status, err)
+}
+
+// throwBuildError responds 422 with the details of the stored error of the failed build
+func throwBuildError(ctx *rex.Context, buildErr *FailedBuildError) interface{} {
+	ctx.SetHeader("Cache-Control", "public, max-age=300")
+	return throwAPIError(ctx, http.StatusUnprocessableEntity, APIError{
+		Code:    errCodeBuildFailed,
+		Message: buildErr.Error(),
+		Detail:  fmt.Sprintf("stage: %s, time: %s", buildErr.Stage, buildErr.Timestamp.Format(time.RFC3339)),
+	})
+}
+
+// throwQueueFullError responds 503 with the `Retry-After` header when the build queue is full
+func throwQueueFullError(ctx *rex.Context) interface{} {
+	ctx.SetHeader("Retry-After", "5")
+	return throwAPIError(ctx, http.StatusServiceUnavailable, APIError{Code: errCodeQueueFull, Message: ErrQueueFull.Error()})
+}
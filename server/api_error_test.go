@@ -0,0 +1,20 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeError(w, 404, APIError{Code: errCodeNotFound, Message: "Types not found"})
+	if w.Code != 404 || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var e APIError
+	err := json.NewDecoder(w.Body).Decode(&e)
+	if err != nil || e.Code != "not-found" || e.Message != "Types not found" || e.Detail != "" {
+		t.Fatalf("unexpected error body: %v %v", e, err)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+// the colors of the badges
+const (
+	badgeColorGreen = "#4c1"
+	badgeColorRed   = "#e05d44"
+	badgeColorBlue  = "#007ec6"
+	badgeColorGray  = "#9f9f9f"
+)
+
+// the max length of the `label` query of the badges
+const maxBadgeLabelLength = 32
+
+const badgeTpl = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">` +
+	`<title>%[3]s: %[4]s</title>` +
+	`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>` +
+	`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>` +
+	`<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[5]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>` +
+	`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">` +
+	`<text x="%[7]d" y="14">%[3]s</text><text x="%[8]d" y="14">%[4]s</text></g></svg>`
+
+// serveBadge serves the badges of the build for the READMEs like `+badges/build.svg`,
+// the build is specified by the `target`, `bundle` and `dev` query like the module URL,
+// and the `label` query overrides the left side text of the badge.
+//
+//	+badges/build.svg   "built" or "failed"
+//	+badges/size.svg    the gzip size of the build like "4.2 kB"
+//	+badges/types.svg   "typed" or "untyped"
+func serveBadge(ctx *rex.Context, pkg *Pkg, name string) interface{} {
+	label := strings.TrimSpace(ctx.Form.Value("label"))
+	if len(label) > maxBadgeLabelLength {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid label query: max %d characters", maxBadgeLabelLength)})
+	}
+
+	task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: pkg.Version})
+	esm, err := findESM(task.ID())
+	_, failed := err.(*FailedBuildError)
+	if err != nil && err != storage.ErrNotFound && !failed {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	var defaultLabel, message, color string
+	switch name {
+	case "build.svg":
+		defaultLabel = "esm.sh"
+		if esm != nil {
+			message, color = "built", badgeColorGreen
+		} else if failed {
+			message, color = "failed", badgeColorRed
+		} else {
+			message, color = "not built", badgeColorGray
+		}
+	case "size.svg":
+		defaultLabel = "gzip size"
+		message, color = "unknown", badgeColorGray
+		if esm != nil {
+			sizes, err := readBuildSizes(task)
+			if err != nil && err != storage.ErrNotFound {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			if err == nil {
+				message, color = formatBadgeSize(sizes.Gzip), badgeColorBlue
+			}
+		}
+	case "types.svg":
+		defaultLabel = "types"
+		message, color = "unknown", badgeColorGray
+		if esm != nil && esm.Dts != "" {
+			message, color = "typed", badgeColorBlue
+		} else if esm != nil {
+			message = "untyped"
+		}
+	default:
+		return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("Badge '%s' not found", name)})
+	}
+	if label == "" {
+		label = defaultLabel
+	}
+
+	ctx.SetHeader("Content-Type", "image/svg+xml")
+	ctx.SetHeader("Cache-Control", "public, max-age=3600")
+	return renderBadge(label, message, color)
+}
+
+// renderBadge renders the badge in the flat style of shields.io, the width of the
+// text is estimated by the count of the characters.
+func renderBadge(label string, message string, color string) string {
+	labelWidth := badgeTextWidth(label)
+	messageWidth := badgeTextWidth(message)
+	return fmt.Sprintf(
+		badgeTpl,
+		labelWidth+messageWidth,
+		labelWidth,
+		html.EscapeString(label),
+		html.EscapeString(message),
+		messageWidth,
+		color,
+		labelWidth/2,
+		labelWidth+messageWidth/2,
+	)
+}
+
+func badgeTextWidth(text string) int {
+	return len([]rune(text))*7 + 10
+}
+
+// formatBadgeSize formats the size in the human-readable form like "4.2 kB"
+func formatBadgeSize(size int) string {
+	if size < 1000 {
+		return fmt.Sprintf("%d B", size)
+	}
+	if size < 1000*1000 {
+		return fmt.Sprintf("%.1f kB", float64(size)/1000)
+	}
+	return fmt.Sprintf("%.1f MB", float64(size)/1000/1000)
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderBadge(t *testing.T) {
+	svg := renderBadge("esm.sh", "built", badgeColorGreen)
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="97" height="20"`) {
+		t.Fatalf("unexpected svg: %s", svg)
+	}
+	if !strings.Contains(svg, `<text x="26" y="14">esm.sh</text><text x="74" y="14">built</text>`) || !strings.Contains(svg, `fill="#4c1"`) {
+		t.Fatalf("unexpected svg: %s", svg)
+	}
+
+	// the label is escaped
+	if svg := renderBadge("<script>", "typed", badgeColorBlue); strings.Contains(svg, "<script>") {
+		t.Fatalf("the label is not escaped: %s", svg)
+	}
+
+	for size, expected := range map[int]string{
+		512:     "512 B",
+		4200:    "4.2 kB",
+		1234567: "1.2 MB",
+	} {
+		if s := formatBadgeSize(size); s != expected {
+			t.Fatalf("unexpected size of %d: %s", size, s)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+const (
+	// the maximum number of the data points stored for a package and target
+	maxBenchmarkPoints = 1000
+	// the number of the latest data points in the response
+	benchmarkResponsePoints = 50
+)
+
+type benchmarkPoint struct {
+	Version   string `json:"version"`
+	BuildMs   int64  `json:"buildMs"`
+	SizeBytes int64  `json:"sizeBytes"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// serveBenchmark serves the `+benchmark` requests, it runs a cold build of the
+// package and appends the build time and the size of the output to the time
+// series of the package and target, then responds the latest 50 data points.
+func serveBenchmark(ctx *rex.Context, pkg *Pkg) interface{} {
+	task := newPkgAPIBuildTask(ctx, *pkg)
+	point, err := benchmarkBuild(task)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeBuildFailed, Message: err.Error()})
+	}
+	points, err := appendBenchmarkPoint(fmt.Sprintf("benchmark:%s:%s", pkg.Name, task.Target), point)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if len(points) > benchmarkResponsePoints {
+		points = points[len(points)-benchmarkResponsePoints:]
+	}
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return map[string]interface{}{
+		"name":   pkg.Name,
+		"target": task.Target,
+		"points": points,
+	}
+}
+
+// benchmarkBuild builds the package without the previous build, the packages are
+// installed in a new temp dir with an empty cache of the package manager to measure
+// the whole build time.
+func benchmarkBuild(task *BuildTask) (point benchmarkPoint, err error) {
+	wd := tempDir(fmt.Sprintf("esm-benchmark-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+	task.wd = path.Join(wd, "build")
+	err = ensureDir(task.wd)
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+	if host, user, repo := splitGitPkgName(task.Pkg.Name); host != "" {
+		err = installFromGit(task.wd, host, user, repo, task.Pkg.Version)
+	} else {
+		flags := append(task.installFlags(), coldCacheFlags(packageManager, path.Join(wd, "cache"))...)
+		err = retryYarnAdd(task.wd, fmt.Sprintf("%s@%s", task.Pkg.Name, task.Pkg.Version), 3, time.Second, flags...)
+	}
+	if err != nil {
+		return
+	}
+	_, err = task.build(newStringSet())
+	if err != nil {
+		return
+	}
+	buildMs := time.Since(start).Milliseconds()
+
+	r, err := fs.ReadFile(path.Join("builds", task.ID()))
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+
+	point = benchmarkPoint{
+		Version:   task.Pkg.Version,
+		BuildMs:   buildMs,
+		SizeBytes: size,
+		Timestamp: start.Unix(),
+	}
+	return
+}
+
+// coldCacheFlags returns the flags of the package manager to use the given empty
+// cache dir instead of the shared cache.
+func coldCacheFlags(pm string, cacheDir string) []string {
+	switch pm {
+	case "npm":
+		return []string{"--cache", cacheDir}
+	case "pnpm":
+		return []string{"--store-dir", cacheDir}
+	default:
+		return []string{"--cache-folder", cacheDir}
+	}
+}
+
+// appendBenchmarkPoint appends the data point to the time series in the db, only
+// the latest 1000 data points are kept.
+func appendBenchmarkPoint(key string, point benchmarkPoint) (points []benchmarkPoint, err error) {
+	store, _, err := db.Get(key)
+	if err == nil {
+		err = json.Unmarshal([]byte(store["points"]), &points)
+	} else if err == storage.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	points = append(points, point)
+	if len(points) > maxBenchmarkPoints {
+		points = points[len(points)-maxBenchmarkPoints:]
+	}
+	err = db.Put(key, "benchmark", storage.Store{
+		"key":    key,
+		"points": string(utils.MustEncodeJSON(points)),
+	})
+	return
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+
+	"esm.sh/server/storage"
+)
+
+func TestAppendBenchmarkPoint(t *testing.T) {
+	var err error
+	db, err = storage.OpenDB(fmt.Sprintf("postdb:%s", path.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	key := "benchmark:react:es2021"
+	for i := 0; i < maxBenchmarkPoints+10; i++ {
+		_, err = appendBenchmarkPoint(key, benchmarkPoint{Version: "17.0.2", BuildMs: int64(i), Timestamp: int64(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	point := benchmarkPoint{Version: "18.0.0", BuildMs: 120, SizeBytes: 1024, Timestamp: 1650000000}
+	points, err := appendBenchmarkPoint(key, point)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != maxBenchmarkPoints {
+		t.Fatalf("unexpected length of the data points: %d", len(points))
+	}
+	if points[0].BuildMs != 11 || !reflect.DeepEqual(points[len(points)-1], point) {
+		t.Fatalf("unexpected data points: %v ... %v", points[0], points[len(points)-1])
+	}
+}
+
+func TestColdCacheFlags(t *testing.T) {
+	if flags := coldCacheFlags("yarn", "/tmp/cache"); !reflect.DeepEqual(flags, []string{"--cache-folder", "/tmp/cache"}) {
+		t.Fatalf("unexpected flags: %v", flags)
+	}
+	if flags := coldCacheFlags("npm", "/tmp/cache"); !reflect.DeepEqual(flags, []string{"--cache", "/tmp/cache"}) {
+		t.Fatalf("unexpected flags: %v", flags)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ije/rex"
+)
+
+// the max size of the request body, the `/build-local` endpoint accepts the package
+// tarball up to `maxLocalPackageSize`
+const maxRequestBodySize = 1 << 20 // 1MB
+
+// limitRequestBody returns a middleware to limit the size of the request body, the
+// requests with a larger `Content-Length` are rejected with 413 directly, and the body
+// is wrapped with `http.MaxBytesReader` for the chunked requests, so the handlers
+// don't need to limit the body themselves.
+func limitRequestBody() rex.Handle {
+	return func(ctx *rex.Context) interface{} {
+		if ctx.R.Body == nil || ctx.R.Body == http.NoBody {
+			return nil
+		}
+		limit := requestBodyLimit(ctx.R.URL.Path)
+		if ctx.R.ContentLength > limit {
+			return throwPayloadTooLargeError(ctx, limit)
+		}
+		ctx.R.Body = http.MaxBytesReader(ctx.W, ctx.R.Body, limit)
+		return nil
+	}
+}
+
+// requestBodyLimit returns the max size of the request body of the pathname
+func requestBodyLimit(pathname string) int64 {
+	if pathname == "/build-local" {
+		return maxLocalPackageSize
+	}
+	return maxRequestBodySize
+}
+
+// isBodyTooLarge checks whether the error is returned by `http.MaxBytesReader`
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
+
+// throwPayloadTooLargeError responds 413 with the limit of the request body
+func throwPayloadTooLargeError(ctx *rex.Context, limit int64) interface{} {
+	return throwAPIError(ctx, http.StatusRequestEntityTooLarge, APIError{
+		Code:    errCodePayloadTooLarge,
+		Message: fmt.Sprintf("The request body exceeds the limit of %dMB", limit>>20),
+		Detail:  fmt.Sprintf("limit: %d", limit),
+	})
+}
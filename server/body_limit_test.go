@@ -0,0 +1,28 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestBodyLimit(t *testing.T) {
+	if limit := requestBodyLimit("/build-local"); limit != maxLocalPackageSize {
+		t.Fatalf("unexpected limit of /build-local: %d", limit)
+	}
+	if limit := requestBodyLimit("/react@17.0.2/+patch"); limit != maxRequestBodySize {
+		t.Fatalf("unexpected limit of +patch: %d", limit)
+	}
+
+	w := httptest.NewRecorder()
+	_, err := ioutil.ReadAll(http.MaxBytesReader(w, ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 11))), 10))
+	if !isBodyTooLarge(err) {
+		t.Fatalf("expected the body too large error, got %v", err)
+	}
+	_, err = ioutil.ReadAll(http.MaxBytesReader(w, ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 10))), 10))
+	if isBodyTooLarge(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const browserslistTargetPrefix = "browserslist:"
+
+// the `extends` query is not allowed since it requires the shareable config package,
+// and the percentage queries like `> 0.5%` are not allowed since the target is a
+// part of the build path which is not escaped in the import URLs.
+var regBrowserslistQuery = regexp.MustCompile(`^[a-z0-9 .<>=_-]+$`)
+
+// parseBrowserslistTarget returns the normalized query of the browserslist target
+// like `browserslist:last 2 Chrome versions`.
+func parseBrowserslistTarget(target string) (query string, ok bool) {
+	if !strings.HasPrefix(target, browserslistTargetPrefix) {
+		return
+	}
+	var queries []string
+	for _, q := range strings.Split(strings.ToLower(strings.TrimPrefix(target, browserslistTargetPrefix)), ",") {
+		q = strings.Join(strings.Fields(q), " ")
+		if q == "" || strings.HasPrefix(q, "extends ") || !regBrowserslistQuery.MatchString(q) {
+			return
+		}
+		queries = append(queries, q)
+	}
+	return strings.Join(queries, ", "), true
+}
+
+// resolveBrowserslistEngines resolves the browserslist query to the esbuild engines
+// by the `browserslistToTargets` node service, the result is cached for 1 hour.
+// The browsers that are not supported by esbuild (like IE) are ignored.
+func resolveBrowserslistEngines(query string) (engines []api.Engine, err error) {
+	key := browserslistTargetPrefix + query
+	data, err := cache.Get(key)
+	cached := err == nil
+	if !cached {
+		data = invokeNodeService("browserslistToTargets", map[string]interface{}{
+			"query": query,
+		}, 10*time.Second)
+	}
+
+	var ret struct {
+		Targets []string `json:"targets"`
+		Error   string   `json:"error"`
+	}
+	err = json.Unmarshal(data, &ret)
+	if err != nil {
+		return
+	}
+	if ret.Error != "" {
+		err = fmt.Errorf("browserslist: %s", ret.Error)
+		return
+	}
+	if !cached {
+		cache.Set(key, data, time.Hour)
+	}
+
+	for _, target := range ret.Targets {
+		if engine, ok := parseEngineTarget(target); ok {
+			engines = append(engines, engine)
+		}
+	}
+	if len(engines) == 0 {
+		err = errors.New("browserslist: no browsers supported by esbuild matched")
+	}
+	return
+}
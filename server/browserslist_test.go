@@ -0,0 +1,28 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParseBrowserslistTarget(t *testing.T) {
+	for target, expected := range map[string]string{
+		"browserslist:last 2 Chrome versions":      "last 2 chrome versions",
+		"browserslist:  Safari >= 14 ,not   dead ": "safari >= 14, not dead",
+		"browserslist:ios_saf 15.2-15.3":           "ios_saf 15.2-15.3",
+		"browserslist:defaults":                    "defaults",
+		"browserslist:":                            "",
+		"browserslist:extends my-config":           "",
+		"browserslist:> 0.5%":                      "",
+		"browserslist:last 2 versions,":            "",
+		"browserslist:last 2 versions */ alert(1)": "",
+		"chrome96": "",
+	} {
+		query, ok := parseBrowserslistTarget(target)
+		if ok != (expected != "") || query != expected {
+			t.Fatalf("parseBrowserslistTarget(%q) should be %q, but got %q", target, expected, query)
+		}
+	}
+	if !isValidTarget("browserslist:last 2 versions") {
+		t.Fatal("the browserslist target should be valid")
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"esm.sh/server/storage"
@@ -20,37 +22,172 @@ import (
 )
 
 type BuildTask struct {
-	BuildVersion int               `json:"buildVersion"`
-	Pkg          Pkg               `json:"pkg"`
-	Alias        map[string]string `json:"alias"`
-	Deps         PkgSlice          `json:"deps"`
-	Target       string            `json:"target"`
-	BundleMode   bool              `json:"bundle"`
-	DevMode      bool              `json:"dev"`
+	BuildVersion        int               `json:"buildVersion"`
+	Pkg                 Pkg               `json:"pkg"`
+	Alias               map[string]string `json:"alias"`
+	Deps                PkgSlice          `json:"deps"`
+	External            []string          `json:"external"`
+	GlobalExternals     map[string]string `json:"globalExternals"`
+	Conditions          []string          `json:"conditions"`
+	TypesAuto           bool              `json:"typesAuto"`
+	Env                 map[string]string `json:"env"`
+	Locale              string            `json:"locale"`
+	Target              string            `json:"target"`
+	BundleMode          bool              `json:"bundle"`
+	DevMode             bool              `json:"dev"`
+	ExportsOnly         bool              `json:"exportsOnly"`
+	CJSOnly             bool              `json:"cjsOnly"`
+	NodeESM             bool              `json:"nodeESM"`
+	AsyncExports        bool              `json:"asyncExports"`
+	KeepCSS             bool              `json:"keepCSS"`
+	WasmInstantiate     bool              `json:"wasmInstantiate"`
+	WasmStreaming       bool              `json:"wasmStreaming"`
+	ModuleWorker        bool              `json:"moduleWorker"`
+	NoTypes             bool              `json:"noTypes"`
+	Entrypoint          string            `json:"entrypoint"`
+	Sourcefile          string            `json:"sourcefile"`
+	IgnoreAnnotations   bool              `json:"ignoreAnnotations"`
+	IgnoreLocks         bool              `json:"ignoreLocks"`
+	DebugExternals      bool              `json:"debugExternals"`
+	NoMinifyIdentifiers bool              `json:"noMinifyIdentifiers"`
+	NoMinifyWhitespace  bool              `json:"noMinifyWhitespace"`
+	NoMinifySyntax      bool              `json:"noMinifySyntax"`
+	OptimizeFor         string            `json:"optimizeFor"`
+	IncludeComments     bool              `json:"includeComments"`
+	// Priority of the task in the build queue, the higher is built sooner
+	Priority int `json:"priority"`
+	// DryRun runs the build without writing the files to the fs and the records to the db
+	DryRun bool `json:"-"`
 
 	// state
-	id    string
-	wd    string
-	stage string
+	id         string
+	wd         string
+	stage      string
+	stageStart time.Time
+	checksum   string
+	stats      *BuildStats
+	// the sub-module tasks deferred by the circular dependencies, they are queued
+	// after the top-level build
+	circularDeps []*BuildTask
 }
 
+// resolvePrefix encodes the `alias`, `deps`, `external` and the build flags of the task like
+// `X-<base64(a:<alias>,d:<deps>,x:<external>,<flags>...)>/`, the alias, deps and external
+// entries are base64 encoded and delimited by `.` to avoid collisions with the `,` and `:`
+// delimiters.
 func (task *BuildTask) resolvePrefix() string {
 	alias := []string{}
 	if len(task.Alias) > 0 {
 		var ss sort.StringSlice
 		for name, to := range task.Alias {
-			ss = append(ss, fmt.Sprintf("%s:%s", name, to))
+			ss = append(ss, fmt.Sprintf("%s:%s", btoaUrl(name), btoaUrl(to)))
 		}
 		ss.Sort()
-		alias = append(alias, fmt.Sprintf("alias:%s", strings.Join(ss, ",")))
+		alias = append(alias, fmt.Sprintf("a:%s", strings.Join(ss, ".")))
 	}
 	if len(task.Deps) > 0 {
 		var ss sort.StringSlice
 		for _, pkg := range task.Deps {
-			ss = append(ss, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version))
+			ss = append(ss, btoaUrl(fmt.Sprintf("%s@%s", pkg.Name, pkg.Version)))
 		}
 		ss.Sort()
-		alias = append(alias, fmt.Sprintf("deps:%s", strings.Join(ss, ",")))
+		alias = append(alias, fmt.Sprintf("d:%s", strings.Join(ss, ".")))
+	}
+	if len(task.External) > 0 {
+		var ss sort.StringSlice
+		for _, name := range task.External {
+			ss = append(ss, btoaUrl(name))
+		}
+		ss.Sort()
+		alias = append(alias, fmt.Sprintf("x:%s", strings.Join(ss, ".")))
+	}
+	if len(task.GlobalExternals) > 0 {
+		var ss sort.StringSlice
+		for name, global := range task.GlobalExternals {
+			ss = append(ss, fmt.Sprintf("%s:%s", btoaUrl(name), btoaUrl(global)))
+		}
+		ss.Sort()
+		alias = append(alias, fmt.Sprintf("g:%s", strings.Join(ss, ".")))
+	}
+	if task.ExportsOnly {
+		alias = append(alias, "exports-only")
+	}
+	if task.CJSOnly {
+		alias = append(alias, "cjs-only")
+	}
+	if task.AsyncExports {
+		alias = append(alias, "async-exports")
+	}
+	if task.NodeESM {
+		alias = append(alias, "node-esm")
+	}
+	if task.KeepCSS {
+		alias = append(alias, "keep-css")
+	}
+	if task.WasmInstantiate {
+		alias = append(alias, "wasm-instantiate")
+	}
+	if task.WasmStreaming {
+		alias = append(alias, "wasm-streaming")
+	}
+	if task.ModuleWorker {
+		alias = append(alias, "module-worker")
+	}
+	if task.NoTypes {
+		alias = append(alias, "no-types")
+	}
+	if task.IgnoreAnnotations {
+		alias = append(alias, "ignore-annotations")
+	}
+	if task.IgnoreLocks {
+		alias = append(alias, "ignore-locks")
+	}
+	if task.DebugExternals {
+		alias = append(alias, "debug-externals")
+	}
+	if task.NoMinifyIdentifiers {
+		alias = append(alias, "no-minify-identifiers")
+	}
+	if task.NoMinifyWhitespace {
+		alias = append(alias, "no-minify-whitespace")
+	}
+	if task.NoMinifySyntax {
+		alias = append(alias, "no-minify-syntax")
+	}
+	if task.IncludeComments {
+		alias = append(alias, "include-comments")
+	}
+	if task.OptimizeFor != "" {
+		// the optimize mode is validated by `isValidOptimizeFor`, no need to be encoded
+		alias = append(alias, fmt.Sprintf("o:%s", task.OptimizeFor))
+	}
+	if task.Entrypoint != "" {
+		alias = append(alias, fmt.Sprintf("e:%s", btoaUrl(task.Entrypoint)))
+	}
+	if task.Sourcefile != "" && task.Sourcefile != defaultSourcefile {
+		// the sourcefile is validated by `isValidSourcefile`, no need to be encoded
+		alias = append(alias, fmt.Sprintf("s:%s", task.Sourcefile))
+	}
+	if task.TypesAuto {
+		alias = append(alias, "types-auto")
+	}
+	if len(task.Conditions) > 0 {
+		// the conditions are validated by `validConditions`, no need to be encoded
+		ss := sort.StringSlice(append([]string{}, task.Conditions...))
+		ss.Sort()
+		alias = append(alias, fmt.Sprintf("c:%s", strings.Join(ss, ".")))
+	}
+	if len(task.Env) > 0 {
+		var ss sort.StringSlice
+		for key, value := range task.Env {
+			ss = append(ss, btoaUrl(fmt.Sprintf("%s=%s", key, value)))
+		}
+		ss.Sort()
+		alias = append(alias, fmt.Sprintf("env:%s", strings.Join(ss, ".")))
+	}
+	if task.Locale != "" {
+		// the locale is validated by `isValidLocale`, no need to be encoded
+		alias = append(alias, fmt.Sprintf("l:%s", task.Locale))
 	}
 	if len(alias) > 0 {
 		return fmt.Sprintf("X-%s/", btoaUrl(strings.Join(alias, ",")))
@@ -58,6 +195,87 @@ func (task *BuildTask) resolvePrefix() string {
 	return ""
 }
 
+// decodeResolvePrefix decodes the segments of the `X-...` resolve prefix
+func decodeResolvePrefix(s string) ([]string, error) {
+	s, err := atobUrl(strings.TrimPrefix(strings.TrimSuffix(s, "/"), "X-"))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(s, ","), nil
+}
+
+// parseResolvePrefix parses the `alias` and `deps` of the `X-...` resolve prefix, the
+// legacy format `alias:k1:v1,k2:v2,deps:p1@v1,p2@v2` is still supported.
+func parseResolvePrefix(s string) (alias map[string]string, deps PkgSlice, err error) {
+	segments, err := decodeResolvePrefix(s)
+	if err != nil {
+		return
+	}
+
+	alias = map[string]string{}
+	deps = PkgSlice{}
+	addDep := func(p string) {
+		name, version := utils.SplitByLastByte(p, '@')
+		if name != "" && version != "" && !deps.Has(name) {
+			deps = append(deps, Pkg{Name: name, Version: version})
+		}
+	}
+	legacy := ""
+	for _, p := range segments {
+		if strings.HasPrefix(p, "a:") {
+			legacy = ""
+			for _, p := range strings.Split(strings.TrimPrefix(p, "a:"), ".") {
+				name, to := utils.SplitByFirstByte(p, ':')
+				name, err = atobUrl(name)
+				if err != nil {
+					return
+				}
+				to, err = atobUrl(to)
+				if err != nil {
+					return
+				}
+				if name != "" && to != "" {
+					alias[name] = to
+				}
+			}
+		} else if strings.HasPrefix(p, "d:") {
+			legacy = ""
+			for _, p := range strings.Split(strings.TrimPrefix(p, "d:"), ".") {
+				p, err = atobUrl(p)
+				if err != nil {
+					return
+				}
+				addDep(p)
+			}
+		} else {
+			if strings.HasPrefix(p, "alias:") {
+				legacy = "alias"
+				p = strings.TrimPrefix(p, "alias:")
+			} else if strings.HasPrefix(p, "deps:") {
+				legacy = "deps"
+				p = strings.TrimPrefix(p, "deps:")
+			}
+			p = strings.TrimSpace(p)
+			switch legacy {
+			case "alias":
+				name, to := utils.SplitByFirstByte(p, ':')
+				name = strings.TrimSpace(name)
+				to = strings.TrimSpace(to)
+				if name != "" && to != "" {
+					alias[name] = to
+				}
+			case "deps":
+				if strings.HasPrefix(p, "@") && !strings.Contains(p, "/") {
+					scope, name := utils.SplitByFirstByte(p, '_')
+					p = scope + "/" + name
+				}
+				addDep(p)
+			}
+		}
+	}
+	return
+}
+
 func (task *BuildTask) ID() string {
 	if task.id != "" {
 		return task.id
@@ -92,7 +310,229 @@ func (task *BuildTask) ID() string {
 	return task.id
 }
 
+// validConditions defines the custom conditions of the package `exports` that can be
+// specified by the `conditions` query, the `import` condition is used by default.
+var validConditions = map[string]bool{
+	"require":      true,
+	"worker":       true,
+	"deno":         true,
+	"development":  true,
+	"production":   true,
+	"react-native": true,
+}
+
+// isValidOptimizeFor checks whether the mode of the `optimize` query is valid:
+// `size` for the aggressive minification and tree shaking, `speed` for the less
+// optimization work (whitespace minification only)
+func isValidOptimizeFor(mode string) bool {
+	return mode == "size" || mode == "speed"
+}
+
+// the default filename of the synthetic stdin entry point, can be overridden by
+// the `sourcefile` query
+const defaultSourcefile = "mod.js"
+
+var regSourcefile = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// isValidSourcefile checks whether the filename of the `sourcefile` query is valid
+func isValidSourcefile(name string) bool {
+	return len(name) <= 64 && regSourcefile.MatchString(name)
+}
+
+// sourcefile returns the filename of the synthetic stdin entry point in the error
+// messages and the source maps
+func (task *BuildTask) sourcefile() string {
+	if task.Sourcefile != "" {
+		return task.Sourcefile
+	}
+	return defaultSourcefile
+}
+
+// the max number of the `process.env` overrides specified by the `env.<KEY>` query
+const maxEnvOverrides = 10
+
+// the keys of the `env.<KEY>` query are restricted to the uppercase names, the
+// `NODE_ENV` is reserved for the `dev` query
+var regEnvKey = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+var regEnvNumber = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// isValidEnvKey checks whether the key of the `env.<KEY>` query is valid
+func isValidEnvKey(key string) bool {
+	return key != "NODE_ENV" && regEnvKey.MatchString(key)
+}
+
+// envDefineValue returns the define value of the env override for esbuild, the
+// booleans, `null` and the numbers are kept as literals, others are strings.
+func envDefineValue(value string) string {
+	if value == "true" || value == "false" || value == "null" {
+		return value
+	}
+	if regEnvNumber.MatchString(value) {
+		return value
+	}
+	return strings.TrimSpace(string(utils.MustEncodeJSON(value)))
+}
+
+var regGlobalName = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[A-Za-z_$][\w$]*)*$`)
+
+// isValidGlobalName checks whether the global variable of the `global-externals`
+// query is valid, like `React` or `window.React`.
+func isValidGlobalName(name string) bool {
+	return regGlobalName.MatchString(name)
+}
+
+// globalExternalIdentifier returns the identifier of the global external package
+// like `_react$`.
+func globalExternalIdentifier(pkgName string) string {
+	return "_" + identify(pkgName) + "$"
+}
+
+// hasCondition returns true if the condition is specified by the `conditions` query
+func (task *BuildTask) hasCondition(condition string) bool {
+	for _, c := range task.Conditions {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
+
+// isCJSOnly returns true if the CommonJS `main` entry should be used, the `require`
+// condition prefers the CommonJS `main` entry resolved from the `require` key of
+// the package `exports`
+func (task *BuildTask) isCJSOnly() bool {
+	return task.CJSOnly || (task.hasCondition("require") && !task.NodeESM)
+}
+
+// isExternal returns true if the package is specified by the `external` query
+func (task *BuildTask) isExternal(pkgName string) bool {
+	for _, name := range task.External {
+		if name == pkgName {
+			return true
+		}
+	}
+	return false
+}
+
+// the ways how the dependencies are resolved by `resolveDependency`
+const (
+	resolvedViaDeps           = "deps"
+	resolvedViaExternal       = "external"
+	resolvedViaDependency     = "dependency"
+	resolvedViaPeerDependency = "peerDependency"
+	resolvedViaInstalled      = "installed"
+	resolvedViaRegistry       = "registry"
+)
+
+// resolvedDependency defines the package that a bare specifier is resolved to, the
+// `Pkg` is nil if the specifier is kept as is by the `external` query.
+type resolvedDependency struct {
+	Pkg       *Pkg
+	Via       string
+	Installed bool
+}
+
+// resolveDependency resolves the bare specifier imported by the package with the
+// `deps` query, the `external` query, the installed packages in the wd, and the npm
+// registry in order. It returns nil if the specifier can't be resolved.
+func (task *BuildTask) resolveDependency(name string, esm *ESM) (*resolvedDependency, error) {
+	pkgName, submodule := splitPkgPath(name)
+
+	// get package info via `deps` query
+	for _, dep := range task.Deps {
+		if name == dep.Name || strings.HasPrefix(name, dep.Name+"/") {
+			return &resolvedDependency{
+				Pkg: &Pkg{
+					Name:      dep.Name,
+					Version:   dep.Version,
+					Submodule: strings.TrimPrefix(strings.TrimPrefix(name, dep.Name), "/"),
+				},
+				Via: resolvedViaDeps,
+			}, nil
+		}
+	}
+
+	// the packages specified by the `external` query are kept as the bare specifiers
+	// for the import maps, unless the version can be guessed from the installed
+	// dependencies
+	if task.isExternal(pkgName) {
+		dep := &resolvedDependency{Via: resolvedViaExternal}
+		if packageFile, e := findPackageJSON(task.wd, pkgName); e == nil {
+			var p NpmPackage
+			if utils.ParseJSONFile(packageFile, &p) == nil && p.Version != "" {
+				dep.Pkg = &Pkg{Name: pkgName, Version: p.Version, Submodule: submodule}
+			}
+		}
+		return dep, nil
+	}
+
+	// the version of the dependency is specified by the package.json of the package
+	via := resolvedViaRegistry
+	version := "latest"
+	if v, ok := esm.Dependencies[name]; ok {
+		via, version = resolvedViaDependency, v
+	} else if v, ok := esm.PeerDependencies[name]; ok {
+		via, version = resolvedViaPeerDependency, v
+	}
+
+	// the installed dependency
+	if packageFile, e := findPackageJSON(task.wd, pkgName); e == nil {
+		var p NpmPackage
+		err := utils.ParseJSONFile(packageFile, &p)
+		if err != nil {
+			return nil, err
+		}
+		if via == resolvedViaRegistry {
+			via = resolvedViaInstalled
+		}
+		return &resolvedDependency{
+			Pkg:       &Pkg{Name: pkgName, Version: p.Version, Submodule: submodule},
+			Via:       via,
+			Installed: true,
+		}, nil
+	}
+
+	// get package info from NPM
+	p, submodule, _, e := getPackageInfo(task.wd, name, version)
+	if e != nil {
+		return nil, nil
+	}
+	return &resolvedDependency{
+		Pkg: &Pkg{Name: p.Name, Version: p.Version, Submodule: submodule},
+		Via: via,
+	}, nil
+}
+
+// resolveAlias resolves the specifier by the `alias` query, if both sides of an alias
+// are package names the submodules are aliased as well, like `@myorg/react/hooks` ->
+// `react/hooks` with the `@myorg/react:react` alias.
+func (task *BuildTask) resolveAlias(specifier string) string {
+	if to, ok := task.Alias[specifier]; ok {
+		return to
+	}
+	for name, to := range task.Alias {
+		if isPackageName(name) && isPackageName(to) && strings.HasPrefix(specifier, name+"/") {
+			return to + strings.TrimPrefix(specifier, name)
+		}
+	}
+	return specifier
+}
+
+// getImportPath returns the import path of the dependency, the `alias` mapping like
+// `react:react16alias` with the dependency `react16alias@16` is respected, the
+// aliased package keeps its own name in the path so that different versions of
+// the same package get distinct URLs and can be loaded side by side.
 func (task *BuildTask) getImportPath(pkg Pkg, extendsAlias bool) string {
+	if to, ok := task.Alias[pkg.Name]; ok && pkg.Name != task.Pkg.Name && isPackageName(to) {
+		for _, dep := range task.Deps {
+			if dep.Name == to {
+				pkg.Name = dep.Name
+				pkg.Version = dep.Version
+				break
+			}
+		}
+	}
+
 	name := path.Base(pkg.Name)
 	if pkg.Submodule != "" {
 		name = pkg.Submodule
@@ -104,7 +544,8 @@ func (task *BuildTask) getImportPath(pkg Pkg, extendsAlias bool) string {
 
 	var resolvePrefix string
 	if extendsAlias {
-		resolvePrefix = task.resolvePrefix()
+		// the dependencies only inherit the `alias`, `deps`, `external` and `global-externals` of the task
+		resolvePrefix = (&BuildTask{Alias: task.Alias, Deps: task.Deps, External: task.External, GlobalExternals: task.GlobalExternals}).resolvePrefix()
 	}
 
 	return fmt.Sprintf(
@@ -118,17 +559,78 @@ func (task *BuildTask) getImportPath(pkg Pkg, extendsAlias bool) string {
 	)
 }
 
+// getBuiltInPolyfillPath returns the import path of the polyfill of the built-in node
+// module for the target of the task, and the version if the polyfill is a npm package.
+// It returns empty if the module is not supported for the target.
+func (task *BuildTask) getBuiltInPolyfillPath(name string) (importPath string, version string, err error) {
+	polyfill := resolveBuiltInPolyfill(task.Target, name)
+	if polyfill == "" {
+		return
+	}
+	if task.Target == "node" || isRemoteImport(polyfill) {
+		importPath = polyfill
+	} else if strings.HasPrefix(polyfill, "node_") && strings.HasSuffix(polyfill, ".js") {
+		importPath = fmt.Sprintf("/v%d/%s", task.BuildVersion, polyfill)
+	} else {
+		p, submodule, _, e := getPackageInfo(task.wd, polyfill, "latest")
+		if e != nil {
+			err = e
+			return
+		}
+		importPath = strings.TrimSuffix(task.getImportPath(Pkg{
+			Name:      p.Name,
+			Version:   p.Version,
+			Submodule: submodule,
+		}, false), ".js") + ".bundle.js"
+		version = p.Version
+	}
+	return
+}
+
+// the stage of the running tasks is read by the build events subscribers
+var buildStageLock sync.RWMutex
+
+func (task *BuildTask) setStage(stage string) {
+	task.recordStage()
+	buildStageLock.Lock()
+	task.stage = stage
+	buildStageLock.Unlock()
+	broadcastBuildEvent(task.ID(), map[string]interface{}{"event": "stage", "stage": stage})
+}
+
+func (task *BuildTask) getStage() string {
+	buildStageLock.RLock()
+	defer buildStageLock.RUnlock()
+	return task.stage
+}
+
 func (task *BuildTask) Build() (esm *ESM, err error) {
-	prev, err := findESM(task.ID())
-	if err == nil {
-		return prev, nil
+	// the dry-run build always runs the pipeline to show what it produces
+	if !task.DryRun {
+		prev, err := findESM(task.ID())
+		if err == nil {
+			return prev, nil
+		}
+		if _, ok := err.(*FailedBuildError); ok {
+			return nil, err
+		}
 	}
 
 	if task.wd == "" {
 		hasher := sha1.New()
 		hasher.Write([]byte(task.ID()))
-		task.wd = path.Join(os.TempDir(), fmt.Sprintf("esm-build-%s-%s", hex.EncodeToString(hasher.Sum(nil)), rs.Hex.String(8)))
-		ensureDir(task.wd)
+		task.wd = tempDir(fmt.Sprintf("esm-build-%s-%s", hex.EncodeToString(hasher.Sum(nil)), rs.Hex.String(8)))
+		err = os.Mkdir(task.wd, 0755)
+		if os.IsExist(err) {
+			// the random suffix is repeated or a stale dir is left, reuse it
+			log.Warnf("build(%s): reuse the existing dir %s", task.ID(), task.wd)
+			err = nil
+		} else if err != nil {
+			err = os.MkdirAll(task.wd, 0755)
+		}
+		if err != nil {
+			return
+		}
 	}
 	defer func() {
 		err := os.RemoveAll(task.wd)
@@ -137,35 +639,99 @@ func (task *BuildTask) Build() (esm *ESM, err error) {
 		}
 	}()
 
-	task.stage = "install-deps"
-	err = yarnAdd(task.wd, fmt.Sprintf("%s@%s", task.Pkg.Name, task.Pkg.Version))
+	task.setStage("install-deps")
+	if host, user, repo := splitGitPkgName(task.Pkg.Name); host != "" {
+		err = installFromGit(task.wd, host, user, repo, task.Pkg.Version)
+	} else {
+		err = retryYarnAdd(task.wd, fmt.Sprintf("%s@%s", task.Pkg.Name, task.Pkg.Version), 3, time.Second, task.installFlags()...)
+	}
 	if err != nil {
 		log.Error("install deps:", err)
 		return
 	}
 
-	return task.build(newStringSet())
+	esm, err = task.build(newStringSet())
+	if err == nil && task.Target != "types" && !task.DryRun {
+		if err := writeDepsMap(task); err != nil {
+			log.Warnf("build(%s): write deps map: %v", task.ID(), err)
+		}
+		// build the sub-modules that are deferred by the circular dependencies
+		for _, t := range task.circularDeps {
+			buildQueue.tryAdd(t.clone())
+		}
+	}
+	return
 }
 
+// clone returns a copy of the task without the build state
+func (task *BuildTask) clone() *BuildTask {
+	t := *task
+	t.id = ""
+	t.wd = ""
+	t.stage = ""
+	t.stageStart = time.Time{}
+	t.checksum = ""
+	t.stats = nil
+	t.circularDeps = nil
+	return &t
+}
+
+// maxBuildAttempts is the maximum number of the esbuild attempts in a build, the
+// build is re-attempted when a missing module is marked as external.
+const maxBuildAttempts = 10
+
 func (task *BuildTask) build(tracing *stringSet) (esm *ESM, err error) {
 	if tracing.Has(task.ID()) {
+		esm = &ESM{NpmPackage: &NpmPackage{}, CircularDep: true}
 		return
 	}
 	tracing.Add(task.ID())
 
-	task.stage = "init"
-	esm, err = initESM(task.wd, task.Pkg, task.Target != "types", task.DevMode)
+	task.setStage("init")
+	cjsOnly := task.isCJSOnly()
+	esm, err = initESM(task.wd, task.Pkg, task.Target != "types", task.DevMode, cjsOnly)
 	if err != nil {
 		return
 	}
 
 	if task.Target == "types" {
-		task.stage = "copy-dts"
+		task.setStage("copy-dts")
 		task.transformDTS(esm)
 		return
 	}
 
-	task.stage = "build"
+	// the module replacements of the `browser` field like `{"ws": "isomorphic-ws"}`,
+	// the relative replacements are resolved by esbuild for the browser platform
+	browserAlias := map[string]string{}
+	if isBrowserTarget(task.Target) {
+		if esm.BrowserString != "" && task.Pkg.Submodule == "" {
+			esm.Main = esm.BrowserString
+		}
+		for name, to := range esm.BrowserMap {
+			if !isLocalImport(name) {
+				browserAlias[name] = to
+			}
+		}
+	}
+
+	// the `paths` of the tsconfig.json like `{"@utils/*": ["src/utils/*"]}` are resolved
+	// for the imports of the package files
+	tsPaths, e := readTsconfigPaths(path.Join(task.wd, "node_modules", esm.Name))
+	if e != nil {
+		log.Warnf("build(%s): tsconfig.json: %v", task.ID(), e)
+	}
+
+	esm.EngineWarning = checkEngines(esm.NpmPackage, task.Target)
+	if esm.EngineWarning != "" {
+		log.Warnf("build(%s): %s", task.ID(), esm.EngineWarning)
+	}
+
+	if esm.NativeAddon {
+		err = ErrNativeAddon
+		return
+	}
+
+	task.setStage("build")
 	defer func() {
 		if err != nil {
 			esm = nil
@@ -175,10 +741,49 @@ func (task *BuildTask) build(tracing *stringSet) (esm *ESM, err error) {
 	var entryPoint string
 	var input *api.StdinOptions
 
-	if esm.Module == "" {
+	if task.Entrypoint != "" {
+		// the entrypoint must be within the package directory
+		pkgDir := path.Join(task.wd, "node_modules", esm.Name)
+		entryPoint = path.Join(pkgDir, task.Entrypoint)
+		if !strings.HasPrefix(entryPoint, pkgDir+"/") || !fileExists(entryPoint) {
+			err = fmt.Errorf("invalid entrypoint '%s'", task.Entrypoint)
+			return
+		}
+	} else if task.ExportsOnly {
+		exports := esm.Exports
+		if esm.Module != "" {
+			_, _, exports, err = checkESM(task.wd, esm.Name, esm.Module)
+			if err != nil {
+				return
+			}
+		}
+		if len(exports) == 0 {
+			err = fmt.Errorf("no named exports found in '%s'", task.Pkg.ImportPath())
+			return
+		}
+		// re-export the named exports only, the unused code of the `default` export
+		// will be dropped by the tree shaking of the bundle mode
+		input = &api.StdinOptions{
+			Contents:   fmt.Sprintf(`export { %s } from "%s";`, strings.Join(exports, ","), task.Pkg.ImportPath()),
+			ResolveDir: task.wd,
+			Sourcefile: task.sourcefile(),
+		}
+		esm.ExportDefault = false
+		esm.Exports = exports
+	} else if esm.Module == "" {
 		buf := bytes.NewBuffer(nil)
 		importPath := task.Pkg.ImportPath()
-		if len(esm.Exports) > 0 {
+		if cjsOnly && esm.Main != "" {
+			// import the `main` file directly, the bundler may pick the `module` field for the package name
+			importPath = path.Join(task.wd, "node_modules", esm.Name, esm.Main)
+		}
+		if len(esm.Exports) > 0 && task.AsyncExports {
+			// wrap the named exports in the lazy getters like `export const foo = () => __mod$.foo`
+			fmt.Fprintf(buf, `import * as __mod$ from "%s";%s`, importPath, "\n")
+			for _, name := range esm.Exports {
+				fmt.Fprintf(buf, `export const %s = () => __mod$.%s;%s`, name, name, "\n")
+			}
+		} else if len(esm.Exports) > 0 {
 			fmt.Fprintf(buf, `import * as __star from "%s";%s`, importPath, "\n")
 			fmt.Fprintf(buf, `export const { %s } = __star;%s`, strings.Join(esm.Exports, ","), "\n")
 		}
@@ -186,7 +791,7 @@ func (task *BuildTask) build(tracing *stringSet) (esm *ESM, err error) {
 		input = &api.StdinOptions{
 			Contents:   buf.String(),
 			ResolveDir: task.wd,
-			Sourcefile: "mod.js",
+			Sourcefile: task.sourcefile(),
 		}
 	} else {
 		entryPoint = path.Join(task.wd, "node_modules", esm.Name, esm.Module)
@@ -213,8 +818,45 @@ func (task *BuildTask) build(tracing *stringSet) (esm *ESM, err error) {
 		"global.require.resolve":      "__rResolve$",
 		"global.process.env.NODE_ENV": fmt.Sprintf(`"%s"`, nodeEnv),
 	}
+	for key, value := range task.Env {
+		define["process.env."+key] = envDefineValue(value)
+		define["global.process.env."+key] = envDefineValue(value)
+	}
+	if task.Locale != "" {
+		define["__LOCALE__"] = fmt.Sprintf(`"%s"`, task.Locale)
+	}
+	if task.Target == "deno" {
+		// deno supports the `import.meta.url` natively
+		define["__filename"] = "__filename$"
+		define["__dirname"] = "__dirname$"
+	}
+
+	// links the workspace packages of the monorepo package to node_modules instead
+	// of the npm ones, they are bundled since they may be not published to npm
+	workspaceDeps := newStringSet()
+	if len(esm.Workspaces) > 0 {
+		pkgDir := path.Join(task.wd, "node_modules", esm.Name)
+		for name := range esm.Dependencies {
+			dir, e := resolveWorkspaceDep(pkgDir, esm.Workspaces, name)
+			if e != nil {
+				continue
+			}
+			linkPath := path.Join(task.wd, "node_modules", name)
+			os.RemoveAll(linkPath)
+			err = ensureDir(path.Dir(linkPath))
+			if err == nil {
+				err = os.Symlink(dir, linkPath)
+			}
+			if err != nil {
+				return
+			}
+			workspaceDeps.Add(name)
+		}
+	}
+
 	external := newStringSet()
 	extraExternal := newStringSet()
+	wasmFiles := newStringSet()
 	esmResolverPlugin := api.Plugin{
 		Name: "esm.sh-resolver",
 		Setup: func(build api.PluginBuild) {
@@ -225,18 +867,82 @@ func (task *BuildTask) build(tracing *stringSet) (esm *ESM, err error) {
 						return api.OnResolveResult{External: true}, nil
 					}
 
+					// virtual modules like `virtual:modules` are provided by the node services
+					if strings.HasPrefix(args.Path, "virtual:") {
+						return api.OnResolveResult{Path: args.Path, Namespace: "virtual"}, nil
+					}
+
+					if (task.WasmInstantiate || task.WasmStreaming) && strings.HasSuffix(args.Path, ".wasm") && isLocalImport(args.Path) {
+						filename := args.Path
+						if !path.IsAbs(filename) {
+							filename = path.Join(args.ResolveDir, filename)
+						}
+						return api.OnResolveResult{Path: filename, Namespace: "wasm"}, nil
+					}
+
 					specifier := strings.TrimSuffix(args.Path, "/")
 
 					// resolve `?alias` query
 					if len(task.Alias) > 0 {
-						if name, ok := task.Alias[specifier]; ok {
-							specifier = name
+						specifier = task.resolveAlias(specifier)
+					}
+
+					// resolve the `paths` of the tsconfig.json for the package files
+					if pkgDir := path.Join(task.wd, "node_modules", esm.Name); tsPaths != nil && !isLocalImport(specifier) && strings.HasPrefix(args.Importer, pkgDir+"/") && !strings.Contains(strings.TrimPrefix(args.Importer, pkgDir), "/node_modules/") {
+						for _, candidate := range tsPaths.match(specifier) {
+							if resolved, e := resolveExportPath(pkgDir, candidate); e == nil && fileExists(path.Join(pkgDir, resolved)) {
+								return api.OnResolveResult{Path: path.Join(pkgDir, resolved)}, nil
+							}
+						}
+					}
+
+					// resolve the module replacements of the `browser` field
+					if to, ok := browserAlias[specifier]; ok {
+						if isLocalImport(to) {
+							return api.OnResolveResult{Path: path.Join(task.wd, "node_modules", esm.Name, to)}, nil
 						}
+						specifier = to
 					}
 
 					// resolve nodejs builtin modules like `node:path`
 					specifier = strings.TrimPrefix(specifier, "node:")
 
+					// the locale data modules only include the data of the `locale` query
+					if specifier, ok := task.resolveLocaleModule(specifier); ok {
+						return api.OnResolveResult{Path: specifier, Namespace: "locale"}, nil
+					}
+
+					// the packages specified by the `global-externals` query are replaced with
+					// the global variables like `window.React`
+					if _, ok := task.GlobalExternals[specifier]; ok {
+						return api.OnResolveResult{Path: specifier, Namespace: "global"}, nil
+					}
+
+					// bundles json files verbatim since they are not es modules to import
+					if strings.HasSuffix(specifier, ".json") || strings.HasSuffix(specifier, ".jsonc") {
+						return api.OnResolveResult{}, nil
+					}
+
+					// the packages specified by the `external` query are always external
+					if len(task.External) > 0 {
+						if pkgName, _ := splitPkgPath(specifier); task.isExternal(pkgName) {
+							external.Add(specifier)
+							return api.OnResolveResult{Path: "__ESM_SH_EXTERNAL:" + specifier, External: true}, nil
+						}
+					}
+
+					// bundles the workspace packages
+					if workspaceDeps.Size() > 0 {
+						a := strings.Split(specifier, "/")
+						pkgName := a[0]
+						if len(a) > 1 && specifier[0] == '@' {
+							pkgName = a[0] + "/" + a[1]
+						}
+						if workspaceDeps.Has(pkgName) {
+							return api.OnResolveResult{}, nil
+						}
+					}
+
 					// bundles all dependencies except in `bundle` mode, apart from peer dependencies
 					if task.BundleMode && !extraExternal.Has(specifier) {
 						a := strings.Split(specifier, "/")
@@ -261,15 +967,18 @@ func (task *BuildTask) build(tracing *stringSet) (esm *ESM, err error) {
 							resolvedPath = strings.TrimPrefix(resolvedPath, "/private")
 						}
 						resolved := "." + strings.TrimPrefix(resolvedPath, path.Join(task.wd, "node_modules", esm.Name))
-						m, ok := esm.DefinedExports.(map[string]interface{})
-						if ok {
-							for export, paths := range m {
-								m, ok := paths.(map[string]interface{})
-								if ok && export != "." {
-									for _, value := range m {
-										s, ok := value.(string)
-										if ok && s != "" {
-											match := resolved == s || resolved+".js" == s || resolved+".mjs" == s
+						if esm.DefinedExports != nil && esm.DefinedExports.IsSubpaths() {
+							for _, export := range esm.DefinedExports.Keys {
+								paths := esm.DefinedExports.Values[export]
+								if paths.Kind == ExportsObject && export != "." {
+									for _, key := range paths.Keys {
+										s := paths.Values[key].Path
+										if s != "" {
+											// the `exports` value may omit the extension like `./dist/index`
+											if !strings.Contains(s, "*") {
+												s = resolveExportPathOrSelf(path.Join(task.wd, "node_modules", esm.Name), s)
+											}
+											match := resolved == s || resolved+".js" == s || resolved+".mjs" == s || resolved+".cjs" == s
 											if !match {
 												if a := strings.Split(s, "*"); len(a) == 2 {
 													prefix := a[0]
@@ -311,45 +1020,87 @@ func (task *BuildTask) build(tracing *stringSet) (esm *ESM, err error) {
 					return api.OnResolveResult{Path: "__ESM_SH_EXTERNAL:" + specifier, External: true}, nil
 				},
 			)
+			build.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: "virtual"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					ret, err := loadVirtualModule(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					loader, ok := loaders["."+ret.Loader]
+					if !ok {
+						loader = api.LoaderJS
+					}
+					return api.OnLoadResult{
+						Contents:   &ret.Code,
+						Loader:     loader,
+						ResolveDir: task.wd,
+					}, nil
+				},
+			)
+			build.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: "locale"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					ret, err := loadLocaleModule(task.wd, args.Path, task.Locale)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					return api.OnLoadResult{
+						Contents:   &ret.Code,
+						Loader:     api.LoaderJS,
+						ResolveDir: task.wd,
+					}, nil
+				},
+			)
+			build.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: "global"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					identifier := globalExternalIdentifier(args.Path)
+					code := fmt.Sprintf("var %s = %s;\nmodule.exports = %s;", identifier, task.GlobalExternals[args.Path], identifier)
+					return api.OnLoadResult{
+						Contents: &code,
+						Loader:   api.LoaderJS,
+					}, nil
+				},
+			)
+			build.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: "wasm"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					code, wasmFile, err := loadWasm(task, args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					if wasmFile != "" {
+						wasmFiles.Add(wasmFile)
+					}
+					return api.OnLoadResult{
+						Contents:   &code,
+						Loader:     api.LoaderJS,
+						ResolveDir: task.wd,
+					}, nil
+				},
+			)
 		},
 	}
 
-esbuild:
 	start := time.Now()
-	options := api.BuildOptions{
-		Outdir:            "/esbuild",
-		Write:             false,
-		Bundle:            true,
-		Target:            targets[task.Target],
-		Format:            api.FormatESModule,
-		Platform:          api.PlatformBrowser,
-		MinifyWhitespace:  !task.DevMode,
-		MinifyIdentifiers: !task.DevMode,
-		MinifySyntax:      !task.DevMode,
-		Plugins:           []api.Plugin{esmResolverPlugin},
-		Loader: map[string]api.Loader{
-			".wasm":  api.LoaderBinary,
-			".svg":   api.LoaderDataURL,
-			".png":   api.LoaderDataURL,
-			".webp":  api.LoaderDataURL,
-			".ttf":   api.LoaderDataURL,
-			".eot":   api.LoaderDataURL,
-			".woff":  api.LoaderDataURL,
-			".woff2": api.LoaderDataURL,
-		},
-	}
-	if task.Target == "node" {
-		options.Platform = api.PlatformNode
-	} else {
-		options.Define = define
-	}
-	if entryPoint != "" {
-		options.EntryPoints = []string{entryPoint}
-	} else {
-		options.Stdin = input
-	}
-	result := api.Build(options)
-	if len(result.Errors) > 0 {
+	var result api.BuildResult
+	for attempt := 0; attempt < maxBuildAttempts; attempt++ {
+		options := task.esbuildOptions()
+		options.Plugins = []api.Plugin{esmResolverPlugin}
+		if task.Target != "node" {
+			options.Define = define
+		}
+		if entryPoint != "" {
+			options.EntryPoints = []string{entryPoint}
+		} else {
+			options.Stdin = input
+		}
+		result = api.Build(options)
+		if len(result.Errors) == 0 {
+			break
+		}
+
 		// mark the missing module as external to exclude it from the bundle
 		msg := result.Errors[0].Text
 		if strings.HasPrefix(msg, "Could not resolve \"") && strings.Contains(msg, "mark it as external to exclude it from the bundle") {
@@ -363,24 +1114,35 @@ esbuild:
 			if !extraExternal.Has(name) {
 				extraExternal.Add(name)
 				external.Add(name)
-				goto esbuild
+				log.Infof("esbuild(%s): re-build with the external module '%s' (attempt %d)", task.ID(), name, attempt+2)
+				continue
 			}
 		} else if strings.HasPrefix(msg, "No matching export in \"") && strings.Contains(msg, "for import \"default\"") {
 			input = &api.StdinOptions{
 				Contents:   fmt.Sprintf(`import "%s";export default null;`, task.Pkg.ImportPath()),
 				ResolveDir: task.wd,
-				Sourcefile: "mod.js",
+				Sourcefile: task.sourcefile(),
 			}
-			goto esbuild
+			log.Infof("esbuild(%s): re-build without the default export (attempt %d)", task.ID(), attempt+2)
+			continue
 		}
 		err = errors.New("esbuild: " + msg)
 		return
 	}
+	if len(result.Errors) > 0 {
+		err = fmt.Errorf("esbuild: too many attempts: %s", result.Errors[0].Text)
+		return
+	}
 
 	for _, w := range result.Warnings {
 		log.Warnf("esbuild(%s): %s", task.ID(), w.Text)
 	}
 
+	entryCSS := "stdin.css"
+	if entryPoint != "" {
+		entryCSS = strings.TrimSuffix(path.Base(entryPoint), path.Ext(entryPoint)) + ".css"
+	}
+	var jsContent []byte
 	for _, file := range result.OutputFiles {
 		outputContent := file.Contents
 		if strings.HasSuffix(file.Path, ".js") {
@@ -391,11 +1153,12 @@ esbuild:
 				nodeEnv,
 			))
 			eol := "\n"
-			if !task.DevMode {
+			if !task.DevMode && !task.NoMinifyWhitespace {
 				eol = ""
 			}
 
 			// replace external imports/requires
+			usedIdentifiers := map[string]string{}
 			for _, name := range external.Values() {
 				var importPath string
 				// remote imports
@@ -411,143 +1174,84 @@ esbuild:
 						Submodule: submodule,
 					}
 					subTask := &BuildTask{
-						BuildVersion: task.BuildVersion,
-						wd:           task.wd, // reuse current wd
-						Pkg:          subPkg,
-						Alias:        task.Alias,
-						Deps:         task.Deps,
-						Target:       task.Target,
-						DevMode:      task.DevMode,
-					}
-					subTask.build(tracing)
-					if err != nil {
+						BuildVersion:    task.BuildVersion,
+						wd:              task.wd, // reuse current wd
+						Pkg:             subPkg,
+						Alias:           task.Alias,
+						Deps:            task.Deps,
+						External:        task.External,
+						GlobalExternals: task.GlobalExternals,
+						Target:          task.Target,
+						DevMode:         task.DevMode,
+						DryRun:          task.DryRun,
+					}
+					subESM, subErr := subTask.build(tracing)
+					if subErr != nil {
+						err = fmt.Errorf("build sub-module '%s': %v", name, subErr)
 						return
 					}
-					importPath = task.getImportPath(subPkg, true)
-				}
-				// is builtin `buffer` module
-				if importPath == "" && name == "buffer" {
-					if task.Target == "node" {
-						importPath = "buffer"
-					} else {
-						importPath = fmt.Sprintf("/v%d/node_buffer.js", task.BuildVersion)
+					task.circularDeps = append(task.circularDeps, subTask.circularDeps...)
+					if subESM != nil && subESM.CircularDep && !task.DryRun {
+						log.Warnf("build(%s): circular dependency '%s', deferred", task.ID(), subTask.ID())
+						task.circularDeps = append(task.circularDeps, subTask)
 					}
+					importPath = task.getImportPath(subPkg, true)
 				}
 				// is builtin node module
 				if importPath == "" && builtInNodeModules[name] {
-					if task.Target == "node" {
-						importPath = name
-					} else if task.Target == "deno" && denoStdNodeModules[name] {
-						importPath = fmt.Sprintf("https://deno.land/std@%s/node/%s.ts", denoStdNodeVersion, name)
-					} else {
-						polyfill, ok := polyfilledBuiltInNodeModules[name]
-						if ok {
-							p, submodule, _, e := getPackageInfo(task.wd, polyfill, "latest")
-							if e != nil {
-								err = e
-								return
-							}
-							importPath = task.getImportPath(Pkg{
-								Name:      p.Name,
-								Version:   p.Version,
-								Submodule: submodule,
-							}, false)
-							importPath = strings.TrimSuffix(importPath, ".js") + ".bundle.js"
-						} else {
-							_, err := embedFS.ReadFile(fmt.Sprintf("server/embed/polyfills/node_%s.js", name))
-							if err == nil {
-								importPath = fmt.Sprintf("/v%d/node_%s.js", task.BuildVersion, name)
-							} else {
-								importPath = fmt.Sprintf(
-									"/error.js?type=unsupported-nodejs-builtin-module&name=%s&importer=%s",
-									name,
-									task.Pkg.Name,
-								)
-							}
-						}
+					esm.NodeBuiltIns = append(esm.NodeBuiltIns, name)
+					importPath, _, err = task.getBuiltInPolyfillPath(name)
+					if err != nil {
+						return
 					}
-				}
-				// get package info via `deps` query
-				if importPath == "" {
-					for _, dep := range task.Deps {
-						if name == dep.Name || strings.HasPrefix(name, dep.Name+"/") {
-							var submodule string
-							if name != dep.Name {
-								submodule = strings.TrimPrefix(name, dep.Name+"/")
-							}
-							importPath = task.getImportPath(Pkg{
-								Name:      dep.Name,
-								Version:   dep.Version,
-								Submodule: submodule,
-							}, false)
-							break
-						}
+					if importPath == "" {
+						importPath = fmt.Sprintf(
+							"/error.js?type=unsupported-nodejs-builtin-module&name=%s&importer=%s",
+							name,
+							task.Pkg.Name,
+						)
 					}
 				}
-				// pre-build dependency
+				// resolve the dependency by the `deps` query, the `external` query, the
+				// installed packages or the npm registry
 				if importPath == "" {
-					var pkgName string
-					var submodule string
-					if a := strings.Split(name, "/"); strings.HasPrefix(name, "@") {
-						if len(a) >= 2 {
-							pkgName = strings.Join(a[:2], "/")
-							submodule = strings.Join(a[2:], "/")
-						}
-					} else {
-						pkgName = a[0]
-						submodule = strings.Join(a[1:], "/")
+					dep, e := task.resolveDependency(name, esm)
+					if e != nil {
+						err = e
+						return
 					}
-
-					packageFile := path.Join(task.wd, "node_modules", pkgName, "package.json")
-					if fileExists(packageFile) {
-						var p NpmPackage
-						err = utils.ParseJSONFile(path.Join(task.wd, "node_modules", pkgName, "package.json"), &p)
-						if err != nil {
-							return
-						}
-						t := &BuildTask{
-							BuildVersion: task.BuildVersion,
-							Pkg: Pkg{
-								Name:      pkgName,
-								Version:   p.Version,
-								Submodule: submodule,
-							},
-							Alias:   task.Alias,
-							Deps:    task.Deps,
-							Target:  task.Target,
-							DevMode: task.DevMode,
+					if dep != nil {
+						if dep.Pkg == nil {
+							// the external package is kept as the bare specifier
+							importPath = name
+						} else {
+							// pre-build the installed dependency
+							if dep.Installed && !task.DryRun {
+								buildQueue.tryAdd(&BuildTask{
+									BuildVersion: task.BuildVersion,
+									Pkg:          *dep.Pkg,
+									Alias:        task.Alias,
+									Deps:         task.Deps,
+									Target:       task.Target,
+									DevMode:      task.DevMode,
+								})
+							}
+							importPath = task.getImportPath(*dep.Pkg, false)
 						}
-						buildQueue.Add(t)
-						importPath = task.getImportPath(Pkg{
-							Name:      p.Name,
-							Version:   p.Version,
-							Submodule: submodule,
-						}, false)
-					}
-				}
-				// get package info from NPM
-				if importPath == "" {
-					version := "latest"
-					if v, ok := esm.Dependencies[name]; ok {
-						version = v
-					} else if v, ok := esm.PeerDependencies[name]; ok {
-						version = v
-					}
-					p, submodule, _, e := getPackageInfo(task.wd, name, version)
-					if e == nil {
-						importPath = task.getImportPath(Pkg{
-							Name:      p.Name,
-							Version:   p.Version,
-							Submodule: submodule,
-						}, false)
 					}
 				}
 				if importPath == "" {
 					err = fmt.Errorf("Could not resolve \"%s\" (Imported by \"%s\")", name, task.Pkg.Name)
 					return
 				}
+				if task.DebugExternals {
+					if esm.Externals == nil {
+						esm.Externals = map[string]string{}
+					}
+					esm.Externals[name] = importPath
+				}
 				buffer := bytes.NewBuffer(nil)
-				identifier := identify(name)
+				identifier := identifyUnique(name, usedIdentifiers)
 				slice := bytes.Split(outputContent, []byte(fmt.Sprintf("\"__ESM_SH_EXTERNAL:%s\"", name)))
 				cjsContext := false
 				cjsImports := newStringSet()
@@ -557,11 +1261,17 @@ esbuild:
 						var marked bool
 						if _, ok := builtInNodeModules[name]; !ok {
 							pkg, err := parsePkg(name)
-							if err == nil && !fileExists(path.Join(task.wd, "node_modules", pkg.Name, "package.json")) {
-								err = yarnAdd(task.wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version))
+							var parseErr *ParsePkgError
+							if errors.As(err, &parseErr) {
+								log.Warnf("build(%s): parse required package '%s': %v", task.ID(), parseErr.Specifier, err)
+							}
+							if err == nil {
+								if _, e := findPackageJSON(task.wd, pkg.Name); e != nil {
+									err = pkgManagerAdd(packageManager, task.wd, append(task.installFlags(), fmt.Sprintf("%s@%s", pkg.Name, pkg.Version))...)
+								}
 							}
 							if err == nil {
-								meta, err := initESM(task.wd, *pkg, true, task.DevMode)
+								meta, err := initESM(task.wd, *pkg, true, task.DevMode, false)
 								if err == nil {
 									if bytes.HasPrefix(p, []byte{'.'}) {
 										// right shift to strip the object `key`
@@ -661,6 +1371,12 @@ esbuild:
 				if bytes.Contains(outputContent, []byte("__rResolve$")) {
 					fmt.Fprintf(buf, `var __rResolve$ = p => p;%s`, eol)
 				}
+				if bytes.Contains(outputContent, []byte("__filename$")) {
+					fmt.Fprintf(buf, `var __filename$ = import.meta.url;%s`, eol)
+				}
+				if bytes.Contains(outputContent, []byte("__dirname$")) {
+					fmt.Fprintf(buf, `var __dirname$ = new URL(".", import.meta.url).href;%s`, eol)
+				}
 			}
 
 			_, err = buf.Write(outputContent)
@@ -668,33 +1384,179 @@ esbuild:
 				return
 			}
 
-			err = fs.WriteData(path.Join("builds", task.ID()), buf.Bytes())
-			if err != nil {
-				return
+			if task.ModuleWorker {
+				buf = bytes.NewBuffer(appendModuleWorkerScaffold(buf.Bytes()))
 			}
+
+			jsContent = buf.Bytes()
+			if e := writeBuildSizes(task, buf.Bytes()); e != nil {
+				log.Warnf("build(%s): write sizes: %v", task.ID(), e)
+			}
+			hasher := sha1.New()
+			hasher.Write(buf.Bytes())
+			task.checksum = hex.EncodeToString(hasher.Sum(nil))
 		} else if strings.HasSuffix(file.Path, ".css") {
-			err = fs.WriteData(path.Join("builds", strings.TrimSuffix(task.ID(), ".js")+".css"), outputContent)
-			if err != nil {
-				return
+			if path.Base(file.Path) == entryCSS {
+				err = task.writeFile(path.Join("builds", strings.TrimSuffix(task.ID(), ".js")+".css"), outputContent)
+				if err != nil {
+					return
+				}
+				esm.PackageCSS = true
+			} else if task.KeepCSS {
+				// keeps the css imported by inner modules as separate files
+				err = task.writeFile(path.Join("builds", strings.TrimSuffix(task.ID(), ".js"), path.Base(file.Path)), outputContent)
+				if err != nil {
+					return
+				}
+			} else {
+				log.Warnf("esbuild(%s): css output '%s' is dropped, use `?keep-css` to keep it", task.ID(), path.Base(file.Path))
 			}
-			esm.PackageCSS = true
+		}
+	}
+
+	if result.Metafile != "" {
+		e := writeBundleAnalysis(task, result.Metafile)
+		if e != nil {
+			log.Warnf("build(%s): write bundle analysis: %v", task.ID(), e)
+		}
+	}
+
+	if wasmFiles.Size() > 0 {
+		esm.WasmFiles = wasmFiles.Values()
+		sort.Strings(esm.WasmFiles)
+		if task.WasmStreaming && task.Target != "node" {
+			esm.WasmURL = "/" + esm.WasmFiles[0]
 		}
 	}
 
 	log.Debugf("esbuild %s %s %s in %v", task.Pkg.String(), task.Target, nodeEnv, time.Since(start))
 
-	task.stage = "copy-dts"
-	task.transformDTS(esm)
+	if task.NoTypes {
+		esm.Dts = ""
+	} else {
+		task.setStage("copy-dts")
+		task.transformDTS(esm)
+	}
+
+	// write the build file and store the build record together, see `buildLocks`
+	unlock := buildLocks.Lock(task.ID())
+	defer unlock()
+	if jsContent != nil {
+		err = task.writeFile(path.Join("builds", task.ID()), jsContent)
+		if err != nil {
+			return
+		}
+		if !noCompress && !task.DryRun {
+			// the raw build file is served if the pre-compression fails
+			if e := compressArtifacts(task.ID()); e != nil {
+				log.Warnf("compressArtifacts(%s): %v", task.ID(), e)
+			}
+		}
+	}
 	task.storeToDB(esm)
 	return
 }
 
+// writeFile writes the build file to the fs, it's a no-op for the dry-run build.
+func (task *BuildTask) writeFile(name string, data []byte) error {
+	if task.DryRun {
+		return nil
+	}
+	return fs.WriteData(name, data)
+}
+
+// the loaders of the package builds in addition to the default loaders of esbuild,
+// the assets like fonts and images are inlined as data URLs
+var pkgLoaders = map[string]api.Loader{
+	".json":  api.LoaderJSON,
+	".jsonc": api.LoaderJSON,
+	".wasm":  api.LoaderBinary,
+	".svg":   api.LoaderDataURL,
+	".png":   api.LoaderDataURL,
+	".webp":  api.LoaderDataURL,
+	".ttf":   api.LoaderDataURL,
+	".eot":   api.LoaderDataURL,
+	".woff":  api.LoaderDataURL,
+	".woff2": api.LoaderDataURL,
+}
+
+// esbuildOptions returns the esbuild options of the task without the plugins,
+// `define` and entry points.
+func (task *BuildTask) esbuildOptions() api.BuildOptions {
+	options := api.BuildOptions{
+		Outdir:            "/esbuild",
+		Write:             false,
+		Bundle:            true,
+		Target:            targets[task.Target],
+		Format:            api.FormatESModule,
+		Platform:          api.PlatformBrowser,
+		MinifyWhitespace:  !task.DevMode && !task.NoMinifyWhitespace,
+		MinifyIdentifiers: !task.DevMode && !task.NoMinifyIdentifiers,
+		MinifySyntax:      !task.DevMode && !task.NoMinifySyntax,
+		IgnoreAnnotations: task.IgnoreAnnotations,
+		Metafile:          true,
+		Loader:            make(map[string]api.Loader, len(pkgLoaders)),
+	}
+	for ext, loader := range pkgLoaders {
+		options.Loader[ext] = loader
+	}
+	if engine, ok := parseEngineTarget(task.Target); ok {
+		options.Engines = []api.Engine{engine}
+	} else if query, ok := parseBrowserslistTarget(task.Target); ok {
+		engines, err := resolveBrowserslistEngines(query)
+		if err != nil {
+			log.Warnf("build(%s): %v, fallback to es2015", task.ID(), err)
+			options.Target = api.ES2015
+		} else {
+			options.Engines = engines
+		}
+	}
+	switch task.OptimizeFor {
+	case "speed":
+		options.MinifyIdentifiers = false
+		options.MinifySyntax = false
+	case "size":
+		// the pure annotations are respected unless the `ignore-annotations` query
+		options.MinifySyntax = !task.NoMinifySyntax
+		options.TreeShaking = api.TreeShakingTrue
+	}
+	if task.IncludeComments {
+		// esbuild drops the other comments, only the legal comments (`/*!`, `@license`
+		// and `@preserve`) are kept, inline with the code they document
+		options.LegalComments = api.LegalCommentsInline
+	}
+	if len(task.Conditions) > 0 {
+		options.Conditions = task.Conditions
+	}
+	if task.Target == "node" {
+		options.Platform = api.PlatformNode
+		// the native ESM of node 18+ prefers the `import` condition, and the `require`
+		// function is created by `createRequire` as it's unavailable in ES modules
+		if task.NodeESM {
+			options.Conditions = append([]string{"import"}, options.Conditions...)
+			options.Banner = map[string]string{
+				"js": `import { createRequire as __createRequire$ } from "module";const require = __createRequire$(import.meta.url);`,
+			}
+		}
+	}
+	return options
+}
+
 func (task *BuildTask) storeToDB(esm *ESM) {
-	dbErr := db.Put(
+	task.setStage("store-db")
+	defer task.saveStats()
+
+	if task.DryRun {
+		return
+	}
+
+	dbErr := putDBWithRetry(
 		task.ID(),
 		"build",
 		storage.Store{
-			"esm": string(utils.MustEncodeJSON(esm)),
+			"id":       task.ID(),
+			"esm":      string(utils.MustEncodeJSON(esm)),
+			"checksum": task.checksum,
 		},
 	)
 	if dbErr != nil {
@@ -702,6 +1564,43 @@ func (task *BuildTask) storeToDB(esm *ESM) {
 	}
 }
 
+// installTypesPackage resolves the version of the `@types` package that matches the
+// version of the package by `<major>.<minor>`, `<major>` and `latest` in order, then
+// installs it in the build dir of the package to avoid the redundant installations.
+func (task *BuildTask) installTypesPackage(typesPkgName string) (p NpmPackage, err error) {
+	if packageFile, e := findPackageJSON(task.wd, typesPkgName); e == nil {
+		err = utils.ParseJSONFile(packageFile, &p)
+		return
+	}
+
+	versions := []string{"latest"}
+	if v, ok := parseVersionTuple(task.Pkg.Version); ok {
+		versions = []string{fmt.Sprintf("%d.%d", v[0], v[1]), fmt.Sprintf("%d", v[0]), "latest"}
+	}
+	for _, version := range versions {
+		p, _, _, err = getPackageInfo("", typesPkgName, version)
+		// stop if the `@types` package doesn't exist
+		if err == nil || !strings.HasPrefix(err.Error(), "npm: version") {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	err = pkgManagerAdd(packageManager, task.wd, append(task.installFlags(), fmt.Sprintf("%s@%s", p.Name, p.Version))...)
+	return
+}
+
+// installFlags returns the extra flags of the package manager to install the
+// packages of the task, the `--no-lockfile` flag makes a clean install that
+// doesn't read or write the lockfile. npm always installs with `--no-package-lock`.
+func (task *BuildTask) installFlags() []string {
+	if task.IgnoreLocks && packageManager != "npm" {
+		return []string{"--no-lockfile"}
+	}
+	return nil
+}
+
 func (task *BuildTask) transformDTS(esm *ESM) {
 	name := task.Pkg.Name
 	submodule := task.Pkg.Submodule
@@ -711,17 +1610,28 @@ func (task *BuildTask) transformDTS(esm *ESM) {
 	}
 
 	var dts string
+	esm.TypesResolution = "none"
 	if esm.Types != "" || esm.Typings != "" {
-		dts = toTypesPath(task.wd, *esm.NpmPackage, submodule)
+		dts = toTypesPath(task.wd, *esm.NpmPackage, submodule, task.Target != "node")
+		esm.TypesResolution = "package"
 	} else if !strings.HasPrefix(name, "@types/") && submodule == "" {
 		typesPkgName := toTypesPackageName(name)
-		p, _, _, err := getPackageInfo(task.wd, typesPkgName, "latest")
+		var p NpmPackage
+		var err error
+		if task.TypesAuto {
+			p, err = task.installTypesPackage(typesPkgName)
+		} else {
+			p, _, _, err = getPackageInfo(task.wd, typesPkgName, "latest")
+		}
 		if err == nil {
-			dts = toTypesPath(task.wd, p, submodule)
+			dts = toTypesPath(task.wd, p, submodule, task.Target != "node")
+			if dts != "" {
+				esm.TypesResolution = "atypes"
+			}
 		}
 	}
 
-	if strings.HasSuffix(dts, ".d.ts") && !strings.HasSuffix(dts, "~.d.ts") {
+	if isDtsFile(dts) && !strings.HasSuffix(dts, "~.d.ts") && !task.DryRun {
 		start := time.Now()
 		err := CopyDTS(
 			task.wd,
@@ -737,5 +1647,7 @@ func (task *BuildTask) transformDTS(esm *ESM) {
 
 	if dts != "" {
 		esm.Dts = fmt.Sprintf("/v%d/%s", task.BuildVersion, dts)
+		esm.DtsFormat = dtsExtname(dts)
+		esm.TypesZip = fmt.Sprintf("/v%d/%s@%s/+types", task.BuildVersion, task.Pkg.Name, task.Pkg.Version)
 	}
 }
@@ -0,0 +1,34 @@
+//go:build go1.18
+// +build go1.18
+
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzResolvePrefix(f *testing.F) {
+	f.Add("react", "preact/compat", "lodash", "4.17.21")
+	f.Add("@org/a,b", "c:d,e", "@babel/core", "7.16.0")
+	f.Add("a.b", "x|y", "pkg,name", "1.0.0-rc,1")
+	f.Fuzz(func(t *testing.T, name string, to string, depName string, depVersion string) {
+		if name == "" || to == "" || depName == "" || depVersion == "" || strings.Contains(depVersion, "@") {
+			t.Skip()
+		}
+		task := &BuildTask{
+			Alias: map[string]string{name: to},
+			Deps:  PkgSlice{{Name: depName, Version: depVersion}},
+		}
+		alias, deps, err := parseResolvePrefix(task.resolvePrefix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(alias) != 1 || alias[name] != to {
+			t.Fatalf("alias mismatch: %v", alias)
+		}
+		if len(deps) != 1 || deps[0].Name != depName || deps[0].Version != depVersion {
+			t.Fatalf("deps mismatch: %v", deps)
+		}
+	})
+}
@@ -0,0 +1,464 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+func TestResolvePrefix(t *testing.T) {
+	task := &BuildTask{
+		Alias: map[string]string{
+			"react":     "preact/compat",
+			"@org/a,b":  "c:d,e",
+			"react-dom": "preact/compat",
+		},
+		Deps: PkgSlice{
+			{Name: "@babel/core", Version: "7.16.0"},
+			{Name: "lodash", Version: "4.17.21+build,1"},
+		},
+		External:    []string{"react-dom", "react"},
+		ExportsOnly: true,
+	}
+	prefix := task.resolvePrefix()
+	if !strings.HasPrefix(prefix, "X-") || !strings.HasSuffix(prefix, "/") {
+		t.Fatalf("invalid resolve prefix: %s", prefix)
+	}
+
+	alias, deps, err := parseResolvePrefix(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(alias, task.Alias) {
+		t.Fatalf("alias mismatch: %v", alias)
+	}
+	sort.Sort(deps)
+	if !reflect.DeepEqual(deps, task.Deps) {
+		t.Fatalf("deps mismatch: %v", deps)
+	}
+	segments, err := decodeResolvePrefix(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments[len(segments)-1] != "exports-only" {
+		t.Fatalf("missing exports-only flag: %v", segments)
+	}
+	if segments[2] != "x:"+btoaUrl("react")+"."+btoaUrl("react-dom") {
+		t.Fatalf("invalid external segment: %v", segments)
+	}
+
+	// legacy format
+	alias, deps, err = parseResolvePrefix("X-" + btoaUrl("alias:react:preact/compat,react-dom:preact/compat,deps:@babel/core@7.16.0,lodash@4.17.21"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(alias, map[string]string{"react": "preact/compat", "react-dom": "preact/compat"}) {
+		t.Fatalf("legacy alias mismatch: %v", alias)
+	}
+	if !reflect.DeepEqual(deps, PkgSlice{{Name: "@babel/core", Version: "7.16.0"}, {Name: "lodash", Version: "4.17.21"}}) {
+		t.Fatalf("legacy deps mismatch: %v", deps)
+	}
+}
+
+// resolvePrefixInput is the random input of the `resolvePrefix` round-trip test, the
+// strings contain the delimiters like `,`, `:`, `.`, `@` and the non-ASCII chars.
+type resolvePrefixInput struct {
+	Alias map[string]string
+	Deps  PkgSlice
+}
+
+func (resolvePrefixInput) Generate(r *rand.Rand, size int) reflect.Value {
+	chars := []rune("abc-_/@.,:|=+ 别名🦕")
+	randString := func(exclude rune) string {
+		n := r.Intn(size+1) + 1
+		s := make([]rune, 0, n)
+		for len(s) < n {
+			if c := chars[r.Intn(len(chars))]; c != exclude {
+				s = append(s, c)
+			}
+		}
+		return string(s)
+	}
+	input := resolvePrefixInput{Alias: map[string]string{}}
+	for i := r.Intn(5); i > 0; i-- {
+		input.Alias[randString(0)] = randString(0)
+	}
+	for i := r.Intn(5); i > 0; i-- {
+		// the version is split by the last `@`
+		name := randString(0)
+		if !input.Deps.Has(name) {
+			input.Deps = append(input.Deps, Pkg{Name: name, Version: randString('@')})
+		}
+	}
+	return reflect.ValueOf(input)
+}
+
+func TestResolvePrefixRoundTrip(t *testing.T) {
+	err := quick.Check(func(input resolvePrefixInput) bool {
+		task := &BuildTask{Alias: input.Alias, Deps: input.Deps}
+		alias, deps, err := parseResolvePrefix(task.resolvePrefix())
+		if err != nil {
+			return false
+		}
+		sort.Sort(deps)
+		sort.Sort(input.Deps)
+		return reflect.DeepEqual(alias, input.Alias) && len(deps) == len(input.Deps) && (len(deps) == 0 || reflect.DeepEqual(deps, input.Deps))
+	}, &quick.Config{MaxCount: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetImportPathWithAlias(t *testing.T) {
+	task := &BuildTask{
+		BuildVersion: 57,
+		Pkg:          Pkg{Name: "react-dom", Version: "17.0.2"},
+		Alias:        map[string]string{"react": "react16alias"},
+		Deps:         PkgSlice{{Name: "react16alias", Version: "16.14.0"}},
+		Target:       "es2021",
+	}
+	for pkg, expected := range map[Pkg]string{
+		{Name: "react", Version: "17.0.2"}:                           "/v57/react16alias@16.14.0/es2021/react16alias.js",
+		{Name: "react", Version: "17.0.2", Submodule: "jsx-runtime"}: "/v57/react16alias@16.14.0/es2021/jsx-runtime.js",
+		{Name: "react16alias", Version: "16.14.0"}:                   "/v57/react16alias@16.14.0/es2021/react16alias.js",
+		{Name: "scheduler", Version: "0.20.2"}:                       "/v57/scheduler@0.20.2/es2021/scheduler.js",
+	} {
+		if importPath := task.getImportPath(pkg, false); importPath != expected {
+			t.Fatalf("getImportPath(%v) should be %s, but got %s", pkg, expected, importPath)
+		}
+	}
+
+	// the alias without the dependency is not applied
+	task.Deps = nil
+	if importPath := task.getImportPath(Pkg{Name: "react", Version: "17.0.2"}, false); importPath != "/v57/react@17.0.2/es2021/react.js" {
+		t.Fatalf("unexpected import path: %s", importPath)
+	}
+}
+
+func TestResolveAlias(t *testing.T) {
+	task := &BuildTask{
+		Alias: map[string]string{"@myorg/react": "react", "react-dom": "preact/compat", "lodash/get": "lodash.get"},
+	}
+	for specifier, expected := range map[string]string{
+		"@myorg/react":       "react",
+		"@myorg/react/hooks": "react/hooks",
+		"@myorg/react-dom":   "@myorg/react-dom",
+		"react-dom":          "preact/compat",
+		"react-dom/server":   "react-dom/server",
+		"lodash/get":         "lodash.get",
+		"lodash/set":         "lodash/set",
+	} {
+		if ret := task.resolveAlias(specifier); ret != expected {
+			t.Fatalf("resolveAlias(%s) should be %s, but got %s", specifier, expected, ret)
+		}
+	}
+}
+
+func TestBuildTaskClone(t *testing.T) {
+	task := &BuildTask{
+		BuildVersion: 58,
+		Pkg:          Pkg{Name: "foo", Version: "1.0.0", Submodule: "bar"},
+		Alias:        map[string]string{"react": "preact/compat"},
+		Target:       "es2021",
+		BundleMode:   true,
+		Conditions:   []string{"worker"},
+		KeepCSS:      true,
+		wd:           "/tmp/esm-build-foo",
+		stage:        "esbuild",
+		circularDeps: []*BuildTask{{Pkg: Pkg{Name: "foo", Version: "1.0.0"}}},
+	}
+	id := task.ID()
+	clone := task.clone()
+	if clone.ID() != id || !clone.BundleMode || !clone.KeepCSS || len(clone.Conditions) != 1 || clone.Alias["react"] != "preact/compat" {
+		t.Fatalf("the clone should keep the options: %+v", clone)
+	}
+	if clone.wd != "" || clone.stage != "" || clone.circularDeps != nil {
+		t.Fatalf("the clone should not keep the build state: %+v", clone)
+	}
+}
+
+func TestGlobalExternals(t *testing.T) {
+	task := &BuildTask{
+		GlobalExternals: map[string]string{"react-dom": "ReactDOM"},
+	}
+	segments, err := decodeResolvePrefix(task.resolvePrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || segments[0] != "g:"+btoaUrl("react-dom")+":"+btoaUrl("ReactDOM") {
+		t.Fatalf("invalid global-externals segment: %v", segments)
+	}
+
+	for name, valid := range map[string]bool{
+		"React":         true,
+		"window.React":  true,
+		"$":             true,
+		"_lib.v2":       true,
+		"1React":        false,
+		"React;alert()": false,
+		"window.":       false,
+		"":              false,
+	} {
+		if isValidGlobalName(name) != valid {
+			t.Fatalf("isValidGlobalName(%s) should be %v", name, valid)
+		}
+	}
+	if id := globalExternalIdentifier("react-dom"); id != "_react_dom$" {
+		t.Fatalf("unexpected identifier: %s", id)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	task := &BuildTask{
+		Env: map[string]string{"API_URL": "https://example.com"},
+	}
+	segments, err := decodeResolvePrefix(task.resolvePrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || segments[0] != "env:"+btoaUrl("API_URL=https://example.com") {
+		t.Fatalf("invalid env segment: %v", segments)
+	}
+
+	for key, valid := range map[string]bool{
+		"FEATURE_X": true,
+		"_DEBUG":    true,
+		"feature_x": false,
+		"1FEATURE":  false,
+		"NODE_ENV":  false,
+		"A-B":       false,
+	} {
+		if isValidEnvKey(key) != valid {
+			t.Fatalf("isValidEnvKey(%s) should be %v", key, valid)
+		}
+	}
+	for value, expected := range map[string]string{
+		"true":     "true",
+		"null":     "null",
+		"-1.5":     "-1.5",
+		"Infinity": `"Infinity"`,
+		"a\"b":     `"a\"b"`,
+	} {
+		if ret := envDefineValue(value); ret != expected {
+			t.Fatalf("envDefineValue(%s) should be %s, but got %s", value, expected, ret)
+		}
+	}
+}
+
+func TestIgnoreAnnotations(t *testing.T) {
+	code := `/* @__PURE__ */ console.log("side effect"); export default 1;`
+	for _, ignoreAnnotations := range []bool{false, true} {
+		task := &BuildTask{Target: "es2020", IgnoreAnnotations: ignoreAnnotations}
+		options := task.esbuildOptions()
+		options.Stdin = &api.StdinOptions{Contents: code, Sourcefile: "mod.js"}
+		result := api.Build(options)
+		if len(result.Errors) > 0 {
+			t.Fatal(result.Errors[0].Text)
+		}
+		preserved := bytes.Contains(result.OutputFiles[0].Contents, []byte("side effect"))
+		if preserved != ignoreAnnotations {
+			t.Fatalf("the pure annotated call should be preserved(%v) with IgnoreAnnotations(%v)", preserved, ignoreAnnotations)
+		}
+	}
+}
+
+func TestAsyncExportsPrefix(t *testing.T) {
+	task := &BuildTask{BuildVersion: 57, Pkg: Pkg{Name: "lodash", Version: "4.17.21"}, Target: "es2021", AsyncExports: true}
+	if task.ID() == (&BuildTask{BuildVersion: 57, Pkg: task.Pkg, Target: "es2021"}).ID() {
+		t.Fatal("the async-exports build should have a different id")
+	}
+	segments, err := decodeResolvePrefix(task.resolvePrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(segments, []string{"async-exports"}) {
+		t.Fatalf("unexpected segments: %v", segments)
+	}
+}
+
+func TestNodeESMOptions(t *testing.T) {
+	task := &BuildTask{Target: "node", NodeESM: true, Conditions: []string{"worker"}}
+	options := task.esbuildOptions()
+	if !reflect.DeepEqual(options.Conditions, []string{"import", "worker"}) {
+		t.Fatalf("unexpected conditions: %v", options.Conditions)
+	}
+	if options.Format != api.FormatESModule || !strings.Contains(options.Banner["js"], "createRequire") {
+		t.Fatalf("the node-esm build should be ESM with the createRequire banner")
+	}
+	if options := (&BuildTask{Target: "node"}).esbuildOptions(); len(options.Conditions) > 0 || options.Banner != nil {
+		t.Fatalf("unexpected options of the node target: %v %v", options.Conditions, options.Banner)
+	}
+}
+
+func TestSourcefile(t *testing.T) {
+	task := &BuildTask{Sourcefile: "index.js"}
+	segments, err := decodeResolvePrefix(task.resolvePrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || segments[0] != "s:index.js" || task.sourcefile() != "index.js" {
+		t.Fatalf("invalid sourcefile segment: %v", segments)
+	}
+
+	// the default sourcefile is not encoded
+	for _, task := range []*BuildTask{{}, {Sourcefile: defaultSourcefile}} {
+		if prefix := task.resolvePrefix(); prefix != "" || task.sourcefile() != defaultSourcefile {
+			t.Fatalf("unexpected resolve prefix: %s", prefix)
+		}
+	}
+
+	for name, valid := range map[string]bool{
+		"index.js":    true,
+		"my_lib-1.js": true,
+		"../mod.js":   false,
+		"mod.js?x":    false,
+		"":            false,
+	} {
+		if isValidSourcefile(name) != valid {
+			t.Fatalf("isValidSourcefile(%s) should be %v", name, valid)
+		}
+	}
+}
+
+func TestOptimizeFor(t *testing.T) {
+	task := &BuildTask{OptimizeFor: "size"}
+	segments, err := decodeResolvePrefix(task.resolvePrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || segments[0] != "o:size" {
+		t.Fatalf("invalid optimize segment: %v", segments)
+	}
+	options := task.esbuildOptions()
+	if !options.MinifySyntax || options.TreeShaking != api.TreeShakingTrue {
+		t.Fatalf("unexpected options of the size mode: %v", options)
+	}
+
+	task = &BuildTask{OptimizeFor: "speed"}
+	options = task.esbuildOptions()
+	if !options.MinifyWhitespace || options.MinifyIdentifiers || options.MinifySyntax {
+		t.Fatalf("unexpected options of the speed mode: %v", options)
+	}
+
+	if isValidOptimizeFor("fast") || !isValidOptimizeFor("speed") {
+		t.Fatal("unexpected optimize mode validation")
+	}
+}
+
+func TestBuiltInPolyfillPath(t *testing.T) {
+	for target, expected := range map[string]string{
+		"es2022": "/v87/node_buffer.js",
+		"node":   "buffer",
+	} {
+		task := &BuildTask{BuildVersion: 87, Target: target}
+		importPath, _, err := task.getBuiltInPolyfillPath("buffer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if importPath != expected {
+			t.Fatalf("unexpected polyfill of buffer for %s: %s", target, importPath)
+		}
+	}
+}
+
+func TestDryRunWriteFile(t *testing.T) {
+	// the fs is not opened in the tests, the dry-run build should never touch it
+	task := &BuildTask{BuildVersion: 87, Pkg: Pkg{Name: "react", Version: "18.2.0"}, Target: "es2022", DryRun: true}
+	if err := task.writeFile(path.Join("builds", task.ID()), []byte("export default 1")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(bytes.ToLower(data), []byte("dryrun")) {
+		t.Fatalf("the dry-run flag should not be persisted in the queue: %s", data)
+	}
+}
+
+func TestIncludeComments(t *testing.T) {
+	code := "/** @preserve Adds two numbers */\nexport function add(a, b) { return a + b }\n"
+	for _, includeComments := range []bool{false, true} {
+		task := &BuildTask{Target: "es2020", IncludeComments: includeComments}
+		options := task.esbuildOptions()
+		options.Stdin = &api.StdinOptions{Contents: code, Sourcefile: "mod.js"}
+		result := api.Build(options)
+		if len(result.Errors) > 0 {
+			t.Fatal(result.Errors[0].Text)
+		}
+		output := string(result.OutputFiles[0].Contents)
+		// the legal comments are moved to the end of the file by default
+		inline := strings.Index(output, "Adds two numbers") < strings.Index(output, "function")
+		if inline != includeComments {
+			t.Fatalf("the comment should be inline(%v) with IncludeComments(%v): %s", includeComments, includeComments, output)
+		}
+	}
+}
+
+func TestLoadWasm(t *testing.T) {
+	dir := t.TempDir()
+	small := path.Join(dir, "small.wasm")
+	large := path.Join(dir, "large.wasm")
+	ioutil.WriteFile(small, []byte{0, 97, 115, 109, 1, 0, 0, 0}, 0644)
+	ioutil.WriteFile(large, make([]byte, wasmStreamingThreshold), 0644)
+
+	task := &BuildTask{BuildVersion: 87, Pkg: Pkg{Name: "pkg", Version: "1.0.0"}, Target: "es2022", WasmStreaming: true, DryRun: true}
+	code, wasmFile, err := loadWasm(task, small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wasmFile != "" || code != "export default WebAssembly.compile(new Uint8Array([0,97,115,109,1,0,0,0]));" {
+		t.Fatalf("the small wasm file should be embedded: %s", code)
+	}
+
+	code, wasmFile, err = loadWasm(task, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path.Dir(wasmFile) != path.Dir(task.ID()) || !strings.HasSuffix(wasmFile, ".wasm") {
+		t.Fatalf("unexpected wasm file: %s", wasmFile)
+	}
+	if code != fmt.Sprintf(`export default WebAssembly.compileStreaming(fetch(new URL("./%s", import.meta.url)));`, path.Base(wasmFile)) {
+		t.Fatalf("unexpected code: %s", code)
+	}
+
+	task.WasmInstantiate = true
+	code, _, err = loadWasm(task, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(code, "export default WebAssembly.instantiateStreaming(fetch(") || !strings.HasSuffix(code, ", {});") {
+		t.Fatalf("unexpected code: %s", code)
+	}
+}
+
+func TestLoadWasmTarget(t *testing.T) {
+	dir := t.TempDir()
+	filename := path.Join(dir, "add.wasm")
+	ioutil.WriteFile(filename, make([]byte, wasmStreamingThreshold), 0644)
+
+	for _, target := range []string{"es2020", "node"} {
+		task := &BuildTask{BuildVersion: 87, Pkg: Pkg{Name: "pkg", Version: "1.0.0"}, Target: target, WasmInstantiate: true, WasmStreaming: true, DryRun: true}
+		code, _, err := loadWasm(task, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the top-level await is an error for the targets older than es2022
+		options := task.esbuildOptions()
+		options.Stdin = &api.StdinOptions{Contents: code, Sourcefile: "wasm.js"}
+		result := api.Build(options)
+		if len(result.Errors) > 0 {
+			t.Fatalf("build wasm module for %s: %s", target, result.Errors[0].Text)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// BuildCheckResult defines the result of checking whether a package can be built
+type BuildCheckResult struct {
+	CanBuild    bool     `json:"canBuild"`
+	HasTypes    bool     `json:"hasTypes"`
+	HasCSS      bool     `json:"hasCSS"`
+	HasESM      bool     `json:"hasESM"`
+	ExportCount int      `json:"exportCount"`
+	Warnings    []string `json:"warnings"`
+}
+
+// Check installs the package and checks the entry and the exports like the build
+// without bundling, the result is not stored. The errors that make the package not
+// buildable, like the native addons or the blocked exports, are reported as the
+// warnings of the result, only the installation errors are returned.
+func (task *BuildTask) Check() (result *BuildCheckResult, err error) {
+	wd := tempDir(fmt.Sprintf("esm-check-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+
+	result = &BuildCheckResult{Warnings: []string{}}
+	if host, user, repo := splitGitPkgName(task.Pkg.Name); host != "" {
+		err = installFromGit(wd, host, user, repo, task.Pkg.Version)
+	} else {
+		err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", task.Pkg.Name, task.Pkg.Version), 3, time.Second, task.installFlags()...)
+	}
+	if err != nil {
+		return
+	}
+
+	esm, err := initESM(wd, task.Pkg, true, task.DevMode, task.isCJSOnly())
+	if err != nil {
+		result.Warnings = append(result.Warnings, err.Error())
+		return result, nil
+	}
+
+	result.HasESM = esm.Module != ""
+	result.HasTypes = esm.Types != "" || esm.Typings != ""
+	result.HasCSS = hasCSSFiles(path.Join(wd, "node_modules", esm.Name))
+	result.ExportCount = len(esm.Exports)
+	if esm.ExportDefault {
+		result.ExportCount++
+	}
+	if warning := checkEngines(esm.NpmPackage, task.Target); warning != "" {
+		result.Warnings = append(result.Warnings, warning)
+	}
+	if esm.NativeAddon {
+		result.Warnings = append(result.Warnings, ErrNativeAddon.Error())
+		return result, nil
+	}
+	result.CanBuild = true
+	return result, nil
+}
+
+// hasCSSFiles checks whether the package ships the CSS files, the nested
+// `node_modules` directories are ignored.
+func hasCSSFiles(pkgDir string) bool {
+	found := false
+	filepath.Walk(pkgDir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || found {
+			return filepath.SkipDir
+		}
+		if info.IsDir() && info.Name() == "node_modules" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(filename, ".css") {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+// serveBuildCheck serves the `+check` requests like `/v{VERSION}/react@17.0.2/es2021/+check`,
+// it checks whether the package can be built for the target without filling the build
+// storage. The target is specified in the path or by the `target` query, and the result
+// is cached for 24 hours.
+func serveBuildCheck(ctx *rex.Context, pkg *Pkg, target string) interface{} {
+	task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: pkg.Version})
+	if target != "" {
+		task.Target = target
+	}
+	key := fmt.Sprintf("check:%s", task.ID())
+	data, err := cache.Get(key)
+	if err != nil {
+		result, err := task.Check()
+		if err == ErrYarnTimeout {
+			return throwAPIError(ctx, http.StatusGatewayTimeout, APIError{Code: errCodeTimeout, Message: err.Error()})
+		}
+		if err == ErrOffline {
+			return throwAPIError(ctx, http.StatusServiceUnavailable, APIError{Code: errCodeOffline, Message: err.Error()})
+		}
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		data = utils.MustEncodeJSON(result)
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestHasCSSFiles(t *testing.T) {
+	dir := t.TempDir()
+	ensureDir(path.Join(dir, "dist"))
+	ensureDir(path.Join(dir, "node_modules", "dep"))
+	ioutil.WriteFile(path.Join(dir, "dist", "index.js"), []byte("export default 1"), 0644)
+	ioutil.WriteFile(path.Join(dir, "node_modules", "dep", "style.css"), []byte("a{}"), 0644)
+	if hasCSSFiles(dir) {
+		t.Fatal("the CSS files of the nested node_modules should be ignored")
+	}
+
+	ioutil.WriteFile(path.Join(dir, "dist", "style.css"), []byte("a{}"), 0644)
+	if !hasCSSFiles(dir) {
+		t.Fatal("the CSS file should be found")
+	}
+}
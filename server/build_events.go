@@ -0,0 +1,361 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+const (
+	maxBuildEventSubscribers = 1000
+	// the max number of the pending events of a subscriber, the slow subscriber
+	// is disconnected when the buffer is full
+	maxBuildEventBuffer = 32
+	// the connection is closed if no frame (including the pong) is received in
+	// `wsPongWait`, a ping is sent every `wsPingPeriod`
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = 50 * time.Second
+	// the max size of the messages sent by the clients
+	maxWSMessageSize = 64 * 1024
+	wsGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	buildEventSubscribers    = map[string]map[*buildEventSubscriber]struct{}{}
+	buildEventSubscriberLock sync.RWMutex
+	buildEventSubscriberN    int32
+)
+
+type buildEventSubscriber struct {
+	conn   net.Conn
+	events chan []byte
+	lock   sync.Mutex
+}
+
+func newBuildEventSubscriber(conn net.Conn) *buildEventSubscriber {
+	return &buildEventSubscriber{conn: conn, events: make(chan []byte, maxBuildEventBuffer)}
+}
+
+// send queues the event to be written by `writeLoop`, it never blocks the caller,
+// the subscriber is disconnected if it can't keep up with the events.
+func (s *buildEventSubscriber) send(event map[string]interface{}) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	select {
+	case s.events <- data:
+	default:
+		s.conn.Close()
+	}
+}
+
+func (s *buildEventSubscriber) write(opcode byte, payload []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return writeWSFrame(s.conn, opcode, payload)
+}
+
+// writeLoop writes the queued events and the pings until the done channel is closed
+func (s *buildEventSubscriber) writeLoop(done chan struct{}) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case data := <-s.events:
+			err = s.write(0x1, data)
+		case <-ticker.C:
+			err = s.write(0x9, nil)
+		case <-done:
+			return
+		}
+		if err != nil {
+			s.conn.Close()
+			return
+		}
+	}
+}
+
+func subscribeBuildEvents(taskID string, s *buildEventSubscriber) {
+	buildEventSubscriberLock.Lock()
+	defer buildEventSubscriberLock.Unlock()
+
+	subscribers, ok := buildEventSubscribers[taskID]
+	if !ok {
+		subscribers = map[*buildEventSubscriber]struct{}{}
+		buildEventSubscribers[taskID] = subscribers
+	}
+	subscribers[s] = struct{}{}
+}
+
+// unsubscribeBuildEvents removes the subscriber of the task, and the task entry
+// when its last subscriber leaves.
+func unsubscribeBuildEvents(taskID string, s *buildEventSubscriber) {
+	buildEventSubscriberLock.Lock()
+	defer buildEventSubscriberLock.Unlock()
+
+	subscribers, ok := buildEventSubscribers[taskID]
+	if ok {
+		delete(subscribers, s)
+		if len(subscribers) == 0 {
+			delete(buildEventSubscribers, taskID)
+		}
+	}
+}
+
+// broadcastBuildEvent sends the build event to all subscribers of the task, the
+// events are buffered by each subscriber so the caller is never blocked by the
+// slow connections.
+func broadcastBuildEvent(taskID string, event map[string]interface{}) {
+	buildEventSubscriberLock.RLock()
+	defer buildEventSubscriberLock.RUnlock()
+
+	for s := range buildEventSubscribers[taskID] {
+		s.send(event)
+	}
+}
+
+// serveBuildEvents handles the `/ws` websocket connection, clients send
+// `{"subscribe":"<task id>"}` to receive the events of a build task.
+func serveBuildEvents(ctx *rex.Context) interface{} {
+	r := ctx.R
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Bad Request"})
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Bad Request"})
+	}
+	if atomic.AddInt32(&buildEventSubscriberN, 1) > maxBuildEventSubscribers {
+		atomic.AddInt32(&buildEventSubscriberN, -1)
+		return throwAPIError(ctx, http.StatusServiceUnavailable, APIError{Code: errCodeRateLimited, Message: "Too many subscribers"})
+	}
+
+	hijacker, ok := ctx.W.(http.Hijacker)
+	if !ok {
+		atomic.AddInt32(&buildEventSubscriberN, -1)
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: "Websocket is not supported"})
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		atomic.AddInt32(&buildEventSubscriberN, -1)
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	hasher := sha1.New()
+	hasher.Write([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hasher.Sum(nil)) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		atomic.AddInt32(&buildEventSubscriberN, -1)
+		return nil
+	}
+
+	go handleBuildEventsConn(conn, rw.Reader)
+	return nil
+}
+
+func handleBuildEventsConn(conn net.Conn, r *bufio.Reader) {
+	s := newBuildEventSubscriber(conn)
+	done := make(chan struct{})
+	subscribed := map[string]struct{}{}
+	defer func() {
+		for taskID := range subscribed {
+			unsubscribeBuildEvents(taskID, s)
+		}
+		close(done)
+		conn.Close()
+		atomic.AddInt32(&buildEventSubscriberN, -1)
+	}()
+	go s.writeLoop(done)
+
+	wr := &wsReader{r: r}
+	for {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		opcode, payload, err := wr.next()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case 0x8: // close
+			s.write(0x8, nil)
+			return
+		case 0x9: // ping
+			if s.write(0xA, payload) != nil {
+				return
+			}
+		case 0x1: // text
+			var msg struct {
+				Subscribe string `json:"subscribe"`
+			}
+			if json.Unmarshal(payload, &msg) != nil || msg.Subscribe == "" {
+				s.send(map[string]interface{}{"event": "error", "message": "invalid message"})
+				continue
+			}
+			taskID := strings.TrimPrefix(msg.Subscribe, "/")
+			if _, ok := subscribed[taskID]; ok {
+				continue
+			}
+			// subscribe before checking the queue to not miss the events of the task
+			// finished in between
+			subscribeBuildEvents(taskID, s)
+			buildQueue.lock.RLock()
+			t, ok := buildQueue.tasks[taskID]
+			var inProcess bool
+			if ok {
+				inProcess = t.inProcess
+			}
+			buildQueue.lock.RUnlock()
+			if ok {
+				subscribed[taskID] = struct{}{}
+				// send the current state of the task, the pending task is reported
+				// by a queued event
+				if inProcess {
+					s.send(map[string]interface{}{"event": "stage", "stage": t.getStage()})
+				} else {
+					s.send(map[string]interface{}{"event": "queued"})
+				}
+				continue
+			}
+			// only the tasks in the queue can be subscribed
+			unsubscribeBuildEvents(taskID, s)
+			esm, err := findESM(taskID)
+			if err == nil {
+				s.send(map[string]interface{}{"event": "done", "esm": esm})
+			} else if buildErr, ok := err.(*FailedBuildError); ok {
+				s.send(map[string]interface{}{"event": "error", "message": buildErr.Error()})
+			} else {
+				s.send(map[string]interface{}{"event": "error", "message": "task not found"})
+			}
+		}
+	}
+}
+
+// wsReader reads the websocket messages, the fragmented data frames are joined
+// into one message and the control frames are returned as they arrive.
+type wsReader struct {
+	r       *bufio.Reader
+	opcode  byte
+	message []byte
+}
+
+func (wr *wsReader) next() (opcode byte, payload []byte, err error) {
+	for {
+		var fin bool
+		fin, opcode, payload, err = readWSFrame(wr.r)
+		if err != nil {
+			return
+		}
+		if opcode >= 0x8 {
+			// the control frames must not be fragmented
+			if !fin {
+				err = errors.New("websocket: fragmented control frame")
+			}
+			return
+		}
+		if opcode == 0x0 {
+			if wr.opcode == 0 {
+				err = errors.New("websocket: unexpected continuation frame")
+				return
+			}
+		} else {
+			if wr.opcode != 0 {
+				err = errors.New("websocket: unexpected data frame")
+				return
+			}
+			wr.opcode = opcode
+		}
+		if len(wr.message)+len(payload) > maxWSMessageSize {
+			err = errors.New("websocket: message too large")
+			return
+		}
+		wr.message = append(wr.message, payload...)
+		if fin {
+			opcode, payload = wr.opcode, wr.message
+			wr.opcode, wr.message = 0, nil
+			return
+		}
+	}
+}
+
+func readWSFrame(r *bufio.Reader) (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		b := make([]byte, 2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(b)
+	}
+	if length > maxWSMessageSize {
+		err = errors.New("websocket: frame too large")
+		return
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(r, mask); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+func writeWSFrame(w io.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	length := len(payload)
+	switch {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, byte(length>>8), byte(length))
+	default:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(length))
+		header = append(append(header, 127), b...)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
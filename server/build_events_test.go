@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBuildEventSubscribers(t *testing.T) {
+	taskID := "v87/react@17.0.2/es2022/react.js"
+	a := &buildEventSubscriber{}
+	b := &buildEventSubscriber{}
+	subscribeBuildEvents(taskID, a)
+	subscribeBuildEvents(taskID, b)
+	if n := len(buildEventSubscribers[taskID]); n != 2 {
+		t.Fatalf("expected 2 subscribers, got %d", n)
+	}
+
+	unsubscribeBuildEvents(taskID, a)
+	if n := len(buildEventSubscribers[taskID]); n != 1 {
+		t.Fatalf("expected 1 subscriber, got %d", n)
+	}
+
+	// the task entry is deleted when the last subscriber leaves
+	unsubscribeBuildEvents(taskID, b)
+	if _, ok := buildEventSubscribers[taskID]; ok {
+		t.Fatal("the task entry should be deleted")
+	}
+	unsubscribeBuildEvents(taskID, b)
+}
+
+func TestWSReaderFragments(t *testing.T) {
+	var buf bytes.Buffer
+	// a text message in two fragments with a ping in between
+	buf.Write([]byte{0x01, 3})
+	buf.WriteString(`{"s`)
+	buf.Write([]byte{0x89, 0})
+	buf.Write([]byte{0x80, 4})
+	buf.WriteString(`":1}`)
+
+	wr := &wsReader{r: bufio.NewReader(&buf)}
+	opcode, payload, err := wr.next()
+	if err != nil || opcode != 0x9 {
+		t.Fatalf("expected a ping, got %x %v", opcode, err)
+	}
+	opcode, payload, err = wr.next()
+	if err != nil || opcode != 0x1 || string(payload) != `{"s":1}` {
+		t.Fatalf("unexpected message %x %q %v", opcode, payload, err)
+	}
+
+	// the continuation frame without a started message is invalid
+	wr = &wsReader{r: bufio.NewReader(bytes.NewReader([]byte{0x80, 0}))}
+	if _, _, err = wr.next(); err == nil {
+		t.Fatal("the unexpected continuation frame should be rejected")
+	}
+}
+
+func TestBroadcastBuildEventNonBlocking(t *testing.T) {
+	taskID := "v87/preact@10.5.14/es2022/preact.js"
+	server, client := net.Pipe()
+	defer client.Close()
+	s := newBuildEventSubscriber(server)
+	subscribeBuildEvents(taskID, s)
+	defer unsubscribeBuildEvents(taskID, s)
+
+	// nobody reads the connection, the broadcast must not block
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < maxBuildEventBuffer*2; i++ {
+			broadcastBuildEvent(taskID, map[string]interface{}{"event": "stage", "stage": "build"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the broadcast is blocked by the slow subscriber")
+	}
+	// the slow subscriber is disconnected
+	if _, err := server.Write([]byte{0}); err == nil {
+		t.Fatal("the connection of the slow subscriber should be closed")
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+// the failed build is retried once after the cooldown
+const buildErrorRetryCooldown = time.Hour
+
+// the build failed again after the cooldown is not retried until the ttl expires
+const buildErrorRetriedTTL = 24 * time.Hour
+
+// BuildError defines the error of the failed build stored in the db as `build-error:<id>`
+type BuildError struct {
+	TaskID    string    `json:"taskId"`
+	Error     string    `json:"error"`
+	Stage     string    `json:"stage"`
+	Timestamp time.Time `json:"timestamp"`
+	Retried   bool      `json:"retried"`
+}
+
+// FailedBuildError is returned by `findESM` if the build is failed and not ready to retry
+type FailedBuildError struct {
+	*BuildError
+}
+
+func (e *FailedBuildError) Error() string {
+	return e.BuildError.Error
+}
+
+// recordBuildError stores the error of the failed build in the db as `build-error:<id>`,
+// it's removed when the build succeeds. The error is marked as retried if the build
+// failed again after the cooldown. Only the deterministic failures are stored, the
+// transient errors are retried by the next request.
+func recordBuildError(id string, stage string, buildErr error) {
+	if _, ok := buildErr.(*FailedBuildError); ok {
+		// the stored error is returned by `findESM`, keep the timestamp
+		return
+	}
+	if buildErr != nil && isTransientBuildError(buildErr) {
+		return
+	}
+	prev, err := findBuildError(id)
+	if err != nil && err != storage.ErrNotFound {
+		log.Errorf("db: %v", err)
+		return
+	}
+	if buildErr == nil {
+		if prev != nil {
+			err = db.Delete("build-error:" + id)
+		}
+	} else {
+		err = db.Put("build-error:"+id, "build-error", storage.Store{
+			"taskId":  id,
+			"error":   buildErr.Error(),
+			"stage":   stage,
+			"time":    time.Now().Format(time.RFC3339),
+			"retried": strconv.FormatBool(prev != nil),
+		})
+	}
+	if err != nil {
+		log.Errorf("db: %v", err)
+	}
+}
+
+// isTransientBuildError checks if the build error is caused by the timeouts or the
+// network, the offline cache can be warmed as well.
+func isTransientBuildError(err error) bool {
+	if err == ErrYarnTimeout || err == ErrBuildTimeout || err == ErrOffline {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return regTransientYarnError.MatchString(err.Error())
+}
+
+// findBuildError finds the stored error of the failed build
+func findBuildError(id string) (*BuildError, error) {
+	store, _, err := db.Get("build-error:" + id)
+	if err != nil {
+		return nil, err
+	}
+	return toBuildError(store), nil
+}
+
+func toBuildError(store map[string]string) *BuildError {
+	timestamp, _ := time.Parse(time.RFC3339, store["time"])
+	return &BuildError{
+		TaskID:    store["taskId"],
+		Error:     store["error"],
+		Stage:     store["stage"],
+		Timestamp: timestamp,
+		Retried:   store["retried"] == "true",
+	}
+}
+
+// serveBuildErrorsAdmin serves the `/admin/build-errors` requests, it lists the recent
+// failed builds sorted by the timestamp (newest first, max 100 entries). Only available
+// in dev mode or with the admin token.
+func serveBuildErrorsAdmin(ctx *rex.Context, devMode bool) interface{} {
+	if err := checkAdminRequest(ctx, devMode); err != nil {
+		return err
+	}
+
+	list, err := db.List("build-error")
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	buildErrors := make([]*BuildError, len(list))
+	for i, item := range list {
+		buildErrors[i] = toBuildError(item.Store)
+	}
+	sort.Slice(buildErrors, func(i, j int) bool {
+		return buildErrors[i].Timestamp.After(buildErrors[j].Timestamp)
+	})
+	if len(buildErrors) > 100 {
+		buildErrors = buildErrors[:100]
+	}
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return map[string]interface{}{
+		"errors": buildErrors,
+	}
+}
+
+// serveBuildHealth serves the build states of the package for all the targets:
+// `built` means the build exists, `failed` means the last build was failed, and
+// `missing` means the build has never been attempted. The build is specified by
+// the `bundle` and `dev` query like the module URL.
+func serveBuildHealth(ctx *rex.Context, pkg *Pkg) interface{} {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	states := map[string]string{}
+	failures := map[string]string{}
+	for _, target := range names {
+		task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: pkg.Version})
+		task.Target = target
+		_, err := findESM(task.ID())
+		if err == nil {
+			states[target] = "built"
+			continue
+		}
+		if err != storage.ErrNotFound {
+			if _, ok := err.(*FailedBuildError); !ok {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+		}
+		buildErr, err := findBuildError(task.ID())
+		if err == nil {
+			states[target] = "failed"
+			failures[target] = buildErr.Error
+		} else if err == storage.ErrNotFound {
+			states[target] = "missing"
+		} else {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return map[string]interface{}{
+		"targets": states,
+		"errors":  failures,
+	}
+}
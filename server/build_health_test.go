@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"testing"
+	"time"
+
+	"esm.sh/server/storage"
+)
+
+func TestBuildError(t *testing.T) {
+	var err error
+	db, err = storage.OpenDB(fmt.Sprintf("postdb:%s", path.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	id := fmt.Sprintf("v%d/test@1.0.0/es2021/test.js", VERSION)
+	recordBuildError(id, "esbuild", errors.New("Could not resolve \"foo\""))
+
+	_, err = findESM(id)
+	buildErr, ok := err.(*FailedBuildError)
+	if !ok {
+		t.Fatalf("expected the failed build error, got %v", err)
+	}
+	if buildErr.TaskID != id || buildErr.Stage != "esbuild" || buildErr.Retried || buildErr.Error() != "Could not resolve \"foo\"" {
+		t.Fatalf("unexpected build error: %v", buildErr.BuildError)
+	}
+
+	// the stored error is not recorded again
+	recordBuildError(id, "init", buildErr)
+	if e, _ := findBuildError(id); e.Stage != "esbuild" {
+		t.Fatalf("unexpected build error: %v", e)
+	}
+
+	// retry after the cooldown
+	err = db.Put("build-error:"+id, "build-error", storage.Store{
+		"taskId": id,
+		"error":  "timeout",
+		"time":   time.Now().Add(-buildErrorRetryCooldown).Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = findESM(id); err != storage.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after the cooldown, got %v", err)
+	}
+
+	// the failed retry is not retried again until the ttl expires
+	recordBuildError(id, "esbuild", errors.New("timeout"))
+	if e, _ := findBuildError(id); !e.Retried {
+		t.Fatalf("expected the retried build error: %v", e)
+	}
+	if _, err = findESM(id); err == nil || err == storage.ErrNotFound {
+		t.Fatalf("expected the failed build error, got %v", err)
+	}
+	err = db.Put("build-error:"+id, "build-error", storage.Store{
+		"taskId":  id,
+		"error":   "timeout",
+		"time":    time.Now().Add(-buildErrorRetriedTTL).Format(time.RFC3339),
+		"retried": "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = findESM(id); err != storage.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after the ttl, got %v", err)
+	}
+
+	recordBuildError(id, "", nil)
+	if _, err = findBuildError(id); err != storage.ErrNotFound {
+		t.Fatalf("expected the build error removed, got %v", err)
+	}
+
+	// the transient errors are not stored
+	for _, transientErr := range []error{
+		ErrYarnTimeout,
+		ErrBuildTimeout,
+		ErrOffline,
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		errors.New("yarn add react@17.0.2: error An unexpected error occurred: \"https://registry.npmjs.org/react: ETIMEDOUT\""),
+	} {
+		recordBuildError(id, "init", transientErr)
+		if _, err = findBuildError(id); err != storage.ErrNotFound {
+			t.Fatalf("the transient error should not be stored: %v", transientErr)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/rex"
+)
+
+const maxLocalPackageSize = 10 << 20 // 10MB
+
+// serveBuildLocal serves the `POST /build-local` requests in dev mode, it builds
+// the uploaded package tarball (field `file`) and returns the ESM metadata, then
+// the library authors can test their packages without publishing them to npm.
+// The version of the build is suffixed with the tarball hash like `1.0.0-local.<hash>`
+// to avoid overriding the builds of the published packages.
+func serveBuildLocal(ctx *rex.Context) interface{} {
+	if ctx.R.Method != "POST" {
+		ctx.SetHeader("Allow", "POST")
+		return throwAPIError(ctx, http.StatusMethodNotAllowed, APIError{Code: errCodeMethodNotAllowed, Message: "Method Not Allowed"})
+	}
+
+	// the body is limited to `maxLocalPackageSize` by the `limitRequestBody` middleware
+	file, _, err := ctx.R.FormFile("file")
+	if err != nil {
+		if isBodyTooLarge(err) {
+			return throwPayloadTooLargeError(ctx, maxLocalPackageSize)
+		}
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing the package tarball"})
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: err.Error()})
+	}
+	p, err := readTarballPackageJSON(data)
+	if err != nil {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: err.Error()})
+	}
+	if p.Name == "" || p.Version == "" {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing the name or version in package.json"})
+	}
+	if err = validatePkgName(p.Name); err != nil {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: err.Error()})
+	}
+	if err = validateVersion(p.Version); err != nil {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: err.Error()})
+	}
+
+	wd := tempDir(fmt.Sprintf("esm-build-local-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	defer os.RemoveAll(wd)
+
+	tarball := path.Join(wd, "package.tgz")
+	err = ioutil.WriteFile(tarball, data, 0644)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	err = pkgManagerAdd(packageManager, wd, "file:"+tarball)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	target := normalizeTarget(strings.ToLower(ctx.Form.Value("target")))
+	if !isValidTarget(target) {
+		target = getTargetByUA(ctx.R.UserAgent())
+	}
+	hasher := sha1.New()
+	hasher.Write(data)
+	task := &BuildTask{
+		BuildVersion: VERSION,
+		Pkg: Pkg{
+			Name:      p.Name,
+			Version:   fmt.Sprintf("%s-local.%s", p.Version, hex.EncodeToString(hasher.Sum(nil))[:8]),
+			Submodule: strings.Trim(ctx.Form.Value("submodule"), "/"),
+		},
+		Target:     target,
+		BundleMode: !ctx.Form.IsNil("bundle"),
+		DevMode:    !ctx.Form.IsNil("dev"),
+		wd:         wd,
+		stage:      "init",
+	}
+	esm, err := task.build(newStringSet())
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	return map[string]interface{}{
+		"id":  task.ID(),
+		"esm": esm,
+	}
+}
+
+// readTarballPackageJSON reads the package.json in the root directory of the
+// gzipped package tarball, like `package/package.json` of npm tarballs.
+func readTarballPackageJSON(data []byte) (p NpmPackage, err error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		var h *tar.Header
+		h, err = tr.Next()
+		if err == io.EOF {
+			err = fmt.Errorf("package.json not found in the tarball")
+			return
+		}
+		if err != nil {
+			return
+		}
+		a := strings.Split(strings.TrimPrefix(h.Name, "./"), "/")
+		if len(a) == 2 && a[1] == "package.json" {
+			err = json.NewDecoder(tr).Decode(&p)
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"sync"
+)
+
+// buildLocks guards the build file and the build record in the db of the same
+// build ID, the storage backends don't support transactions across the fs and
+// the db, so `findESM` may see a new build file with an old record without it.
+var buildLocks = newKeyedMutex()
+
+// keyedMutex is a set of mutexes keyed by the string, the mutex of a key is
+// removed when it's not used by any caller.
+type keyedMutex struct {
+	lock  sync.Mutex
+	locks map[string]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: map[string]*refMutex{}}
+}
+
+// Lock locks the mutex of the key, and returns the function to unlock it.
+func (m *keyedMutex) Lock(key string) (unlock func()) {
+	m.lock.Lock()
+	mu, ok := m.locks[key]
+	if !ok {
+		mu = &refMutex{}
+		m.locks[key] = mu
+	}
+	mu.refs++
+	m.lock.Unlock()
+
+	mu.Lock()
+	return func() {
+		mu.Unlock()
+		m.lock.Lock()
+		mu.refs--
+		if mu.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.lock.Unlock()
+	}
+}
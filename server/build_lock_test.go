@@ -0,0 +1,95 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	m := newKeyedMutex()
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := m.Lock("a")
+			defer unlock()
+			counter++
+		}()
+	}
+	wg.Wait()
+	if counter != 100 {
+		t.Fatalf("unexpected counter %d", counter)
+	}
+	if len(m.locks) != 0 {
+		t.Fatalf("the unused locks should be removed: %d", len(m.locks))
+	}
+}
+
+func TestFindESMConcurrently(t *testing.T) {
+	testDir := t.TempDir()
+	var err error
+	fs, err = storage.OpenFS(fmt.Sprintf("local:%s", testDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = storage.OpenDB(fmt.Sprintf("postdb:%s", path.Join(testDir, "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	verifyBuilds = true
+	defer func() {
+		verifyBuilds = false
+	}()
+
+	id := fmt.Sprintf("v%d/test@1.0.0/es2021/test.js", VERSION)
+	store := func(i int) error {
+		unlock := buildLocks.Lock(id)
+		defer unlock()
+		content := []byte(fmt.Sprintf("export default %d", i))
+		err := fs.WriteData(path.Join("builds", id), content)
+		if err != nil {
+			return err
+		}
+		checksum := sha1.Sum(content)
+		return db.Put(id, "build", storage.Store{
+			"id":       id,
+			"esm":      string(utils.MustEncodeJSON(&ESM{NpmPackage: &NpmPackage{Name: "test", Version: "1.0.0"}})),
+			"checksum": hex.EncodeToString(checksum[:]),
+		})
+	}
+	err = store(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				errs <- store(i)
+			} else if _, err := findESM(id); err != nil {
+				errs <- fmt.Errorf("findESM: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
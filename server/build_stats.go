@@ -0,0 +1,89 @@
+package server
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// BuildStats defines the stats of a build
+type BuildStats struct {
+	StageTimings map[string]time.Duration `json:"stageTimings"`
+}
+
+// the max number of the recent durations kept for each stage
+const maxStageSamples = 1000
+
+var stageSamples = struct {
+	sync.Mutex
+	m map[string][]time.Duration
+}{m: map[string][]time.Duration{}}
+
+// recordStage adds the elapsed time of the current stage to the stats, then
+// restarts the timer for the next stage.
+func (task *BuildTask) recordStage() {
+	now := time.Now()
+	if task.stats == nil {
+		task.stats = &BuildStats{StageTimings: map[string]time.Duration{}}
+	}
+	if !task.stageStart.IsZero() {
+		task.stats.StageTimings[task.stage] += now.Sub(task.stageStart)
+	}
+	task.stageStart = now
+}
+
+// saveStats stores the stats of the build as `builds/<id>.stats.json`, and adds the
+// stage timings to the samples of `/admin/stats/stages`.
+func (task *BuildTask) saveStats() {
+	task.recordStage()
+	task.stageStart = time.Time{}
+
+	stageSamples.Lock()
+	for stage, d := range task.stats.StageTimings {
+		samples := append(stageSamples.m[stage], d)
+		if len(samples) > maxStageSamples {
+			samples = samples[len(samples)-maxStageSamples:]
+		}
+		stageSamples.m[stage] = samples
+	}
+	stageSamples.Unlock()
+
+	err := task.writeFile(path.Join("builds", strings.TrimSuffix(task.ID(), ".js")+".stats.json"), utils.MustEncodeJSON(task.stats))
+	if err != nil {
+		log.Warnf("build(%s): write stats: %v", task.ID(), err)
+	}
+}
+
+// serveStageStatsAdmin serves the `/admin/stats/stages` requests, it shows the
+// percentiles of the recent build stage durations in milliseconds. Only available in
+// dev mode or with the admin token.
+func serveStageStatsAdmin(ctx *rex.Context, devMode bool) interface{} {
+	if err := checkAdminRequest(ctx, devMode); err != nil {
+		return err
+	}
+
+	stageSamples.Lock()
+	defer stageSamples.Unlock()
+
+	ret := map[string]interface{}{}
+	for stage, samples := range stageSamples.m {
+		sorted := make([]time.Duration, len(samples))
+		copy(sorted, samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		percentile := func(p int) int64 {
+			return sorted[(len(sorted)-1)*p/100].Milliseconds()
+		}
+		ret[stage] = map[string]int64{
+			"count": int64(len(sorted)),
+			"p50":   percentile(50),
+			"p90":   percentile(90),
+			"p99":   percentile(99),
+		}
+	}
+	return ret
+}
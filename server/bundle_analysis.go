@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// writeBundleAnalysis stores the esbuild metafile of the build as
+// `builds/<id>.meta.json`, and renders it to a self-contained HTML page
+// `builds/<id>.analysis.html` that shows the treemap of the modules by size and
+// the dependency graph of the packages.
+func writeBundleAnalysis(task *BuildTask, metafile string) (err error) {
+	// strip the build dir from the module paths
+	metafile = strings.ReplaceAll(metafile, task.wd+"/", "")
+
+	var meta interface{}
+	err = json.Unmarshal([]byte(metafile), &meta)
+	if err != nil {
+		return
+	}
+
+	tpl, err := embedFS.ReadFile("server/embed/bundle-analysis.html")
+	if err != nil {
+		return
+	}
+
+	id := strings.TrimSuffix(task.ID(), ".js")
+	err = task.writeFile(path.Join("builds", id+".meta.json"), []byte(metafile))
+	if err != nil {
+		return
+	}
+
+	html := bytes.ReplaceAll(tpl, []byte("{PKG}"), []byte(fmt.Sprintf("%s (%s)", task.Pkg.String(), task.Target)))
+	// the json encoder escapes `<` and `>`, that is safe to be inlined in the `<script>` tag
+	html = bytes.ReplaceAll(html, []byte("{METAFILE}"), bytes.TrimSpace(utils.MustEncodeJSON(meta)))
+	return task.writeFile(path.Join("builds", id+".analysis.html"), html)
+}
+
+// serveBundleAnalysis serves the bundle analysis page of the package build, the
+// build is specified by the `target`, `bundle` and `dev` query like the module URL.
+func serveBundleAnalysis(ctx *rex.Context, pkg *Pkg) interface{} {
+	task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: pkg.Version})
+	savePath := path.Join("builds", strings.TrimSuffix(task.ID(), ".js")+".analysis.html")
+	exists, modtime, err := fs.Exists(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if exists {
+		// the page of the evicted build is not served, see `evictPackageBuilds`
+		_, _, err = db.Get(task.ID())
+		if err == storage.ErrNotFound {
+			exists = false
+		} else if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+	}
+	if !exists {
+		return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "The bundle analysis is not found, the module may not be built yet"})
+	}
+	r, err := fs.ReadFile(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	ctx.SetHeader("Content-Type", "text/html; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	return rex.Content(savePath, modtime, r)
+}
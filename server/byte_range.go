@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+var regByteRange = regexp.MustCompile(`^bytes=(\d*)-(\d*)$`)
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// parseByteRange parses the single byte range of the `Range` header like
+// `bytes=0-499`, `bytes=500-` or `bytes=-500`, the end is inclusive.
+func parseByteRange(header string, size int64) (start int64, end int64, err error) {
+	m := regByteRange.FindStringSubmatch(header)
+	if m == nil || (m[1] == "" && m[2] == "") {
+		err = errInvalidRange
+		return
+	}
+	if m[1] == "" {
+		// the suffix range `bytes=-N` selects the last N bytes
+		n, e := strconv.ParseInt(m[2], 10, 64)
+		if e != nil {
+			err = errInvalidRange
+			return
+		}
+		if n == 0 || size == 0 {
+			err = errUnsatisfiableRange
+			return
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+	start, e := strconv.ParseInt(m[1], 10, 64)
+	if e != nil {
+		err = errInvalidRange
+		return
+	}
+	if start >= size {
+		err = errUnsatisfiableRange
+		return
+	}
+	end = size - 1
+	if m[2] != "" {
+		end, e = strconv.ParseInt(m[2], 10, 64)
+		if e != nil || end < start {
+			err = errInvalidRange
+			return
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return
+}
+
+// serveByteRange serves the requested byte range of the content with the 206
+// status, it reads only the requested range. It returns false if the request has
+// no valid `Range` header, then the full content should be served by the caller.
+// Multiple ranges are not supported and served as the full content.
+func serveByteRange(w http.ResponseWriter, r *http.Request, name string, content io.ReadSeeker, size int64) bool {
+	header := r.Header.Get("Range")
+	if header == "" {
+		return false
+	}
+	start, end, err := parseByteRange(header, size)
+	if err == errInvalidRange {
+		return false
+	}
+	if err == errUnsatisfiableRange {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	_, err = content.Seek(start, io.SeekStart)
+	if err != nil {
+		writeError(w, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		return true
+	}
+	if w.Header().Get("Content-Type") == "" {
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	io.CopyN(w, content, end-start+1)
+	return true
+}
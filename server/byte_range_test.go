@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeByteRange(t *testing.T) {
+	content := "export default function hello() { return 'hello world'; }"
+	size := int64(len(content))
+
+	for _, c := range []struct {
+		header       string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"bytes=0-5", 206, "bytes 0-5/57", "export"},
+		{"bytes=51-", 206, "bytes 51-56/57", "ld'; }"},
+		{"bytes=-3", 206, "bytes 54-56/57", "; }"},
+		{"bytes=51-99", 206, "bytes 51-56/57", "ld'; }"},
+		{"bytes=57-", 416, "bytes */57", ""},
+	} {
+		req := httptest.NewRequest("GET", "/v58/hello@1.0.0/es2020/hello.js", nil)
+		req.Header.Set("Range", c.header)
+		w := httptest.NewRecorder()
+		if !serveByteRange(w, req, "hello.js", strings.NewReader(content), size) {
+			t.Fatalf("%s: should be served", c.header)
+		}
+		if w.Code != c.status {
+			t.Fatalf("%s: unexpected status %d", c.header, w.Code)
+		}
+		if contentRange := w.Header().Get("Content-Range"); contentRange != c.contentRange {
+			t.Fatalf("%s: unexpected Content-Range '%s'", c.header, contentRange)
+		}
+		if body := w.Body.String(); body != c.body {
+			t.Fatalf("%s: unexpected body '%s'", c.header, body)
+		}
+	}
+
+	// no range, invalid range or multiple ranges
+	for _, header := range []string{"", "bytes=5-1", "bytes=0-1,3-4", "items=0-1"} {
+		req := httptest.NewRequest("GET", "/v58/hello@1.0.0/es2020/hello.js", nil)
+		if header != "" {
+			req.Header.Set("Range", header)
+		}
+		if serveByteRange(httptest.NewRecorder(), req, "hello.js", strings.NewReader(content), size) {
+			t.Fatalf("'%s' should not be served as a range", header)
+		}
+	}
+}
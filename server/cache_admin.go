@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+// serveCacheAdmin serves the `/_cache` requests that are used by the `esmctl` tool
+// and the `esmclient` SDK, only available in dev mode or with the admin token.
+//
+//	GET    /_cache?id=v58/react@17.0.2/es2021/react.js     inspects the build record
+//	DELETE /_cache?id=v58/react@17.0.2/es2021/react.js     evicts the build
+//	DELETE /_cache?pkg=react@17.0.2                        evicts all builds of the package
+func serveCacheAdmin(ctx *rex.Context, devMode bool) interface{} {
+	if err := checkAdminRequest(ctx, devMode); err != nil {
+		return err
+	}
+
+	switch ctx.R.Method {
+	case "GET":
+		id := strings.TrimPrefix(ctx.Form.Value("id"), "/")
+		if id == "" {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing the id query"})
+		}
+		store, modtime, err := db.Get(id)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Record not found"})
+			}
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		var esm ESM
+		err = json.Unmarshal([]byte(store["esm"]), &esm)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		return map[string]interface{}{
+			"id":      id,
+			"modtime": modtime.Format(http.TimeFormat),
+			"esm":     esm,
+		}
+
+	case "DELETE":
+		if id := strings.TrimPrefix(ctx.Form.Value("id"), "/"); id != "" {
+			err := db.Delete(id)
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			return map[string]interface{}{
+				"evicted": []string{id},
+			}
+		}
+
+		pkg := ctx.Form.Value("pkg")
+		if pkg == "" || !strings.Contains(strings.TrimPrefix(pkg, "@"), "@") {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing the pkg query, should be <name>@<version>"})
+		}
+		evicted, err := evictPackageBuilds(pkg)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		return map[string]interface{}{
+			"evicted": evicted,
+		}
+	}
+
+	ctx.SetHeader("Allow", "GET, DELETE")
+	return throwAPIError(ctx, http.StatusMethodNotAllowed, APIError{Code: errCodeMethodNotAllowed, Message: "Method Not Allowed"})
+}
+
+// evictPackageBuilds deletes the build records (and the errors of the failed builds) of
+// all the targets and variants of the package `<name>@<version>`, the builds will be
+// rebuilt on the next requests. The build files are left in the fs, they are not served
+// without the build records and are overwritten by the rebuilds.
+func evictPackageBuilds(pkg string) ([]string, error) {
+	prefix := fmt.Sprintf("v%d/%s/", VERSION, pkg)
+	ids, err := db.ListIDs("build", prefix)
+	if err != nil {
+		return nil, err
+	}
+	evicted := []string{}
+	for _, id := range ids {
+		err = db.Delete(id)
+		if err != nil {
+			return nil, err
+		}
+		evicted = append(evicted, id)
+	}
+	// clear the errors of the failed builds to rebuild them immediately
+	ids, err = db.ListIDs("build-error", "build-error:"+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		err = db.Delete(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return evicted, nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"esm.sh/server/storage"
+)
+
+func TestEvictPackageBuilds(t *testing.T) {
+	var err error
+	db, err = storage.OpenDB(fmt.Sprintf("postdb:%s", path.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ids := []string{
+		fmt.Sprintf("v%d/foo@1.0.0/es2021/foo.js", VERSION),
+		fmt.Sprintf("v%d/foo@1.0.0/es2022/foo.development.js", VERSION),
+		fmt.Sprintf("v%d/foo@1.0.1/es2021/foo.js", VERSION),
+		fmt.Sprintf("v%d/foo-bar@1.0.0/es2021/foo-bar.js", VERSION),
+	}
+	for _, id := range ids {
+		db.Put(id, "build", storage.Store{"id": id, "esm": "{}"})
+	}
+	db.Put("build-error:"+ids[0], "build-error", storage.Store{"taskId": ids[0]})
+
+	evicted, err := evictPackageBuilds("foo@1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(evicted)
+	if !reflect.DeepEqual(evicted, ids[:2]) {
+		t.Fatalf("unexpected evicted builds: %v", evicted)
+	}
+	for i, id := range append(ids, "build-error:"+ids[0]) {
+		_, _, err = db.Get(id)
+		if (i < 2 || i == 4) != (err == storage.ErrNotFound) {
+			t.Fatalf("unexpected record of '%s': %v", id, err)
+		}
+	}
+}
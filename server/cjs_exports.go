@@ -2,7 +2,19 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
 	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
 )
 
 type cjsExportsResult struct {
@@ -20,3 +32,140 @@ func parseCJSModuleExports(buildDir string, importPath string, nodeEnv string) (
 	err = json.Unmarshal(data, &ret)
 	return
 }
+
+var regCJSExports = []*regexp.Regexp{
+	// exports.foo = ...
+	regexp.MustCompile(`(?:^|[^.\w$])(?:module\.)?exports\.([A-Za-z_$][\w$]*)\s*=[^=]`),
+	// exports["foo"] = ...
+	regexp.MustCompile(`(?:^|[^.\w$])(?:module\.)?exports\[\s*["']([A-Za-z_$][\w$]*)["']\s*\]\s*=[^=]`),
+	// Object.defineProperty(exports, "foo", ...)
+	regexp.MustCompile(`Object\.defineProperty\(\s*(?:module\.)?exports\s*,\s*["']([A-Za-z_$][\w$]*)["']`),
+}
+
+// parseCJSExportsStatic detects the named exports of the CommonJS module by the
+// assignment patterns like `exports.foo = ...` statically, it's less accurate than
+// the `parseCjsExports` node service which follows the re-exports.
+func parseCJSExportsStatic(code []byte) []string {
+	exports := []string{}
+	set := newStringSet()
+	for _, reg := range regCJSExports {
+		for _, m := range reg.FindAllSubmatch(code, -1) {
+			name := string(m[1])
+			if name != "__esModule" && !set.Has(name) {
+				set.Add(name)
+				exports = append(exports, name)
+			}
+		}
+	}
+	return exports
+}
+
+// serveCJSExports serves the named exports of the CommonJS main entry of the
+// package detected by the `parseCjsExports` node service without building, the
+// result is cached in the db. It falls back to `parseCJSExportsStatic` if the
+// node service times out.
+func serveCJSExports(ctx *rex.Context, pkg *Pkg) interface{} {
+	key := fmt.Sprintf("cjs-exports:%s@%s", pkg.Name, pkg.Version)
+	store, _, err := db.Get(key)
+	if err == nil {
+		var exports []string
+		if json.Unmarshal([]byte(store["exports"]), &exports) == nil {
+			ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+			return exports
+		}
+	} else if err != storage.ErrNotFound {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	wd := tempDir(fmt.Sprintf("esm-cjs-exports-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	defer os.RemoveAll(wd)
+
+	err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version), 3, time.Second)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	ret, err := parseCJSModuleExports(wd, pkg.Name, "production")
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if ret.Error == "timeout" {
+		exports, err := parseMainCJSExportsStatic(wd, pkg.Name)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		// don't cache the static result, the node service may work next time
+		ctx.SetHeader("Cache-Control", "public, max-age=600")
+		return exports
+	}
+	if ret.Error != "" {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: ret.Error})
+	}
+	if ret.Exports == nil {
+		ret.Exports = []string{}
+	}
+
+	err = db.Put(key, "cjs-exports", storage.Store{"exports": string(utils.MustEncodeJSON(ret.Exports))})
+	if err != nil {
+		log.Errorf("db: %v", err)
+	}
+	ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	return ret.Exports
+}
+
+// parseMainCJSExportsStatic parses the exports of the `main` entry of the installed package statically
+func parseMainCJSExportsStatic(wd string, pkgName string) ([]string, error) {
+	packageFile, err := findPackageJSON(wd, pkgName)
+	if err != nil {
+		return nil, err
+	}
+	var p NpmPackage
+	err = utils.ParseJSONFile(packageFile, &p)
+	if err != nil {
+		return nil, err
+	}
+	main := p.Main
+	if main == "" {
+		main = "index.js"
+	}
+	filename := path.Join(path.Dir(packageFile), main)
+	if !fileExists(filename) {
+		if fileExists(filename + ".js") {
+			filename += ".js"
+		} else if !strings.HasSuffix(filename, ".js") {
+			filename = path.Join(filename, "index.js")
+		}
+	}
+	code, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseCJSExportsStatic(code), nil
+}
+
+type virtualModuleResult struct {
+	Code   string `json:"code"`
+	Loader string `json:"loader"`
+	Error  string `json:"error"`
+}
+
+// loadVirtualModule loads the virtual module like `virtual:modules` by the
+// `virtualModules` node service
+func loadVirtualModule(specifier string) (ret virtualModuleResult, err error) {
+	data := invokeNodeService("virtualModules", map[string]interface{}{
+		"specifier": specifier,
+	}, 10*time.Second)
+
+	err = json.Unmarshal(data, &ret)
+	if err == nil && ret.Error != "" {
+		err = errors.New(ret.Error)
+	}
+	if err != nil {
+		err = fmt.Errorf("load virtual module '%s': %v", specifier, err)
+	}
+	return
+}
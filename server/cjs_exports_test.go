@@ -0,0 +1,23 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCJSExportsStatic(t *testing.T) {
+	code := `"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+exports.foo = void 0;
+module.exports.bar = function bar() {};
+exports["baz"] = 1;
+Object.defineProperty(exports, 'qux', { enumerable: true, get: function () { return 1; } });
+if (exports.foo === undefined) {}
+myexports.nope = 1;
+exports.foo = 1;
+`
+	exports := parseCJSExportsStatic([]byte(code))
+	if !reflect.DeepEqual(exports, []string{"foo", "bar", "baz", "qux"}) {
+		t.Fatalf("unexpected exports: %v", exports)
+	}
+}
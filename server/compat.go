@@ -2,6 +2,7 @@ package server
 
 import (
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -12,6 +13,7 @@ import (
 
 var regBrowserVersion = regexp.MustCompile(`^([0-9]+)(?:\.([0-9]+))?(?:\.([0-9]+))?$`)
 
+// all the ES targets of esbuild except `es5` which is not supported by the ES module
 var targets = map[string]api.Target{
 	"es2015": api.ES2015,
 	"es2016": api.ES2016,
@@ -34,6 +36,18 @@ var engines = map[string]api.EngineName{
 	"safari":  api.EngineSafari,
 }
 
+// the major version ranges of the engine targets, the versions out of the range don't
+// change the build: the min version is the first one supporting the ES modules, and the
+// max version is the latest one in the compat table of esbuild.
+var engineVersionRanges = map[string][2]int{
+	"node":    {12, 16},
+	"chrome":  {61, 91},
+	"edge":    {16, 85},
+	"firefox": {60, 90},
+	"ios":     {11, 15},
+	"safari":  {11, 15},
+}
+
 var jsFeatures = []compat.JSFeature{
 	compat.ArbitraryModuleNamespaceNames,
 	compat.ArraySpread,
@@ -80,6 +94,11 @@ var jsFeatures = []compat.JSFeature{
 }
 
 func validateESMAFeatures(target api.Target) int {
+	return countFeatures(compat.UnsupportedJSFeatures(esConstraints(target)))
+}
+
+// esConstraints returns the constraints of the ES target for the compat table
+func esConstraints(target api.Target) map[compat.Engine][]int {
 	constraints := make(map[compat.Engine][]int)
 
 	switch target {
@@ -101,8 +120,7 @@ func validateESMAFeatures(target api.Target) int {
 	default:
 		panic("invalid target")
 	}
-
-	return countFeatures(compat.UnsupportedJSFeatures(constraints))
+	return constraints
 }
 
 func validateEngineFeatures(engine api.Engine) int {
@@ -178,3 +196,99 @@ func getTargetByUA(ua string) string {
 	}
 	return target
 }
+
+// getEngineTargetByUA returns the engine target like `chrome90` by the user agent,
+// or `es2021` (the latest ES target of esbuild) if the browser is unrecognized.
+func getEngineTargetByUA(ua string) string {
+	name, version := user_agent.New(ua).Browser()
+	name = strings.ToLower(name)
+	if _, ok := engines[name]; ok && name != "node" {
+		if target, ok := normalizeEngineTarget(name + version); ok {
+			return target
+		}
+	}
+	return "es2021"
+}
+
+// parseEngineTarget parses the engine target like `chrome90` or `safari15.1`
+func parseEngineTarget(target string) (engine api.Engine, ok bool) {
+	for name, e := range engines {
+		if strings.HasPrefix(target, name) {
+			version := strings.TrimPrefix(target, name)
+			if regBrowserVersion.MatchString(version) {
+				return api.Engine{Name: e, Version: version}, true
+			}
+		}
+	}
+	return
+}
+
+// normalizeEngineTarget normalizes the engine target like `safari15.1` to the major
+// version `safari15`, the version is clamped to the range of `engineVersionRanges` to
+// not create the builds that make no difference.
+func normalizeEngineTarget(target string) (string, bool) {
+	for name, r := range engineVersionRanges {
+		if strings.HasPrefix(target, name) {
+			match := regBrowserVersion.FindStringSubmatch(strings.TrimPrefix(target, name))
+			if match == nil {
+				continue
+			}
+			major, err := strconv.Atoi(match[1])
+			if err != nil {
+				continue
+			}
+			if major < r[0] {
+				major = r[0]
+			} else if major > r[1] {
+				major = r[1]
+			}
+			return name + strconv.Itoa(major), true
+		}
+	}
+	return "", false
+}
+
+// normalizeTarget normalizes the engine target of the `target` query, see
+// `normalizeEngineTarget`, the other targets are returned as is.
+func normalizeTarget(target string) string {
+	if t, ok := normalizeEngineTarget(target); ok {
+		return t
+	}
+	return target
+}
+
+// validTargetNames returns the names of the valid targets, the engine targets
+// are listed like `chrome<version>`.
+func validTargetNames() []string {
+	names := []string{"auto"}
+	for name := range targets {
+		names = append(names, name)
+	}
+	for name := range engines {
+		names = append(names, name+"<version>")
+	}
+	names = append(names, browserslistTargetPrefix+"<query>")
+	sort.Strings(names[1:])
+	return names
+}
+
+// the common targets that are pre-built, like rebuilding the invalidated packages
+var commonTargets = []string{"es2021", "esnext", "deno", "node"}
+
+// isBrowserTarget returns true if the target is not for the server runtimes
+func isBrowserTarget(target string) bool {
+	return target != "types" && target != "deno" && !strings.HasPrefix(target, "node")
+}
+
+// isValidTarget checks the target, only the normalized engine targets are valid
+// since the target is a part of the build ID.
+func isValidTarget(target string) bool {
+	if _, ok := targets[target]; ok {
+		return true
+	}
+	if _, ok := parseBrowserslistTarget(target); ok {
+		return true
+	}
+	t, ok := normalizeEngineTarget(target)
+	return ok && t == target
+}
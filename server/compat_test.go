@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestNormalizeEngineTarget(t *testing.T) {
+	for target, expected := range map[string]string{
+		"chrome90":      "chrome90",
+		"chrome90.0.1":  "chrome90",
+		"safari14.1":    "safari14",
+		"chrome999":     "chrome91",
+		"firefox2":      "firefox60",
+		"node100000000": "node16",
+	} {
+		normalized, ok := normalizeEngineTarget(target)
+		if !ok || normalized != expected {
+			t.Fatalf("unexpected normalized target of '%s': %s", target, normalized)
+		}
+		if !isValidTarget(normalized) || isValidTarget(target) != (target == expected) {
+			t.Fatalf("only the normalized target '%s' should be valid", normalized)
+		}
+	}
+	for _, target := range []string{"node", "es2021", "chrome", "chrome1a", "opera90"} {
+		if _, ok := normalizeEngineTarget(target); ok {
+			t.Fatalf("'%s' is not an engine target", target)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/ije/gox/utils"
+)
+
+// the extensions of the pre-compressed build files by the content encodings
+var precompressedExts = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// compressArtifacts generates the brotli and gzip versions (`.br` and `.gz`) of the
+// build file alongside the raw file, the pre-compressed files are served directly
+// instead of compressing the file on every request.
+func compressArtifacts(id string) error {
+	r, err := fs.ReadFile(path.Join("builds", id))
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	for _, encoding := range []string{"br", "gzip"} {
+		compressed, err := compressData(data, encoding)
+		if err != nil {
+			return err
+		}
+		err = fs.WriteData(path.Join("builds", id+precompressedExts[encoding]), compressed)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressData compresses the data with the best compression level of the encoding
+func compressData(data []byte, encoding string) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	var w io.WriteCloser
+	switch encoding {
+	case "br":
+		w = brotli.NewWriterLevel(buf, brotli.BestCompression)
+	default:
+		w, _ = gzip.NewWriterLevel(buf, gzip.BestCompression)
+	}
+	_, err := w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// preferredEncoding returns the preferred content encoding of the `Accept-Encoding`
+// header, `br` is preferred over `gzip`, and the encodings with `q=0` are ignored.
+func preferredEncoding(acceptEncoding string) string {
+	var encoding string
+	for _, p := range strings.Split(acceptEncoding, ",") {
+		name, params := utils.SplitByFirstByte(p, ';')
+		if q := strings.TrimSpace(params); strings.HasPrefix(q, "q=") {
+			if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "br":
+			encoding = "br"
+		case "gzip":
+			if encoding == "" {
+				encoding = "gzip"
+			}
+		}
+	}
+	return encoding
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPreferredEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                        "",
+		"gzip, deflate":           "gzip",
+		"gzip, deflate, br":       "br",
+		"br;q=1.0, gzip;q=0.8":    "br",
+		"br;q=0, gzip":            "gzip",
+		"identity, deflate;q=0.5": "",
+		"GZIP":                    "gzip",
+	} {
+		if encoding := preferredEncoding(header); encoding != expected {
+			t.Fatalf("unexpected encoding of '%s': %s", header, encoding)
+		}
+	}
+}
+
+func TestCompressData(t *testing.T) {
+	data := bytes.Repeat([]byte("export default function foo() {}\n"), 100)
+	compressed, err := compressData(data, "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(data) {
+		t.Fatalf("the data is not compressed: %d >= %d", len(compressed), len(data))
+	}
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, data) {
+		t.Fatal("the decompressed data is not equal to the raw data")
+	}
+}
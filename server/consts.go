@@ -1,7 +1,9 @@
 package server
 
+import "esm.sh/pkg/esmmeta"
+
 // ems.sh version
-const VERSION = 58
+const VERSION = esmmeta.VERSION
 
 const (
 	pkgCacheTimeout    = 5 * 60 // 5 minutes
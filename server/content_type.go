@@ -0,0 +1,51 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/ije/rex"
+)
+
+// jsContentType returns the content type of the JS responses by the `Accept` header,
+// the standard `text/javascript` is used unless the client asks for the legacy
+// `application/javascript` explicitly.
+func jsContentType(accept string) string {
+	if strings.Contains(accept, "application/javascript") && !strings.Contains(accept, "text/javascript") {
+		return "application/javascript; charset=utf-8"
+	}
+	return "text/javascript; charset=utf-8"
+}
+
+// setJSContentType sets the content type of the JS response negotiated by the `Accept`
+// header, and adds `Accept` to the `Vary` header for the caches.
+func setJSContentType(ctx *rex.Context) {
+	ctx.SetHeader("Content-Type", jsContentType(ctx.R.Header.Get("Accept")))
+	addVary(ctx, "Accept")
+}
+
+// addVary adds the header name to the `Vary` header if it's not added. The
+// `AutoCompress` middleware of rex doesn't add `Accept-Encoding` if the `Vary` header
+// is set, so it's added as well unless the compression is disabled.
+func addVary(ctx *rex.Context, name string) {
+	names := []string{name}
+	if !noCompress {
+		names = append(names, "Accept-Encoding")
+	}
+	vary := ctx.W.Header().Get("Vary")
+	for _, name := range names {
+		if !hasVary(vary, name) {
+			vary = strings.TrimPrefix(vary+", "+name, ", ")
+		}
+	}
+	ctx.SetHeader("Vary", vary)
+}
+
+// hasVary checks whether the `Vary` header value contains the header name
+func hasVary(vary string, name string) bool {
+	for _, v := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), name) {
+			return true
+		}
+	}
+	return false
+}
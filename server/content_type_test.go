@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ije/rex"
+)
+
+func TestJSContentType(t *testing.T) {
+	for accept, contentType := range map[string]string{
+		"":                                  "text/javascript; charset=utf-8",
+		"*/*":                               "text/javascript; charset=utf-8",
+		"text/javascript":                   "text/javascript; charset=utf-8",
+		"application/javascript":            "application/javascript; charset=utf-8",
+		"application/javascript, */*;q=0.8": "application/javascript; charset=utf-8",
+		"text/javascript, application/javascript": "text/javascript; charset=utf-8",
+	} {
+		if ret := jsContentType(accept); ret != contentType {
+			t.Fatalf("unexpected content type of '%s': %s", accept, ret)
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	defer func(v bool) { noCompress = v }(noCompress)
+
+	for _, c := range []struct {
+		noCompress bool
+		vary       string
+		expected   string
+	}{
+		{false, "", "Accept, Accept-Encoding"},
+		{false, "Origin", "Origin, Accept, Accept-Encoding"},
+		{false, "accept-encoding, accept", "accept-encoding, accept"},
+		{true, "", "Accept"},
+		{true, "Origin", "Origin, Accept"},
+	} {
+		noCompress = c.noCompress
+		ctx := &rex.Context{W: httptest.NewRecorder(), R: httptest.NewRequest("GET", "/", nil)}
+		if c.vary != "" {
+			ctx.SetHeader("Vary", c.vary)
+		}
+		addVary(ctx, "Accept")
+		if vary := ctx.W.Header().Get("Vary"); vary != c.expected {
+			t.Fatalf("unexpected Vary of %q (noCompress=%v): %q", c.vary, c.noCompress, vary)
+		}
+	}
+}
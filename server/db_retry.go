@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+)
+
+// the index of the records failed to store in the db, the records are
+// saved as `<id>.pending-db.json` files in the fs
+const pendingDBIndexFile = "builds/pending-db.json"
+
+var pendingDBLock sync.Mutex
+
+// pendingDBRecord is the record failed to store in the db, with its category
+type pendingDBRecord struct {
+	Category string        `json:"category"`
+	Store    storage.Store `json:"store"`
+}
+
+// putDBWithRetry puts the store to the db with 3 attempts and exponential backoff
+// (100ms, 200ms), if all attempts fail, the store is saved in the fs and will be
+// retried by `reconcilePendingDBRecords`.
+func putDBWithRetry(id string, category string, store storage.Store) (err error) {
+	delay := 100 * time.Millisecond
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		err = db.Put(id, category, store)
+		if err == nil {
+			return
+		}
+	}
+	log.Warnf("db: put %s failed after 3 attempts, save it as pending: %v", id, err)
+	return savePendingDBRecord(id, category, store)
+}
+
+// savePendingDBRecord saves the record in the fs and adds the id to the index
+func savePendingDBRecord(id string, category string, store storage.Store) error {
+	pendingDBLock.Lock()
+	defer pendingDBLock.Unlock()
+
+	err := fs.WriteData(pendingDBRecordFile(id), utils.MustEncodeJSON(pendingDBRecord{category, store}))
+	if err != nil {
+		return err
+	}
+	ids, err := readPendingDBIndex()
+	if err != nil {
+		return err
+	}
+	for _, v := range ids {
+		if v == id {
+			return nil
+		}
+	}
+	return writePendingDBIndex(append(ids, id))
+}
+
+// reconcilePendingDBRecords retries to store the pending build records in the db
+// periodically.
+func reconcilePendingDBRecords(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		n, err := storePendingDBRecords()
+		if err != nil {
+			log.Warnf("db: reconcile pending records: %v", err)
+		} else if n > 0 {
+			log.Infof("db: %d pending records stored", n)
+		}
+	}
+}
+
+// storePendingDBRecords stores the pending records in the db in their categories,
+// and removes the stored ones from the index. The fs has no delete API, the record
+// files are left as is and will be overwritten when the same build fails to store again.
+func storePendingDBRecords() (stored int, err error) {
+	pendingDBLock.Lock()
+	defer pendingDBLock.Unlock()
+
+	ids, err := readPendingDBIndex()
+	if err != nil || len(ids) == 0 {
+		return
+	}
+
+	var pending []string
+	for _, id := range ids {
+		record, e := readPendingDBRecord(id)
+		if e != nil {
+			log.Warnf("db: read pending record %s: %v", id, e)
+			pending = append(pending, id)
+			continue
+		}
+		if e := db.Put(id, record.Category, record.Store); e != nil {
+			pending = append(pending, id)
+			continue
+		}
+		stored++
+	}
+	if stored > 0 {
+		err = writePendingDBIndex(pending)
+	}
+	return
+}
+
+func pendingDBRecordFile(id string) string {
+	return path.Join("builds", id+".pending-db.json")
+}
+
+func readPendingDBRecord(id string) (record pendingDBRecord, err error) {
+	r, err := fs.ReadFile(pendingDBRecordFile(id))
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	err = json.NewDecoder(r).Decode(&record)
+	return
+}
+
+func readPendingDBIndex() (ids []string, err error) {
+	exists, _, err := fs.Exists(pendingDBIndexFile)
+	if err != nil || !exists {
+		return
+	}
+	r, err := fs.ReadFile(pendingDBIndexFile)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &ids)
+	return
+}
+
+func writePendingDBIndex(ids []string) error {
+	sort.Strings(ids)
+	if ids == nil {
+		ids = []string{}
+	}
+	return fs.WriteData(pendingDBIndexFile, utils.MustEncodeJSON(ids))
+}
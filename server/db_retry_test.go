@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"testing"
+
+	"esm.sh/server/storage"
+)
+
+// flakyDB fails the first `failures` puts
+type flakyDB struct {
+	storage.DB
+	failures int
+	puts     int
+}
+
+func (db *flakyDB) Put(id string, category string, store storage.Store) error {
+	db.puts++
+	if db.puts <= db.failures {
+		return errors.New("connection reset")
+	}
+	return db.DB.Put(id, category, store)
+}
+
+func TestPutDBWithRetry(t *testing.T) {
+	testDir := t.TempDir()
+	var err error
+	fs, err = storage.OpenFS(fmt.Sprintf("local:%s", testDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	postdb, err := storage.OpenDB(fmt.Sprintf("postdb:%s", path.Join(testDir, "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer postdb.Close()
+
+	id := fmt.Sprintf("v%d/test@1.0.0/es2021/test.js", VERSION)
+	store := storage.Store{"id": id, "esm": "{}"}
+
+	// succeeds in the third attempt
+	db = &flakyDB{DB: postdb, failures: 2}
+	err = putDBWithRetry(id, "build", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := postdb.Get(id); err != nil {
+		t.Fatalf("the record should be stored: %v", err)
+	}
+	if ids, _ := readPendingDBIndex(); len(ids) != 0 {
+		t.Fatalf("unexpected pending records: %v", ids)
+	}
+	postdb.Delete(id)
+
+	// all attempts fail, the record is saved as pending
+	db = &flakyDB{DB: postdb, failures: 3}
+	err = putDBWithRetry(id, "build", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := readPendingDBIndex(); len(ids) != 1 || ids[0] != id {
+		t.Fatalf("unexpected pending records: %v", ids)
+	}
+	if _, _, err := postdb.Get(id); err != storage.ErrNotFound {
+		t.Fatalf("the record should not be stored: %v", err)
+	}
+
+	// the pending record is stored by the reconciliation
+	stored, err := storePendingDBRecords()
+	if err != nil || stored != 1 {
+		t.Fatalf("unexpected reconciliation: %d %v", stored, err)
+	}
+	if s, _, err := postdb.Get(id); err != nil || s["esm"] != "{}" {
+		t.Fatalf("the record should be stored: %v %v", s, err)
+	}
+	if ids, err := postdb.ListIDs("build", id); err != nil || len(ids) != 1 {
+		t.Fatalf("the record should be stored in the build category: %v %v", ids, err)
+	}
+	if ids, _ := readPendingDBIndex(); len(ids) != 0 {
+		t.Fatalf("unexpected pending records: %v", ids)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// the latest version of deno std, synced by `syncDenoStdVersion`
+var latestDenoStdVersion atomic.Value
+
+// getDenoStdVersion returns the synced version of deno std, or the compiled-in
+// `denoStdNodeVersion` if it hasn't been synced.
+func getDenoStdVersion() string {
+	if v, ok := latestDenoStdVersion.Load().(string); ok && v != "" {
+		return v
+	}
+	return denoStdNodeVersion
+}
+
+// syncDenoStdVersion fetches the latest stable version of deno std periodically.
+func syncDenoStdVersion(interval time.Duration) {
+	for {
+		version, err := fetchDenoStdVersion()
+		if err != nil {
+			log.Warnf("sync deno std version: %v", err)
+		} else if version != getDenoStdVersion() {
+			latestDenoStdVersion.Store(version)
+			log.Infof("deno std version updated to %s", version)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func fetchDenoStdVersion() (version string, err error) {
+	resp, err := httpClient.Get("https://cdn.deno.land/std/meta/versions.json")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+		return
+	}
+
+	var meta struct {
+		Latest   string   `json:"latest"`
+		Versions []string `json:"versions"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&meta)
+	if err != nil {
+		return
+	}
+
+	// skip the pre-releases like `0.100.0-rc.1`
+	if meta.Latest != "" && !strings.Contains(meta.Latest, "-") {
+		return meta.Latest, nil
+	}
+	for _, v := range meta.Versions {
+		if !strings.Contains(v, "-") {
+			return v, nil
+		}
+	}
+	err = fmt.Errorf("no stable version found")
+	return
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// the max depth of the dependency graph of the `+dependencies` API
+const maxDependencyDepth = 5
+
+// yarnLockEntry defines a resolved package in the `yarn.lock`, the dependencies
+// are the specs like `loose-envify@^1.1.0` that map to the other entries.
+type yarnLockEntry struct {
+	Name         string
+	Version      string
+	Dependencies []string
+}
+
+// dependencyNode defines a node of the D3 hierarchical JSON
+type dependencyNode struct {
+	ID       string           `json:"id"`
+	Children []dependencyNode `json:"children,omitempty"`
+}
+
+type flatDependency struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// serveDependencies serves the resolved dependency graph of the package as a D3
+// hierarchical JSON like `{"id":"react@17.0.2","children":[{"id":"loose-envify@1.4.0"}]}`
+// (up to 5 levels), or a flat list of all the transitive dependencies with the
+// `flat` query. The result is cached for 24 hours.
+func serveDependencies(ctx *rex.Context, pkg *Pkg) interface{} {
+	flat := ctx.Form.Value("flat") == "true" || ctx.Form.Value("flat") == "1"
+	key := fmt.Sprintf("dependencies:%s@%s:%v", pkg.Name, pkg.Version, flat)
+	data, err := cache.Get(key)
+	if err != nil {
+		entries, err := readYarnLockEntries(pkg)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		if flat {
+			data = utils.MustEncodeJSON(map[string]interface{}{
+				"id":           fmt.Sprintf("%s@%s", pkg.Name, pkg.Version),
+				"dependencies": flattenDependencies(entries, pkg.Name, pkg.Version),
+			})
+		} else {
+			data = utils.MustEncodeJSON(buildDependencyTree(entries, pkg.Name, pkg.Version, maxDependencyDepth))
+		}
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
+
+// readYarnLockEntries installs the package by yarn in a temporary directory and
+// parses the generated `yarn.lock`.
+func readYarnLockEntries(pkg *Pkg) (entries map[string]*yarnLockEntry, err error) {
+	wd := tempDir(fmt.Sprintf("esm-dependencies-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+
+	err = yarnAdd(wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version))
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadFile(path.Join(wd, "yarn.lock"))
+	if err != nil {
+		return
+	}
+	return parseYarnLockEntries(data), nil
+}
+
+// parseYarnLockEntries parses the entries of the `yarn.lock` keyed by the specs,
+// both the classic format and the berry format are supported like `parseYarnLock`.
+func parseYarnLockEntries(data []byte) map[string]*yarnLockEntry {
+	entries := map[string]*yarnLockEntry{}
+	var entry *yarnLockEntry
+	inDeps := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// the entry line like `"@babel/core@^7.0.0", "@babel/core@^7.1.0":`
+		if !strings.HasPrefix(line, " ") && strings.HasSuffix(line, ":") {
+			entry = nil
+			inDeps = false
+			for _, spec := range strings.Split(strings.TrimSuffix(line, ":"), ",") {
+				spec = strings.Trim(strings.TrimSpace(spec), `"`)
+				if i := strings.LastIndexByte(spec, '@'); i > 0 {
+					if entry == nil {
+						entry = &yarnLockEntry{Name: spec[:i]}
+					}
+					entries[spec] = entry
+				}
+			}
+			continue
+		}
+		if entry == nil {
+			continue
+		}
+		field := strings.TrimSpace(line)
+		// the fields of the entry are indented by 2 spaces, the dependencies by 4 spaces
+		if !strings.HasPrefix(line, "    ") {
+			inDeps = field == "dependencies:" || field == "optionalDependencies:"
+			if strings.HasPrefix(field, "version") {
+				entry.Version = strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(field, "version"), ":")), `"`)
+			}
+			continue
+		}
+		if inDeps {
+			name, version := splitYarnLockField(field)
+			if name != "" && version != "" {
+				entry.Dependencies = append(entry.Dependencies, name+"@"+version)
+			}
+		}
+	}
+	return entries
+}
+
+// splitYarnLockField splits the dependency field like `loose-envify "^1.1.0"` (classic)
+// or `"@types/react": "npm:^17.0.0"` (berry) into the name and the version range.
+func splitYarnLockField(field string) (name string, version string) {
+	if strings.HasPrefix(field, `"`) {
+		i := strings.IndexByte(field[1:], '"')
+		if i < 0 {
+			return
+		}
+		name, version = field[1:i+1], field[i+2:]
+	} else {
+		i := strings.IndexAny(field, " :")
+		if i < 0 {
+			return
+		}
+		name, version = field[:i], field[i:]
+	}
+	version = strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(version), ":")), `"`)
+	return
+}
+
+// findYarnLockEntry finds the entry of the package with the exact version
+func findYarnLockEntry(entries map[string]*yarnLockEntry, name string, version string) *yarnLockEntry {
+	for _, entry := range entries {
+		if entry.Name == name && entry.Version == version {
+			return entry
+		}
+	}
+	return nil
+}
+
+// buildDependencyTree builds the dependency tree of the package up to the depth,
+// the circular dependencies are listed without children.
+func buildDependencyTree(entries map[string]*yarnLockEntry, name string, version string, depth int) dependencyNode {
+	var walk func(entry *yarnLockEntry, depth int, ancestors map[*yarnLockEntry]bool) dependencyNode
+	walk = func(entry *yarnLockEntry, depth int, ancestors map[*yarnLockEntry]bool) dependencyNode {
+		node := dependencyNode{ID: fmt.Sprintf("%s@%s", entry.Name, entry.Version)}
+		if depth <= 0 || ancestors[entry] {
+			return node
+		}
+		ancestors[entry] = true
+		for _, spec := range entry.Dependencies {
+			if dep, ok := entries[spec]; ok {
+				node.Children = append(node.Children, walk(dep, depth-1, ancestors))
+			}
+		}
+		delete(ancestors, entry)
+		return node
+	}
+
+	entry := findYarnLockEntry(entries, name, version)
+	if entry == nil {
+		return dependencyNode{ID: fmt.Sprintf("%s@%s", name, version)}
+	}
+	return walk(entry, depth, map[*yarnLockEntry]bool{})
+}
+
+// flattenDependencies returns all the transitive dependencies of the package sorted
+// by the name, the package that is resolved to multiple versions is listed for each.
+func flattenDependencies(entries map[string]*yarnLockEntry, name string, version string) []flatDependency {
+	list := []flatDependency{}
+	root := findYarnLockEntry(entries, name, version)
+	if root == nil {
+		return list
+	}
+	visited := map[*yarnLockEntry]bool{root: true}
+	queue := []*yarnLockEntry{root}
+	for len(queue) > 0 {
+		entry := queue[0]
+		queue = queue[1:]
+		for _, spec := range entry.Dependencies {
+			if dep, ok := entries[spec]; ok && !visited[dep] {
+				visited[dep] = true
+				list = append(list, flatDependency{dep.Name, dep.Version})
+				queue = append(queue, dep)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name == list[j].Name {
+			return compareVersions(list[i].Version, list[j].Version) < 0
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	classic := `# yarn lockfile v1
+
+
+react-dom@17.0.2:
+  version "17.0.2"
+  resolved "https://registry.yarnpkg.com/react-dom/-/react-dom-17.0.2.tgz"
+  dependencies:
+    loose-envify "^1.1.0"
+    object-assign "^4.1.1"
+    scheduler "^0.20.2"
+
+"js-tokens@^3.0.0 || ^4.0.0":
+  version "4.0.0"
+
+loose-envify@^1.1.0:
+  version "1.4.0"
+  dependencies:
+    js-tokens "^3.0.0 || ^4.0.0"
+
+object-assign@^4.1.1:
+  version "4.1.1"
+
+scheduler@^0.20.2:
+  version "0.20.2"
+  dependencies:
+    loose-envify "^1.1.0"
+    object-assign "^4.1.1"
+`
+	entries := parseYarnLockEntries([]byte(classic))
+	tree := buildDependencyTree(entries, "react-dom", "17.0.2", maxDependencyDepth)
+	if tree.ID != "react-dom@17.0.2" || len(tree.Children) != 3 {
+		t.Fatalf("unexpected tree: %v", tree)
+	}
+	if c := tree.Children[0]; c.ID != "loose-envify@1.4.0" || len(c.Children) != 1 || c.Children[0].ID != "js-tokens@4.0.0" {
+		t.Fatalf("unexpected node: %v", c)
+	}
+	if c := tree.Children[2]; c.ID != "scheduler@0.20.2" || len(c.Children) != 2 || len(c.Children[0].Children) != 1 {
+		t.Fatalf("unexpected node: %v", c)
+	}
+
+	// the depth limit
+	tree = buildDependencyTree(entries, "react-dom", "17.0.2", 1)
+	if len(tree.Children) != 3 || len(tree.Children[0].Children) != 0 {
+		t.Fatalf("unexpected tree: %v", tree)
+	}
+
+	list := flattenDependencies(entries, "react-dom", "17.0.2")
+	if len(list) != 4 || list[0] != (flatDependency{"js-tokens", "4.0.0"}) || list[3] != (flatDependency{"scheduler", "0.20.2"}) {
+		t.Fatalf("unexpected dependencies: %v", list)
+	}
+
+	berry := `__metadata:
+  version: 4
+
+"a@npm:1.0.0":
+  version: 1.0.0
+  resolution: "a@npm:1.0.0"
+  dependencies:
+    "@scope/b": "npm:^2.0.0"
+
+"@scope/b@npm:^2.0.0":
+  version: 2.1.0
+  resolution: "@scope/b@npm:2.1.0"
+  dependencies:
+    a: "npm:1.0.0"
+`
+	entries = parseYarnLockEntries([]byte(berry))
+	tree = buildDependencyTree(entries, "a", "1.0.0", maxDependencyDepth)
+	// the circular dependency
+	if len(tree.Children) != 1 || tree.Children[0].ID != "@scope/b@2.1.0" || len(tree.Children[0].Children) != 1 || tree.Children[0].Children[0].Children != nil {
+		t.Fatalf("unexpected tree: %v", tree)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+)
+
+// parseYarnLock parses the resolved versions of the packages in the `yarn.lock`,
+// both the classic format (`version "1.0.0"`) and the berry format (`version: 1.0.0`)
+// are supported. The highest version is picked if a package is resolved to multiple
+// versions.
+func parseYarnLock(data []byte) map[string]string {
+	versions := map[string]string{}
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// the entry line like `"@babel/core@^7.0.0", "@babel/core@^7.1.0":`
+		if !strings.HasPrefix(line, " ") && strings.HasSuffix(line, ":") {
+			names = names[:0]
+			for _, spec := range strings.Split(strings.TrimSuffix(line, ":"), ",") {
+				spec = strings.Trim(strings.TrimSpace(spec), `"`)
+				if i := strings.LastIndexByte(spec, '@'); i > 0 {
+					names = append(names, spec[:i])
+				}
+			}
+			continue
+		}
+		field := strings.TrimSpace(line)
+		if len(names) == 0 || !strings.HasPrefix(field, "version") {
+			continue
+		}
+		version := strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(field, "version"), ":")), `"`)
+		for _, name := range names {
+			if prev, ok := versions[name]; !ok || compareVersions(version, prev) > 0 {
+				versions[name] = version
+			}
+		}
+		names = names[:0]
+	}
+	return versions
+}
+
+// compareVersions compares the versions by the major, minor and patch numbers
+func compareVersions(a string, b string) int {
+	va, _ := parseVersionTuple(a)
+	vb, _ := parseVersionTuple(b)
+	return compareVersionTuple(va, vb, 3)
+}
+
+// writeDepsMap stores the resolved versions of the dependencies in the `yarn.lock`
+// of the build as `builds/<id>.deps.json`, the package itself is excluded.
+func writeDepsMap(task *BuildTask) error {
+	data, err := ioutil.ReadFile(path.Join(task.wd, "yarn.lock"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// the package is not installed by yarn
+			return nil
+		}
+		return err
+	}
+	depsMap := parseYarnLock(data)
+	delete(depsMap, task.Pkg.Name)
+	return fs.WriteData(path.Join("builds", strings.TrimSuffix(task.ID(), ".js")+".deps.json"), utils.MustEncodeJSON(depsMap))
+}
+
+func readDepsMap(id string) (depsMap map[string]string, err error) {
+	savePath := path.Join("builds", strings.TrimSuffix(id, ".js")+".deps.json")
+	exists, _, err := fs.Exists(savePath)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = storage.ErrNotFound
+		return
+	}
+	r, err := fs.ReadFile(savePath)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	err = json.NewDecoder(r).Decode(&depsMap)
+	return
+}
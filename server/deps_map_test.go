@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParseYarnLock(t *testing.T) {
+	classic := `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+"@babel/runtime@^7.0.0", "@babel/runtime@^7.12.5":
+  version "7.16.3"
+  resolved "https://registry.yarnpkg.com/@babel/runtime/-/runtime-7.16.3.tgz"
+  dependencies:
+    regenerator-runtime "^0.13.4"
+
+loose-envify@^1.1.0:
+  version "1.4.0"
+
+scheduler@^0.20.2:
+  version "0.20.2"
+
+scheduler@^0.19.0:
+  version "0.19.1"
+`
+	versions := parseYarnLock([]byte(classic))
+	for name, version := range map[string]string{
+		"@babel/runtime": "7.16.3",
+		"loose-envify":   "1.4.0",
+		"scheduler":      "0.20.2",
+	} {
+		if versions[name] != version {
+			t.Fatalf("the version of %s should be %s, but got %s", name, version, versions[name])
+		}
+	}
+	if len(versions) != 3 {
+		t.Fatalf("unexpected versions: %v", versions)
+	}
+
+	berry := `__metadata:
+  version: 4
+
+"react@npm:^17.0.2":
+  version: 17.0.2
+  resolution: "react@npm:17.0.2"
+`
+	versions = parseYarnLock([]byte(berry))
+	if versions["react"] != "17.0.2" || len(versions) != 1 {
+		t.Fatalf("unexpected versions: %v", versions)
+	}
+}
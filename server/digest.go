@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+// the supported algorithms of the `Digest` header (RFC 3230)
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// parseWantDigest parses the `Want-Digest` request header like `SHA-512;q=0.3, sha-256;q=1`
+// and returns the supported algorithm with the highest qvalue, `sha-256` is used if the
+// header is empty. It returns an empty string if no supported algorithm is wanted.
+func parseWantDigest(header string) string {
+	if strings.TrimSpace(header) == "" {
+		return "sha-256"
+	}
+	var alg string
+	var maxQ float64
+	for _, part := range strings.Split(header, ",") {
+		name, params := part, ""
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			name, params = part[:i], part[i+1:]
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := digestAlgorithms[name]; !ok {
+			continue
+		}
+		q := 1.0
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			q = v
+		}
+		// prefer sha-256 when the qvalues are equal
+		if q > maxQ || (q == maxQ && q > 0 && name == "sha-256") {
+			alg, maxQ = name, q
+		}
+	}
+	return alg
+}
+
+// setDigestHeader computes the digest of the build file with the algorithm wanted by the
+// `Want-Digest` header and sets the `Digest` header, the reader is rewound after reading.
+// The digests are cached for 24 hours as the build files are immutable.
+func setDigestHeader(ctx *rex.Context, name string, r io.ReadSeeker) error {
+	wantDigest := ctx.R.Header.Get("Want-Digest")
+	if wantDigest != "" {
+		addVary(ctx, "Want-Digest")
+	}
+	alg := parseWantDigest(wantDigest)
+	if alg == "" {
+		return nil
+	}
+
+	key := fmt.Sprintf("digest:%s:%s", alg, name)
+	data, err := cache.Get(key)
+	if err != nil {
+		hasher := digestAlgorithms[alg]()
+		_, err = io.Copy(hasher, r)
+		if err == nil {
+			_, err = r.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return err
+		}
+		data = []byte(base64.StdEncoding.EncodeToString(hasher.Sum(nil)))
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Digest", fmt.Sprintf("%s=%s", alg, data))
+	return nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+func TestParseWantDigest(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                           "sha-256",
+		"sha-256":                    "sha-256",
+		"SHA-512":                    "sha-512",
+		"sha-512;q=0.3, sha-256;q=1": "sha-256",
+		"sha-512;q=1, sha-256;q=0.5": "sha-512",
+		"sha-512, sha-256":           "sha-256",
+		"md5, sha":                   "",
+		"sha-256;q=0":                "",
+	} {
+		if alg := parseWantDigest(header); alg != expected {
+			t.Fatalf("parseWantDigest(%q) should be %q, but got %q", header, expected, alg)
+		}
+	}
+}
+
+func TestSetDigestHeader(t *testing.T) {
+	var err error
+	cache, err = storage.OpenCache("memory:main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(v bool) { noCompress = v }(noCompress)
+	noCompress = true
+
+	for wantDigest, expected := range map[string][2]string{
+		"":        {"sha-256=", ""},
+		"sha-512": {"sha-512=", "Want-Digest"},
+		"md5":     {"", "Want-Digest"},
+	} {
+		req := httptest.NewRequest("GET", "/v58/foo@1.0.0/es2021/foo.js", nil)
+		if wantDigest != "" {
+			req.Header.Set("Want-Digest", wantDigest)
+		}
+		ctx := &rex.Context{W: httptest.NewRecorder(), R: req}
+		err = setDigestHeader(ctx, "v58/foo@1.0.0/es2021/foo.js", strings.NewReader("export default 1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest := ctx.W.Header().Get("Digest"); !strings.HasPrefix(digest, expected[0]) || (expected[0] == "" && digest != "") {
+			t.Fatalf("unexpected Digest with %q: %q", wantDigest, digest)
+		}
+		if vary := ctx.W.Header().Get("Vary"); vary != expected[1] {
+			t.Fatalf("unexpected Vary with %q: %q", wantDigest, vary)
+		}
+	}
+}
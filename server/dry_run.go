@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ije/rex"
+)
+
+// DryRunOutput is the output of the dry-run build
+type DryRunOutput struct {
+	*ESM
+	DryRun bool `json:"dryRun"`
+}
+
+// serveDryRunBuild serves the `?dry-run` requests, it runs the build pipeline of the
+// task without writing the files to the fs and the records to the db, then returns
+// the build metadata. The task is built outside of the build queue, so it's only
+// available in dev mode or with the admin token like the `+patch` API.
+func serveDryRunBuild(ctx *rex.Context, task *BuildTask, devMode bool) interface{} {
+	if err := checkAdminRequest(ctx, devMode); err != nil {
+		return err
+	}
+
+	task.DryRun = true
+	esm, err := task.Build()
+	if err != nil {
+		if buildErr, ok := err.(*FailedBuildError); ok {
+			return throwBuildError(ctx, buildErr)
+		}
+		if errors.Is(err, ErrExportBlocked) {
+			return throwAPIError(ctx, 403, APIError{Code: errCodeForbidden, Message: err.Error()})
+		}
+		switch err {
+		case ErrNativeAddon:
+			return throwAPIError(ctx, 422, APIError{Code: errCodeNativeAddon, Message: err.Error()})
+		case ErrYarnTimeout:
+			return throwAPIError(ctx, http.StatusGatewayTimeout, APIError{Code: errCodeTimeout, Message: err.Error()})
+		case ErrOffline:
+			return throwAPIError(ctx, http.StatusServiceUnavailable, APIError{Code: errCodeOffline, Message: err.Error()})
+		}
+		return throwAPIError(ctx, 500, APIError{Code: errCodeBuildFailed, Message: err.Error()})
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	return &DryRunOutput{ESM: esm, DryRun: true}
+}
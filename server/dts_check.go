@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/rex"
+)
+
+// serveDTSCheck serves the `/v{VERSION}/+dts-check?pkg=react@17&types=@types/react@17`
+// requests, it resolves the types of the package without building, the `types`
+// query is optional and the `@types` package is checked if the package doesn't
+// have the types.
+func serveDTSCheck(ctx *rex.Context) interface{} {
+	pkg, err := parsePkg(ctx.Form.Value("pkg"))
+	if err != nil {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeInvalidPackage, Message: fmt.Sprintf("Invalid pkg query: %v", err)})
+	}
+	var typesPkg *Pkg
+	if v := ctx.Form.Value("types"); v != "" {
+		typesPkg, err = parsePkg(v)
+		if err != nil {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeInvalidPackage, Message: fmt.Sprintf("Invalid types query: %v", err)})
+		}
+	}
+
+	ctx.SetHeader("Cache-Control", "public, max-age=3600")
+	dts, err := checkDTS(*pkg, typesPkg)
+	if err != nil {
+		return map[string]interface{}{
+			"available": false,
+			"reason":    err.Error(),
+		}
+	}
+	return map[string]interface{}{
+		"dts":       fmt.Sprintf("v%d/%s", VERSION, dts),
+		"available": true,
+	}
+}
+
+// checkDTS installs the package (and the types package) to resolve the types path
+// by `toTypesPath`, and checks whether the declaration file exists.
+func checkDTS(pkg Pkg, typesPkg *Pkg) (dts string, err error) {
+	wd := tempDir(fmt.Sprintf("esm-dts-check-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+
+	err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version), 3, time.Second)
+	if err != nil {
+		return
+	}
+	p, _, _, err := getPackageInfo(wd, pkg.Name, pkg.Version)
+	if err != nil {
+		return
+	}
+
+	submodule := pkg.Submodule
+	if typesPkg == nil && p.Types == "" && p.Typings == "" {
+		if strings.HasPrefix(p.Name, "@types/") || submodule != "" {
+			err = fmt.Errorf("%s@%s has no types", pkg.Name, pkg.Version)
+			return
+		}
+		var info NpmPackage
+		info, _, _, err = getPackageInfo("", toTypesPackageName(pkg.Name), "latest")
+		if err != nil {
+			err = fmt.Errorf("%s@%s has no types: %v", pkg.Name, pkg.Version, err)
+			return
+		}
+		typesPkg = &Pkg{Name: info.Name, Version: info.Version}
+	}
+	if typesPkg != nil {
+		err = pkgManagerAdd(packageManager, wd, fmt.Sprintf("%s@%s", typesPkg.Name, typesPkg.Version))
+		if err != nil {
+			return
+		}
+		p, _, _, err = getPackageInfo(wd, typesPkg.Name, typesPkg.Version)
+		if err != nil {
+			return
+		}
+		submodule = typesPkg.Submodule
+	}
+
+	dts = toTypesPath(wd, p, submodule, true)
+	if dts == "" {
+		err = fmt.Errorf("%s@%s has no types", p.Name, p.Version)
+		return
+	}
+	if strings.HasSuffix(dts, "~.d.ts") {
+		err = fmt.Errorf("the types '%s' can't be resolved statically", dts)
+		return
+	}
+	filename := path.Join(wd, "node_modules", p.Name, strings.TrimPrefix(dts, fmt.Sprintf("%s@%s", p.Name, p.Version)))
+	if !fileExists(filename) {
+		err = fmt.Errorf("the types '%s' not found", dts)
+	}
+	return
+}
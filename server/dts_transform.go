@@ -5,14 +5,45 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/ije/gox/utils"
 )
 
+var (
+	// the concurrent copies of the same dts are deduplicated, e.g. many builds
+	// may copy `@types/node` at the same time
+	dtsCopyGroup             singleFlight
+	dtsCopyDeduplicatedTotal int64
+	// the build info and the config files published in the package by mistake are
+	// never copied as the declaration files
+	skipExtensions = newStringSet()
+)
+
+func init() {
+	for _, ext := range []string{".tsbuildinfo", ".babelrc", ".eslintrc", ".prettierrc", ".npmrc", ".editorconfig"} {
+		skipExtensions.Add(ext)
+	}
+}
+
+// isSkippedDtsFile checks if the file should be skipped by the extension, the
+// dotfiles like `.babelrc` are matched by the whole name.
+func isSkippedDtsFile(name string) bool {
+	return skipExtensions.Has(strings.ToLower(path.Ext(name)))
+}
+
 func CopyDTS(wd string, resolvePrefix string, dts string) (err error) {
-	return copyDTS(wd, resolvePrefix, dts, newStringSet())
+	err, shared := dtsCopyGroup.Do(resolvePrefix+dts, func() error {
+		return copyDTS(wd, resolvePrefix, dts, newStringSet())
+	})
+	if shared {
+		atomic.AddInt64(&dtsCopyDeduplicatedTotal, 1)
+	}
+	return
 }
 
 func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (err error) {
@@ -22,6 +53,14 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 	}
 	tracing.Add(resolvePrefix + dts)
 
+	if isSkippedDtsFile(dts) {
+		log.Warnf("copyDTS(%s): skip the non-declaration file, it should be excluded from the package", dts)
+		return
+	}
+
+	// the `.d.cts` declaration files are for the CommonJS modules
+	esmTypes := !strings.HasSuffix(dts, ".d.cts")
+
 	a := strings.Split(utils.CleanPath(dts)[1:], "/")
 	versionedName := a[0]
 	subPath := a[1:]
@@ -62,6 +101,14 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 		return
 	}
 
+	// the `paths` of the tsconfig.json are resolved to the declaration files in the package
+	pkgDir := path.Join(wd, "node_modules", pkgName)
+	tsPaths, err := readTsconfigPaths(pkgDir)
+	if err != nil {
+		log.Warnf("copyDTS(%s): tsconfig.json: %v", dts, err)
+		tsPaths = nil
+	}
+
 	pass1stBuf := bytes.NewBuffer(nil)
 	err = walkDts(dtsFile, pass1stBuf, func(importPath string, kind string, position int) string {
 		if kind == "declare module" {
@@ -75,6 +122,26 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 		return
 	}
 
+	// the imports inside the ambient module declarations may reference the packages
+	// that are installed in the build context, ensure they are included in the copy set
+	for _, importPath := range extractAmbientModuleImports(pass1stBuf.Bytes()) {
+		if isLocalImport(importPath) || allDeclareModules.Has(importPath) || strings.HasPrefix(importPath, "node:") {
+			continue
+		}
+		if _, ok := builtInNodeModules[importPath]; ok {
+			continue
+		}
+		info, subpath, formPackageJSON, err := getPackageInfo(wd, importPath, "latest")
+		if err != nil || (info.Types == "" && info.Typings == "") {
+			info, _, formPackageJSON, err = getPackageInfo(wd, toTypesPackageName(importPath), "latest")
+		}
+		if err == nil && formPackageJSON && (info.Types != "" || info.Typings != "") {
+			if dts := toTypesPath(wd, info, subpath, esmTypes); isDtsFile(dts) && !strings.HasSuffix(dts, "~.d.ts") {
+				imports.Add(dts)
+			}
+		}
+	}
+
 	buf := bytes.NewBuffer(nil)
 	if pkgName == "@types/node" {
 		fmt.Fprintf(buf, "/// <reference path=\"%s/v%d/node.ns.d.ts\" />\n", origin, VERSION)
@@ -85,10 +152,8 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 			moduleName := pkgName
 			if len(subPath) > 0 {
 				moduleName += "/" + strings.Join(subPath, "/")
-				if strings.HasSuffix(moduleName, "/index.d.ts") {
-					moduleName = strings.TrimSuffix(moduleName, "/index.d.ts")
-				} else if strings.HasSuffix(moduleName, ".d.ts") {
-					moduleName = strings.TrimSuffix(moduleName, ".d.ts")
+				if ext := dtsExtname(moduleName); ext != "" {
+					moduleName = strings.TrimSuffix(strings.TrimSuffix(moduleName, ext), "/index")
 				}
 			}
 			if strings.HasPrefix(importPath, "node:") {
@@ -110,6 +175,33 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 			return importPath
 		}
 
+		// resolve the `paths` of the tsconfig.json to the relative path like `@utils/types` -> `../utils/types.d.ts`
+		if tsPaths != nil && !strings.HasPrefix(kind, "reference ") && !isLocalImport(importPath) {
+			if dtsFile, ok := resolveTsconfigPathsDts(pkgDir, tsPaths, importPath, dtsExtname(dts)); ok {
+				if rel, err := filepath.Rel(dtsDir, dtsFile); err == nil {
+					importPath = filepath.ToSlash(rel)
+					if !strings.HasPrefix(importPath, ".") {
+						importPath = "./" + importPath
+					}
+				}
+			}
+		}
+
+		// resolve `/// <reference path="..." />` and `/// <reference types="..." />`
+		if strings.HasPrefix(kind, "reference ") {
+			isPath := kind == "reference path"
+			refWd := wd
+			if isPath {
+				refWd = dtsDir
+			}
+			ref, err := resolveTripleSlashReference(refWd, importPath, isPath)
+			if err != nil {
+				log.Warnf("copyDTS(%s): %v", dts, err)
+				return importPath
+			}
+			importPath = ref
+		}
+
 		if isLocalImport(importPath) {
 			if importPath == "." {
 				importPath = "./index.d.ts"
@@ -117,15 +209,13 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 			if importPath == ".." {
 				importPath = "../index.d.ts"
 			}
-			// some types is using `.js` extname
-			if strings.HasSuffix(importPath, ".js") {
-				importPath = strings.TrimSuffix(importPath, ".js")
+			// some types is using `.js` extname, and `.mjs`/`.cjs` for the `.d.mts`/`.d.cts` files
+			if ext := path.Ext(importPath); ext == ".js" || ext == ".mjs" || ext == ".cjs" {
+				importPath = strings.TrimSuffix(importPath, ext)
 			}
-			if !strings.HasSuffix(importPath, ".d.ts") {
-				if fileExists(path.Join(dtsDir, importPath, "index.d.ts")) {
-					importPath = strings.TrimSuffix(importPath, "/") + "/index.d.ts"
-				} else if fileExists(path.Join(dtsDir, importPath+".d.ts")) {
-					importPath = importPath + ".d.ts"
+			if !isDtsFile(importPath) {
+				if dtsFile, ok := findDtsFile(dtsDir, importPath, dtsExtname(dts)); ok {
+					importPath = dtsFile
 				} else {
 					var p NpmPackage
 					packageJSONFile := path.Join(dtsDir, importPath, "package.json")
@@ -138,7 +228,7 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 					}
 				}
 			}
-			if strings.HasSuffix(dts, ".d.ts") && !strings.HasSuffix(dts, "~.d.ts") {
+			if isDtsFile(dts) && !strings.HasSuffix(dts, "~.d.ts") {
 				imports.Add(importPath)
 			}
 		} else {
@@ -162,8 +252,8 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 					prefix := versioned + "/" + resolvePrefix
 					// copy dependent dts files in the node_modules directory in current build context
 					if formPackageJSON {
-						importPath = toTypesPath(wd, info, subpath)
-						if strings.HasSuffix(importPath, ".d.ts") && !strings.HasSuffix(importPath, "~.d.ts") {
+						importPath = toTypesPath(wd, info, subpath, esmTypes)
+						if isDtsFile(importPath) && !strings.HasSuffix(importPath, "~.d.ts") {
 							imports.Add(importPath)
 						}
 						importPath = prefix + strings.TrimPrefix(importPath, versioned+"/")
@@ -177,7 +267,7 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 						} else {
 							importPath = prefix + utils.CleanPath(subpath)[1:]
 						}
-						if !strings.HasSuffix(importPath, ".d.ts") {
+						if !isDtsFile(importPath) {
 							importPath += "~.d.ts"
 						}
 					}
@@ -277,8 +367,61 @@ func copyDTS(wd string, resolvePrefix string, dts string, tracing *stringSet) (e
 	return
 }
 
-func toTypesPath(wd string, p NpmPackage, subpath string) string {
+// resolveTripleSlashReference resolves the value of a triple-slash reference directive.
+// For `/// <reference path="./foo.d.ts" />` the wd is the directory of the declaration
+// file and the relative path of the declaration file is returned; for
+// `/// <reference types="foo" />` the wd is the build directory and the package name
+// providing the types is returned, `@types/foo` is preferred like the typescript compiler.
+func resolveTripleSlashReference(wd string, refValue string, isPath bool) (string, error) {
+	if isPath {
+		if !isLocalImport(refValue) {
+			refValue = "./" + refValue
+		}
+		if isDtsFile(refValue) && fileExists(path.Join(wd, refValue)) {
+			return refValue, nil
+		}
+		refValue = strings.TrimSuffix(refValue, ".ts")
+		if fileExists(path.Join(wd, refValue+".d.ts")) {
+			return refValue + ".d.ts", nil
+		}
+		if fileExists(path.Join(wd, refValue, "index.d.ts")) {
+			return strings.TrimSuffix(refValue, "/") + "/index.d.ts", nil
+		}
+		return "", fmt.Errorf("reference path '%s' not found", refValue)
+	}
+
+	if refValue == "" {
+		return "", fmt.Errorf("empty reference types")
+	}
+	if refValue == "node" || strings.HasPrefix(refValue, "@types/") {
+		return refValue, nil
+	}
+	typesPkgName := toTypesPackageName(refValue)
+	if _, err := findPackageJSON(wd, typesPkgName); err == nil {
+		return typesPkgName, nil
+	}
+	return refValue, nil
+}
+
+// toTypesPath returns the path of the declaration file of the package like
+// `react@17.0.2/index.d.ts`, the `.d.mts` file (for the ESM targets) or the `.d.cts`
+// file (for the node target) is preferred when it exists beside the `.d.ts` file.
+// The path of the unresolved types ends with `~.d.ts`.
+func toTypesPath(wd string, p NpmPackage, subpath string, esmTypes bool) string {
+	preferExt := ".d.cts"
+	if esmTypes {
+		preferExt = ".d.mts"
+	}
+
+	if (typescriptModuleResolution == "node16" || typescriptModuleResolution == "bundler") && p.DefinedExports != nil {
+		if types, ok := resolveTypesExports(path.Join(wd, "node_modules", p.Name), *p.DefinedExports, subpath, esmTypes); ok {
+			return fmt.Sprintf("%s@%s%s", p.Name, p.Version, utils.CleanPath(types))
+		}
+	}
+
 	var types string
+	// the declaration file of the subpath is requested explicitly
+	explicit := isDtsFile(subpath)
 	if subpath != "" {
 		types = subpath
 		packageJSONFile := path.Join(wd, "node_modules", p.Name, subpath, "package.json")
@@ -300,16 +443,246 @@ func toTypesPath(wd string, p NpmPackage, subpath string) string {
 		return ""
 	}
 
-	if !strings.HasSuffix(types, ".d.ts") {
-		pkgDir := path.Join(wd, "node_modules", p.Name)
-		if fileExists(path.Join(pkgDir, types, "index.d.ts")) {
-			types = types + "/index.d.ts"
-		} else if fileExists(path.Join(pkgDir, types+".d.ts")) {
-			types = types + ".d.ts"
+	pkgDir := path.Join(wd, "node_modules", p.Name)
+	if !isDtsFile(types) {
+		if dtsFile, ok := findDtsFile(pkgDir, types, preferExt); ok {
+			types = dtsFile
 		} else {
 			types = types + "~.d.ts" // dynamic
 		}
+	} else if !explicit {
+		if dtsFile := strings.TrimSuffix(types, dtsExtname(types)) + preferExt; fileExists(path.Join(pkgDir, dtsFile)) {
+			types = dtsFile
+		}
+	}
+
+	if len(p.TypesVersions) > 0 && !strings.HasSuffix(types, "~.d.ts") {
+		types = resolveTypesVersions(wd, p, types)
 	}
 
 	return fmt.Sprintf("%s@%s%s", p.Name, p.Version, utils.CleanPath(types))
 }
+
+// resolveTypesExports resolves the declaration file of the subpath by the `exports` of
+// package.json like the `node16` and `bundler` module resolutions of typescript, the
+// `types` condition is preferred and the JS file is mapped to the declaration file
+// beside it. It returns false if the subpath is not exported or has no types.
+func resolveTypesExports(pkgDir string, exports ExportsMap, subpath string, esmTypes bool) (string, bool) {
+	conditions := []string{"types", "require"}
+	preferExt := ".d.cts"
+	if esmTypes {
+		conditions = []string{"types", "import", "module"}
+		preferExt = ".d.mts"
+	}
+
+	value := exports
+	if exports.IsSubpaths() {
+		key := "."
+		if subpath != "" {
+			key = "./" + strings.TrimPrefix(subpath, "/")
+		}
+		v, ok := exports.Get(key)
+		if !ok {
+			for _, name := range exports.Keys {
+				if strings.HasSuffix(name, "/*") && strings.HasPrefix(key, strings.TrimSuffix(name, "*")) {
+					v, ok = exports.Values[name].ReplaceAll("*", strings.TrimPrefix(key, strings.TrimSuffix(name, "*"))), true
+					break
+				}
+			}
+		}
+		if !ok {
+			return "", false
+		}
+		value = v
+	} else if subpath != "" {
+		return "", false
+	}
+
+	s, ok := value.Resolve(conditions)
+	if !ok {
+		return "", false
+	}
+	if isDtsFile(s) {
+		return s, fileExists(path.Join(pkgDir, s))
+	}
+	if ext := path.Ext(s); ext == ".js" || ext == ".mjs" || ext == ".cjs" {
+		s = strings.TrimSuffix(s, ext)
+	}
+	return findDtsFile(pkgDir, s, preferExt)
+}
+
+// the extensions of the declaration files
+var dtsExts = []string{".d.ts", ".d.mts", ".d.cts"}
+
+// isDtsFile checks whether the file is a declaration file like `index.d.ts`,
+// `index.d.mts` or `index.d.cts`
+func isDtsFile(name string) bool {
+	return dtsExtname(name) != ""
+}
+
+// dtsExtname returns the extension of the declaration file like `.d.mts`, or an
+// empty string if the file is not a declaration file
+func dtsExtname(name string) string {
+	for _, ext := range dtsExts {
+		if strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// findDtsFile finds the declaration file of the module path in the dir like
+// `<name>/index.d.ts` or `<name>.d.ts`, the files with the `preferExt` extension
+// are checked first.
+// resolveTsconfigPathsDts resolves the specifier by the `paths` of the tsconfig.json to
+// the declaration file in the package, it returns the absolute path of the file.
+func resolveTsconfigPathsDts(pkgDir string, tsPaths *tsconfigPaths, specifier string, preferExt string) (string, bool) {
+	for _, candidate := range tsPaths.match(specifier) {
+		if isDtsFile(candidate) {
+			if fileExists(path.Join(pkgDir, candidate)) {
+				return path.Join(pkgDir, candidate), true
+			}
+			continue
+		}
+		if ext := path.Ext(candidate); ext == ".js" || ext == ".mjs" || ext == ".cjs" || ext == ".ts" || ext == ".tsx" {
+			candidate = strings.TrimSuffix(candidate, ext)
+		}
+		if dtsFile, ok := findDtsFile(pkgDir, candidate, preferExt); ok {
+			return path.Join(pkgDir, dtsFile), true
+		}
+	}
+	return "", false
+}
+
+func findDtsFile(dir string, name string, preferExt string) (string, bool) {
+	exts := dtsExts
+	if preferExt != "" {
+		exts = append([]string{preferExt}, dtsExts...)
+	}
+	for _, ext := range exts {
+		if fileExists(path.Join(dir, name, "index"+ext)) {
+			return strings.TrimSuffix(name, "/") + "/index" + ext, true
+		}
+		if fileExists(path.Join(dir, name+ext)) {
+			return name + ext, true
+		}
+	}
+	return "", false
+}
+
+// resolveTypesVersions maps the types path by the `typesVersions` of package.json
+// for the typescript version, see https://www.typescriptlang.org/docs/handbook/declaration-files/publishing.html#version-selection-with-typesversions
+func resolveTypesVersions(wd string, p NpmPackage, types string) string {
+	ranges := make([]string, 0, len(p.TypesVersions))
+	for r := range p.TypesVersions {
+		ranges = append(ranges, r)
+	}
+	// the key order of the json object is lost, so check the ranges with
+	// higher version first and the catch-all `*` last
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i] == "*" || ranges[j] == "*" {
+			return ranges[j] == "*" && ranges[i] != "*"
+		}
+		return compareTSVersion(rangeMinVersion(ranges[i]), rangeMinVersion(ranges[j])) > 0
+	})
+
+	filename := strings.TrimPrefix(utils.CleanPath(types), "/")
+	for _, r := range ranges {
+		if !matchTSVersionRange(typescriptVersion, r) {
+			continue
+		}
+		for pattern, paths := range p.TypesVersions[r] {
+			if len(paths) == 0 {
+				continue
+			}
+			pattern = strings.TrimPrefix(pattern, "./")
+			to := strings.TrimPrefix(paths[0], "./")
+			if a := strings.Split(pattern, "*"); len(a) == 2 {
+				if strings.HasPrefix(filename, a[0]) && strings.HasSuffix(filename, a[1]) {
+					to = strings.Replace(to, "*", strings.TrimSuffix(strings.TrimPrefix(filename, a[0]), a[1]), 1)
+				} else {
+					continue
+				}
+			} else if pattern != filename {
+				continue
+			}
+			if fileExists(path.Join(wd, "node_modules", p.Name, to)) {
+				return to
+			}
+		}
+		break
+	}
+	return types
+}
+
+// matchTSVersionRange checks whether the version matches the range like `>=4.2`
+// or `>=3.1 <4`, the version `*` stands for the latest typescript
+func matchTSVersionRange(version string, r string) bool {
+	for _, c := range strings.Fields(r) {
+		if c == "*" {
+			continue
+		}
+		op := c[:len(c)-len(strings.TrimLeft(c, "<>="))]
+		ret := compareTSVersion(version, c[len(op):])
+		switch op {
+		case ">=":
+			if ret < 0 {
+				return false
+			}
+		case ">":
+			if ret <= 0 {
+				return false
+			}
+		case "<=":
+			if ret > 0 {
+				return false
+			}
+		case "<":
+			if ret >= 0 {
+				return false
+			}
+		default:
+			if ret != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func rangeMinVersion(r string) string {
+	if a := strings.Fields(r); len(a) > 0 {
+		return strings.TrimLeft(a[0], "<>=")
+	}
+	return ""
+}
+
+func compareTSVersion(a string, b string) int {
+	if a == "*" || b == "*" {
+		if a == b {
+			return 0
+		}
+		if a == "*" {
+			return 1
+		}
+		return -1
+	}
+	va := strings.Split(a, ".")
+	vb := strings.Split(b, ".")
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x, _ = strconv.Atoi(va[i])
+		}
+		if i < len(vb) {
+			y, _ = strconv.Atoi(vb[i])
+		}
+		if x != y {
+			if x > y {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -155,3 +156,139 @@ func TestCopyDTS(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveTripleSlashReference(t *testing.T) {
+	wd := t.TempDir()
+	ensureDir(path.Join(wd, "lib"))
+	ensureDir(path.Join(wd, "node_modules", "@types", "foo"))
+	ioutil.WriteFile(path.Join(wd, "global.d.ts"), []byte("declare const a: string;"), 0644)
+	ioutil.WriteFile(path.Join(wd, "lib", "index.d.ts"), []byte("declare const b: string;"), 0644)
+	ioutil.WriteFile(path.Join(wd, "node_modules", "@types", "foo", "package.json"), []byte(`{"name":"@types/foo","version":"1.0.0"}`), 0644)
+
+	for ref, expected := range map[string]string{
+		"global.d.ts": "./global.d.ts",
+		"./global":    "./global.d.ts",
+		"./lib":       "./lib/index.d.ts",
+		"./lib/":      "./lib/index.d.ts",
+	} {
+		ret, err := resolveTripleSlashReference(wd, ref, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret != expected {
+			t.Fatalf("reference path '%s' should be resolved to '%s', but got '%s'", ref, expected, ret)
+		}
+	}
+	if _, err := resolveTripleSlashReference(wd, "./missing.d.ts", true); err == nil {
+		t.Fatal("missing reference path should fail")
+	}
+
+	for ref, expected := range map[string]string{
+		"node":        "node",
+		"foo":         "@types/foo",
+		"bar":         "bar",
+		"@types/jest": "@types/jest",
+	} {
+		ret, err := resolveTripleSlashReference(wd, ref, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret != expected {
+			t.Fatalf("reference types '%s' should be resolved to '%s', but got '%s'", ref, expected, ret)
+		}
+	}
+}
+
+func TestToTypesPathFormats(t *testing.T) {
+	wd := t.TempDir()
+	pkgDir := path.Join(wd, "node_modules", "foo")
+	ensureDir(path.Join(pkgDir, "lib"))
+	for _, name := range []string{"index.d.ts", "index.d.mts", "index.d.cts", "lib/index.d.mts"} {
+		ioutil.WriteFile(path.Join(pkgDir, name), []byte("export declare const a: string;"), 0644)
+	}
+
+	p := NpmPackage{Name: "foo", Version: "1.0.0", Types: "index.d.ts"}
+	for _, c := range []struct {
+		subpath  string
+		esm      bool
+		expected string
+	}{
+		{"", true, "foo@1.0.0/index.d.mts"},
+		{"", false, "foo@1.0.0/index.d.cts"},
+		{"index.d.ts", true, "foo@1.0.0/index.d.ts"},
+		{"lib", true, "foo@1.0.0/lib/index.d.mts"},
+		{"lib", false, "foo@1.0.0/lib/index.d.mts"},
+		{"missing", true, "foo@1.0.0/missing~.d.ts"},
+	} {
+		if dts := toTypesPath(wd, p, c.subpath, c.esm); dts != c.expected {
+			t.Fatalf("toTypesPath(%q, %v) should be %s, but got %s", c.subpath, c.esm, c.expected, dts)
+		}
+	}
+
+	if ext := dtsExtname("foo@1.0.0/index.d.mts"); ext != ".d.mts" {
+		t.Fatalf("unexpected dts extname: %s", ext)
+	}
+	if isDtsFile("index.ts") || isDtsFile("index.mts") {
+		t.Fatal("the ts files are not declaration files")
+	}
+}
+
+func TestToTypesPathBundlerResolution(t *testing.T) {
+	wd := t.TempDir()
+	pkgDir := path.Join(wd, "node_modules", "foo")
+	ensureDir(path.Join(pkgDir, "dist", "utils"))
+	ensureDir(path.Join(pkgDir, "types"))
+	for _, name := range []string{"index.d.ts", "types/index.d.ts", "dist/hooks.mjs", "dist/hooks.d.mts", "dist/utils/a.d.ts"} {
+		ioutil.WriteFile(path.Join(pkgDir, name), []byte("export declare const a: string;"), 0644)
+	}
+
+	var exports ExportsMap
+	err := json.Unmarshal([]byte(`{
+		".": {"types": "./types/index.d.ts", "import": "./dist/index.mjs"},
+		"./hooks": {"import": "./dist/hooks.mjs", "require": "./dist/hooks.cjs"},
+		"./utils/*": "./dist/utils/*.js"
+	}`), &exports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NpmPackage{Name: "foo", Version: "1.0.0", Types: "index.d.ts", DefinedExports: &exports}
+
+	defer func(v string) { typescriptModuleResolution = v }(typescriptModuleResolution)
+	typescriptModuleResolution = "bundler"
+	for _, c := range []struct {
+		subpath  string
+		esm      bool
+		expected string
+	}{
+		{"", true, "foo@1.0.0/types/index.d.ts"},
+		{"hooks", true, "foo@1.0.0/dist/hooks.d.mts"},
+		{"utils/a", true, "foo@1.0.0/dist/utils/a.d.ts"},
+		// not exported
+		{"missing", true, "foo@1.0.0/missing~.d.ts"},
+	} {
+		if dts := toTypesPath(wd, p, c.subpath, c.esm); dts != c.expected {
+			t.Fatalf("toTypesPath(%q, %v) should be %s, but got %s", c.subpath, c.esm, c.expected, dts)
+		}
+	}
+
+	// the `exports` is ignored by the node module resolution
+	typescriptModuleResolution = "node"
+	if dts := toTypesPath(wd, p, "", true); dts != "foo@1.0.0/index.d.ts" {
+		t.Fatalf("unexpected types path: %s", dts)
+	}
+}
+
+func TestIsSkippedDtsFile(t *testing.T) {
+	for name, skipped := range map[string]bool{
+		"foo@1.0.0/tsconfig.tsbuildinfo": true,
+		"foo@1.0.0/.babelrc":             true,
+		"foo@1.0.0/lib/.eslintrc":        true,
+		"foo@1.0.0/index.d.ts":           false,
+		"foo@1.0.0/index.d.mts":          false,
+		"foo@1.0.0":                      false,
+	} {
+		if isSkippedDtsFile(name) != skipped {
+			t.Fatalf("unexpected skip of '%s': %v", name, !skipped)
+		}
+	}
+}
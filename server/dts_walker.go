@@ -14,6 +14,7 @@ var (
 	regImportCallExpr    = regexp.MustCompile(`import\((('[^']+')|("[^"]+"))\)`)
 	regDeclareModuleExpr = regexp.MustCompile(`declare\s+module\s*('|")([^'"]+)("|')`)
 	regReferenceTag      = regexp.MustCompile(`<reference\s+(path|types)\s*=\s*('|")([^'"]+)("|')\s*/?>`)
+	regAmbientImportExpr = regexp.MustCompile(`(?:(?:}|\s)from|import|import\()\s*(?:'([^']+)'|"([^"]+)")`)
 )
 
 var (
@@ -181,6 +182,42 @@ func walkDts(r io.Reader, buf *bytes.Buffer, resolve func(path string, kind stri
 	return
 }
 
+// extractAmbientModuleImports extracts the import paths inside the ambient module
+// declarations like `declare module "foo" { import { Bar } from "bar"; }`.
+func extractAmbientModuleImports(data []byte) []string {
+	imports := []string{}
+	for _, loc := range regDeclareModuleExpr.FindAllIndex(data, -1) {
+		i := bytes.IndexByte(data[loc[1]:], '{')
+		if i == -1 {
+			continue
+		}
+		start := loc[1] + i
+		end := len(data)
+		depth := 0
+	Loop:
+		for j := start; j < len(data); j++ {
+			switch data[j] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = j + 1
+					break Loop
+				}
+			}
+		}
+		for _, m := range regAmbientImportExpr.FindAllSubmatch(data[start:end], -1) {
+			importPath := string(m[1])
+			if importPath == "" {
+				importPath = string(m[2])
+			}
+			imports = append(imports, importPath)
+		}
+	}
+	return imports
+}
+
 func splitInlineToken(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	var commentScope bool
 	var stringScope byte
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// the prefix of the environment variables of the server options
+const envVarPrefix = "ESM_"
+
+// envVarName returns the environment variable name of the option like
+// `build-queue-max-depth` -> `ESM_BUILD_QUEUE_MAX_DEPTH`
+func envVarName(option string) string {
+	return envVarPrefix + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// envVarConfig sets the options of the flag set by the environment variables like
+// `ESM_CDN_DOMAIN`, it should be called before parsing the command line arguments,
+// so the environment variables take precedence over the defaults, and the command
+// line arguments take precedence over the environment variables. The variables set
+// to empty strings are applied as well.
+func envVarConfig(flags *flag.FlagSet, lookupEnv func(key string) (string, bool)) (err error) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		name := envVarName(f.Name)
+		if value, ok := lookupEnv(name); ok {
+			if e := flags.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid %s: %v", name, e)
+			}
+		}
+	})
+	return
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestEnvVarConfig(t *testing.T) {
+	if name := envVarName("build-queue-max-depth"); name != "ESM_BUILD_QUEUE_MAX_DEPTH" {
+		t.Fatalf("unexpected env var name: %s", name)
+	}
+
+	env := map[string]string{
+		"ESM_CDN_DOMAIN":            "esm.example.com",
+		"ESM_BUILD_QUEUE_MAX_DEPTH": "100",
+		"ESM_ADMIN_TOKEN":           "",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	newFlags := func() (*flag.FlagSet, *string, *int, *string) {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		domain := flags.String("cdn-domain", "", "")
+		depth := flags.Int("build-queue-max-depth", 500, "")
+		token := flags.String("admin-token", "default", "")
+		return flags, domain, depth, token
+	}
+
+	flags, domain, depth, token := newFlags()
+	if err := envVarConfig(flags, lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Parse([]string{"--build-queue-max-depth=200"}); err != nil {
+		t.Fatal(err)
+	}
+	// the command line arguments take precedence over the env vars, and the empty
+	// env vars are applied
+	if *domain != "esm.example.com" || *depth != 200 || *token != "" {
+		t.Fatalf("unexpected options: %s, %d, %s", *domain, *depth, *token)
+	}
+
+	env["ESM_BUILD_QUEUE_MAX_DEPTH"] = "many"
+	flags, _, _, _ = newFlags()
+	if err := envVarConfig(flags, lookupEnv); err == nil {
+		t.Fatal("should fail with the invalid env var")
+	}
+}
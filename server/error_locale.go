@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ije/gox/utils"
+)
+
+// the locales of the error messages in `embed/errors_<lang>.json`, the first one is
+// the fallback
+var errorLocales = []string{"en", "zh"}
+
+var (
+	errorMessages     map[string]map[string]string
+	errorMessagesOnce sync.Once
+)
+
+// localeError returns the error message of the type in the language, the `{key}`
+// placeholders of the message are replaced with the params. It falls back to English
+// if the language or the message is not found.
+func localeError(lang string, errorType string, params map[string]string) string {
+	errorMessagesOnce.Do(func() {
+		errorMessages = map[string]map[string]string{}
+		for _, locale := range errorLocales {
+			var messages map[string]string
+			data, err := embedFS.ReadFile(fmt.Sprintf("server/embed/errors_%s.json", locale))
+			if err == nil {
+				err = json.Unmarshal(data, &messages)
+			}
+			if err != nil {
+				log.Errorf("load error messages(%s): %v", locale, err)
+				continue
+			}
+			errorMessages[locale] = messages
+		}
+	})
+
+	message, ok := errorMessages[lang][errorType]
+	if !ok {
+		message, ok = errorMessages[errorLocales[0]][errorType]
+	}
+	if !ok {
+		return errorType
+	}
+	for key, value := range params {
+		message = strings.ReplaceAll(message, "{"+key+"}", value)
+	}
+	return message
+}
+
+// matchErrorLocale selects the best locale of the error messages by the
+// `Accept-Language` header like `zh-CN,zh;q=0.9,en;q=0.8`, the region of the
+// language is ignored.
+func matchErrorLocale(acceptLanguage string) string {
+	type weightedLang struct {
+		lang string
+		q    float64
+	}
+	var langs []weightedLang
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		lang, params := utils.SplitByFirstByte(strings.TrimSpace(part), ';')
+		q := 1.0
+		if v := strings.TrimSpace(params); strings.HasPrefix(v, "q=") {
+			f, err := strconv.ParseFloat(v[2:], 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if lang != "" && q > 0 {
+			langs = append(langs, weightedLang{strings.ToLower(lang), q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	for _, l := range langs {
+		primary, _ := utils.SplitByFirstByte(l.lang, '-')
+		for _, locale := range errorLocales {
+			if primary == locale {
+				return locale
+			}
+		}
+	}
+	return errorLocales[0]
+}
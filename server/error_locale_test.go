@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestLocaleError(t *testing.T) {
+	embedFS = &devFS{".."}
+
+	for acceptLanguage, locale := range map[string]string{
+		"":                           "en",
+		"zh-CN,zh;q=0.9,en;q=0.8":    "zh",
+		"en-US,en;q=0.9,zh;q=0.8":    "en",
+		"de-DE,de;q=0.9,zh-TW;q=0.8": "zh",
+		"fr;q=0.9,zh;q=0":            "en",
+		"en;q=0.5,zh;q=0.6":          "zh",
+	} {
+		if ret := matchErrorLocale(acceptLanguage); ret != locale {
+			t.Fatalf("unexpected locale of '%s': %s", acceptLanguage, ret)
+		}
+	}
+
+	params := map[string]string{"name": "fs", "importer": "/v58/foo@1.0.0/es2021/foo.js"}
+	if msg := localeError("en", "unsupported-nodejs-builtin-module", params); msg != `Unsupported nodejs builtin module "fs" (Imported by "/v58/foo@1.0.0/es2021/foo.js")` {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	if msg := localeError("zh", "unsupported-nodejs-builtin-module", params); msg != `不支持的 Node.js 内置模块 "fs"（由 "/v58/foo@1.0.0/es2021/foo.js" 导入）` {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	if msg := localeError("ja", "unknown", nil); msg != "Unknown error" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
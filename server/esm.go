@@ -1,14 +1,22 @@
 package server
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"esm.sh/pkg/esmmeta"
 	"esm.sh/server/storage"
+	"github.com/ije/esbuild-internal/config"
 	"github.com/ije/esbuild-internal/js_ast"
 	"github.com/ije/esbuild-internal/js_parser"
 	"github.com/ije/esbuild-internal/logger"
@@ -17,16 +25,18 @@ import (
 )
 
 // ESM defines the ES Module meta
-type ESM struct {
-	*NpmPackage
-	ExportDefault bool     `json:"exportDefault"`
-	Exports       []string `json:"exports"`
-	Dts           string   `json:"dts"`
-	PackageCSS    bool     `json:"packageCSS"`
-}
+type ESM = esmmeta.ESM
 
-func initESM(wd string, pkg Pkg, checkExports bool, isDev bool) (esm *ESM, err error) {
-	packageFile := path.Join(wd, "node_modules", pkg.Name, "package.json")
+func initESM(wd string, pkg Pkg, checkExports bool, isDev bool, cjsOnly bool) (esm *ESM, err error) {
+	pkg.Submodule, err = sanitizeSubmodule(pkg.Submodule)
+	if err != nil {
+		return
+	}
+
+	packageFile, err := findPackageJSON(wd, pkg.Name)
+	if err != nil {
+		return
+	}
 
 	var p NpmPackage
 	err = utils.ParseJSONFile(packageFile, &p)
@@ -34,12 +44,14 @@ func initESM(wd string, pkg Pkg, checkExports bool, isDev bool) (esm *ESM, err e
 		return
 	}
 
+	pkgDir := path.Dir(packageFile)
 	esm = &ESM{
-		NpmPackage: fixNpmPackage(p),
+		NpmPackage:  fixNpmPackage(p, pkgDir),
+		NativeAddon: hasNativeAddon(pkgDir),
 	}
 
 	if pkg.Submodule != "" {
-		if strings.HasSuffix(pkg.Submodule, ".d.ts") {
+		if isDtsFile(pkg.Submodule) {
 			esm.Typings = ""
 			if strings.HasSuffix(pkg.Submodule, "~.d.ts") {
 				submodule := strings.TrimSuffix(pkg.Submodule, "~.d.ts")
@@ -54,78 +66,68 @@ func initESM(wd string, pkg Pkg, checkExports bool, isDev bool) (esm *ESM, err e
 			}
 		} else {
 			subDir := path.Join(wd, "node_modules", esm.Name, pkg.Submodule)
-			packageFile := path.Join(subDir, "package.json")
-			if fileExists(packageFile) {
-				var p NpmPackage
-				err = utils.ParseJSONFile(packageFile, &p)
-				if err != nil {
-					return
-				}
-				np := fixNpmPackage(p)
-				if np.Module != "" {
-					esm.Module = path.Join(pkg.Submodule, np.Module)
-				} else {
-					esm.Module = ""
-				}
-				if p.Main != "" {
-					esm.Main = path.Join(pkg.Submodule, p.Main)
-				} else {
-					esm.Main = path.Join(pkg.Submodule, "index.js")
-				}
-				esm.Types = ""
-				esm.Typings = ""
-				if p.Types != "" {
-					esm.Types = path.Join(pkg.Submodule, p.Types)
-				} else if p.Typings != "" {
-					esm.Typings = path.Join(pkg.Submodule, p.Typings)
-				} else if fileExists(path.Join(subDir, "index.d.ts")) {
-					esm.Types = path.Join(pkg.Submodule, "index.d.ts")
-				} else if fileExists(path.Join(subDir + ".d.ts")) {
-					esm.Types = pkg.Submodule + ".d.ts"
+			var np *NpmPackage
+			np, err = resolveSubmodulePackageJSON(wd, esm.Name, pkg.Submodule)
+			if err != nil {
+				return
+			}
+			if np != nil && np.Main != "" {
+				esm.Module = np.Module
+				esm.Main = np.Main
+				esm.Types = np.Types
+				esm.Typings = np.Typings
+				if esm.Types == "" && esm.Typings == "" {
+					if fileExists(path.Join(subDir, "index.d.ts")) {
+						esm.Types = path.Join(pkg.Submodule, "index.d.ts")
+					} else if fileExists(path.Join(subDir + ".d.ts")) {
+						esm.Types = pkg.Submodule + ".d.ts"
+					}
 				}
 			} else {
 				var defined bool
-				if p.DefinedExports != nil {
-					if m, ok := p.DefinedExports.(map[string]interface{}); ok {
-						for name, v := range m {
+				if p.DefinedExports != nil && p.DefinedExports.Kind == ExportsObject {
+					for _, name := range p.DefinedExports.Keys {
+						v := p.DefinedExports.Values[name]
+						/**
+						exports: {
+							"./lib/core": {
+								"require": "./lib/core.js",
+								"import": "./es/core.js"
+							}
+						}
+						*/
+						if name == "./"+pkg.Submodule {
+							err = resolveDefinedExports(esm.NpmPackage, v)
+							if err != nil {
+								return
+							}
+							defined = true
+							esm.Module = resolveExportPathOrSelf(pkgDir, esm.Module)
+							esm.Main = resolveExportPathOrSelf(pkgDir, esm.Main)
+							break
 							/**
 							exports: {
-								"./lib/core": {
-									"require": "./lib/core.js",
-									"import": "./es/core.js"
-								}
+								"./lib/languages/*": {
+									"require": "./lib/languages/*.js",
+									"import": "./es/languages/*.js"
+								},
 							}
 							*/
-							if name == "./"+pkg.Submodule {
-								resolveDefinedExports(esm.NpmPackage, v)
-								defined = true
-								break
-								/**
-								exports: {
-									"./lib/languages/*": {
-										"require": "./lib/languages/*.js",
-										"import": "./es/languages/*.js"
-									},
-								}
-								*/
-							} else if strings.HasSuffix(name, "/*") && strings.HasPrefix("./"+pkg.Submodule, strings.TrimSuffix(name, "*")) {
-								suffix := strings.TrimPrefix("./"+pkg.Submodule, strings.TrimSuffix(name, "*"))
-								if m, ok := v.(map[string]interface{}); ok {
-									for key, value := range m {
-										s, ok := value.(string)
-										if ok {
-											m[key] = strings.Replace(s, "*", suffix, -1)
-										}
-									}
-								}
-								resolveDefinedExports(esm.NpmPackage, v)
-								defined = true
+						} else if strings.HasSuffix(name, "/*") && strings.HasPrefix("./"+pkg.Submodule, strings.TrimSuffix(name, "*")) {
+							suffix := strings.TrimPrefix("./"+pkg.Submodule, strings.TrimSuffix(name, "*"))
+							err = resolveDefinedExports(esm.NpmPackage, v.ReplaceAll("*", suffix))
+							if err != nil {
+								return
 							}
+							defined = true
+							esm.Module = resolveExportPathOrSelf(pkgDir, esm.Module)
+							esm.Main = resolveExportPathOrSelf(pkgDir, esm.Main)
 						}
 					}
 				}
 				if !defined {
-					if esm.Module != "" {
+					// the nested package.json like `{"type": "module"}` marks the submodule as ESM
+					if esm.Module != "" || (np != nil && np.Type == "module") {
 						esm.Module = pkg.Submodule
 					} else {
 						esm.Main = pkg.Submodule
@@ -140,14 +142,37 @@ func initESM(wd string, pkg Pkg, checkExports bool, isDev bool) (esm *ESM, err e
 				}
 			}
 		}
+	} else if p.Module == "" && p.Main == "" {
+		/**
+		exports: {
+			".": {
+				"types": "./index.d.ts",
+				"default": "./index.js"
+			}
+		}
+		*/
+		module, main, ok, e := resolveRootExports(p, pkgDir)
+		if e != nil {
+			err = e
+			return
+		}
+		if ok {
+			esm.Module = module
+			esm.Main = main
+		}
 	}
 
 	if !checkExports {
 		return
 	}
 
+	// skip the ESM check to use the CommonJS `main` entry
+	if cjsOnly {
+		esm.Module = ""
+	}
+
 	if esm.Module != "" {
-		resolved, exportDefault, err := checkESM(wd, esm.Name, esm.Module)
+		resolved, exportDefault, _, err := checkESM(wd, esm.Name, esm.Module)
 		if err != nil {
 			log.Warnf("fake module from '%s' of '%s': %v", esm.Module, esm.Name, err)
 			esm.Module = ""
@@ -188,8 +213,117 @@ func initESM(wd string, pkg Pkg, checkExports bool, isDev bool) (esm *ESM, err e
 	return
 }
 
+// resolveRootExports resolves the entries of the `"."` exports for the package without
+// the `module` and `main` fields. The `default` entry of the pure ESM package (`"type":
+// "module"`) is used as the module since there is no CommonJS entry.
+func resolveRootExports(p NpmPackage, pkgDir string) (module string, main string, ok bool, err error) {
+	if p.DefinedExports == nil {
+		return
+	}
+	v, ok := p.DefinedExports.Get(".")
+	if !ok || v.IsNull() {
+		return "", "", false, nil
+	}
+	np := &NpmPackage{Type: p.Type}
+	err = resolveDefinedExports(np, v)
+	if err != nil {
+		return "", "", false, err
+	}
+	if np.Module == "" && np.Main != "" && p.Type == "module" {
+		np.Module = np.Main
+	}
+	module = resolveExportPathOrSelf(pkgDir, np.Module)
+	main = normalizeMain(resolveExportPathOrSelf(pkgDir, np.Main), pkgDir)
+	ok = module != "" || main != ""
+	return
+}
+
+// resolveSubmodulePackageJSON walks up from the submodule directory to the package
+// root to find the nearest nested package.json, and merges it into the root one. Like
+// node, the `type` is decided by the nearest package.json. The `main`, `module` and
+// `types` entries (resolved to the paths in the package) are only taken from the
+// package.json in the submodule directory, the entries of the root don't apply to the
+// submodule. It returns nil if there is no nested package.json.
+func resolveSubmodulePackageJSON(wd string, pkgName string, submodule string) (*NpmPackage, error) {
+	packageFile, err := findPackageJSON(wd, pkgName)
+	if err != nil {
+		return nil, err
+	}
+	pkgDir := path.Dir(packageFile)
+
+	dir := submodule
+	if !dirExists(path.Join(pkgDir, dir)) {
+		dir = path.Dir(dir)
+	}
+	for ; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		if fileExists(path.Join(pkgDir, dir, "package.json")) {
+			break
+		}
+	}
+	if dir == "." || dir == "/" || dir == "" {
+		return nil, nil
+	}
+
+	var np NpmPackage
+	err = utils.ParseJSONFile(packageFile, &np)
+	if err != nil {
+		return nil, err
+	}
+	var p NpmPackage
+	subDir := path.Join(pkgDir, dir)
+	err = utils.ParseJSONFile(path.Join(subDir, "package.json"), &p)
+	if err != nil {
+		return nil, err
+	}
+
+	np.Type = p.Type
+	np.Main = ""
+	np.Module = ""
+	np.Types = ""
+	np.Typings = ""
+	if dir == submodule {
+		fp := fixNpmPackage(p, subDir)
+		if fp.Module == "" && fp.Main != "" && p.Type == "module" {
+			fp.Module = fp.Main
+		}
+		if fp.Module != "" {
+			np.Module = path.Join(dir, fp.Module)
+		}
+		if fp.Main != "" {
+			np.Main = path.Join(dir, fp.Main)
+		} else {
+			np.Main = path.Join(dir, "index.js")
+		}
+		if p.Types != "" {
+			np.Types = path.Join(dir, p.Types)
+		} else if p.Typings != "" {
+			np.Typings = path.Join(dir, p.Typings)
+		}
+	}
+	return &np, nil
+}
+
 func findESM(id string) (esm *ESM, err error) {
+	// check the build record and the build file atomically, see `buildLocks`
+	unlock := buildLocks.Lock(id)
+	defer unlock()
+
 	store, _, err := db.Get(id)
+	if err == storage.ErrNotFound {
+		// the failed build is retried once after the cooldown, and again after the ttl
+		// if the retry failed
+		buildErr, e := findBuildError(id)
+		if e == nil {
+			cooldown := buildErrorRetryCooldown
+			if buildErr.Retried {
+				cooldown = buildErrorRetriedTTL
+			}
+			if time.Since(buildErr.Timestamp) < cooldown {
+				err = &FailedBuildError{buildErr}
+			}
+		}
+		return
+	}
 	if err == nil {
 		err = json.Unmarshal([]byte(store["esm"]), &esm)
 		if err != nil {
@@ -206,12 +340,54 @@ func findESM(id string) (esm *ESM, err error) {
 			err = storage.ErrNotFound
 			return
 		}
+
+		if err == nil && verifyBuilds && store["checksum"] != "" {
+			var checksum string
+			checksum, err = fileChecksum(path.Join("builds", id))
+			if err == nil && checksum != store["checksum"] {
+				log.Warnf("findESM(%s): checksum mismatch, the build file may be corrupted", id)
+				db.Delete(id)
+				esm = nil
+				err = storage.ErrNotFound
+			}
+		}
 	}
 	return
 }
 
-func checkESM(wd string, packageName string, moduleSpecifier string) (resolveName string, exportDefault bool, err error) {
-	pkgDir := path.Join(wd, "node_modules", packageName)
+// fileChecksum returns the hex encoded SHA1 checksum of the file in the fs.
+func fileChecksum(name string) (string, error) {
+	r, err := fs.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	hasher := sha1.New()
+	_, err = io.Copy(hasher, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// hasNativeAddon checks whether the package contains the native node addons(`.node`
+// files) or the `binding.gyp` to build them.
+func hasNativeAddon(pkgDir string) bool {
+	if fileExists(path.Join(pkgDir, "binding.gyp")) {
+		return true
+	}
+	for _, pattern := range []string{"*.node", "build/Release/*.node", "prebuilds/*/*.node", "lib/binding/*/*.node"} {
+		matches, err := filepath.Glob(path.Join(pkgDir, pattern))
+		if err == nil && len(matches) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func checkESM(wd string, packageName string, moduleSpecifier string) (resolveName string, exportDefault bool, namedExports []string, err error) {
+	pkgDir := path.Join(filepath.ToSlash(wd), "node_modules", packageName)
 	if dirExists(path.Join(pkgDir, moduleSpecifier)) {
 		f := path.Join(moduleSpecifier, "index.mjs")
 		if !fileExists(path.Join(pkgDir, f)) {
@@ -229,8 +405,18 @@ func checkESM(wd string, packageName string, moduleSpecifier string) (resolveNam
 	if err != nil {
 		return
 	}
+	var parserOptions config.Options
+	switch path.Ext(filename) {
+	case ".jsx":
+		parserOptions.JSX.Parse = true
+	case ".ts":
+		parserOptions.TS.Parse = true
+	case ".tsx":
+		parserOptions.JSX.Parse = true
+		parserOptions.TS.Parse = true
+	}
 	log := logger.NewDeferLog(logger.DeferLogNoVerboseOrDebug)
-	ast, pass := js_parser.Parse(log, test.SourceForTest(string(data)), js_parser.Options{})
+	ast, pass := js_parser.Parse(log, test.SourceForTest(string(data)), js_parser.OptionsFromConfig(&parserOptions))
 	if pass {
 		esm := ast.ExportsKind == js_ast.ExportsESM
 		if !esm {
@@ -240,9 +426,11 @@ func checkESM(wd string, packageName string, moduleSpecifier string) (resolveNam
 		for name := range ast.NamedExports {
 			if name == "default" {
 				exportDefault = true
-				break
+			} else {
+				namedExports = append(namedExports, name)
 			}
 		}
+		sort.Strings(namedExports)
 	}
 	resolveName = moduleSpecifier
 	return
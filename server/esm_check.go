@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// ESMCheckResult defines the result of checking whether a module is ESM
+type ESMCheckResult struct {
+	IsESM         bool     `json:"isESM"`
+	ExportDefault bool     `json:"exportDefault"`
+	Exports       []string `json:"exports,omitempty"`
+	Reason        string   `json:"reason,omitempty"`
+	CJSExports    []string `json:"cjsExports,omitempty"`
+}
+
+// CheckESM installs the package and checks whether the module of the task is ESM by
+// the `initESM` and `checkESM` without running esbuild, the result is not stored.
+// The errors of the check are reported as the reason of the result, only the
+// installation errors are returned.
+func (task *BuildTask) CheckESM() (result *ESMCheckResult, err error) {
+	wd := tempDir(fmt.Sprintf("esm-check-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+
+	if host, user, repo := splitGitPkgName(task.Pkg.Name); host != "" {
+		err = installFromGit(wd, host, user, repo, task.Pkg.Version)
+	} else {
+		err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", task.Pkg.Name, task.Pkg.Version), 3, time.Second, task.installFlags()...)
+	}
+	if err != nil {
+		return
+	}
+
+	esm, err := initESM(wd, task.Pkg, true, task.DevMode, task.isCJSOnly())
+	if err != nil {
+		return &ESMCheckResult{Reason: err.Error()}, nil
+	}
+	return toESMCheckResult(wd, esm), nil
+}
+
+func toESMCheckResult(wd string, esm *ESM) *ESMCheckResult {
+	if esm.Module == "" {
+		return &ESMCheckResult{ExportDefault: esm.ExportDefault, Reason: "CJS module", CJSExports: esm.Exports}
+	}
+	// the named exports of the ES module are not returned by `initESM`
+	_, exportDefault, exports, err := checkESM(wd, esm.Name, esm.Module)
+	if err != nil {
+		return &ESMCheckResult{Reason: err.Error()}
+	}
+	return &ESMCheckResult{IsESM: true, ExportDefault: exportDefault, Exports: exports}
+}
+
+// serveESMCheck serves the `?esm-check` requests of the module URLs like
+// `/v{VERSION}/react@17.0.2/es2021/react.js?esm-check`, it checks whether the module
+// resolves to an ES module without building it. The result is cached for 1 hour.
+func serveESMCheck(ctx *rex.Context, task *BuildTask) interface{} {
+	key := fmt.Sprintf("esm-check:%s", task.ID())
+	data, err := cache.Get(key)
+	if err != nil {
+		result, err := task.CheckESM()
+		if err == ErrYarnTimeout {
+			return throwAPIError(ctx, http.StatusGatewayTimeout, APIError{Code: errCodeTimeout, Message: err.Error()})
+		}
+		if err == ErrOffline {
+			return throwAPIError(ctx, http.StatusServiceUnavailable, APIError{Code: errCodeOffline, Message: err.Error()})
+		}
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		data = utils.MustEncodeJSON(result)
+		cache.Set(key, data, time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=3600")
+	return data
+}
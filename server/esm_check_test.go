@@ -0,0 +1,25 @@
+package server
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestToESMCheckResult(t *testing.T) {
+	wd := t.TempDir()
+	pkgDir := path.Join(wd, "node_modules", "pkg")
+	ensureDir(pkgDir)
+	ioutil.WriteFile(path.Join(pkgDir, "index.mjs"), []byte("export const useState = 1; export function useEffect() {}; export default 2"), 0644)
+
+	result := toESMCheckResult(wd, &ESM{NpmPackage: &NpmPackage{Name: "pkg", Module: "index.mjs"}})
+	if !result.IsESM || !result.ExportDefault || !reflect.DeepEqual(result.Exports, []string{"useEffect", "useState"}) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	result = toESMCheckResult(wd, &ESM{NpmPackage: &NpmPackage{Name: "pkg", Main: "index.js"}, ExportDefault: true, Exports: []string{"foo"}})
+	if result.IsESM || result.Reason != "CJS module" || !reflect.DeepEqual(result.CJSExports, []string{"foo"}) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
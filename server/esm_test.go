@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ije/gox/utils"
+)
+
+func TestInitESMCJSOnly(t *testing.T) {
+	testDir := t.TempDir()
+
+	// stand in for the `parseCjsExports` node service, the exports of the CommonJS
+	// entry are detected statically
+	go func() {
+		nsTask := <-nsChannel
+		code, _ := ioutil.ReadFile(path.Join(nsTask.input["buildDir"].(string), "node_modules", nsTask.input["importPath"].(string), "index.cjs"))
+		nsTask.output <- utils.MustEncodeJSON(map[string]interface{}{"exports": parseCJSExportsStatic(code)})
+	}()
+
+	// a dual package with the ESM `module` and the CommonJS `main` entries
+	wd := path.Join(testDir, "build")
+	pkgDir := path.Join(wd, "node_modules", "dual")
+	ensureDir(pkgDir)
+	ioutil.WriteFile(path.Join(pkgDir, "package.json"), []byte(`{"name":"dual","version":"1.0.0","main":"index.cjs","module":"index.mjs"}`), 0644)
+	ioutil.WriteFile(path.Join(pkgDir, "index.mjs"), []byte("export const foo = 1; export default foo;"), 0644)
+	ioutil.WriteFile(path.Join(pkgDir, "index.cjs"), []byte("exports.foo = 1; exports.bar = 2;"), 0644)
+
+	pkg := Pkg{Name: "dual", Version: "1.0.0"}
+	esm, err := initESM(wd, pkg, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esm.Module != "index.mjs" || !esm.ExportDefault {
+		t.Fatalf("the module should be used without the cjs-only flag: '%s'", esm.Module)
+	}
+
+	cjsOnly, err := initESM(wd, pkg, true, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cjsOnly.Module != "" || cjsOnly.Main != "index.cjs" {
+		t.Fatalf("the module should be cleared with the cjs-only flag: '%s', '%s'", cjsOnly.Module, cjsOnly.Main)
+	}
+	sort.Strings(cjsOnly.Exports)
+	if !reflect.DeepEqual(cjsOnly.Exports, []string{"bar", "foo"}) {
+		t.Fatalf("unexpected exports: %v", cjsOnly.Exports)
+	}
+}
+
+func TestInitESMExportBlocked(t *testing.T) {
+	wd := t.TempDir()
+	pkgDir := path.Join(wd, "node_modules", "foo")
+	ensureDir(path.Join(pkgDir, "lib"))
+	ioutil.WriteFile(path.Join(pkgDir, "package.json"), []byte(`{"name":"foo","version":"1.0.0","exports":{".":"./index.js","./internal":null,"./lib/*":"./lib/*.js","./lib/private/*":null}}`), 0644)
+
+	for _, submodule := range []string{"internal", "lib/private/a"} {
+		_, err := initESM(wd, Pkg{Name: "foo", Version: "1.0.0", Submodule: submodule}, false, false, false)
+		if !errors.Is(err, ErrExportBlocked) {
+			t.Fatalf("the submodule '%s' should be blocked, but got %v", submodule, err)
+		}
+	}
+}
+
+func TestCheckESMWithJSX(t *testing.T) {
+	wd := t.TempDir()
+	pkgDir := path.Join(wd, "node_modules", "react-tsx-components")
+	ensureDir(path.Join(pkgDir, "src"))
+
+	files := map[string]string{
+		"src/index.tsx": `import React from "react";
+export interface ButtonProps { label: string }
+export const Button = ({ label }: ButtonProps) => <button>{label}</button>;
+export default function App() { return <><Button label="Hello" /></>; }
+`,
+		"src/Card.jsx": `export const Card = ({ children }) => <div className="card">{children}</div>;
+`,
+	}
+	for name, code := range files {
+		err := ioutil.WriteFile(path.Join(pkgDir, name), []byte(code), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolved, exportDefault, exports, err := checkESM(wd, "react-tsx-components", "src/index.tsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != "src/index.tsx" || !exportDefault {
+		t.Fatalf("unexpected result: '%s', %v", resolved, exportDefault)
+	}
+	if !reflect.DeepEqual(exports, []string{"Button"}) {
+		t.Fatalf("unexpected exports: %v", exports)
+	}
+
+	_, exportDefault, exports, err = checkESM(wd, "react-tsx-components", "src/Card.jsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exportDefault || !reflect.DeepEqual(exports, []string{"Card"}) {
+		t.Fatalf("unexpected exports: %v", exports)
+	}
+}
+
+func TestResolveRootExports(t *testing.T) {
+	pkgDir := t.TempDir()
+	ensureDir(path.Join(pkgDir, "dist"))
+	ioutil.WriteFile(path.Join(pkgDir, "dist", "index.mjs"), []byte("export default 1"), 0644)
+
+	for packageJSON, expected := range map[string][3]string{
+		`{"type":"module","exports":{".":{"types":"./index.d.ts","default":"./index.js"}}}`: {"./index.js", "index.js", "true"},
+		`{"exports":{".":{"import":"./dist/index","require":"./dist/index.cjs"}}}`:          {"./dist/index.mjs", "dist/index.cjs", "true"},
+		`{"exports":{".":"./index.js"}}`:                                                    {"", "index.js", "true"},
+		`{"exports":{"./foo":"./foo.js"}}`:                                                  {"", "", "false"},
+		`{"exports":{".":null}}`:                                                            {"", "", "false"},
+	} {
+		var p NpmPackage
+		err := json.Unmarshal([]byte(packageJSON), &p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		module, main, ok, err := resolveRootExports(p, pkgDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if module != expected[0] || main != expected[1] || fmt.Sprint(ok) != expected[2] {
+			t.Fatalf("unexpected entries of %s: '%s', '%s', %v", packageJSON, module, main, ok)
+		}
+	}
+}
+
+func TestResolveSubmodulePackageJSON(t *testing.T) {
+	wd := t.TempDir()
+	pkgDir := path.Join(wd, "node_modules", "@emotion", "react")
+	for name, content := range map[string]string{
+		"package.json":             `{"name":"@emotion/react","version":"11.7.0","main":"dist/emotion-react.cjs.js","module":"dist/emotion-react.esm.js"}`,
+		"jsx-runtime/package.json": `{"main":"dist/emotion-react-jsx-runtime.cjs.js","module":"dist/emotion-react-jsx-runtime.esm.js","types":"dist/emotion-react-jsx-runtime.cjs.d.ts"}`,
+		"esm/package.json":         `{"type":"module"}`,
+		"esm/utils.js":             `export const a = 1`,
+		"cjs/utils.js":             `exports.a = 1`,
+	} {
+		ensureDir(path.Dir(path.Join(pkgDir, name)))
+		ioutil.WriteFile(path.Join(pkgDir, name), []byte(content), 0644)
+	}
+
+	np, err := resolveSubmodulePackageJSON(wd, "@emotion/react", "jsx-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np == nil || np.Name != "@emotion/react" || np.Module != "jsx-runtime/dist/emotion-react-jsx-runtime.esm.js" || np.Main != "jsx-runtime/dist/emotion-react-jsx-runtime.cjs.js" || np.Types != "jsx-runtime/dist/emotion-react-jsx-runtime.cjs.d.ts" {
+		t.Fatalf("unexpected package of 'jsx-runtime': %+v", np)
+	}
+
+	np, err = resolveSubmodulePackageJSON(wd, "@emotion/react", "esm/utils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np == nil || np.Type != "module" || np.Main != "" || np.Module != "" {
+		t.Fatalf("unexpected package of 'esm/utils': %+v", np)
+	}
+
+	np, err = resolveSubmodulePackageJSON(wd, "@emotion/react", "cjs/utils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np != nil {
+		t.Fatalf("unexpected package of 'cjs/utils': %+v", np)
+	}
+}
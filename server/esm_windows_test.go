@@ -0,0 +1,38 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTempDirOnWindows(t *testing.T) {
+	if dir := tempDir("esm-build"); strings.Contains(dir, "\\") {
+		t.Fatalf("tempDir should be slash separated: %s", dir)
+	}
+}
+
+func TestCheckESMOnWindows(t *testing.T) {
+	// the backslash separated wd
+	wd := t.TempDir()
+	pkgDir := filepath.Join(wd, "node_modules", "win-pkg", "lib")
+	ensureDir(pkgDir)
+	err := ioutil.WriteFile(filepath.Join(pkgDir, "index.js"), []byte(`export const foo = 1; export default foo;`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, exportDefault, exports, err := checkESM(wd, "win-pkg", "lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != path.Join("lib", "index.js") || !exportDefault || !reflect.DeepEqual(exports, []string{"foo"}) {
+		t.Fatalf("unexpected result: '%s', %v, %v", resolved, exportDefault, exports)
+	}
+}
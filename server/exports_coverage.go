@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+// the max number of the tracked export names of a package
+const maxTrackedExports = 1000
+
+// exportsUsage stores the accessed export names of the packages by the `?exports=`
+// query of the module requests, keyed by `<name>@<version>`. It's in memory only and
+// reset on server restart.
+var exportsUsage sync.Map
+
+// exportsCoverage defines the coverage of the named exports of a package
+type exportsCoverage struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Exports  []string `json:"exports"`
+	Used     []string `json:"used"`
+	Unused   []string `json:"unused"`
+	Coverage float64  `json:"coverage"`
+}
+
+// recordExportsUsage adds the export names of the `?exports=a,b` query to the usage of the package
+func recordExportsUsage(pkg Pkg, exports string) {
+	v, _ := exportsUsage.LoadOrStore(pkg.Name+"@"+pkg.Version, newStringSet())
+	used := v.(*stringSet)
+	for _, name := range strings.Split(exports, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && used.Size() < maxTrackedExports {
+			used.Add(name)
+		}
+	}
+}
+
+// serveExportsCoverage serves the `+coverage` API, it shows the percentage of the named
+// exports of the build accessed by the `?exports=` query since the server started. It's
+// only available with the `--exports-coverage` option as a developer aid.
+func serveExportsCoverage(ctx *rex.Context, pkg *Pkg) interface{} {
+	if !trackExportsCoverage {
+		return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "not found"})
+	}
+
+	task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: pkg.Version})
+	esm, err := findESM(task.ID())
+	if err == storage.ErrNotFound {
+		if !buildQueue.tryAdd(task) {
+			return throwQueueFullError(ctx)
+		}
+		ctx.SetHeader("Retry-After", "30")
+		return rex.Status(http.StatusAccepted, fmt.Sprintf("Building %s@%s, please retry later", pkg.Name, pkg.Version))
+	}
+	if buildErr, ok := err.(*FailedBuildError); ok {
+		return throwBuildError(ctx, buildErr)
+	}
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	var used []string
+	if v, ok := exportsUsage.Load(pkg.Name + "@" + pkg.Version); ok {
+		used = v.(*stringSet).Values()
+	}
+	coverage := computeExportsCoverage(esm.Exports, used)
+	coverage.Name = pkg.Name
+	coverage.Version = pkg.Version
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return coverage
+}
+
+// computeExportsCoverage computes the coverage of the exports by the used names, the
+// used names that are not exported are ignored.
+func computeExportsCoverage(exports []string, used []string) *exportsCoverage {
+	usedSet := newStringSet()
+	for _, name := range used {
+		usedSet.Add(name)
+	}
+	coverage := &exportsCoverage{
+		Exports: append([]string{}, exports...),
+		Used:    []string{},
+		Unused:  []string{},
+	}
+	sort.Strings(coverage.Exports)
+	for _, name := range coverage.Exports {
+		if usedSet.Has(name) {
+			coverage.Used = append(coverage.Used, name)
+		} else {
+			coverage.Unused = append(coverage.Unused, name)
+		}
+	}
+	if len(coverage.Exports) > 0 {
+		coverage.Coverage = float64(len(coverage.Used)) * 100 / float64(len(coverage.Exports))
+	}
+	return coverage
+}
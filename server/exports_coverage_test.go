@@ -0,0 +1,31 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExportsCoverage(t *testing.T) {
+	pkg := Pkg{Name: "coverage-test", Version: "1.0.0"}
+	recordExportsUsage(pkg, "useState, useEffect")
+	recordExportsUsage(pkg, "useState,notExported,")
+	v, ok := exportsUsage.Load("coverage-test@1.0.0")
+	if !ok {
+		t.Fatal("the usage should be recorded")
+	}
+
+	coverage := computeExportsCoverage([]string{"useState", "useEffect", "useRef", "useMemo"}, v.(*stringSet).Values())
+	if !reflect.DeepEqual(coverage.Used, []string{"useEffect", "useState"}) {
+		t.Fatalf("unexpected used exports: %v", coverage.Used)
+	}
+	if !reflect.DeepEqual(coverage.Unused, []string{"useMemo", "useRef"}) {
+		t.Fatalf("unexpected unused exports: %v", coverage.Unused)
+	}
+	if coverage.Coverage != 50 {
+		t.Fatalf("unexpected coverage: %v", coverage.Coverage)
+	}
+
+	if coverage := computeExportsCoverage(nil, nil); coverage.Coverage != 0 || len(coverage.Used) != 0 {
+		t.Fatalf("unexpected coverage: %v", coverage)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// exportsDiff defines the changes of the exports between two versions of a package
+type exportsDiff struct {
+	From                 string   `json:"from"`
+	To                   string   `json:"to"`
+	Added                []string `json:"added"`
+	Removed              []string `json:"removed"`
+	ExportDefaultChanged bool     `json:"exportDefaultChanged"`
+	Breaking             bool     `json:"breaking"`
+}
+
+// serveNpmDiff serves the `+npm-diff?from=<version>&to=<version>` requests, it compares
+// the exports of the builds of two versions, the removed exports and the change of the
+// default export (CJS <-> ESM) are breaking changes. The builds are queued if they are
+// not found, then a 202 response is returned to retry later. The diff is cached for
+// 24 hours.
+func serveNpmDiff(ctx *rex.Context, pkg *Pkg) interface{} {
+	versions := [2]string{ctx.Form.Value("from"), ctx.Form.Value("to")}
+	for i, version := range versions {
+		if version == "" {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing the `from` or `to` query"})
+		}
+		info, _, _, err := getPackageInfo("", pkg.Name, version)
+		if err != nil {
+			if strings.HasSuffix(err.Error(), "not found") {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: err.Error()})
+			}
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		versions[i] = info.Version
+	}
+
+	key := fmt.Sprintf("diff:%s:%s:%s", pkg.Name, versions[0], versions[1])
+	data, err := cache.Get(key)
+	if err != nil {
+		// queue the builds of both versions to build them in parallel
+		builds := [2]*ESM{}
+		pending := false
+		for i, version := range versions {
+			task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: version})
+			builds[i], err = findESM(task.ID())
+			if err == storage.ErrNotFound {
+				if !buildQueue.tryAdd(task) {
+					return throwQueueFullError(ctx)
+				}
+				pending = true
+			} else if buildErr, ok := err.(*FailedBuildError); ok {
+				return throwBuildError(ctx, buildErr)
+			} else if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+		}
+		if pending {
+			ctx.SetHeader("Retry-After", "30")
+			return rex.Status(http.StatusAccepted, fmt.Sprintf("Building %s@%s and %s@%s, please retry later", pkg.Name, versions[0], pkg.Name, versions[1]))
+		}
+
+		diff := diffExports(builds[0], builds[1])
+		diff.From = versions[0]
+		diff.To = versions[1]
+		data = utils.MustEncodeJSON(diff)
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
+
+// diffExports compares the named exports and the default export of two builds
+func diffExports(from *ESM, to *ESM) *exportsDiff {
+	diff := &exportsDiff{Added: []string{}, Removed: []string{}}
+	fromExports := newStringSet()
+	for _, name := range from.Exports {
+		fromExports.Add(name)
+	}
+	toExports := newStringSet()
+	for _, name := range to.Exports {
+		toExports.Add(name)
+		if !fromExports.Has(name) {
+			diff.Added = append(diff.Added, name)
+		}
+	}
+	for _, name := range from.Exports {
+		if !toExports.Has(name) {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	diff.ExportDefaultChanged = from.ExportDefault != to.ExportDefault
+	diff.Breaking = len(diff.Removed) > 0 || diff.ExportDefaultChanged
+	return diff
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffExports(t *testing.T) {
+	from := &ESM{Exports: []string{"render", "hydrate", "findDOMNode"}, ExportDefault: true}
+	to := &ESM{Exports: []string{"render", "hydrate", "createRoot", "hydrateRoot"}, ExportDefault: true}
+	diff := diffExports(from, to)
+	if !reflect.DeepEqual(diff.Added, []string{"createRoot", "hydrateRoot"}) || !reflect.DeepEqual(diff.Removed, []string{"findDOMNode"}) {
+		t.Fatalf("unexpected diff: %v", diff)
+	}
+	if diff.ExportDefaultChanged || !diff.Breaking {
+		t.Fatalf("unexpected diff: %v", diff)
+	}
+
+	// the default export is changed
+	diff = diffExports(&ESM{Exports: []string{"a"}, ExportDefault: true}, &ESM{Exports: []string{"a", "b"}})
+	if len(diff.Removed) != 0 || !diff.ExportDefaultChanged || !diff.Breaking {
+		t.Fatalf("unexpected diff: %v", diff)
+	}
+
+	// no changes
+	diff = diffExports(&ESM{Exports: []string{"a"}}, &ESM{Exports: []string{"a"}})
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || diff.Breaking {
+		t.Fatalf("unexpected diff: %v", diff)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"path"
+
+	"esm.sh/pkg/esmmeta"
+)
+
+// ExportsKind defines the kind of an `exports` value in package.json
+type ExportsKind = esmmeta.ExportsKind
+
+const (
+	ExportsNull   = esmmeta.ExportsNull
+	ExportsPath   = esmmeta.ExportsPath
+	ExportsArray  = esmmeta.ExportsArray
+	ExportsObject = esmmeta.ExportsObject
+)
+
+// ExportsMap defines the `exports` field of package.json
+type ExportsMap = esmmeta.ExportsMap
+
+// the extensions to try for the `exports` values without extension in order
+var exportPathExts = []string{".js", ".mjs", ".cjs"}
+
+// resolveExportPath resolves the `exports` value like `./dist/index` in the package
+// directory, the `.js`, `.mjs` and `.cjs` extensions are tried in order if the path
+// doesn't exist and has no extension.
+func resolveExportPath(dir string, value string) (string, error) {
+	if path.Ext(value) != "" || fileExists(path.Join(dir, value)) {
+		return value, nil
+	}
+	for _, ext := range exportPathExts {
+		if fileExists(path.Join(dir, value+ext)) {
+			return value + ext, nil
+		}
+	}
+	return "", fmt.Errorf("export path '%s' not found", value)
+}
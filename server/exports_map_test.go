@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestResolveExportPath(t *testing.T) {
+	dir := t.TempDir()
+	ensureDir(path.Join(dir, "dist", "lib"))
+	for _, name := range []string{"dist/index.mjs", "dist/index.cjs", "dist/util.cjs", "dist/main.js", "dist/main.mjs", "dist/raw"} {
+		ioutil.WriteFile(path.Join(dir, name), []byte{}, 0644)
+	}
+
+	for value, expected := range map[string]string{
+		"./dist/index":    "./dist/index.mjs",
+		"./dist/util":     "./dist/util.cjs",
+		"./dist/main":     "./dist/main.js",
+		"./dist/raw":      "./dist/raw",
+		"./dist/index.js": "./dist/index.js",
+	} {
+		ret, err := resolveExportPath(dir, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret != expected {
+			t.Fatalf("the export path '%s' should be resolved to '%s', but got '%s'", value, expected, ret)
+		}
+	}
+	for _, value := range []string{"./dist/missing", "./dist/lib"} {
+		if _, err := resolveExportPath(dir, value); err == nil {
+			t.Fatalf("the export path '%s' should not be resolved", value)
+		}
+	}
+
+	// the `exports` values without extension in package.json
+	var p NpmPackage
+	err := json.Unmarshal([]byte(`{"name":"foo","type":"module","exports":{".":{"import":"./dist/index","require":"./dist/util"}}}`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np := fixNpmPackage(p, dir)
+	if np.Module != "./dist/index.mjs" || np.Main != "dist/util.cjs" {
+		t.Fatalf("unexpected module '%s' and main '%s'", np.Module, np.Main)
+	}
+}
+
+func TestListExports(t *testing.T) {
+	var exports ExportsMap
+	err := json.Unmarshal([]byte(`{
+		".": {"import": "./index.mjs", "require": "./index.cjs"},
+		"./hooks": {"browser": "./hooks.browser.js", "node": "./hooks.node.js"},
+		"./server": {"node": "./server.js"},
+		"./internal": null,
+		"./utils/*": "./utils/*.js"
+	}`), &exports)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"es2020": ".,./hooks,./utils/*",
+		"node":   ".,./hooks,./server,./utils/*",
+		"deno":   ".,./hooks,./utils/*",
+	} {
+		list := listExports(exports, exportsConditionsOf(target))
+		if strings.Join(list, ",") != expected {
+			t.Fatalf("unexpected exports of the %s target: %v", target, list)
+		}
+	}
+
+	// the exports without subpaths
+	err = json.Unmarshal([]byte(`{"require": "./index.cjs"}`), &exports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := listExports(exports, exportsConditionsOf("es2020")); len(list) != 0 {
+		t.Fatalf("unexpected exports: %v", list)
+	}
+}
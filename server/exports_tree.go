@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ije/rex"
+)
+
+// exportsTreeConditions defines the conditions of the targets to resolve the
+// `exports` of package.json for the `+exports-tree` API.
+var exportsTreeConditions = []struct {
+	target     string
+	conditions []string
+}{
+	{"browser", []string{"browser", "import", "module"}},
+	{"node", []string{"node", "import"}},
+	{"deno", []string{"deno", "browser", "import", "module"}},
+	{"import", []string{"import"}},
+	{"require", []string{"require"}},
+}
+
+// serveExportsTree serves the resolution tree of the `exports` of package.json,
+// it shows the resolved file of each `(subpath, target)` combination, the
+// unresolvable ones are marked as `null`.
+func serveExportsTree(ctx *rex.Context, pkg *Pkg) interface{} {
+	info, _, _, err := getPackageInfo("", pkg.Name, pkg.Version)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "not found") {
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: err.Error()})
+		}
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if info.DefinedExports == nil {
+		return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("No exports defined in the package.json of %s@%s", info.Name, info.Version)})
+	}
+
+	// the exports like `"./index.js"` or `{ "import": "./index.mjs" }` are the sugar of `{ ".": ... }`
+	subpaths := []string{"."}
+	values := map[string]ExportsMap{".": *info.DefinedExports}
+	if info.DefinedExports.IsSubpaths() {
+		subpaths = info.DefinedExports.Keys
+		values = info.DefinedExports.Values
+	}
+
+	tree := map[string]map[string]interface{}{}
+	for _, subpath := range subpaths {
+		resolved := map[string]interface{}{}
+		for _, t := range exportsTreeConditions {
+			if s, ok := values[subpath].Resolve(t.conditions); ok {
+				resolved[t.target] = s
+			} else {
+				resolved[t.target] = nil
+			}
+		}
+		tree[subpath] = resolved
+	}
+
+	ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
+	return map[string]interface{}{
+		"name":    info.Name,
+		"version": info.Version,
+		"exports": tree,
+	}
+}
+
+// serveExports serves the importable subpaths of the package like
+// `{"exports":[".","./hooks"]}`, the subpaths that can't be resolved with the
+// conditions of the target (specified by the `target` query, or detected by the
+// `User-Agent` header) are omitted.
+func serveExports(ctx *rex.Context, pkg *Pkg) interface{} {
+	target := normalizeTarget(strings.ToLower(ctx.Form.Value("target")))
+	if target == "" {
+		target = getTargetByUA(ctx.R.UserAgent())
+	} else if !isValidTarget(target) {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid target: %s", target)})
+	}
+
+	info, _, _, err := getPackageInfo("", pkg.Name, pkg.Version)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "not found") {
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: err.Error()})
+		}
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	exports := []string{}
+	if info.DefinedExports != nil {
+		exports = listExports(*info.DefinedExports, exportsConditionsOf(target))
+	} else if info.Main != "" || info.Module != "" {
+		exports = append(exports, ".")
+	}
+
+	ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
+	return map[string]interface{}{
+		"name":    info.Name,
+		"version": info.Version,
+		"target":  target,
+		"exports": exports,
+	}
+}
+
+// exportsConditionsOf returns the conditions of the `exports` to resolve for the target
+func exportsConditionsOf(target string) []string {
+	platform := "browser"
+	if target == "node" || target == "deno" {
+		platform = target
+	}
+	for _, t := range exportsTreeConditions {
+		if t.target == platform {
+			return t.conditions
+		}
+	}
+	return nil
+}
+
+// listExports returns the subpaths of the `exports` in the defined order that can be
+// resolved with the conditions, the subpath patterns like `./*` are listed as is.
+func listExports(exports ExportsMap, conditions []string) []string {
+	subpaths := []string{"."}
+	values := map[string]ExportsMap{".": exports}
+	if exports.IsSubpaths() {
+		subpaths = exports.Keys
+		values = exports.Values
+	}
+	list := []string{}
+	for _, subpath := range subpaths {
+		if _, ok := values[subpath].Resolve(conditions); ok {
+			list = append(list, subpath)
+		}
+	}
+	return list
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// the git packages are requested like `/gh:user/repo@ref/submodule`, and
+// normalized to `/github.com/user/repo@ref/submodule`
+var regGitHubPackage = regexp.MustCompile(`^(gh:|github\.com/|gitlab\.com/)([\w\-\.]+)/([\w\-\.]+)@([\w\-\.]+)(/.*)?$`)
+
+// the git specifiers used by npm like `github:user/repo#ref` or `gitlab:user/repo`,
+// the ref is optional
+var regGitPkgSpecifier = regexp.MustCompile(`^(github|gitlab):([\w\-\.]+)/([\w\-\.]+)(?:#([\w\-\.]+))?(/.*)?$`)
+
+// gitHosts defines the raw file URL of package.json and the package manager
+// protocol of the supported git hosts
+var gitHosts = map[string]struct {
+	rawPackageJSON string
+	protocol       string
+}{
+	"github.com": {"https://raw.githubusercontent.com/%s/%s/%s/package.json", "github"},
+	"gitlab.com": {"https://gitlab.com/%s/%s/-/raw/%s/package.json", "gitlab"},
+}
+
+// parseGitSpecifier parses the git package specifier like `gh:user/repo@ref`,
+// `github.com/user/repo@ref`, `github:user/repo#ref` or `gitlab:user/repo`. The
+// ref defaults to `HEAD` if it's omitted.
+func parseGitSpecifier(spec string) (host string, user string, repo string, ref string, submodule string, ok bool) {
+	spec = strings.TrimPrefix(spec, "/")
+	if m := regGitHubPackage.FindStringSubmatch(spec); m != nil {
+		host = "github.com"
+		if m[1] == "gitlab.com/" {
+			host = "gitlab.com"
+		}
+		return host, m[2], m[3], m[4], m[5], true
+	}
+	if m := regGitPkgSpecifier.FindStringSubmatch(spec); m != nil {
+		ref = m[4]
+		if ref == "" {
+			ref = "HEAD"
+		}
+		return m[1] + ".com", m[2], m[3], ref, m[5], true
+	}
+	return
+}
+
+// isGitPackage checks whether the specifier is a git package like `gh:user/repo@ref`
+// or `github:user/repo#ref`
+func isGitPackage(spec string) bool {
+	_, _, _, _, _, ok := parseGitSpecifier(spec)
+	return ok
+}
+
+// splitGitPkgName splits the package name like `github.com/user/repo` into the
+// host, user and repo, returns empty strings if the name is not a git package name.
+func splitGitPkgName(name string) (host string, user string, repo string) {
+	a := strings.Split(name, "/")
+	if len(a) == 3 {
+		if _, ok := gitHosts[a[0]]; ok {
+			return a[0], a[1], a[2]
+		}
+	}
+	return "", "", ""
+}
+
+// installFromGit installs the git package by the package manager, then links
+// the installed package to `node_modules/<host>/<user>/<repo>` since the name
+// in the package.json of the repo may differ from the repo name.
+func installFromGit(wd string, host string, user string, repo string, ref string) (err error) {
+	gitHost, ok := gitHosts[host]
+	if !ok {
+		return fmt.Errorf("git: unsupported host '%s'", host)
+	}
+	name := fmt.Sprintf("%s/%s/%s@%s", host, user, repo, ref)
+
+	resp, err := httpClient.Get(fmt.Sprintf(gitHost.rawPackageJSON, user, repo, ref))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return fmt.Errorf("%s: package '%s' not found", gitHost.protocol, name)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: can't get package.json of '%s' (%s)", gitHost.protocol, name, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	var p NpmPackage
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return
+	}
+	if p.Name == "" {
+		return fmt.Errorf("%s: missing name in package.json of '%s'", gitHost.protocol, name)
+	}
+
+	err = retryYarnAdd(wd, fmt.Sprintf("%s:%s/%s#%s", gitHost.protocol, user, repo, ref), 3, time.Second)
+	if err != nil {
+		return
+	}
+
+	packageFile, err := findPackageJSON(wd, p.Name)
+	if err != nil {
+		return
+	}
+	link := path.Join(wd, "node_modules", host, user, repo)
+	err = ensureDir(path.Dir(link))
+	if err != nil {
+		return
+	}
+	return os.Symlink(path.Dir(packageFile), link)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+type installInfo struct {
+	ResolvedVersion string   `json:"resolvedVersion"`
+	ResolvedBy      string   `json:"resolvedBy"`
+	Chain           []string `json:"chain"`
+}
+
+var (
+	regYarnWhyFound     = regexp.MustCompile(`=> Found "([^"]+)"`)
+	regYarnWhySpecified = regexp.MustCompile(`(?i)specified in "([^"]+)"`)
+	regYarnWhyDependent = regexp.MustCompile(`"([^"]+)" depends on it`)
+)
+
+// serveInstallInfo serves how yarn resolved the package, the packages of the `deps`
+// query are installed alongside the package, and the `why` query specifies the
+// dependency to inspect instead of the package itself. The result is cached for 1 hour.
+func serveInstallInfo(ctx *rex.Context, pkg *Pkg) interface{} {
+	specs := []string{fmt.Sprintf("%s@%s", pkg.Name, pkg.Version)}
+	for _, p := range strings.Split(ctx.Form.Value("deps"), ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			m, err := parsePkg(p)
+			if err != nil {
+				return throwAPIError(ctx, 400, APIError{Code: errCodeInvalidPackage, Message: fmt.Sprintf("Invalid deps query: %v", err)})
+			}
+			specs = append(specs, fmt.Sprintf("%s@%s", m.Name, m.Version))
+		}
+	}
+	name := pkg.Name
+	if v := strings.TrimSpace(ctx.Form.Value("why")); v != "" {
+		if !isPackageName(v) || strings.HasPrefix(v, "-") {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeInvalidPackage, Message: fmt.Sprintf("Invalid why query: %s", v)})
+		}
+		name = v
+	}
+
+	key := fmt.Sprintf("install-info:%s:%s", strings.Join(specs, ","), name)
+	data, err := cache.Get(key)
+	if err != nil {
+		info, err := getInstallInfo(specs, name)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		data = utils.MustEncodeJSON(info)
+		cache.Set(key, data, time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=3600")
+	return data
+}
+
+// getInstallInfo installs the packages by yarn in a temporary directory and
+// parses the output of `yarn why <name>`.
+func getInstallInfo(specs []string, name string) (info *installInfo, err error) {
+	wd := tempDir(fmt.Sprintf("esm-install-info-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+
+	err = yarnAdd(wd, specs...)
+	if err != nil {
+		return
+	}
+
+	cmd := exec.Command("yarn", "why", name, "--json", "--non-interactive")
+	cmd.Dir = wd
+	output, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("yarn why %s: %v", name, err)
+		return
+	}
+
+	return parseYarnWhy(output, func(name string) string {
+		packageFile, err := findPackageJSON(wd, name)
+		if err != nil {
+			return ""
+		}
+		var p NpmPackage
+		if utils.ParseJSONFile(packageFile, &p) != nil {
+			return ""
+		}
+		return p.Version
+	})
+}
+
+// parseYarnWhy parses the json lines output of `yarn why`, like:
+//
+//	{"type":"info","data":"\r=> Found \"react-dom#scheduler@0.20.2\""}
+//	{"type":"info","data":"This module exists because \"react-dom\" depends on it."}
+//
+// the versions of the packages in the chain are resolved by the `versionOf` function.
+func parseYarnWhy(output []byte, versionOf func(name string) string) (*installInfo, error) {
+	var found string
+	var specifiedIn string
+	var dependents []string
+	for _, line := range strings.Split(string(output), "\n") {
+		var msg struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if json.Unmarshal([]byte(line), &msg) != nil {
+			continue
+		}
+		var texts []string
+		switch msg.Type {
+		case "info":
+			var s string
+			if json.Unmarshal(msg.Data, &s) == nil {
+				texts = append(texts, s)
+			}
+		case "list":
+			var list struct {
+				Type  string   `json:"type"`
+				Items []string `json:"items"`
+			}
+			if json.Unmarshal(msg.Data, &list) == nil && list.Type == "reasons" {
+				texts = list.Items
+			}
+		case "error":
+			var s string
+			json.Unmarshal(msg.Data, &s)
+			return nil, fmt.Errorf("yarn why: %s", s)
+		}
+		for _, s := range texts {
+			if m := regYarnWhyFound.FindStringSubmatch(s); m != nil && found == "" {
+				found = m[1]
+			} else if m := regYarnWhySpecified.FindStringSubmatch(s); m != nil && specifiedIn == "" {
+				specifiedIn = m[1]
+			} else if m := regYarnWhyDependent.FindStringSubmatch(s); m != nil {
+				dependents = append(dependents, m[1])
+			}
+		}
+	}
+	if found == "" {
+		return nil, fmt.Errorf("yarn why: module not found")
+	}
+
+	// the found module is like `react@17.0.2` or `react-dom#scheduler@0.20.2`
+	i := strings.LastIndexByte(found, '@')
+	if i <= 0 {
+		return nil, fmt.Errorf("yarn why: invalid module '%s'", found)
+	}
+	path := strings.Split(found[:i], "#")
+	info := &installInfo{
+		ResolvedVersion: found[i+1:],
+		ResolvedBy:      specifiedIn,
+		Chain:           []string{"app"},
+	}
+	parents := path[:len(path)-1]
+	if len(parents) == 0 && len(dependents) > 0 {
+		parents = strings.Split(dependents[0], "#")
+	}
+	if info.ResolvedBy == "" && len(parents) > 0 {
+		info.ResolvedBy = parents[len(parents)-1]
+	}
+	for _, name := range parents {
+		if version := versionOf(name); version != "" {
+			name += "@" + version
+		}
+		info.Chain = append(info.Chain, name)
+	}
+	info.Chain = append(info.Chain, fmt.Sprintf("%s@%s", path[len(path)-1], info.ResolvedVersion))
+	return info, nil
+}
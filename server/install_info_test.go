@@ -0,0 +1,49 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYarnWhy(t *testing.T) {
+	versionOf := func(name string) string {
+		return map[string]string{"react-dom": "18.2.0"}[name]
+	}
+
+	info, err := parseYarnWhy([]byte(`{"type":"step","data":{"message":"Why do we have the module \"react\"...?","current":1,"total":4}}
+{"type":"info","data":"\r=> Found \"react@18.2.0\""}
+{"type":"info","data":"Reasons this module exists"}
+{"type":"list","data":{"type":"reasons","items":["Specified in \"dependencies\"","\"react-dom\" depends on it"]}}
+`), versionOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &installInfo{
+		ResolvedVersion: "18.2.0",
+		ResolvedBy:      "dependencies",
+		Chain:           []string{"app", "react-dom@18.2.0", "react@18.2.0"},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Fatalf("unexpected install info: %v", info)
+	}
+
+	info, err = parseYarnWhy([]byte(`{"type":"info","data":"\r=> Found \"react-dom#scheduler@0.23.0\""}
+{"type":"info","data":"This module exists because \"react-dom\" depends on it."}
+`), versionOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = &installInfo{
+		ResolvedVersion: "0.23.0",
+		ResolvedBy:      "react-dom",
+		Chain:           []string{"app", "react-dom@18.2.0", "scheduler@0.23.0"},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Fatalf("unexpected install info: %v", info)
+	}
+
+	_, err = parseYarnWhy([]byte(`{"type":"error","data":"We couldn't find a match!"}`), versionOf)
+	if err == nil {
+		t.Fatal("should be failed")
+	}
+}
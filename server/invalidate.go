@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ije/rex"
+)
+
+// serveInvalidate serves the `POST +invalidate` requests, it evicts all the builds
+// of the package and re-queues the builds of the common targets. Only the package
+// maintainers are allowed with the npm token in the `Authorization` header.
+func serveInvalidate(ctx *rex.Context, pkg *Pkg) interface{} {
+	if ctx.R.Method != "POST" {
+		ctx.SetHeader("Allow", "POST")
+		return throwAPIError(ctx, http.StatusMethodNotAllowed, APIError{Code: errCodeMethodNotAllowed, Message: "Method Not Allowed"})
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(ctx.R.Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		ctx.SetHeader("WWW-Authenticate", "Bearer")
+		return throwAPIError(ctx, http.StatusUnauthorized, APIError{Code: errCodeUnauthorized, Message: "Missing the npm token in the Authorization header"})
+	}
+	ok, err := isNpmMaintainer(token, pkg.Name)
+	if err != nil {
+		return throwAPIError(ctx, http.StatusBadGateway, APIError{Code: errCodeInternal, Message: "Failed to check the maintainers of the package", Detail: err.Error()})
+	}
+	if !ok {
+		return throwAPIError(ctx, http.StatusForbidden, APIError{Code: errCodeUnauthorized, Message: fmt.Sprintf("Only the maintainers of %s can invalidate the builds", pkg.Name)})
+	}
+
+	evicted, err := evictPackageBuilds(fmt.Sprintf("%s@%s", pkg.Name, pkg.Version))
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	queued := 0
+	for _, target := range commonTargets {
+		if buildQueue.tryAdd(&BuildTask{
+			BuildVersion: VERSION,
+			Pkg:          Pkg{Name: pkg.Name, Version: pkg.Version},
+			Target:       target,
+			stage:        "init",
+		}) {
+			queued++
+		}
+	}
+	log.Infof("invalidate %s@%s: %d builds evicted", pkg.Name, pkg.Version, len(evicted))
+
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	return map[string]interface{}{
+		"invalidated": len(evicted),
+		"queued":      queued,
+	}
+}
+
+// isNpmMaintainer checks whether the owner of the npm token is a maintainer of
+// the package by the `whoami` API of the npm registry.
+func isNpmMaintainer(token string, pkgName string) (bool, error) {
+	req, err := http.NewRequest("GET", node.npmRegistry+"-/whoami", nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		return false, nil
+	}
+	var user struct {
+		Username string `json:"username"`
+	}
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("npm whoami: %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	if err != nil || user.Username == "" {
+		return false, err
+	}
+
+	var info struct {
+		Maintainers []struct {
+			Name string `json:"name"`
+		} `json:"maintainers"`
+	}
+	err = fetchJSON(node.npmRegistry+pkgName, &info)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range info.Maintainers {
+		if m.Name == user.Username {
+			return true, nil
+		}
+	}
+	return false, nil
+}
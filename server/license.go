@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// serveLicense serves the license text of the package, the text is cached in the fs
+// at `licenses/<pkg>@<version>.txt` for 24 hours.
+func serveLicense(ctx *rex.Context, pkg *Pkg) interface{} {
+	savePath := path.Join("licenses", pkg.Name+"@"+pkg.Version+".txt")
+	exists, modtime, err := fs.Exists(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if !exists || time.Since(modtime) > 24*time.Hour {
+		data, license, err := readPackageLicense(pkg)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		if data == nil {
+			if license != "" {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("No license file found in %s@%s, the license declared in package.json is '%s'", pkg.Name, pkg.Version, license)})
+			}
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("No license file found in %s@%s", pkg.Name, pkg.Version)})
+		}
+		err = fs.WriteData(savePath, data)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		modtime = time.Now()
+	}
+	r, err := fs.ReadFile(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return rex.Content(savePath, modtime, r)
+}
+
+// readPackageLicense installs the package and reads the license files like `LICENSE`,
+// `LICENSE.md` or `licence.txt` in the package directory, multiple files are joined
+// with the `---` separator. It returns nil data and the `license` field of
+// package.json if no license file found.
+func readPackageLicense(pkg *Pkg) (data []byte, license string, err error) {
+	wd := tempDir(fmt.Sprintf("esm-license-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+
+	err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version), 3, time.Second)
+	if err != nil {
+		return
+	}
+
+	packageFile, err := findPackageJSON(wd, pkg.Name)
+	if err != nil {
+		return
+	}
+	var p struct {
+		License interface{} `json:"license"`
+	}
+	if utils.ParseJSONFile(packageFile, &p) == nil {
+		if s, ok := p.License.(string); ok {
+			license = s
+		}
+	}
+
+	pkgDir := path.Dir(packageFile)
+	entries, err := ioutil.ReadDir(pkgDir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if !entry.IsDir() && (strings.HasPrefix(name, "license") || strings.HasPrefix(name, "licence")) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	buf := bytes.NewBuffer(nil)
+	for i, name := range names {
+		var content []byte
+		content, err = ioutil.ReadFile(path.Join(pkgDir, name))
+		if err != nil {
+			return
+		}
+		if i > 0 {
+			buf.WriteString("\n---\n\n")
+		}
+		buf.Write(bytes.TrimSpace(content))
+		buf.WriteByte('\n')
+	}
+	if buf.Len() > 0 {
+		data = buf.Bytes()
+	}
+	return
+}
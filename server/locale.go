@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// localeConfig defines the locale data module of a package in the
+// `embed/supported-locales.json`
+type localeConfig struct {
+	// the module that includes the data of all locales, like `date-fns/locale`
+	Module string `json:"module"`
+	// the pattern of the locale file, like `date-fns/locale/{locale}/index.js`
+	File string `json:"file"`
+	// `named` exports the locale as the camel-cased name like `enUS`, `side-effect`
+	// imports the locale file that registers itself, like the moment locales.
+	Export string `json:"export"`
+}
+
+var (
+	supportedLocales     map[string][]localeConfig
+	supportedLocalesOnce sync.Once
+)
+
+var regLocale = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// isValidLocale checks whether the locale of the `locale` query is valid, like
+// `de` or `en-US`.
+func isValidLocale(locale string) bool {
+	return regLocale.MatchString(locale)
+}
+
+// getLocaleConfig returns the config of the locale data module, the specifier is
+// the bare import path like `date-fns/locale` or `date-fns/locale/index.js`.
+func getLocaleConfig(specifier string) *localeConfig {
+	supportedLocalesOnce.Do(func() {
+		data, err := embedFS.ReadFile("server/embed/supported-locales.json")
+		if err == nil {
+			err = json.Unmarshal(data, &supportedLocales)
+		}
+		if err != nil {
+			log.Errorf("load supported locales: %v", err)
+		}
+	})
+
+	specifier = strings.TrimSuffix(strings.TrimSuffix(specifier, ".js"), "/index")
+	pkgName, _ := splitPkgPath(specifier)
+	for _, c := range supportedLocales[pkgName] {
+		if c.Module == specifier {
+			return &c
+		}
+	}
+	return nil
+}
+
+// resolveLocaleModule returns the bare specifier of the locale data module, the
+// entry point of the build is an absolute path in the `node_modules`.
+func (task *BuildTask) resolveLocaleModule(importPath string) (specifier string, ok bool) {
+	if task.Locale == "" {
+		return
+	}
+	specifier = importPath
+	if nodeModulesDir := path.Join(task.wd, "node_modules") + "/"; strings.HasPrefix(importPath, nodeModulesDir) {
+		specifier = strings.TrimPrefix(importPath, nodeModulesDir)
+	}
+	return specifier, getLocaleConfig(specifier) != nil
+}
+
+// loadLocaleModule loads the code of the locale data module that only includes the
+// data of the locale by the `localeModule` node service
+func loadLocaleModule(wd string, specifier string, locale string) (ret virtualModuleResult, err error) {
+	c := getLocaleConfig(specifier)
+	if c == nil {
+		err = fmt.Errorf("locale module '%s' not supported", specifier)
+		return
+	}
+
+	data := invokeNodeService("localeModule", map[string]interface{}{
+		"buildDir": wd,
+		"locale":   locale,
+		"file":     c.File,
+		"export":   c.Export,
+	}, 10*time.Second)
+
+	err = json.Unmarshal(data, &ret)
+	if err == nil && ret.Error != "" {
+		err = errors.New(ret.Error)
+	}
+	if err != nil {
+		err = fmt.Errorf("load locale module '%s': %v", specifier, err)
+	}
+	return
+}
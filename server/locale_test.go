@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestLocaleModule(t *testing.T) {
+	embedFS = &devFS{".."}
+
+	for locale, valid := range map[string]bool{
+		"de":         true,
+		"en-US":      true,
+		"zh-Hant-TW": true,
+		"e":          false,
+		"en_US":      false,
+		"de/../x":    false,
+	} {
+		if isValidLocale(locale) != valid {
+			t.Fatalf("isValidLocale(%s) should be %v", locale, valid)
+		}
+	}
+
+	for specifier, expected := range map[string]string{
+		"date-fns/locale":              "date-fns/locale/{locale}/index.js",
+		"date-fns/locale/index.js":     "date-fns/locale/{locale}/index.js",
+		"date-fns/esm/locale/index.js": "date-fns/esm/locale/{locale}/index.js",
+		"moment/min/locales":           "moment/locale/{locale}.js",
+		"date-fns/locale/de/index.js":  "",
+		"date-fns":                     "",
+		"react":                        "",
+	} {
+		c := getLocaleConfig(specifier)
+		if (c == nil && expected != "") || (c != nil && c.File != expected) {
+			t.Fatalf("unexpected locale config of %s: %v", specifier, c)
+		}
+	}
+
+	task := &BuildTask{Locale: "de", wd: "/tmp/esm-build"}
+	if specifier, ok := task.resolveLocaleModule("/tmp/esm-build/node_modules/date-fns/locale/index.js"); !ok || specifier != "date-fns/locale/index.js" {
+		t.Fatalf("unexpected locale module: %s", specifier)
+	}
+	task.Locale = ""
+	if _, ok := task.resolveLocaleModule("date-fns/locale"); ok {
+		t.Fatal("the locale module should not be resolved without the locale")
+	}
+}
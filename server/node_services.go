@@ -20,6 +20,12 @@ import (
 	"github.com/ije/gox/utils"
 )
 
+// NSProtocolVersion is the version of the protocol between the server and the node
+// services process, it's announced by the process in the `READY:v<N>` line. The
+// process is restarted with the current script if the version mismatches, that
+// happens when an old process is left by a rolling deployment.
+const NSProtocolVersion = 2
+
 const nsApp = `
 	const readline = require('readline')
 	const rl = readline.createInterface({
@@ -27,6 +33,13 @@ const nsApp = `
 		historySize: 0,
 		crlfDelay: Infinity
 	})
+	// a service is an async function that takes the input object and returns an
+	// output object, an '{ error }' output is treated as failure. the services can
+	// be registered by the '--node-services' option, for example:
+	//
+	//   // returns the synthetic code of the virtual module like 'virtual:modules',
+	//   // the optional 'loader' is one of 'js', 'jsx', 'ts', 'tsx', 'css'
+	//   exports.virtualModules = async ({ specifier }) => ({ code: '...', loader: 'js' })
 	const services = {
 		test: async input => ({ ...input })
 	}
@@ -60,7 +73,7 @@ const nsApp = `
 	})
 
 	setTimeout(() => {
-		process.stdout.write('READY\n')
+		process.stdout.write('READY:v%d\n')
 	}, 0)
 `
 
@@ -71,6 +84,10 @@ type NSTask struct {
 }
 
 var nsInvokeIndex uint32 = 0
+
+// nsHealthy is set to 1 when the node services process is ready, and reset to 0
+// when the process exits
+var nsHealthy int32 = 0
 var nsChannel = make(chan *NSTask, 1000)
 
 func invokeNodeService(serviceName string, input map[string]interface{}, timeout time.Duration) []byte {
@@ -79,6 +96,10 @@ func invokeNodeService(serviceName string, input map[string]interface{}, timeout
 		input:   input,
 		output:  make(chan []byte, 1),
 	}
+	start := time.Now()
+	defer func() {
+		nsInvokeDuration.Observe(time.Since(start))
+	}()
 	nsChannel <- task
 	if timeout > 0 {
 		select {
@@ -98,12 +119,18 @@ func startNodeServices(wd string, services []string) (err error) {
 
 	// install services
 	if len(services) > 0 {
-		cmd := exec.Command("yarn", append([]string{"add"}, services...)...)
-		cmd.Dir = wd
-		var output []byte
-		output, err = cmd.CombinedOutput()
+		addCmd := "add"
+		if packageManager == "npm" {
+			addCmd = "install"
+		}
+		args := []string{addCmd}
+		if offlineMode {
+			// the services must be in the local cache as well
+			args = append(args, "--offline")
+		}
+		err = pkgManagerRun(packageManager, wd, append(args, services...)...)
 		if err != nil {
-			err = fmt.Errorf("install services: %v %s", err, string(output))
+			err = fmt.Errorf("install services: %v", err)
 			return
 		}
 		data, _ := json.Marshal(services)
@@ -114,7 +141,7 @@ func startNodeServices(wd string, services []string) (err error) {
 	// create ns app js
 	err = ioutil.WriteFile(
 		path.Join(wd, "ns.js"),
-		[]byte(fmt.Sprintf(nsApp, servicesInject)),
+		[]byte(fmt.Sprintf(nsApp, servicesInject, NSProtocolVersion)),
 		0644,
 	)
 	if err != nil {
@@ -152,6 +179,7 @@ func startNodeServices(wd string, services []string) (err error) {
 
 	var tasks sync.Map
 	var ready bool
+	var protocolErr error
 
 	go func() {
 		for {
@@ -187,8 +215,14 @@ func startNodeServices(wd string, services []string) (err error) {
 		scanner := bufio.NewScanner(out)
 		for scanner.Scan() {
 			line := scanner.Bytes()
-			if string(line) == "READY" {
+			if version, ok := parseNSReadyLine(string(line)); ok {
+				if version != NSProtocolVersion {
+					protocolErr = fmt.Errorf("node services protocol v%d mismatches v%d, restarting", version, NSProtocolVersion)
+					cmd.Process.Kill()
+					return
+				}
 				ready = true
+				atomic.StoreInt32(&nsHealthy, 1)
 			} else if len(line) > 8 {
 				invokeId := string(line[:8])
 				v, ok := tasks.Load(invokeId)
@@ -201,8 +235,27 @@ func startNodeServices(wd string, services []string) (err error) {
 
 	// wait the process to exit
 	err = cmd.Wait()
-	if errBuf.Len() > 0 {
+	atomic.StoreInt32(&nsHealthy, 0)
+	if protocolErr != nil {
+		err = protocolErr
+	} else if errBuf.Len() > 0 {
 		err = errors.New(strings.TrimSpace(errBuf.String()))
 	}
 	return
 }
+
+// parseNSReadyLine parses the protocol version of the `READY:v<N>` line, the legacy
+// `READY` line without the version is the protocol v1.
+func parseNSReadyLine(line string) (version int, ok bool) {
+	if line == "READY" {
+		return 1, true
+	}
+	if !strings.HasPrefix(line, "READY:v") {
+		return 0, false
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(line, "READY:v"))
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
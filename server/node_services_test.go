@@ -36,3 +36,18 @@ func TestNodeServices(t *testing.T) {
 	kill(path.Join(testDir, "ns.pid"))
 	time.Sleep(100 * time.Millisecond)
 }
+
+func TestParseNSReadyLine(t *testing.T) {
+	for line, expected := range map[string]int{
+		"READY":                         1,
+		"READY:v2":                      2,
+		"READY:v":                       0,
+		"READY:vx":                      0,
+		"0a1b2c3d{\"error\":\"READY\"}": 0,
+	} {
+		version, ok := parseNSReadyLine(line)
+		if ok != (expected > 0) || version != expected {
+			t.Fatalf("unexpected version of '%s': %d", line, version)
+		}
+	}
+}
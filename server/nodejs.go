@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,12 +11,15 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"esm.sh/pkg/esmmeta"
 	"esm.sh/server/storage"
 
 	"github.com/ije/gox/utils"
@@ -114,6 +118,71 @@ var polyfilledBuiltInNodeModules = map[string]string{
 	"zlib":                "browserify-zlib",
 }
 
+// extraBuiltInModules defines the custom built-in modules of the self-hosted
+// deployments, the modules are resolved to the CDN URLs for all targets.
+var extraBuiltInModules = map[string]string{}
+
+// registerBuiltInModules merges the custom built-in modules (module name to CDN URL)
+// and the custom polyfills (module name to npm package name) into the built-in
+// node modules.
+func registerBuiltInModules(builtIn map[string]string, polyfilled map[string]string) {
+	for name, url := range builtIn {
+		builtInNodeModules[name] = true
+		extraBuiltInModules[name] = url
+	}
+	for name, polyfill := range polyfilled {
+		builtInNodeModules[name] = true
+		polyfilledBuiltInNodeModules[name] = polyfill
+	}
+}
+
+// parseModulesMap parses the modules map option like `a=https://cdn.example.com/a.js,b=b-polyfill`
+func parseModulesMap(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value := utils.SplitByFirstByte(pair, '=')
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if name == "" || value == "" {
+			return nil, fmt.Errorf("invalid modules map '%s'", pair)
+		}
+		m[name] = value
+	}
+	return m, nil
+}
+
+// resolveBuiltInPolyfill returns the polyfill of the built-in node module for the
+// build target: the CDN URL of the custom built-in modules, the module itself for
+// `node`, the deno std module URL for `deno`, otherwise the browser polyfills (an
+// npm package of `polyfilledBuiltInNodeModules` or an embedded polyfill like
+// `node_buffer.js`). It returns empty if not found.
+func resolveBuiltInPolyfill(target string, module string) string {
+	if url, ok := extraBuiltInModules[module]; ok {
+		return url
+	}
+	if target == "node" {
+		return module
+	}
+	// the `Buffer` global is polyfilled by `node_buffer.js` as well
+	if module == "buffer" {
+		return "node_buffer.js"
+	}
+	if target == "deno" && denoStdNodeModules[module] {
+		return fmt.Sprintf("https://deno.land/std@%s/node/%s.ts", getDenoStdVersion(), module)
+	}
+	if polyfill, ok := polyfilledBuiltInNodeModules[module]; ok {
+		return polyfill
+	}
+	if _, err := embedFS.ReadFile(fmt.Sprintf("server/embed/polyfills/node_%s.js", module)); err == nil {
+		return fmt.Sprintf("node_%s.js", module)
+	}
+	return ""
+}
+
 // status: https://deno.land/std/node
 var denoStdNodeModules = map[string]bool{
 	"assert":          true,
@@ -152,24 +221,37 @@ type NpmPackageRecords struct {
 }
 
 // NpmPackage defines the package.json of npm
-type NpmPackage struct {
-	Name             string            `json:"name"`
-	Version          string            `json:"version"`
-	Main             string            `json:"main,omitempty"`
-	Module           string            `json:"module,omitempty"`
-	Type             string            `json:"type,omitempty"`
-	Types            string            `json:"types,omitempty"`
-	Typings          string            `json:"typings,omitempty"`
-	Dependencies     map[string]string `json:"dependencies,omitempty"`
-	PeerDependencies map[string]string `json:"peerDependencies,omitempty"`
-	DefinedExports   interface{}       `json:"exports,omitempty"`
+type NpmPackage = esmmeta.NpmPackage
+
+// NpmPeerDependencyMeta defines the entry of the `peerDependenciesMeta` field of package.json
+type NpmPeerDependencyMeta = esmmeta.NpmPeerDependencyMeta
+
+// NpmEngines defines the `engines` field of package.json
+type NpmEngines = esmmeta.NpmEngines
+
+// checkEngines returns a warning if the `engines` of package.json is incompatible
+// with the nodejs version of the server, or the package is not for browsers when
+// building for a browser target. The `browser` engine is considered as unsupported
+// when it's `false`, or it's absent while the package declares other engines.
+func checkEngines(p *NpmPackage, target string) string {
+	var warnings []string
+	if r, ok := p.Engines["node"]; ok && node != nil && !satisfiesVersion(node.version, r) {
+		warnings = append(warnings, fmt.Sprintf("%s@%s requires node %s but the server runs node %s", p.Name, p.Version, r, node.version))
+	}
+	if isBrowserTarget(target) && len(p.Engines) > 0 {
+		if browser, ok := p.Engines["browser"]; !ok || browser == "false" {
+			warnings = append(warnings, fmt.Sprintf("%s@%s may not work in browsers", p.Name, p.Version))
+		}
+	}
+	return strings.Join(warnings, "; ")
 }
 
 // Node defines the nodejs info
 type Node struct {
-	version     string
-	npmRegistry string
-	yarn        string
+	version        string
+	npmRegistry    string
+	packageManager string
+	pmVersion      string
 }
 
 func checkNode(installDir string) (node *Node, err error) {
@@ -212,22 +294,21 @@ CheckNodejs:
 		node.npmRegistry = strings.TrimRight(strings.TrimSpace(string(output)), "/") + "/"
 	}
 
-CheckYarn:
-	output, err = exec.Command("yarn", "-v").CombinedOutput()
-	if err != nil {
-		if errors.Is(err, exec.ErrNotFound) {
-			output, err = exec.Command("npm", "install", "yarn", "-g").CombinedOutput()
-			if err != nil {
-				err = fmt.Errorf("install yarn: %s", strings.TrimSpace(string(output)))
-				return
-			}
-			goto CheckYarn
-		}
-		err = fmt.Errorf("bad yarn version: %s", strings.TrimSpace(string(output)))
+	// detect the installed package manager if it's not specified
+	pms := []string{"yarn", "pnpm", "npm"}
+	if packageManager != "" {
+		pms = []string{packageManager}
 	}
-	if err == nil {
-		node.yarn = strings.TrimSpace(string(output))
+	for _, pm := range pms {
+		output, err = exec.Command(pm, "-v").CombinedOutput()
+		if err == nil {
+			node.packageManager = pm
+			node.pmVersion = strings.TrimSpace(string(output))
+			packageManager = pm
+			return
+		}
 	}
+	err = fmt.Errorf("bad package manager %s: %s", pms[len(pms)-1], strings.TrimSpace(string(output)))
 	return
 }
 
@@ -255,8 +336,8 @@ func getPackageInfo(wd string, name string, version string) (info NpmPackage, su
 	}
 
 	if wd != "" {
-		pkgJsonPath := path.Join(wd, "node_modules", name, "package.json")
-		if fileExists(pkgJsonPath) {
+		pkgJsonPath, e := findPackageJSON(wd, name)
+		if e == nil {
 			err = utils.ParseJSONFile(pkgJsonPath, &info)
 			if err == nil {
 				formPackageJSON = true
@@ -274,6 +355,11 @@ func getPackageInfo(wd string, name string, version string) (info NpmPackage, su
 	if err != nil && err != storage.ErrNotFound && err != storage.ErrExpired {
 		log.Error("db:", err)
 	}
+	if offlineMode {
+		log.Warnf("npm: package info of '%s@%s' is not cached in offline mode", name, version)
+		err = ErrOffline
+		return
+	}
 
 	info, err = cachePackageInfo(name, version)
 	return
@@ -402,62 +488,124 @@ func getNodejsVersion() (version string, major int, err error) {
 }
 
 // see https://nodejs.org/api/packages.html
-func resolveDefinedExports(p *NpmPackage, exports interface{}) {
-	s, ok := exports.(string)
-	if ok {
+// ErrExportBlocked is returned when the submodule is marked as `null` in the
+// `exports` of package.json
+var ErrExportBlocked = errors.New("the submodule is not exported by the package")
+
+// ErrNativeAddon is returned when the package contains native node addons which
+// can't be built as ES module
+var ErrNativeAddon = errors.New("This package contains native Node.js addons which can't be built as ES module.")
+
+// ErrYarnTimeout is returned when the package manager doesn't finish installing the
+// packages in the `yarnTimeout`, usually caused by the slow registry
+var ErrYarnTimeout = errors.New("Installing the package timed out, please try again later.")
+
+// ErrOffline is returned in the offline mode when the package (or the package info)
+// isn't found in the local cache, the cache can be warmed by `esmctl warm-cache`
+var ErrOffline = errors.New("The package is not in the local cache, the server is running in offline mode.")
+
+// the errors of the package managers when the package isn't cached in the offline mode
+var regOfflineCacheMiss = regexp.MustCompile(`in our cache|in offline mode|ENOTCACHED|ERR_PNPM_NO_OFFLINE`)
+
+// the default timeout of installing the packages
+const defaultYarnTimeout = 90 * time.Second
+
+func resolveDefinedExports(p *NpmPackage, exports ExportsMap) error {
+	switch exports.Kind {
+	/**
+	exports: {
+		"./internal": null
+	}
+	*/
+	case ExportsNull:
+		return ErrExportBlocked
+
+	case ExportsPath:
 		if p.Type == "module" && p.Module == "" {
-			p.Module = s
+			p.Module = exports.Path
 		} else if p.Main == "" {
-			p.Main = s
+			p.Main = exports.Path
 		}
-		return
-	}
 
-	m, ok := exports.(map[string]interface{})
-	if ok {
+	/**
+	exports: [
+		{ "import": "./index.mjs" },
+		"./index.js"
+	]
+	*/
+	case ExportsArray:
+		// the array is a fallback chain, the first resolvable value wins
+		for _, v := range exports.Fallbacks {
+			np := &NpmPackage{Type: p.Type}
+			if resolveDefinedExports(np, v) == nil && (np.Module != "" || np.Main != "") {
+				return resolveDefinedExports(p, v)
+			}
+		}
+
+	case ExportsObject:
 		for _, key := range []string{"import", "module", "browser"} {
-			value, ok := m[key]
-			if ok {
-				s, ok := value.(string)
-				if ok && s != "" {
+			if value, ok := exports.Get(key); ok {
+				if s := value.FirstPath(); s != "" {
 					p.Module = s
 					break
 				}
 			}
 		}
 		for _, key := range []string{"require", "node", "default"} {
-			value, ok := m[key]
-			if ok {
-				s, ok := value.(string)
-				if ok && s != "" {
+			if value, ok := exports.Get(key); ok {
+				if s := value.FirstPath(); s != "" {
 					p.Main = s
 					break
 				}
 			}
 		}
-		for key, value := range m {
-			s, ok := value.(string)
-			if ok && s != "" {
-				switch key {
-				case "types":
-					p.Types = s
-				case "typings":
-					p.Typings = s
-				}
-			}
+		if value, ok := exports.Get("types"); ok && value.Kind == ExportsPath && value.Path != "" {
+			p.Types = value.Path
+		}
+		if value, ok := exports.Get("typings"); ok && value.Kind == ExportsPath && value.Path != "" {
+			p.Typings = value.Path
 		}
 	}
+	return nil
 }
 
-func fixNpmPackage(p NpmPackage) *NpmPackage {
+func fixNpmPackage(p NpmPackage, pkgDir string) *NpmPackage {
 	np := &p
 
 	if p.Module == "" && p.DefinedExports != nil {
-		resolveDefinedExports(np, p.DefinedExports)
-		if m, ok := p.DefinedExports.(map[string]interface{}); ok {
-			v, ok := m["."]
-			if ok {
-				resolveDefinedExports(np, v)
+		resolveDefinedExports(np, *p.DefinedExports)
+		if v, ok := p.DefinedExports.Get("."); ok && !v.IsNull() {
+			resolveDefinedExports(np, v)
+		}
+		if pkgDir != "" {
+			p.Module = resolveExportPathOrSelf(pkgDir, p.Module)
+			p.Main = resolveExportPathOrSelf(pkgDir, p.Main)
+		}
+	}
+
+	p.Main = normalizeMain(p.Main, pkgDir)
+
+	// the deprecated `typings` field is used as the `types` if it's the only typing field
+	p.Types = normalizeTypes(p.Types, pkgDir)
+	p.Typings = normalizeTypes(p.Typings, pkgDir)
+	if p.Types == "" {
+		p.Types = p.Typings
+	}
+
+	// the `browser` field is either a string like `"./browser.js"`, or a map like
+	// `{"ws": "isomorphic-ws", "fs": false}`, the `false` mappings are ignored.
+	// see https://github.com/defunctzombie/package-browser-field-spec
+	if len(p.Browser) > 0 {
+		var s string
+		var m map[string]interface{}
+		if json.Unmarshal(p.Browser, &s) == nil {
+			p.BrowserString = normalizeMain(s, pkgDir)
+		} else if json.Unmarshal(p.Browser, &m) == nil {
+			p.BrowserMap = map[string]string{}
+			for name, value := range m {
+				if s, ok := value.(string); ok && s != "" {
+					p.BrowserMap[name] = s
+				}
 			}
 		}
 	}
@@ -469,6 +617,67 @@ func fixNpmPackage(p NpmPackage) *NpmPackage {
 	return np
 }
 
+// resolveExportPathOrSelf returns the resolved path of the `exports` value by
+// `resolveExportPath`, or the value itself if it can't be resolved.
+func resolveExportPathOrSelf(dir string, value string) string {
+	if value == "" {
+		return ""
+	}
+	if resolved, err := resolveExportPath(dir, value); err == nil {
+		return resolved
+	}
+	return value
+}
+
+// normalizeMain normalizes the `main` field of package.json like `./dist\index` to
+// `dist/index.js`, the `.js` extension is added only if the file of the raw value
+// doesn't exist in the package directory.
+func normalizeMain(main string, pkgDir string) string {
+	if main == "" {
+		return ""
+	}
+	main = strings.TrimPrefix(path.Clean(strings.ReplaceAll(main, "\\", "/")), "./")
+	if path.Ext(main) == "" && pkgDir != "" {
+		filename := path.Join(pkgDir, main)
+		if !fileExists(filename) && !dirExists(filename) {
+			main += ".js"
+		}
+	}
+	return main
+}
+
+// normalizeTypes normalizes the `types` field of package.json like `./dist\index.d.ts`
+// to `dist/index.d.ts`. The types pointing to the JS files like `index.js` (a common
+// mistake) are replaced with the sibling declaration files like `index.d.ts`, the
+// declaration file is checked in the package directory if it's specified.
+func normalizeTypes(types string, pkgDir string) string {
+	types = strings.TrimSpace(types)
+	if types == "" {
+		return ""
+	}
+	types = strings.TrimPrefix(path.Clean(strings.ReplaceAll(types, "\\", "/")), "./")
+	ext := path.Ext(types)
+	if ext != ".js" && ext != ".mjs" && ext != ".cjs" && ext != ".jsx" {
+		return types
+	}
+	base := strings.TrimSuffix(types, ext)
+	dtsExt := ".d.ts"
+	if ext == ".mjs" {
+		dtsExt = ".d.mts"
+	} else if ext == ".cjs" {
+		dtsExt = ".d.cts"
+	}
+	if pkgDir == "" {
+		return base + dtsExt
+	}
+	for _, dts := range []string{base + dtsExt, base + ".d.ts"} {
+		if fileExists(path.Join(pkgDir, dts)) {
+			return dts
+		}
+	}
+	return types
+}
+
 func installNodejs(dir string, version string) (err error) {
 	dlURL := fmt.Sprintf("%sv%s/node-v%s-%s-x64.tar.xz", nodejsDistURL, version, version, runtime.GOOS)
 	log.Debugf("downloading %s", dlURL)
@@ -508,6 +717,100 @@ func installNodejs(dir string, version string) (err error) {
 	return
 }
 
+// pkgManagerAdd installs the packages in the working directory by the package
+// manager, supports `yarn`, `npm` and `pnpm`.
+func pkgManagerAdd(pm string, wd string, packages ...string) (err error) {
+	switch pm {
+	case "npm":
+		if len(packages) > 0 {
+			start := time.Now()
+			args := []string{
+				"install",
+				"--no-save",
+				"--no-audit",
+				"--no-fund",
+				"--no-package-lock",
+				"--no-bin-links",
+				"--ignore-scripts",
+				"--legacy-peer-deps",
+			}
+			if offlineMode {
+				args = append(args, "--offline")
+			}
+			err = pkgManagerRun(pm, wd, append(args, packages...)...)
+			if err == nil {
+				log.Debug("npm install", strings.Join(packages, " "), "in", time.Now().Sub(start))
+			}
+		}
+	case "pnpm":
+		if len(packages) > 0 {
+			start := time.Now()
+			args := []string{
+				"add",
+				"--ignore-scripts",
+				"--shamefully-hoist", // flat node_modules like yarn/npm
+			}
+			if offlineMode {
+				args = append(args, "--offline")
+			}
+			err = pkgManagerRun(pm, wd, append(args, packages...)...)
+			if err == nil {
+				log.Debug("pnpm add", strings.Join(packages, " "), "in", time.Now().Sub(start))
+			}
+		}
+	default:
+		err = yarnAdd(wd, packages...)
+	}
+	return
+}
+
+// findPackageJSON finds the package.json of the package in the `node_modules`,
+// it checks the flat path first and then the virtual store of pnpm like
+// `node_modules/.pnpm/@babel+core@7.16.0/node_modules/@babel/core/package.json`.
+func findPackageJSON(wd string, pkgName string) (string, error) {
+	packageFile := path.Join(wd, "node_modules", pkgName, "package.json")
+	if fileExists(packageFile) {
+		return packageFile, nil
+	}
+	matches, err := filepath.Glob(path.Join(wd, "node_modules", ".pnpm", strings.Replace(pkgName, "/", "+", 1)+"@*", "node_modules", pkgName, "package.json"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) > 0 {
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("package.json of '%s' not found", pkgName)
+}
+
+// pkgManagerRun runs the package manager command in the working directory
+func pkgManagerRun(pm string, wd string, args ...string) error {
+	ctx, cancel := yarnContext()
+	defer cancel()
+	cmd := exec.CommandContext(ctx, pm, args...)
+	cmd.Dir = wd
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrYarnTimeout
+		}
+		if offlineMode && regOfflineCacheMiss.Match(output) {
+			log.Warnf("%s %s: %s", pm, strings.Join(args, " "), string(output))
+			return ErrOffline
+		}
+		return fmt.Errorf("%s %s: %s", pm, strings.Join(args, " "), string(output))
+	}
+	return nil
+}
+
+// yarnContext returns the context to run the package manager with the `yarnTimeout`,
+// the process is killed when the context is done.
+func yarnContext() (context.Context, context.CancelFunc) {
+	if yarnTimeout > 0 {
+		return context.WithTimeout(context.Background(), yarnTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
 func yarnAdd(wd string, packages ...string) (err error) {
 	if len(packages) > 0 {
 		start := time.Now()
@@ -528,10 +831,22 @@ func yarnAdd(wd string, packages ...string) (err error) {
 		if yarnMutex != "" {
 			args = append(args, "--mutex", yarnMutex)
 		}
-		cmd := exec.Command("yarn", append(args, packages...)...)
+		if offlineMode {
+			args = append(args, "--offline")
+		}
+		ctx, cancel := yarnContext()
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "yarn", append(args, packages...)...)
 		cmd.Dir = wd
 		output, err := cmd.CombinedOutput()
 		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrYarnTimeout
+			}
+			if offlineMode && regOfflineCacheMiss.Match(output) {
+				log.Warnf("yarn add %s: %s", strings.Join(packages, " "), string(output))
+				return ErrOffline
+			}
 			return fmt.Errorf("yarn add %s: %s", strings.Join(packages, " "), string(output))
 		}
 		log.Debug("yarn add", strings.Join(packages, " "), "in", time.Now().Sub(start))
@@ -539,8 +854,33 @@ func yarnAdd(wd string, packages ...string) (err error) {
 	return
 }
 
+var regTransientYarnError = regexp.MustCompile(`ETIMEDOUT|ESOCKETTIMEDOUT|ECONNRESET|ECONNREFUSED|EAI_AGAIN|network connection|"5\d\d `)
+
+// retryYarnAdd calls `pkgManagerAdd` with exponential backoff when it fails due to
+// the registry timeouts or 5xx errors, other errors like 404(package not found)
+// or 403(auth) are returned immediately. The optional flags are passed to the
+// package manager before the spec.
+func retryYarnAdd(wd string, spec string, maxAttempts int, baseDelay time.Duration, flags ...string) (err error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		err = pkgManagerAdd(packageManager, wd, append(append([]string{}, flags...), spec)...)
+		if err == nil || !regTransientYarnError.MatchString(err.Error()) || attempt == maxAttempts-1 {
+			return
+		}
+		delay := baseDelay * time.Duration(1<<attempt)
+		if delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+		log.Warnf("%s add %s failed (attempt %d/%d), retry in %v: %v", packageManager, spec, attempt+1, maxAttempts, delay, err)
+		time.Sleep(delay)
+	}
+	return
+}
+
 // provided by @jimisaacs
 func toTypesPackageName(pkgName string) string {
+	if strings.HasPrefix(pkgName, "@types/") {
+		return pkgName
+	}
 	if strings.HasPrefix(pkgName, "@") {
 		pkgName = strings.Replace(pkgName[1:], "/", "__", 1)
 	}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryYarnAdd(t *testing.T) {
+	if _, err := exec.LookPath("yarn"); err != nil {
+		t.Skip("yarn not found")
+	}
+
+	pkgJSON := []byte(`{"name":"esm-retry-test","version":"1.0.0","main":"index.js"}`)
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "package/package.json", Mode: 0644, Size: int64(len(pkgJSON))})
+	tw.Write(pkgJSON)
+	tw.Close()
+	gw.Close()
+	tarball := buf.Bytes()
+	shasum := sha1.Sum(tarball)
+
+	// a mock registry that fails twice then succeeds
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			http.Error(w, "Service Unavailable", 503)
+			return
+		}
+		switch r.URL.Path {
+		case "/esm-retry-test":
+			fmt.Fprintf(w, `{"name":"esm-retry-test","dist-tags":{"latest":"1.0.0"},"versions":{"1.0.0":{"name":"esm-retry-test","version":"1.0.0","main":"index.js","dist":{"tarball":"http://%s/esm-retry-test/-/esm-retry-test-1.0.0.tgz","shasum":"%s"}}}}`, r.Host, hex.EncodeToString(shasum[:]))
+		case "/esm-retry-test/-/esm-retry-test-1.0.0.tgz":
+			w.Write(tarball)
+		default:
+			http.Error(w, "Not Found", 404)
+		}
+	}))
+	defer ts.Close()
+
+	wd := t.TempDir()
+	cacheDir := t.TempDir()
+	yarnCacheDir := os.Getenv("YARN_CACHE_DIR")
+	os.Setenv("YARN_CACHE_DIR", cacheDir)
+	defer os.Setenv("YARN_CACHE_DIR", yarnCacheDir)
+
+	err := ioutil.WriteFile(path.Join(wd, ".yarnrc"), []byte(fmt.Sprintf("registry \"%s\"\n", ts.URL)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = retryYarnAdd(wd, "esm-retry-test@1.0.0", 3, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(path.Join(wd, "node_modules", "esm-retry-test", "package.json")) {
+		t.Fatal("package not installed")
+	}
+
+	// 404 should not be retried
+	atomic.StoreInt32(&requests, 10)
+	err = retryYarnAdd(wd, "esm-retry-test-not-found@1.0.0", 3, 10*time.Second)
+	if err == nil {
+		t.Fatal("should fail with 404")
+	}
+}
+
+func TestPkgManagerAdd(t *testing.T) {
+	binDir := t.TempDir()
+	for _, pm := range []string{"yarn", "npm"} {
+		// a fake CLI that records the arguments
+		script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\n", path.Join(binDir, pm+".args"))
+		err := ioutil.WriteFile(path.Join(binDir, pm), []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	PATH := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s%c%s", binDir, os.PathListSeparator, PATH))
+	defer os.Setenv("PATH", PATH)
+
+	wd := t.TempDir()
+	for pm, cmd := range map[string]string{"yarn": "add", "npm": "install"} {
+		err := pkgManagerAdd(pm, wd, "react@17.0.2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path.Join(binDir, pm+".args"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := strings.Fields(string(data))
+		if len(args) < 2 || args[0] != cmd || args[len(args)-1] != "react@17.0.2" {
+			t.Fatalf("unexpected %s args: %v", pm, args)
+		}
+	}
+
+	err := pkgManagerRun("npm", wd, "install", "esm-node-services")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path.Join(binDir, "npm.args"))
+	if strings.TrimSpace(string(data)) != "install esm-node-services" {
+		t.Fatalf("unexpected npm args: %s", data)
+	}
+
+	pm := packageManager
+	packageManager = "yarn"
+	defer func() { packageManager = pm }()
+	task := &BuildTask{IgnoreLocks: true}
+	err = retryYarnAdd(wd, "react@17.0.2", 1, 0, task.installFlags()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(path.Join(binDir, "yarn.args"))
+	args := strings.Fields(string(data))
+	if len(args) < 2 || args[len(args)-2] != "--no-lockfile" || args[len(args)-1] != "react@17.0.2" {
+		t.Fatalf("unexpected yarn args: %v", args)
+	}
+}
+
+func TestToTypesPackageName(t *testing.T) {
+	for name, typesName := range map[string]string{
+		"some-pkg":            "@types/some-pkg",
+		"@babel/core":         "@types/babel__core",
+		"@myorg/my-package":   "@types/myorg__my-package",
+		"@my-org/my-package":  "@types/my-org__my-package",
+		"@babel/core/lib/api": "@types/babel__core/lib/api",
+		"@types/node":         "@types/node",
+	} {
+		if ret := toTypesPackageName(name); ret != typesName {
+			t.Fatalf("toTypesPackageName(%s) should be %s, but got %s", name, typesName, ret)
+		}
+	}
+}
+
+func TestFixNpmPackageMain(t *testing.T) {
+	pkgDir := path.Join(os.TempDir(), "esm-fix-main-test")
+	os.RemoveAll(pkgDir)
+	defer os.RemoveAll(pkgDir)
+	ensureDir(path.Join(pkgDir, "lib"))
+	ensureDir(path.Join(pkgDir, "bin"))
+	ioutil.WriteFile(path.Join(pkgDir, "bin", "cli"), []byte{}, 0644)
+
+	for main, expected := range map[string]string{
+		"":                  "",
+		"index.js":          "index.js",
+		"./dist/index":      "dist/index.js",
+		"dist\\index.js":    "dist/index.js",
+		"./dist/./index.js": "dist/index.js",
+		".\\dist\\index":    "dist/index.js",
+		"dist//index.mjs":   "dist/index.mjs",
+		"./lib":             "lib",
+		"./bin/cli":         "bin/cli",
+	} {
+		np := fixNpmPackage(NpmPackage{Main: main}, pkgDir)
+		if np.Main != expected {
+			t.Fatalf("the main '%s' should be normalized to '%s', but got '%s'", main, expected, np.Main)
+		}
+	}
+}
+
+func TestFixNpmPackageTypes(t *testing.T) {
+	pkgDir := t.TempDir()
+	ensureDir(path.Join(pkgDir, "dist"))
+	ensureDir(path.Join(pkgDir, "esm"))
+	ioutil.WriteFile(path.Join(pkgDir, "dist", "index.d.ts"), []byte{}, 0644)
+	ioutil.WriteFile(path.Join(pkgDir, "esm", "index.d.ts"), []byte{}, 0644)
+
+	for _, c := range []struct {
+		types    string
+		typings  string
+		expected string
+	}{
+		{"", "", ""},
+		{"index.d.ts", "", "index.d.ts"},
+		{"./index.d.ts", "", "index.d.ts"},
+		{" ./index.d.ts ", "", "index.d.ts"},
+		{".\\dist\\index.d.ts", "", "dist/index.d.ts"},
+		{"./dist//./index.d.ts", "", "dist/index.d.ts"},
+		// the deprecated `typings` field
+		{"", "./dist/index.d.ts", "dist/index.d.ts"},
+		{"types.d.ts", "./dist/index.d.ts", "types.d.ts"},
+		// the types pointing to the JS files
+		{"./dist/index.js", "", "dist/index.d.ts"},
+		{"", "dist/index.js", "dist/index.d.ts"},
+		{"./esm/index.mjs", "", "esm/index.d.ts"},
+		{"./lib/index.js", "", "lib/index.js"},
+		// the extensionless path and the TS source are resolved by `toTypesPath`
+		{"./dist/index", "", "dist/index"},
+		{"./src/index.ts", "", "src/index.ts"},
+	} {
+		np := fixNpmPackage(NpmPackage{Types: c.types, Typings: c.typings}, pkgDir)
+		if np.Types != c.expected {
+			t.Fatalf("the types(%q, %q) should be normalized to '%s', but got '%s'", c.types, c.typings, c.expected, np.Types)
+		}
+	}
+
+	// the declaration files can't be checked without the package directory
+	for types, expected := range map[string]string{
+		"./index.js":  "index.d.ts",
+		"./index.mjs": "index.d.mts",
+		"./index.cjs": "index.d.cts",
+	} {
+		if np := fixNpmPackage(NpmPackage{Types: types}, ""); np.Types != expected {
+			t.Fatalf("the types '%s' should be normalized to '%s', but got '%s'", types, expected, np.Types)
+		}
+	}
+}
+
+func TestFixNpmPackageBrowser(t *testing.T) {
+	var p NpmPackage
+	err := json.Unmarshal([]byte(`{"main":"index.js","browser":"./browser.js"}`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np := fixNpmPackage(p, ""); np.BrowserString != "browser.js" || np.BrowserMap != nil {
+		t.Fatalf("unexpected browser string: '%s' %v", np.BrowserString, np.BrowserMap)
+	}
+
+	p = NpmPackage{}
+	err = json.Unmarshal([]byte(`{"main":"index.js","browser":{"ws":"isomorphic-ws","fs":false,"./lib/node.js":"./lib/browser.js"}}`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np := fixNpmPackage(p, "")
+	if np.BrowserString != "" || len(np.BrowserMap) != 2 || np.BrowserMap["ws"] != "isomorphic-ws" || np.BrowserMap["./lib/node.js"] != "./lib/browser.js" {
+		t.Fatalf("unexpected browser map: %v", np.BrowserMap)
+	}
+}
+
+func TestExtraBuiltInModules(t *testing.T) {
+	builtIn, err := parseModulesMap("my-global=https://cdn.example.com/my-global.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	polyfilled, err := parseModulesMap(" my-fs = my-fs-polyfill ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseModulesMap("my-global"); err == nil {
+		t.Fatal("the modules map without value should be invalid")
+	}
+	registerBuiltInModules(builtIn, polyfilled)
+	defer func() {
+		delete(builtInNodeModules, "my-global")
+		delete(builtInNodeModules, "my-fs")
+		delete(extraBuiltInModules, "my-global")
+		delete(polyfilledBuiltInNodeModules, "my-fs")
+	}()
+
+	if !builtInNodeModules["my-global"] || !builtInNodeModules["my-fs"] {
+		t.Fatal("the extra modules should be built-in")
+	}
+	for _, target := range []string{"es2021", "deno", "node"} {
+		if url := resolveBuiltInPolyfill(target, "my-global"); url != "https://cdn.example.com/my-global.js" {
+			t.Fatalf("unexpected url of 'my-global' for %s: %s", target, url)
+		}
+	}
+	if polyfill := resolveBuiltInPolyfill("es2021", "my-fs"); polyfill != "my-fs-polyfill" {
+		t.Fatalf("unexpected polyfill of 'my-fs': %s", polyfill)
+	}
+}
+
+func TestYarnTimeout(t *testing.T) {
+	binDir := t.TempDir()
+	// a fake yarn that hangs like waiting for the registry
+	err := ioutil.WriteFile(path.Join(binDir, "yarn"), []byte("#!/bin/sh\nexec sleep 10\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	PATH := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s%c%s", binDir, os.PathListSeparator, PATH))
+	defer os.Setenv("PATH", PATH)
+
+	timeout := yarnTimeout
+	yarnTimeout = 100 * time.Millisecond
+	defer func() { yarnTimeout = timeout }()
+
+	start := time.Now()
+	err = yarnAdd(t.TempDir(), "react@17.0.2")
+	if err != ErrYarnTimeout {
+		t.Fatalf("expected ErrYarnTimeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("the yarn process is not killed")
+	}
+}
+
+func TestYarnOffline(t *testing.T) {
+	binDir := t.TempDir()
+	// a fake yarn that fails like the package isn't in the offline cache unless
+	// the `--offline` flag is missing
+	err := ioutil.WriteFile(path.Join(binDir, "yarn"), []byte(`#!/bin/sh
+case "$*" in
+  *--offline*) echo 'error Couldn'"'"'t find any versions for "react" that matches "17.0.2" in our cache'; exit 1;;
+esac
+exit 0
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	PATH := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s%c%s", binDir, os.PathListSeparator, PATH))
+	defer os.Setenv("PATH", PATH)
+
+	err = yarnAdd(t.TempDir(), "react@17.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offlineMode = true
+	defer func() { offlineMode = false }()
+
+	err = yarnAdd(t.TempDir(), "react@17.0.2")
+	if err != ErrOffline {
+		t.Fatalf("expected ErrOffline, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+const npmsAPI = "https://api.npms.io/v2/package/"
+
+// NpmsPackage defines the subset of the package analysis of the npms.io API
+type NpmsPackage struct {
+	Score struct {
+		Final  float64 `json:"final"`
+		Detail struct {
+			Quality     float64 `json:"quality"`
+			Popularity  float64 `json:"popularity"`
+			Maintenance float64 `json:"maintenance"`
+		} `json:"detail"`
+	} `json:"score"`
+	Collected struct {
+		Metadata struct {
+			Version string `json:"version"`
+		} `json:"metadata"`
+		Npm struct {
+			Downloads []struct {
+				From  string `json:"from"`
+				To    string `json:"to"`
+				Count int64  `json:"count"`
+			} `json:"downloads"`
+		} `json:"npm"`
+	} `json:"collected"`
+}
+
+// NpmScore is the response of the `+npm-score` API
+type NpmScore struct {
+	Name            string                 `json:"name"`
+	Version         string                 `json:"version"`
+	AnalyzedVersion string                 `json:"analyzedVersion"`
+	Score           map[string]float64     `json:"score"`
+	Collected       map[string]interface{} `json:"collected"`
+}
+
+// serveNpmScore serves the `+npm-score` requests, it responds the quality, popularity
+// and maintenance scores of the package by the npms.io API. The scores are analyzed
+// for the latest version of the package, the `analyzedVersion` tells if the scores
+// are stale for the requested version. The response is cached for 1 hour.
+func serveNpmScore(ctx *rex.Context, pkg *Pkg) interface{} {
+	if host, _, _ := splitGitPkgName(pkg.Name); host != "" {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("%s is not a npm package", pkg.Name)})
+	}
+
+	key := fmt.Sprintf("npm-score:%s@%s", pkg.Name, pkg.Version)
+	data, err := cache.Get(key)
+	if err != nil {
+		var ret NpmsPackage
+		err = fetchJSON(npmsAPI+url.PathEscape(pkg.Name), &ret)
+		if err != nil {
+			if strings.Contains(err.Error(), ": 404 ") {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("npms: score of %s not found", pkg.Name)})
+			}
+			return throwAPIError(ctx, 502, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		data = utils.MustEncodeJSON(toNpmScore(pkg, ret))
+		cache.Set(key, data, time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=3600")
+	return data
+}
+
+func toNpmScore(pkg *Pkg, ret NpmsPackage) NpmScore {
+	return NpmScore{
+		Name:            pkg.Name,
+		Version:         pkg.Version,
+		AnalyzedVersion: ret.Collected.Metadata.Version,
+		Score: map[string]float64{
+			"final":       ret.Score.Final,
+			"quality":     ret.Score.Detail.Quality,
+			"popularity":  ret.Score.Detail.Popularity,
+			"maintenance": ret.Score.Detail.Maintenance,
+		},
+		Collected: map[string]interface{}{
+			"npm": map[string]interface{}{
+				"downloads": ret.Collected.Npm.Downloads,
+			},
+		},
+	}
+}
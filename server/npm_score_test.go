@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToNpmScore(t *testing.T) {
+	var ret NpmsPackage
+	err := json.Unmarshal([]byte(`{
+		"analyzedAt": "2021-11-20T08:00:00.000Z",
+		"collected": {
+			"metadata": {"name": "react", "version": "17.0.2"},
+			"npm": {"downloads": [{"from": "2021-11-19T00:00:00.000Z", "to": "2021-11-20T00:00:00.000Z", "count": 1000}], "starsCount": 100}
+		},
+		"score": {"final": 0.85, "detail": {"quality": 0.78, "popularity": 0.92, "maintenance": 0.84}}
+	}`), &ret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	score := toNpmScore(&Pkg{Name: "react", Version: "17.0.1"}, ret)
+	if score.Version != "17.0.1" || score.AnalyzedVersion != "17.0.2" {
+		t.Fatalf("unexpected versions: %s %s", score.Version, score.AnalyzedVersion)
+	}
+	if score.Score["final"] != 0.85 || score.Score["quality"] != 0.78 || score.Score["popularity"] != 0.92 || score.Score["maintenance"] != 0.84 {
+		t.Fatalf("unexpected score: %v", score.Score)
+	}
+	data, _ := json.Marshal(score.Collected)
+	if string(data) != `{"npm":{"downloads":[{"from":"2021-11-19T00:00:00.000Z","to":"2021-11-20T00:00:00.000Z","count":1000}]}}` {
+		t.Fatalf("unexpected collected: %s", data)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// NpmDist defines the `dist` of the version metadata of npm
+type NpmDist struct {
+	Tarball   string `json:"tarball"`
+	Integrity string `json:"integrity"`
+	Shasum    string `json:"shasum"`
+}
+
+// serveNpmTarballURL serves the `+npm-tarball-url` requests, it responds the tarball URL
+// and the checksums of the exact version from the npm registry for the reproducible
+// builds and the SBOM tools. The tarball of a published version never changes, so the
+// response is cached permanently.
+func serveNpmTarballURL(ctx *rex.Context, pkg *Pkg) interface{} {
+	if host, _, _ := splitGitPkgName(pkg.Name); host != "" {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("%s is not a npm package", pkg.Name)})
+	}
+
+	key := fmt.Sprintf("npm-dist:%s@%s", pkg.Name, pkg.Version)
+	data, err := cache.Get(key)
+	if err != nil {
+		var info struct {
+			Dist NpmDist `json:"dist"`
+		}
+		err = fetchJSON(fmt.Sprintf("%s%s/%s", node.npmRegistry, pkg.Name, pkg.Version), &info)
+		if err != nil {
+			if strings.Contains(err.Error(), ": 404 ") {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("npm: %s@%s not found", pkg.Name, pkg.Version)})
+			}
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		if info.Dist.Tarball == "" {
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("npm: tarball of %s@%s not found", pkg.Name, pkg.Version)})
+		}
+		if info.Dist.Integrity == "" {
+			info.Dist.Integrity = sha1Integrity(info.Dist.Shasum)
+		}
+		data = utils.MustEncodeJSON(info.Dist)
+		cache.Set(key, data, 0)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	return data
+}
+
+// sha1Integrity converts the hex `shasum` to the subresource integrity like yarn does
+// for the legacy packages that are published without the `integrity`
+func sha1Integrity(shasum string) string {
+	sum, err := hex.DecodeString(shasum)
+	if err != nil || len(sum) == 0 {
+		return ""
+	}
+	return "sha1-" + base64.StdEncoding.EncodeToString(sum)
+}
@@ -0,0 +1,14 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestSha1Integrity(t *testing.T) {
+	if integrity := sha1Integrity("da39a3ee5e6b4b0d3255bfef95601890afd80709"); integrity != "sha1-2jmj7l5rSw0yVb/vlWAYkK/YBwk=" {
+		t.Fatalf("unexpected integrity: %s", integrity)
+	}
+	if integrity := sha1Integrity("not-a-hex"); integrity != "" {
+		t.Fatalf("unexpected integrity: %s", integrity)
+	}
+}
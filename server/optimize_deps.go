@@ -0,0 +1,52 @@
+package server
+
+// optimizeDeps queues the bundle builds of the dependencies of the package, the
+// peer dependencies of the dependencies are queued recursively since they are
+// not bundled. It returns a map of the dependency name to the build id.
+func optimizeDeps(task *BuildTask, esm *ESM) (deps map[string]string, err error) {
+	deps = map[string]string{}
+	err = optimizeDependencies(task, esm.Dependencies, esm.PeerDependencies, deps)
+	return
+}
+
+func optimizeDependencies(task *BuildTask, dependencies map[string]string, peerDependencies map[string]string, deps map[string]string) error {
+	for _, m := range []map[string]string{dependencies, peerDependencies} {
+		for name, version := range m {
+			if _, ok := deps[name]; ok || builtInNodeModules[name] {
+				continue
+			}
+			var p NpmPackage
+			var err error
+			if dep, ok := task.Deps.Get(name); ok {
+				p, _, _, err = getPackageInfo("", dep.Name, dep.Version)
+			} else {
+				p, _, _, err = getPackageInfo("", name, version)
+			}
+			if err != nil {
+				return err
+			}
+			t := &BuildTask{
+				BuildVersion: task.BuildVersion,
+				Pkg: Pkg{
+					Name:    p.Name,
+					Version: p.Version,
+				},
+				Alias:      task.Alias,
+				Deps:       task.Deps,
+				Target:     task.Target,
+				DevMode:    task.DevMode,
+				BundleMode: true,
+				stage:      "init",
+			}
+			deps[name] = t.ID()
+			if _, err := findESM(t.ID()); err != nil {
+				buildQueue.tryAdd(t)
+			}
+			err = optimizeDependencies(task, nil, p.PeerDependencies, deps)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
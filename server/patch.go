@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/rex"
+)
+
+const maxPatchSize = 1 << 20 // 1MB
+
+// isAdminRequest checks the admin token in the `Authorization` header
+func isAdminRequest(ctx *rex.Context) bool {
+	token := strings.TrimSpace(strings.TrimPrefix(ctx.R.Header.Get("Authorization"), "Bearer "))
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// checkAdminRequest returns the error response if the request can't access the admin
+// APIs, which are only available in dev mode or with the admin token. The APIs are
+// hidden (404) if the server has no admin token.
+func checkAdminRequest(ctx *rex.Context, devMode bool) interface{} {
+	if devMode {
+		return nil
+	}
+	if adminToken == "" {
+		return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "not found"})
+	}
+	if !isAdminRequest(ctx) {
+		ctx.SetHeader("WWW-Authenticate", "Bearer")
+		return throwAPIError(ctx, http.StatusUnauthorized, APIError{Code: errCodeUnauthorized, Message: "Invalid admin token"})
+	}
+	return nil
+}
+
+// servePatchBuild serves the `POST +patch` requests, it builds the package with the
+// uploaded files (`multipart/form-data` keyed by the paths like `dist/index.js`)
+// overlaid on the installed package. The version of the build is suffixed with the
+// hash of the files like `1.0.0-patch.<hash>` to avoid overriding the builds of the
+// published packages.
+func servePatchBuild(ctx *rex.Context, pkg *Pkg) interface{} {
+	if ctx.R.Method != "POST" {
+		ctx.SetHeader("Allow", "POST")
+		return throwAPIError(ctx, http.StatusMethodNotAllowed, APIError{Code: errCodeMethodNotAllowed, Message: "Method Not Allowed"})
+	}
+
+	// the body is limited to `maxRequestBodySize` by the `limitRequestBody` middleware
+	err := ctx.R.ParseMultipartForm(maxPatchSize)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			return throwPayloadTooLargeError(ctx, maxRequestBodySize)
+		}
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Invalid multipart form", Detail: err.Error()})
+	}
+	files, err := readPatchFiles(ctx.R)
+	if err != nil {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: err.Error()})
+	}
+
+	wd := tempDir(fmt.Sprintf("esm-build-patch-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	defer os.RemoveAll(wd)
+
+	err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version), 3, time.Second)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	pkgDir := path.Join(wd, "node_modules", pkg.Name)
+	for name, data := range files {
+		filename := path.Join(pkgDir, name)
+		err = ensureDir(path.Dir(filename))
+		if err == nil {
+			err = ioutil.WriteFile(filename, data, 0644)
+		}
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+	}
+
+	target := normalizeTarget(strings.ToLower(ctx.Form.Value("target")))
+	if !isValidTarget(target) {
+		target = getTargetByUA(ctx.R.UserAgent())
+	}
+	task := &BuildTask{
+		BuildVersion: VERSION,
+		Pkg: Pkg{
+			Name:      pkg.Name,
+			Version:   fmt.Sprintf("%s-patch.%s", pkg.Version, hashPatchFiles(files)),
+			Submodule: strings.Trim(ctx.Form.Value("submodule"), "/"),
+		},
+		Target:     target,
+		BundleMode: !ctx.Form.IsNil("bundle"),
+		DevMode:    !ctx.Form.IsNil("dev"),
+		wd:         wd,
+		stage:      "init",
+	}
+	esm, err := task.build(newStringSet())
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeBuildFailed, Message: err.Error()})
+	}
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, `/* esm.sh - %v */%s`, task.Pkg, "\n")
+	fmt.Fprintf(buf, `export * from "/%s";%s`, task.ID(), "\n")
+	if esm.ExportDefault {
+		fmt.Fprintf(buf, `export { default } from "/%s";%s`, task.ID(), "\n")
+	}
+	if esm.Dts != "" {
+		ctx.SetHeader("X-TypeScript-Types", "/"+strings.TrimPrefix(esm.Dts, "/"))
+		ctx.SetHeader("Access-Control-Expose-Headers", "X-TypeScript-Types")
+	}
+	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+	setJSContentType(ctx)
+	return buf
+}
+
+// readPatchFiles reads the uploaded files of the multipart form, the form keys are
+// the paths relative to the package directory.
+func readPatchFiles(r *http.Request) (files map[string][]byte, err error) {
+	files = map[string][]byte{}
+	for key, headers := range r.MultipartForm.File {
+		name := path.Clean(strings.TrimPrefix(key, "./"))
+		if path.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			err = fmt.Errorf("Invalid patch file path '%s'", key)
+			return
+		}
+		if len(headers) != 1 {
+			err = fmt.Errorf("Duplicate patch file '%s'", key)
+			return
+		}
+		f, e := headers[0].Open()
+		if e != nil {
+			err = e
+			return
+		}
+		data, e := ioutil.ReadAll(f)
+		f.Close()
+		if e != nil {
+			err = e
+			return
+		}
+		files[name] = data
+	}
+	if len(files) == 0 {
+		err = fmt.Errorf("Missing the patch files")
+	}
+	return
+}
+
+// hashPatchFiles returns the short hash of the patch files
+func hashPatchFiles(files map[string][]byte) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hasher := sha1.New()
+	for _, name := range names {
+		fmt.Fprintf(hasher, "%s\n%d\n", name, len(files[name]))
+		hasher.Write(files[name])
+	}
+	return hex.EncodeToString(hasher.Sum(nil))[:8]
+}
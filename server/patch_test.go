@@ -0,0 +1,48 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadPatchFiles(t *testing.T) {
+	for files, ok := range map[[2]string]bool{
+		{"dist/index.js", "export default 1"}: true,
+		{"./lib/../index.js", "export {}"}:    true,
+		{"../escape.js", "alert(1)"}:          false,
+		{"/etc/passwd", "root"}:               false,
+		{".", ""}:                             false,
+	} {
+		body := bytes.NewBuffer(nil)
+		w := multipart.NewWriter(body)
+		fw, err := w.CreateFormFile(files[0], "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(files[1]))
+		w.Close()
+
+		r := httptest.NewRequest("POST", "/v57/react@17.0.2/+patch", body)
+		r.Header.Set("Content-Type", w.FormDataContentType())
+		err = r.ParseMultipartForm(maxPatchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patched, err := readPatchFiles(r)
+		if ok != (err == nil) {
+			t.Fatalf("readPatchFiles(%s) should be ok(%v), but got error: %v", files[0], ok, err)
+		}
+		if ok && len(patched) != 1 {
+			t.Fatalf("unexpected patch files: %v", patched)
+		}
+	}
+
+	a := hashPatchFiles(map[string][]byte{"a.js": []byte("1"), "b.js": []byte("2")})
+	b := hashPatchFiles(map[string][]byte{"b.js": []byte("2"), "a.js": []byte("1")})
+	c := hashPatchFiles(map[string][]byte{"a.js": []byte("12"), "b.js": []byte("")})
+	if a != b || a == c || len(a) != 8 {
+		t.Fatalf("unexpected hashes: %s, %s, %s", a, b, c)
+	}
+}
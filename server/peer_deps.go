@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+type peerDependency struct {
+	Range    string `json:"range"`
+	Required bool   `json:"required"`
+}
+
+// servePeerDeps serves the peer dependencies of the package with the semver ranges,
+// the peers marked as `optional` in the `peerDependenciesMeta` are not required.
+// The result is cached for 24 hours.
+func servePeerDeps(ctx *rex.Context, pkg *Pkg) interface{} {
+	key := fmt.Sprintf("peer-deps:%s@%s", pkg.Name, pkg.Version)
+	data, err := cache.Get(key)
+	if err != nil {
+		info, _, _, err := getPackageInfo("", pkg.Name, pkg.Version)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		data = utils.MustEncodeJSON(map[string]interface{}{
+			"peerDependencies": getPeerDependencies(&info),
+		})
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
+
+// getPeerDependencies returns the peer dependencies of the package, the peers only
+// declared in the `peerDependenciesMeta` accept any version.
+func getPeerDependencies(p *NpmPackage) map[string]peerDependency {
+	peers := map[string]peerDependency{}
+	for name, versionRange := range p.PeerDependencies {
+		peers[name] = peerDependency{
+			Range:    versionRange,
+			Required: !p.PeerDependenciesMeta[name].Optional,
+		}
+	}
+	for name, meta := range p.PeerDependenciesMeta {
+		if _, ok := peers[name]; !ok {
+			peers[name] = peerDependency{Range: "*", Required: !meta.Optional}
+		}
+	}
+	return peers
+}
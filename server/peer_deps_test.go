@@ -0,0 +1,29 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGetPeerDependencies(t *testing.T) {
+	var p NpmPackage
+	err := json.Unmarshal([]byte(`{
+		"name": "some-plugin",
+		"version": "1.0.0",
+		"peerDependencies": { "react": ">=16", "react-dom": ">=16" },
+		"peerDependenciesMeta": { "react-dom": { "optional": true }, "typescript": { "optional": true } }
+	}`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := getPeerDependencies(&p)
+	expected := map[string]peerDependency{
+		"react":      {Range: ">=16", Required: true},
+		"react-dom":  {Range: ">=16", Required: false},
+		"typescript": {Range: "*", Required: false},
+	}
+	if !reflect.DeepEqual(peers, expected) {
+		t.Fatalf("unexpected peer dependencies: %v", peers)
+	}
+}
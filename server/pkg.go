@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"path"
 	"strings"
 
 	"github.com/ije/gox/utils"
@@ -13,54 +14,159 @@ type Pkg struct {
 	Submodule string `json:"submodule"`
 }
 
-func parsePkg(pathname string) (*Pkg, error) {
-	a := strings.Split(strings.Trim(pathname, "/"), "/")
-	for i, s := range a {
-		a[i] = strings.TrimSpace(s)
+// ParsePkgError is returned by parsePkg if the package specifier is malformed
+type ParsePkgError struct {
+	Specifier string
+	Reason    string
+}
+
+func (e *ParsePkgError) Error() string {
+	return e.Reason
+}
+
+// splitPkgSpecifier splits the package specifier like `@scope/name@version/submodule`
+// into the name, version and submodule without fetching the package info. The git
+// specifiers like `github:user/repo#ref` are normalized to `github.com/user/repo`
+// with the ref as the version.
+func splitPkgSpecifier(spec string) (name string, version string, submodule string, err error) {
+	if host, user, repo, ref, sub, ok := parseGitSpecifier(spec); ok {
+		return fmt.Sprintf("%s/%s/%s", host, user, repo), ref, strings.Trim(sub, "/"), nil
+	}
+
+	var a []string
+	for _, s := range strings.Split(spec, "/") {
+		// drop the empty segments of the path like `react//index.js`
+		if s = strings.TrimSpace(s); s != "" {
+			a = append(a, s)
+		}
+	}
+	if len(a) == 0 {
+		err = &ParsePkgError{spec, "invalid path"}
+		return
 	}
 	scope := ""
 	packageName := a[0]
-	submodule := strings.Join(a[1:], "/")
+	submodule = strings.Join(a[1:], "/")
 	if strings.HasPrefix(packageName, "@") && len(a) > 1 {
 		scope = packageName[1:]
 		packageName = a[1]
 		submodule = strings.Join(a[2:], "/")
 	}
 
-	// ref https://github.com/npm/validate-npm-package-name
-	if scope != "" && (len(scope) > 214 || !npmNaming.Is(scope)) {
-		return nil, fmt.Errorf("invalid scope '%s'", scope)
+	name, version = utils.SplitByLastByte(packageName, '@')
+	if scope != "" {
+		name = fmt.Sprintf("@%s/%s", scope, name)
 	}
-
-	name, version := utils.SplitByLastByte(packageName, '@')
-	if name != "" && (len(name) > 214 || !npmNaming.Is(name)) {
-		return nil, fmt.Errorf("invalid package name '%s'", name)
+	if e := validatePkgName(name); e != nil {
+		err = &ParsePkgError{spec, e.Error()}
 	}
+	return
+}
 
-	if scope != "" {
-		name = fmt.Sprintf("@%s/%s", scope, name)
+// validatePkgName checks the package name by the npm naming rules, the name is
+// passed to the package manager to install the package, so only the lowercase
+// letters, digits, `.`, `_` and `-` are allowed besides the `@scope/` prefix.
+// ref https://github.com/npm/validate-npm-package-name
+func validatePkgName(name string) error {
+	if len(name) > 214 {
+		return fmt.Errorf("package name '%s' is too long", name)
+	}
+	pkgName := name
+	if strings.HasPrefix(name, "@") {
+		var scope string
+		scope, pkgName = utils.SplitByFirstByte(name[1:], '/')
+		if scope == "" || !npmNaming.Is(scope) {
+			return fmt.Errorf("invalid scope '%s'", scope)
+		}
+	} else if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+		return fmt.Errorf("invalid package name '%s'", name)
 	}
+	if pkgName == "" || !npmNaming.Is(pkgName) {
+		return fmt.Errorf("invalid package name '%s'", pkgName)
+	}
+	return nil
+}
 
-	if regFullVersion.MatchString(version) {
+func parsePkg(pathname string) (*Pkg, error) {
+	name, version, submodule, err := splitPkgSpecifier(pathname)
+	if err != nil {
+		return nil, err
+	}
+	submodule = strings.TrimSuffix(submodule, ".js")
+
+	// the ref of git packages can't be resolved by the npm registry
+	if host, _, _ := splitGitPkgName(name); host != "" || regFullVersion.MatchString(version) {
+		if version != "" {
+			if err := validateVersion(version); err != nil {
+				return nil, &ParsePkgError{pathname, err.Error()}
+			}
+		}
 		return &Pkg{
 			Name:      name,
 			Version:   version,
-			Submodule: strings.TrimSuffix(submodule, ".js"),
+			Submodule: submodule,
 		}, nil
 	}
 
+	if version == "" {
+		version = "latest"
+	}
 	info, _, _, err := getPackageInfo("", name, version)
 	if err != nil {
 		return nil, err
 	}
+	// the version ranges are resolved by the npm registry, check the resolved version
+	// that is passed to the package manager
+	if err := validateVersion(info.Version); err != nil {
+		return nil, &ParsePkgError{pathname, err.Error()}
+	}
 
 	return &Pkg{
 		Name:      name,
 		Version:   info.Version,
-		Submodule: strings.TrimSuffix(submodule, ".js"),
+		Submodule: submodule,
 	}, nil
 }
 
+// sanitizeSubmodule checks the submodule from the URL before joining it to the package
+// directory, the absolute paths, the `..` segments and the null bytes are rejected to
+// prevent the path traversal.
+func sanitizeSubmodule(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(s, "/") || strings.ContainsRune(s, 0) {
+		return "", fmt.Errorf("invalid submodule '%s'", s)
+	}
+	for _, p := range strings.Split(strings.ReplaceAll(s, "\\", "/"), "/") {
+		if p == ".." {
+			return "", fmt.Errorf("invalid submodule '%s'", s)
+		}
+	}
+	return path.Clean(s), nil
+}
+
+// validateVersion checks the version before passing it to the package manager like
+// `yarn add react@<version>`, only the characters of the semver versions, the dist
+// tags and the git refs are allowed, and the leading `-` is rejected to not be taken
+// as a flag.
+func validateVersion(version string) error {
+	if version == "" || strings.HasPrefix(version, "-") || !npmVersioning.Is(version) {
+		return fmt.Errorf("invalid version '%s'", version)
+	}
+	return nil
+}
+
+// isPackageName checks whether the specifier is a package name without submodule,
+// like `react` or `@babel/core`
+func isPackageName(specifier string) bool {
+	a := strings.Split(specifier, "/")
+	if strings.HasPrefix(specifier, "@") {
+		return len(a) == 2 && a[1] != ""
+	}
+	return len(a) == 1 && specifier != ""
+}
+
 func (m Pkg) Equels(other Pkg) bool {
 	return m.Name == other.Name && m.Version == other.Version && m.Submodule == other.Submodule
 }
@@ -96,6 +202,15 @@ func (a PkgSlice) Has(name string) bool {
 	return false
 }
 
+func (a PkgSlice) Get(name string) (Pkg, bool) {
+	for _, m := range a {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Pkg{}, false
+}
+
 func (a PkgSlice) String() string {
 	s := make([]string, a.Len())
 	for i, m := range a {
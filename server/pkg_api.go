@@ -0,0 +1,272 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// servePkgAPI serves the `/v{VERSION}/<pkg>@<version>/+<api>` requests
+func servePkgAPI(ctx *rex.Context, pkg *Pkg, api string) interface{} {
+	if strings.HasPrefix(api, "file/") {
+		return servePackageFile(ctx, pkg, strings.TrimPrefix(api, "file/"))
+	}
+	if strings.HasPrefix(api, "source/") {
+		return serveSourceFile(ctx, pkg, strings.TrimPrefix(api, "source/"))
+	}
+	if strings.HasPrefix(api, "badges/") {
+		return serveBadge(ctx, pkg, strings.TrimPrefix(api, "badges/"))
+	}
+
+	switch api {
+	case "dependents":
+		key := fmt.Sprintf("dependents:%s", pkg.Name)
+		data, err := cache.Get(key)
+		if err != nil {
+			dependents, err := getDependents(pkg.Name, 20)
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			data = utils.MustEncodeJSON(map[string]interface{}{
+				"name":       pkg.Name,
+				"dependents": dependents,
+			})
+			cache.Set(key, data, time.Hour)
+		}
+		ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+		ctx.SetHeader("Cache-Control", "public, max-age=3600")
+		return data
+
+	case "dependencies":
+		return serveDependencies(ctx, pkg)
+
+	case "files":
+		savePath := path.Join("files", pkg.Name+"@"+pkg.Version+".json")
+		exists, modtime, err := fs.Exists(savePath)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		if !exists || time.Since(modtime) > 24*time.Hour {
+			files, err := listPackageFiles(pkg.Name, pkg.Version)
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			err = fs.WriteData(savePath, utils.MustEncodeJSON(map[string]interface{}{"files": files}))
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			modtime = time.Now()
+		}
+		r, err := fs.ReadFile(savePath)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+		ctx.SetHeader("Cache-Control", "public, max-age=86400")
+		return rex.Content(savePath, modtime, r)
+
+	case "tree":
+		return servePackageTree(ctx, pkg)
+
+	case "package-json":
+		return servePackageJSON(ctx, pkg)
+
+	case "manifest.json":
+		return servePackageManifest(ctx, pkg)
+
+	case "tsconfig.json":
+		return serveTsconfig(ctx, pkg)
+
+	case "playground":
+		return servePlayground(ctx, pkg)
+
+	case "preview.js":
+		return servePreviewScript(ctx, pkg)
+
+	case "types":
+		return serveTypesZip(ctx, pkg)
+
+	case "types-graph":
+		return serveTypesGraph(ctx, pkg)
+
+	case "bundle-analysis":
+		return serveBundleAnalysis(ctx, pkg)
+
+	case "compare":
+		return serveSizeCompare(ctx, pkg)
+
+	case "npm-diff":
+		return serveNpmDiff(ctx, pkg)
+
+	case "npm-tarball-url":
+		return serveNpmTarballURL(ctx, pkg)
+
+	case "npm-score":
+		return serveNpmScore(ctx, pkg)
+
+	case "license":
+		return serveLicense(ctx, pkg)
+
+	case "exports":
+		return serveExports(ctx, pkg)
+
+	case "exports-tree":
+		return serveExportsTree(ctx, pkg)
+
+	case "coverage":
+		return serveExportsCoverage(ctx, pkg)
+
+	case "cjs-exports":
+		return serveCJSExports(ctx, pkg)
+
+	case "security-policy":
+		return serveSecurityPolicy(ctx, pkg)
+
+	case "polyfills":
+		return serveBuiltInPolyfills(ctx, pkg)
+
+	case "peer-deps":
+		return servePeerDeps(ctx, pkg)
+
+	case "install-info":
+		return serveInstallInfo(ctx, pkg)
+
+	case "check":
+		return serveBuildCheck(ctx, pkg, "")
+
+	case "health":
+		return serveBuildHealth(ctx, pkg)
+
+	case "invalidate":
+		return serveInvalidate(ctx, pkg)
+	}
+
+	return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "not found"})
+}
+
+// newPkgAPIBuildTask creates a build task of the package for the package APIs, the
+// build is specified by the `target`, `bundle` and `dev` query like the module URL.
+func newPkgAPIBuildTask(ctx *rex.Context, pkg Pkg) *BuildTask {
+	target := normalizeTarget(strings.ToLower(ctx.Form.Value("target")))
+	if !isValidTarget(target) {
+		target = getTargetByUA(ctx.R.UserAgent())
+	}
+	return &BuildTask{
+		BuildVersion: VERSION,
+		Pkg:          pkg,
+		Target:       target,
+		BundleMode:   !ctx.Form.IsNil("bundle"),
+		DevMode:      !ctx.Form.IsNil("dev"),
+		stage:        "init",
+	}
+}
+
+type npmDependent struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Downloads int64  `json:"downloads"`
+}
+
+// getDependents searches the npm registry for the packages that depend on the given package
+func getDependents(name string, size int) (dependents []npmDependent, err error) {
+	var ret struct {
+		Objects []struct {
+			Package struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"package"`
+		} `json:"objects"`
+	}
+	err = fetchJSON(fmt.Sprintf("%s-/v1/search?text=%s&size=%d", node.npmRegistry, url.QueryEscape("dependencies:"+name), size), &ret)
+	if err != nil {
+		return
+	}
+
+	dependents = make([]npmDependent, len(ret.Objects))
+	var wg sync.WaitGroup
+	for i, o := range ret.Objects {
+		dependents[i] = npmDependent{Name: o.Package.Name, Version: o.Package.Version}
+		wg.Add(1)
+		go func(d *npmDependent) {
+			defer wg.Done()
+			var point struct {
+				Downloads int64 `json:"downloads"`
+			}
+			if fetchJSON("https://api.npmjs.org/downloads/point/last-week/"+d.Name, &point) == nil {
+				d.Downloads = point.Downloads
+			}
+		}(&dependents[i])
+	}
+	wg.Wait()
+	return
+}
+
+type packageFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// listPackageFiles lists the files of the package tarball by `npm pack --dry-run`
+func listPackageFiles(name string, version string) (files []packageFile, err error) {
+	wd := tempDir(fmt.Sprintf("esm-pack-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+
+	cmd := exec.Command("npm", "pack", "--dry-run", "--json", fmt.Sprintf("%s@%s", name, version))
+	cmd.Dir = wd
+	output, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("npm pack %s@%s: %v", name, version, err)
+		return
+	}
+
+	var ret []struct {
+		Files []packageFile `json:"files"`
+	}
+	err = json.Unmarshal(output, &ret)
+	if err != nil {
+		return
+	}
+	if len(ret) > 0 {
+		files = ret[0].Files
+	}
+	return
+}
+
+// getOrigin returns the origin of the request like `https://esm.sh`
+func getOrigin(ctx *rex.Context) string {
+	hostname := ctx.R.Host
+	proto := "https"
+	if hostname == "localhost" || strings.HasPrefix(hostname, "localhost:") {
+		proto = "http"
+	}
+	return proto + "://" + hostname
+}
+
+func fetchJSON(url string, v interface{}) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		ret, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("fetch %s: %s %s", url, resp.Status, string(ret))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,58 @@
+//go:build go1.18
+// +build go1.18
+
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func FuzzSplitPkgSpecifier(f *testing.F) {
+	f.Add("react@17.0.2/jsx-runtime")
+	f.Add("@babel/core")
+	f.Add("gh:catdad/canvas-confetti@v1.4.0")
+	f.Add("github:user/repo#main/lib/index.js")
+	f.Add("gitlab:user/repo")
+	f.Add("@/foo@")
+	f.Fuzz(func(t *testing.T, spec string) {
+		name, version, submodule, err := splitPkgSpecifier(spec)
+		if err != nil {
+			var parseErr *ParsePkgError
+			if !errors.As(err, &parseErr) || parseErr.Specifier != spec {
+				t.Fatalf("unexpected error type of '%s': %v", spec, err)
+			}
+			return
+		}
+		if name == "" {
+			t.Fatalf("empty name of '%s'", spec)
+		}
+		if strings.HasPrefix(submodule, "/") || strings.Contains(version, "/") {
+			t.Fatalf("unexpected split of '%s': %s %s %s", spec, name, version, submodule)
+		}
+	})
+}
+
+func FuzzSanitizeSubmodule(f *testing.F) {
+	f.Add("jsx-runtime")
+	f.Add("lib/./index.js")
+	f.Add("../../etc/passwd")
+	f.Add("lib/../../etc/passwd")
+	f.Add("/etc/passwd")
+	f.Add("a\x00b")
+	f.Fuzz(func(t *testing.T, s string) {
+		submodule, err := sanitizeSubmodule(s)
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(submodule, "/") || strings.ContainsRune(submodule, 0) {
+			t.Fatalf("unsafe submodule of '%s': %s", s, submodule)
+		}
+		for _, p := range strings.Split(submodule, "/") {
+			if p == ".." {
+				t.Fatalf("unsafe submodule of '%s': %s", s, submodule)
+			}
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// servePackageManifest serves the package.json of the package with the CDN URLs for
+// the browser tooling: the `main` is the URL of the build, and the `types` is the URL
+// of the declaration file. The build is specified by the `target`, `bundle` and `dev`
+// query like the module URL, it's queued if not found, and the manifest is cached for
+// 24 hours after the build is done.
+func servePackageManifest(ctx *rex.Context, pkg *Pkg) interface{} {
+	task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: pkg.Version})
+	key := fmt.Sprintf("manifest:%s", task.ID())
+	data, err := cache.Get(key)
+	if err != nil {
+		esm, err := findESM(task.ID())
+		if err == storage.ErrNotFound {
+			// the types are unknown until the package is built
+			buildQueue.tryAdd(task)
+		} else if buildErr, ok := err.(*FailedBuildError); ok {
+			return throwBuildError(ctx, buildErr)
+		} else if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+
+		raw, err := readInstalledPackageJSON(pkg)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		var types string
+		if esm != nil {
+			types = esm.Dts
+		}
+		manifest, err := toPackageManifest(raw, "/"+task.ID(), types)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		data = utils.MustEncodeJSON(manifest)
+		if esm != nil {
+			cache.Set(key, data, 24*time.Hour)
+		}
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
+
+// toPackageManifest converts the package.json to the manifest, the fields of package.json
+// are kept except the `main` and `types` that are replaced with the CDN URLs, and the
+// `repository` object is simplified to the URL.
+func toPackageManifest(packageJSON []byte, main string, types string) (map[string]interface{}, error) {
+	var manifest map[string]interface{}
+	err := json.Unmarshal(packageJSON, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	if repo, ok := manifest["repository"].(map[string]interface{}); ok {
+		if url, ok := repo["url"].(string); ok {
+			manifest["repository"] = url
+		}
+	}
+	manifest["main"] = main
+	delete(manifest, "typings")
+	if types != "" {
+		manifest["types"] = types
+	} else {
+		delete(manifest, "types")
+	}
+	return manifest, nil
+}
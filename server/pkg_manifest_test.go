@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestToPackageManifest(t *testing.T) {
+	packageJSON := `{
+  "name": "react",
+  "version": "18.2.0",
+  "description": "React is a JavaScript library for building user interfaces.",
+  "keywords": ["react"],
+  "homepage": "https://reactjs.org/",
+  "license": "MIT",
+  "main": "index.js",
+  "typings": "index.d.ts",
+  "repository": {"type": "git", "url": "https://github.com/facebook/react.git"}
+}`
+	manifest, err := toPackageManifest([]byte(packageJSON), "/v87/react@18.2.0/es2022/react.js", "/v87/@types/react@18.0.28/index.d.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest["main"] != "/v87/react@18.2.0/es2022/react.js" || manifest["types"] != "/v87/@types/react@18.0.28/index.d.ts" {
+		t.Fatalf("unexpected CDN URLs: %v", manifest)
+	}
+	if manifest["repository"] != "https://github.com/facebook/react.git" || manifest["license"] != "MIT" || manifest["typings"] != nil {
+		t.Fatalf("unexpected manifest: %v", manifest)
+	}
+
+	// no types
+	manifest, err = toPackageManifest([]byte(`{"name":"lib","types":"index.d.ts"}`), "/v87/lib@1.0.0/es2022/lib.js", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manifest["types"]; ok {
+		t.Fatalf("unexpected types: %v", manifest)
+	}
+
+	if _, err = toPackageManifest([]byte(`[]`), "", ""); err == nil {
+		t.Fatal("should fail with the invalid package.json")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// serveBuiltInPolyfills serves the `+polyfills` requests, it lists the built-in node
+// modules imported by the build and the URLs of their polyfills for the target like
+// `{"polyfills":{"buffer":"https://esm.sh/v58/node_buffer.js"}}`, the modules that
+// are not supported for the target are listed with `null`. The build is specified by
+// the `target`, `bundle` and `dev` query like the module URL, it's queued if not found,
+// then a 202 response is returned to retry later. The list is cached for 24 hours.
+func serveBuiltInPolyfills(ctx *rex.Context, pkg *Pkg) interface{} {
+	task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: pkg.Version})
+	key := fmt.Sprintf("polyfills:%s", task.ID())
+	data, err := cache.Get(key)
+	if err != nil {
+		esm, err := findESM(task.ID())
+		if err == storage.ErrNotFound {
+			if !buildQueue.tryAdd(task) {
+				return throwQueueFullError(ctx)
+			}
+			ctx.SetHeader("Retry-After", "30")
+			return rex.Status(http.StatusAccepted, fmt.Sprintf("Building %s@%s, please retry later", pkg.Name, pkg.Version))
+		} else if buildErr, ok := err.(*FailedBuildError); ok {
+			return throwBuildError(ctx, buildErr)
+		} else if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+
+		origin := getOrigin(ctx)
+		polyfills := map[string]interface{}{}
+		for _, name := range esm.NodeBuiltIns {
+			importPath, _, err := task.getBuiltInPolyfillPath(name)
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			if importPath == "" {
+				polyfills[name] = nil
+			} else if strings.HasPrefix(importPath, "/") {
+				polyfills[name] = origin + importPath
+			} else {
+				polyfills[name] = importPath
+			}
+		}
+		data = utils.MustEncodeJSON(map[string]interface{}{
+			"polyfills": polyfills,
+		})
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+var regExampleImport = regexp.MustCompile(`((?:\bfrom|\bimport)\s*\(?\s*)(["'])([^"']+)(["'])`)
+
+// servePreviewScript serves the `+preview.js` API, a runnable module of the first
+// example in the readme of the package, the imports of the package are rewritten
+// to the CDN URLs so the script runs without an import map.
+func servePreviewScript(ctx *rex.Context, pkg *Pkg) interface{} {
+	key := fmt.Sprintf("preview:%s@%s", pkg.Name, pkg.Version)
+	data, err := cache.Get(key)
+	if err != nil {
+		example := getReadmeExample(getPackageReadme(pkg.Name), pkg.Name)
+		pkgURL := fmt.Sprintf("%s/%s@%s", getOrigin(ctx), pkg.Name, pkg.Version)
+		buf := bytes.NewBuffer(nil)
+		fmt.Fprintf(buf, "/* esm.sh - preview of %s@%s */\n", pkg.Name, pkg.Version)
+		buf.WriteString(rewriteExampleImports(example, pkg.Name, pkgURL))
+		data = buf.Bytes()
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	setJSContentType(ctx)
+	return rex.Content("preview.js", time.Now(), bytes.NewReader(data))
+}
+
+// rewriteExampleImports rewrites the imports of the package and its submodules in the
+// example code to the CDN URL, other imports are kept.
+func rewriteExampleImports(code string, pkgName string, pkgURL string) string {
+	return regExampleImport.ReplaceAllStringFunc(code, func(s string) string {
+		m := regExampleImport.FindStringSubmatch(s)
+		if m[2] != m[4] {
+			return s
+		}
+		specifier := m[3]
+		if specifier == pkgName {
+			specifier = pkgURL
+		} else if strings.HasPrefix(specifier, pkgName+"/") {
+			specifier = pkgURL + strings.TrimPrefix(specifier, pkgName)
+		} else {
+			return s
+		}
+		return m[1] + m[2] + specifier + m[4]
+	})
+}
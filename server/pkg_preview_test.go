@@ -0,0 +1,16 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestRewriteExampleImports(t *testing.T) {
+	code := "import React from 'react'\nimport { render } from \"react-dom\"\nimport * as server from 'react/server'\nconst m = await import('react')\n"
+	expected := "import React from 'https://esm.sh/react@18.2.0'\nimport { render } from \"react-dom\"\nimport * as server from 'https://esm.sh/react@18.2.0/server'\nconst m = await import('https://esm.sh/react@18.2.0')\n"
+	if ret := rewriteExampleImports(code, "react", "https://esm.sh/react@18.2.0"); ret != expected {
+		t.Fatalf("unexpected code: %s", ret)
+	}
+	if ret := rewriteExampleImports("import 'react'", "react", "https://esm.sh/react@18.2.0"); ret != "import 'https://esm.sh/react@18.2.0'" {
+		t.Fatalf("unexpected code: %s", ret)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePkgSubmodule(t *testing.T) {
+	// the submodule URLs with or without the `.js` extension are the same module
+	for _, pathname := range []string{"/lodash-es@4.17.21/map", "/lodash-es@4.17.21/map.js"} {
+		pkg, err := parsePkg(pathname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkg.Name != "lodash-es" || pkg.Version != "4.17.21" || pkg.Submodule != "map" {
+			t.Fatalf("unexpected pkg of '%s': %v", pathname, pkg)
+		}
+	}
+}
+
+func TestValidatePkgName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"react":                  true,
+		"@babel/core":            true,
+		"lodash.debounce":        true,
+		"@types/node":            true,
+		"":                       false,
+		".hidden":                false,
+		"_private":               false,
+		"React":                  false,
+		"react;rm":               false,
+		"react&&id":              false,
+		"@babel":                 false,
+		"@/core":                 false,
+		"@babel/core/lib":        false,
+		"@ba$el/core":            false,
+		strings.Repeat("a", 215): false,
+	} {
+		if err := validatePkgName(name); (err == nil) != valid {
+			t.Fatalf("validatePkgName(%s) should be %v: %v", name, valid, err)
+		}
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	for version, valid := range map[string]bool{
+		"17.0.2":                       true,
+		"1.0.0-beta.1+build.2":         true,
+		"latest":                       true,
+		"^17.0.0":                      true,
+		"~4.17":                        true,
+		"v1.4.0":                       true,
+		"":                             false,
+		"--ignore-scripts":             false,
+		"1.0.0 --ignore-scripts=false": false,
+		"1.0.0;rm":                     false,
+		"1.0.0&&id":                    false,
+		"$(id)":                        false,
+	} {
+		if err := validateVersion(version); (err == nil) != valid {
+			t.Fatalf("validateVersion(%s) should be %v: %v", version, valid, err)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+const (
+	defaultTreeDepth = 3
+	maxTreeDepth     = 10
+	// the max number of the entries in the `+tree` response
+	maxTreeEntries = 1000
+	// the files larger than this size are not cached by the `+file` and `+source` APIs
+	maxCachedPackageFileSize = 1 << 20 // 1MB
+	// the files larger than this size are not served by the `+file` and `+source` APIs
+	maxPackageFileSize = 50 << 20 // 50MB
+	// the installed packages are removed after 10 minutes since the last use
+	installedPackageTTL = 10 * time.Minute
+)
+
+var (
+	installedPackageLocks      = newKeyedMutex()
+	installedPackageTimers     = map[string]*time.Timer{}
+	installedPackageTimersLock sync.Mutex
+)
+
+type treeEntry struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Size    int64       `json:"size,omitempty"`
+	Entries []treeEntry `json:"entries,omitempty"`
+}
+
+// servePackageTree serves the file tree of the package like
+// `/v{VERSION}/react@17.0.2/+tree?path=cjs&depth=2`, the depth is 3 by default and
+// up to 10. The tree is cached for 24 hours.
+func servePackageTree(ctx *rex.Context, pkg *Pkg) interface{} {
+	dir := strings.TrimPrefix(utils.CleanPath(ctx.Form.Value("path")), "/")
+	depth := defaultTreeDepth
+	if v := ctx.Form.Value("depth"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 1 || i > maxTreeDepth {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid depth query: %s, must be 1-%d", v, maxTreeDepth)})
+		}
+		depth = i
+	}
+
+	key := fmt.Sprintf("tree:%s@%s:%s:%d", pkg.Name, pkg.Version, dir, depth)
+	data, err := cache.Get(key)
+	if err != nil {
+		err = withInstalledPackage(pkg, func(pkgDir string) error {
+			if !dirExists(path.Join(pkgDir, dir)) {
+				return os.ErrNotExist
+			}
+			count := 0
+			entries, err := readTree(path.Join(pkgDir, dir), depth, &count)
+			if err != nil {
+				return err
+			}
+			data = utils.MustEncodeJSON(map[string]interface{}{
+				"entries":   entries,
+				"truncated": count > maxTreeEntries,
+			})
+			return nil
+		})
+		if err != nil {
+			if os.IsNotExist(err) {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("Directory '%s' not found", dir)})
+			}
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
+
+// servePackageFile serves the original file of the package like
+// `/v{VERSION}/react@17.0.2/+file/cjs/react.development.js`, unlike the `?raw` query
+// the file is not switched by the `dev` query. The dotfiles are not served like the
+// `+source` API.
+func servePackageFile(ctx *rex.Context, pkg *Pkg, filename string) interface{} {
+	filename = strings.TrimPrefix(utils.CleanPath(filename), "/")
+	if filename == "" {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing the file path"})
+	}
+	if hasDotfile(filename) {
+		return throwAPIError(ctx, 403, APIError{Code: errCodeForbidden, Message: fmt.Sprintf("File '%s' is not allowed", filename)})
+	}
+
+	file, err := readPackageFile(pkg, filename)
+	if err != nil {
+		return throwPackageFileError(ctx, filename, err)
+	}
+	ctx.SetHeader("Content-Type", rawContentType(file.name))
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return file.content()
+}
+
+// serveSourceFile serves the source file of the package before bundling like
+// `/v{VERSION}/react@17.0.2/+source/cjs/react.development.js`, the path is checked like
+// the submodule, and the dotfiles like `.env` and `.npmrc` are not served as they may
+// contain the sensitive information.
+func serveSourceFile(ctx *rex.Context, pkg *Pkg, filename string) interface{} {
+	filename, err := sanitizeSubmodule(filename)
+	if err != nil {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: err.Error()})
+	}
+	if filename == "" || filename == "." {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing the file path"})
+	}
+	if hasDotfile(filename) {
+		return throwAPIError(ctx, 403, APIError{Code: errCodeForbidden, Message: fmt.Sprintf("File '%s' is not allowed", filename)})
+	}
+
+	file, err := readPackageFile(pkg, filename)
+	if err != nil {
+		return throwPackageFileError(ctx, filename, err)
+	}
+	ctx.SetHeader("Content-Type", sourceContentType(file.name))
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return file.content()
+}
+
+// hasDotfile returns true if any part of the path is a dotfile (or a dot directory)
+func hasDotfile(filename string) bool {
+	for _, p := range strings.Split(filename, "/") {
+		if strings.HasPrefix(p, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// throwPackageFileError responds the error of `readPackageFile`
+func throwPackageFileError(ctx *rex.Context, filename string, err error) interface{} {
+	if os.IsNotExist(err) || err == errPackageFileIsDir {
+		return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("File '%s' not found", filename)})
+	}
+	if err == errPackageFileTooLarge {
+		return throwAPIError(ctx, 403, APIError{Code: errCodeForbidden, Message: fmt.Sprintf("File '%s' is too large, the max size is %dMB", filename, maxPackageFileSize>>20)})
+	}
+	return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+}
+
+// sourceContentType returns the content type of the source file by the extension,
+// the unknown files are served as plain text.
+func sourceContentType(filename string) string {
+	switch path.Ext(filename) {
+	case ".js", ".mjs", ".cjs":
+		return "text/javascript; charset=utf-8"
+	case ".ts", ".mts", ".tsx":
+		return "application/typescript; charset=utf-8"
+	case ".json":
+		return "application/json; charset=utf-8"
+	case ".css":
+		return "text/css; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+var (
+	errPackageFileIsDir    = errors.New("is a directory")
+	errPackageFileTooLarge = errors.New("file too large")
+)
+
+// installedFile is the file of the installed package, the small files are read in
+// the memory, and the large ones are opened to be streamed.
+type installedFile struct {
+	name    string
+	data    []byte
+	file    *os.File
+	modtime time.Time
+}
+
+// content returns the response of the file, the opened file is closed by rex after
+// the response.
+func (f *installedFile) content() interface{} {
+	if f.file != nil {
+		return rex.Content(f.name, f.modtime, f.file)
+	}
+	return f.data
+}
+
+// readPackageFile reads the file of the installed package, the file name is resolved
+// with the `.js` extension that is trimmed by `parsePkg` if the file is not found. The
+// files up to `maxCachedPackageFileSize` are read and cached for 24 hours, the larger
+// files up to `maxPackageFileSize` are opened to be streamed.
+func readPackageFile(pkg *Pkg, filename string) (file *installedFile, err error) {
+	key := fmt.Sprintf("package-file:%s@%s/%s", pkg.Name, pkg.Version, filename)
+	cached, err := cache.Get(key)
+	// the resolved file name is stored before the content, delimited by a NUL byte
+	if i := bytes.IndexByte(cached, 0); err == nil && i > 0 {
+		return &installedFile{name: string(cached[:i]), data: cached[i+1:]}, nil
+	}
+
+	file = &installedFile{name: filename}
+	err = withInstalledPackage(pkg, func(pkgDir string) error {
+		if !fileExists(path.Join(pkgDir, filename)) && fileExists(path.Join(pkgDir, filename+".js")) {
+			file.name = filename + ".js"
+		}
+		f, err := os.Open(path.Join(pkgDir, file.name))
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if fi.IsDir() {
+			f.Close()
+			return errPackageFileIsDir
+		}
+		if fi.Size() > maxPackageFileSize {
+			f.Close()
+			return errPackageFileTooLarge
+		}
+		if fi.Size() > maxCachedPackageFileSize {
+			// the opened file is still readable after the installed package is removed
+			file.file = f
+			file.modtime = fi.ModTime()
+			return nil
+		}
+		defer f.Close()
+		file.data, err = ioutil.ReadAll(f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if file.file == nil {
+		cache.Set(key, append([]byte(file.name+"\x00"), file.data...), 24*time.Hour)
+	}
+	return
+}
+
+// servePackageJSON serves the package.json of the installed package as is, which may
+// differ from the metadata of the npm registry. The response is cached for 24 hours.
+func servePackageJSON(ctx *rex.Context, pkg *Pkg) interface{} {
+	data, err := readInstalledPackageJSON(pkg)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
+
+// readInstalledPackageJSON reads the package.json of the installed package, the content
+// is cached for 24 hours.
+func readInstalledPackageJSON(pkg *Pkg) ([]byte, error) {
+	key := fmt.Sprintf("package-json:%s@%s", pkg.Name, pkg.Version)
+	data, err := cache.Get(key)
+	if err != nil {
+		err = withInstalledPackage(pkg, func(pkgDir string) (err error) {
+			data, err = ioutil.ReadFile(path.Join(pkgDir, "package.json"))
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		cache.Set(key, data, 24*time.Hour)
+	}
+	return data, nil
+}
+
+// withInstalledPackage installs the package in a temporary directory and calls the
+// function with the package directory. The installed package is reused by the calls
+// of the same package, and removed after `installedPackageTTL` since the last use.
+func withInstalledPackage(pkg *Pkg, fn func(pkgDir string) error) error {
+	key := fmt.Sprintf("%s@%s", pkg.Name, pkg.Version)
+	unlock := installedPackageLocks.Lock(key)
+	defer unlock()
+
+	hasher := sha1.New()
+	hasher.Write([]byte(key))
+	wd := tempDir(fmt.Sprintf("esm-pkg-%s", hex.EncodeToString(hasher.Sum(nil))))
+	packageFile, err := findPackageJSON(wd, pkg.Name)
+	if err != nil {
+		err = ensureDir(wd)
+		if err != nil {
+			return err
+		}
+		err = retryYarnAdd(wd, key, 3, time.Second)
+		if err == nil {
+			packageFile, err = findPackageJSON(wd, pkg.Name)
+		}
+		if err != nil {
+			os.RemoveAll(wd)
+			return err
+		}
+	}
+	keepInstalledPackage(key, wd)
+	return fn(path.Dir(packageFile))
+}
+
+// keepInstalledPackage (re)schedules the removal of the installed package, the caller
+// must hold the lock of the package.
+func keepInstalledPackage(key string, wd string) {
+	installedPackageTimersLock.Lock()
+	defer installedPackageTimersLock.Unlock()
+
+	if timer, ok := installedPackageTimers[key]; ok && timer.Stop() {
+		timer.Reset(installedPackageTTL)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(installedPackageTTL, func() {
+		unlock := installedPackageLocks.Lock(key)
+		defer unlock()
+		installedPackageTimersLock.Lock()
+		current := installedPackageTimers[key] == timer
+		if current {
+			delete(installedPackageTimers, key)
+		}
+		installedPackageTimersLock.Unlock()
+		// the package is used again after the timer fired
+		if !current {
+			return
+		}
+		os.RemoveAll(wd)
+	})
+	installedPackageTimers[key] = timer
+}
+
+// readTree reads the entries of the dir recursively up to the depth, the nested
+// `node_modules` directories are ignored. The reading stops when the count of the
+// entries exceeds `maxTreeEntries`.
+func readTree(dir string, depth int, count *int) (entries []treeEntry, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	entries = []treeEntry{}
+	for _, info := range infos {
+		if *count >= maxTreeEntries {
+			*count++
+			return
+		}
+		if info.IsDir() {
+			if info.Name() == "node_modules" {
+				continue
+			}
+			*count++
+			entry := treeEntry{Name: info.Name(), Type: "dir"}
+			if depth > 1 {
+				entry.Entries, err = readTree(path.Join(dir, info.Name()), depth-1, count)
+				if err != nil {
+					return
+				}
+			}
+			entries = append(entries, entry)
+		} else if info.Mode().IsRegular() {
+			*count++
+			entries = append(entries, treeEntry{Name: info.Name(), Type: "file", Size: info.Size()})
+		}
+	}
+	return
+}
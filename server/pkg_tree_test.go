@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+func TestReadTree(t *testing.T) {
+	dir := t.TempDir()
+	ensureDir(path.Join(dir, "lib", "utils"))
+	ensureDir(path.Join(dir, "node_modules", "dep"))
+	ioutil.WriteFile(path.Join(dir, "index.js"), []byte("export default 1"), 0644)
+	ioutil.WriteFile(path.Join(dir, "lib", "a.js"), []byte("a"), 0644)
+	ioutil.WriteFile(path.Join(dir, "lib", "utils", "b.js"), []byte("bb"), 0644)
+
+	count := 0
+	entries, err := readTree(dir, 2, &count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []treeEntry{
+		{Name: "index.js", Type: "file", Size: 16},
+		{Name: "lib", Type: "dir", Entries: []treeEntry{
+			{Name: "a.js", Type: "file", Size: 1},
+			{Name: "utils", Type: "dir"},
+		}},
+	}
+	if !reflect.DeepEqual(entries, expected) || count != 4 {
+		t.Fatalf("unexpected tree(%d): %v", count, entries)
+	}
+
+	count = maxTreeEntries
+	entries, err = readTree(dir, 2, &count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 || count <= maxTreeEntries {
+		t.Fatalf("the tree should be truncated: %v", entries)
+	}
+}
+
+func TestSourceContentType(t *testing.T) {
+	for filename, contentType := range map[string]string{
+		"index.js":       "text/javascript; charset=utf-8",
+		"esm/index.mjs":  "text/javascript; charset=utf-8",
+		"src/index.ts":   "application/typescript; charset=utf-8",
+		"src/App.tsx":    "application/typescript; charset=utf-8",
+		"package.json":   "application/json; charset=utf-8",
+		"dist/style.css": "text/css; charset=utf-8",
+		"README.md":      "text/plain; charset=utf-8",
+		"bin/cli":        "text/plain; charset=utf-8",
+	} {
+		if ret := sourceContentType(filename); ret != contentType {
+			t.Fatalf("unexpected content type of '%s': %s", filename, ret)
+		}
+	}
+}
+
+func TestServeCachedPackageFiles(t *testing.T) {
+	var err error
+	cache, err = storage.OpenCache("memory:main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cached file is served without installing the package
+	cache.Set("package-file:foo@1.0.0/index", []byte("index.js\x00export default 1"), time.Minute)
+
+	ctx := &rex.Context{W: httptest.NewRecorder(), R: httptest.NewRequest("GET", "/v58/foo@1.0.0/+file/index", nil)}
+	ret := servePackageFile(ctx, &Pkg{Name: "foo", Version: "1.0.0"}, "index")
+	if data, ok := ret.([]byte); !ok || string(data) != "export default 1" {
+		t.Fatalf("unexpected response: %v", ret)
+	}
+	if contentType := ctx.W.Header().Get("Content-Type"); contentType != rawContentType("index.js") {
+		t.Fatalf("unexpected content type: %s", contentType)
+	}
+
+	ctx = &rex.Context{W: httptest.NewRecorder(), R: httptest.NewRequest("GET", "/v58/foo@1.0.0/+source/index", nil)}
+	ret = serveSourceFile(ctx, &Pkg{Name: "foo", Version: "1.0.0"}, "index")
+	if data, ok := ret.([]byte); !ok || string(data) != "export default 1" {
+		t.Fatalf("unexpected response: %v", ret)
+	}
+	if contentType := ctx.W.Header().Get("Content-Type"); contentType != "text/javascript; charset=utf-8" {
+		t.Fatalf("unexpected content type: %s", contentType)
+	}
+
+	// the `?raw` request reads the resolved file through the same cache
+	cache.Set("raw-file:foo@1.0.0/?dev=false", []byte("index"), time.Minute)
+	ctx = &rex.Context{W: httptest.NewRecorder(), R: httptest.NewRequest("GET", "/v58/foo@1.0.0?raw", nil)}
+	ret = serveRawFile(ctx, &Pkg{Name: "foo", Version: "1.0.0"}, false)
+	if data, ok := ret.([]byte); !ok || string(data) != "export default 1" {
+		t.Fatalf("unexpected response: %v", ret)
+	}
+	if cacheControl := ctx.W.Header().Get("Cache-Control"); cacheControl != "public, max-age=31536000, immutable" {
+		t.Fatalf("unexpected cache control: %s", cacheControl)
+	}
+}
+
+func TestReadInstalledPackageFile(t *testing.T) {
+	var err error
+	cache, err = storage.OpenCache("memory:main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := &Pkg{Name: "esm-test-pkg-file", Version: "1.0.0"}
+	// install the package fixture in the directory used by `withInstalledPackage`
+	hasher := sha1.New()
+	hasher.Write([]byte(pkg.Name + "@" + pkg.Version))
+	wd := tempDir(fmt.Sprintf("esm-pkg-%s", hex.EncodeToString(hasher.Sum(nil))))
+	pkgDir := path.Join(wd, "node_modules", pkg.Name)
+	ensureDir(pkgDir)
+	defer os.RemoveAll(wd)
+	ioutil.WriteFile(path.Join(pkgDir, "package.json"), []byte(`{"name":"esm-test-pkg-file","version":"1.0.0"}`), 0644)
+	ioutil.WriteFile(path.Join(pkgDir, ".npmrc"), []byte("//registry.npmjs.org/:_authToken=secret"), 0644)
+	ioutil.WriteFile(path.Join(pkgDir, "large.js"), bytes.Repeat([]byte("a"), maxCachedPackageFileSize+1), 0644)
+
+	// the dotfiles are not served
+	ctx := &rex.Context{W: httptest.NewRecorder(), R: httptest.NewRequest("GET", "/v58/esm-test-pkg-file@1.0.0/+file/.npmrc", nil)}
+	ret := servePackageFile(ctx, pkg, ".npmrc")
+	if !reflect.DeepEqual(ret, rex.Status(403, APIError{Code: errCodeForbidden, Message: "File '.npmrc' is not allowed"})) {
+		t.Fatalf("the dotfile should not be served: %v", ret)
+	}
+
+	// the large file is streamed without being cached
+	file, err := readPackageFile(pkg, "large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.file.Close()
+	if file.name != "large.js" || file.file == nil || file.data != nil {
+		t.Fatalf("the large file should be opened: %s", file.name)
+	}
+	if _, err = cache.Get("package-file:esm-test-pkg-file@1.0.0/large"); err == nil {
+		t.Fatal("the large file should not be cached")
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/rex"
+)
+
+// the directory of the downloaded declaration files in the `paths` of the `+tsconfig.json`
+const tsconfigTypesDir = "./types"
+
+// serveTsconfig serves the `+tsconfig.json` requests, it generates a tsconfig.json snippet
+// that maps the package to the local copy of its declaration file with the `paths` option.
+// The build is specified by the `target`, `bundle` and `dev` query like the module URL,
+// it's queued if not found, then a 202 response is returned to retry later. The snippet is
+// cached for 24 hours.
+func serveTsconfig(ctx *rex.Context, pkg *Pkg) interface{} {
+	task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: pkg.Version})
+	key := fmt.Sprintf("tsconfig:%s", task.ID())
+	data, err := cache.Get(key)
+	if err != nil {
+		esm, err := findESM(task.ID())
+		if err == storage.ErrNotFound {
+			if !buildQueue.tryAdd(task) {
+				return throwQueueFullError(ctx)
+			}
+			ctx.SetHeader("Retry-After", "30")
+			return rex.Status(http.StatusAccepted, fmt.Sprintf("Building %s@%s, please retry later", pkg.Name, pkg.Version))
+		} else if buildErr, ok := err.(*FailedBuildError); ok {
+			return throwBuildError(ctx, buildErr)
+		} else if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		if esm.Dts == "" {
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("Types of %s@%s not found", pkg.Name, pkg.Version)})
+		}
+		data, err = toTsconfig(pkg.Name, esm.Dts, getOrigin(ctx))
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		cache.Set(key, data, 24*time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return data
+}
+
+// toTsconfig generates the tsconfig.json snippet of the package, the local path of the
+// declaration file keeps the layout of the CDN path without the build version prefix
+// like `/v57/react@17.0.2/index.d.ts` -> `./types/react@17.0.2/index.d.ts`. The snippet
+// starts with a comment explaining how to use it, which is allowed in tsconfig.json.
+func toTsconfig(pkgName string, dts string, origin string) ([]byte, error) {
+	localPath := tsconfigTypesDir + regBuildVersionPath.ReplaceAllString(dts, "/")
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"compilerOptions": map[string]interface{}{
+			"paths": map[string][]string{
+				pkgName: {localPath},
+			},
+			"moduleResolution": "bundler",
+			"types":            []string{},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "// Merge the `compilerOptions` into the tsconfig.json of your project, and download\n")
+	fmt.Fprintf(buf, "// the declaration file from %s%s to %s.\n", origin, dts, localPath)
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
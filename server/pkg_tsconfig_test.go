@@ -0,0 +1,37 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTsconfig(t *testing.T) {
+	data, err := toTsconfig("react", "/v58/@types/react@17.0.37/index.d.ts", "https://esm.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := "// the declaration file from https://esm.sh/v58/@types/react@17.0.37/index.d.ts to ./types/@types/react@17.0.37/index.d.ts.\n"
+	if !strings.Contains(string(data), comment) {
+		t.Fatalf("missing the comment: %s", data)
+	}
+
+	var tsconfig struct {
+		CompilerOptions struct {
+			Paths            map[string][]string `json:"paths"`
+			ModuleResolution string              `json:"moduleResolution"`
+			Types            []string            `json:"types"`
+		} `json:"compilerOptions"`
+	}
+	err = json.Unmarshal(data[strings.Index(string(data), "{"):], &tsconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := tsconfig.CompilerOptions.Paths["react"]
+	if len(paths) != 1 || paths[0] != "./types/@types/react@17.0.37/index.d.ts" {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+	if tsconfig.CompilerOptions.ModuleResolution != "bundler" || tsconfig.CompilerOptions.Types == nil || len(tsconfig.CompilerOptions.Types) != 0 {
+		t.Fatalf("unexpected compiler options: %+v", tsconfig.CompilerOptions)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+var regMarkdownCodeBlock = regexp.MustCompile("(?s)```[a-zA-Z]*\\n(.*?)```")
+
+// servePlayground serves a self-contained HTML page to try the package online
+func servePlayground(ctx *rex.Context, pkg *Pkg) interface{} {
+	tpl, err := embedFS.ReadFile("server/embed/playground.html")
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	page := renderPlayground(tpl, pkg, getOrigin(ctx), getPackageReadme(pkg.Name))
+	ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
+	return rex.Content("playground.html", time.Now(), bytes.NewReader(page))
+}
+
+// renderPlayground fills the playground template. The submodule comes from the
+// URL as is, so the values are JSON encoded in the scripts (the `<` and `>` are
+// escaped by the encoder) and HTML escaped in the markup.
+func renderPlayground(tpl []byte, pkg *Pkg, origin string, readme string) []byte {
+	moduleURL := fmt.Sprintf("%s/%s@%s", origin, pkg.Name, pkg.Version)
+	if pkg.Submodule != "" {
+		moduleURL += "/" + pkg.Submodule
+	}
+	importMap := map[string]interface{}{
+		"imports": map[string]string{
+			pkg.Name:       fmt.Sprintf("%s/%s@%s", origin, pkg.Name, pkg.Version),
+			pkg.Name + "/": fmt.Sprintf("%s/%s@%s/", origin, pkg.Name, pkg.Version),
+		},
+	}
+
+	page := bytes.ReplaceAll(tpl, []byte("{PKG}"), []byte(html.EscapeString(pkg.Name+"@"+pkg.Version)))
+	page = bytes.ReplaceAll(page, []byte("{IMPORT_MAP}"), bytes.TrimSpace(utils.MustEncodeJSON(importMap)))
+	page = bytes.ReplaceAll(page, []byte("{MODULE_URL}"), bytes.TrimSpace(utils.MustEncodeJSON(moduleURL)))
+	page = bytes.ReplaceAll(page, []byte("{EXAMPLE}"), bytes.TrimSpace(utils.MustEncodeJSON(getReadmeExample(readme, pkg.Name))))
+	return page
+}
+
+// getPackageReadme returns the readme of the package from the npm registry, it
+// returns an empty string if the readme is not available.
+func getPackageReadme(name string) string {
+	key := fmt.Sprintf("readme:%s", name)
+	data, err := cache.Get(key)
+	if err == nil {
+		return string(data)
+	}
+	var doc struct {
+		Readme string `json:"readme"`
+	}
+	if fetchJSON(node.npmRegistry+name, &doc) != nil {
+		return ""
+	}
+	cache.Set(key, []byte(doc.Readme), time.Hour)
+	return doc.Readme
+}
+
+// getReadmeExample returns the first code block in the readme that starts
+// with `import` or `require`, or a minimal example if not found.
+func getReadmeExample(readme string, pkgName string) string {
+	for _, m := range regMarkdownCodeBlock.FindAllStringSubmatch(readme, -1) {
+		code := strings.TrimSpace(m[1])
+		if strings.HasPrefix(code, "import") || strings.HasPrefix(code, "require") || strings.Contains(strings.Split(code, "\n")[0], "require(") {
+			return code + "\n"
+		}
+	}
+	return fmt.Sprintf("import * as mod from \"%s\"\n\nconsole.log(mod)\n", pkgName)
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRenderPlayground(t *testing.T) {
+	embedFS = &devFS{".."}
+	tpl, err := embedFS.ReadFile("server/embed/playground.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := &Pkg{Name: "react", Version: "17.0.2", Submodule: `x"</script><script>alert(1)</script>`}
+	page := renderPlayground(tpl, pkg, "https://esm.sh", "")
+	if bytes.Contains(page, []byte("<script>alert(1)")) {
+		t.Fatalf("the submodule should be escaped: %s", page)
+	}
+	if !bytes.Contains(page, []byte(`import * as mod from "https://esm.sh/react@17.0.2/x\"\u003c/script\u003e`)) {
+		t.Fatalf("the module URL should be JSON encoded: %s", page)
+	}
+	if !bytes.Contains(page, []byte("<h1>react@17.0.2</h1>")) {
+		t.Fatalf("unexpected heading: %s", page)
+	}
+}
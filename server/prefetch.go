@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const npmDownloadsAPI = "https://api.npmjs.org/downloads/point/last-month/"
+
+// the well-known packages that are always considered for the pre-fetching, the
+// packages built by the previous build versions are considered as well.
+var popularPackageSeeds = []string{
+	"react",
+	"react-dom",
+	"vue",
+	"preact",
+	"lodash",
+	"lodash-es",
+	"axios",
+	"dayjs",
+	"date-fns",
+	"uuid",
+	"nanoid",
+	"classnames",
+	"clsx",
+	"immer",
+	"zustand",
+	"redux",
+	"rxjs",
+	"d3",
+	"three",
+	"marked",
+	"canvas-confetti",
+	"swr",
+	"@emotion/react",
+	"@babel/core",
+}
+
+// prefetchPopularPackages queues the builds of the common targets for the most
+// downloaded packages of the last month, to warm the cache of the new build
+// version before the user requests arrive.
+func prefetchPopularPackages(ctx context.Context, limit int) {
+	if limit <= 0 {
+		return
+	}
+
+	names := getPrefetchCandidates()
+	downloads := getPackageDownloads(ctx, names)
+	sort.SliceStable(names, func(i, j int) bool {
+		return downloads[names[i]] > downloads[names[j]]
+	})
+	if len(names) > limit {
+		names = names[:limit]
+	}
+
+	queued := 0
+	for _, name := range names {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		info, _, _, err := getPackageInfo("", name, "latest")
+		if err != nil {
+			log.Warnf("prefetch %s: %v", name, err)
+			continue
+		}
+		for _, target := range commonTargets {
+			task := &BuildTask{
+				BuildVersion: VERSION,
+				Pkg:          Pkg{Name: info.Name, Version: info.Version},
+				Target:       target,
+				stage:        "init",
+			}
+			if _, err := findESM(task.ID()); err == nil {
+				continue
+			}
+			if !buildQueue.tryAdd(task) {
+				log.Warnf("prefetch popular packages: %d builds queued, %v", queued, ErrQueueFull)
+				return
+			}
+			queued++
+		}
+	}
+	log.Infof("prefetch popular packages: %d builds queued", queued)
+}
+
+// getPrefetchCandidates returns the seed packages and the packages built by the
+// previous build versions.
+func getPrefetchCandidates() []string {
+	set := newStringSet()
+	for _, name := range popularPackageSeeds {
+		set.Add(name)
+	}
+	list, err := db.List("build")
+	if err != nil {
+		log.Warnf("prefetch: list builds: %v", err)
+		return set.Values()
+	}
+	for _, item := range list {
+		// the build ID is like `v57/react@17.0.2/es2021/react.js`
+		id := item.Store["id"]
+		if !strings.HasPrefix(id, "v") || strings.HasPrefix(id, fmt.Sprintf("v%d/", VERSION)) {
+			continue
+		}
+		pkg, err := parsePkgFromBuildID(id)
+		if err == nil {
+			set.Add(pkg)
+		}
+	}
+	return set.Values()
+}
+
+// parsePkgFromBuildID returns the package name of the build ID
+func parsePkgFromBuildID(id string) (string, error) {
+	a := strings.Split(id, "/")
+	if len(a) < 2 {
+		return "", fmt.Errorf("invalid build id '%s'", id)
+	}
+	nameWithVersion := a[1]
+	if strings.HasPrefix(nameWithVersion, "@") && len(a) > 2 {
+		nameWithVersion = a[1] + "/" + a[2]
+	}
+	i := strings.LastIndexByte(nameWithVersion, '@')
+	if i <= 0 {
+		return "", fmt.Errorf("invalid build id '%s'", id)
+	}
+	name := nameWithVersion[:i]
+	if !isPackageName(name) {
+		return "", fmt.Errorf("invalid build id '%s'", id)
+	}
+	return name, nil
+}
+
+// getPackageDownloads fetches the download counts of the last month by the npm
+// downloads API, the unscoped packages are queried in bulk (max 128 per request)
+// and the scoped packages are queried one by one since the bulk query doesn't
+// support them.
+func getPackageDownloads(ctx context.Context, names []string) map[string]int64 {
+	type point struct {
+		Downloads int64 `json:"downloads"`
+	}
+	downloads := map[string]int64{}
+	var unscoped []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "@") {
+			if ctx.Err() != nil {
+				return downloads
+			}
+			var ret point
+			if err := fetchJSON(npmDownloadsAPI+name, &ret); err == nil {
+				downloads[name] = ret.Downloads
+			}
+		} else {
+			unscoped = append(unscoped, name)
+		}
+	}
+	for i := 0; i < len(unscoped); i += 128 {
+		if ctx.Err() != nil {
+			return downloads
+		}
+		end := i + 128
+		if end > len(unscoped) {
+			end = len(unscoped)
+		}
+		chunk := unscoped[i:end]
+		if len(chunk) == 1 {
+			var ret point
+			if err := fetchJSON(npmDownloadsAPI+chunk[0], &ret); err == nil {
+				downloads[chunk[0]] = ret.Downloads
+			}
+			continue
+		}
+		// the bulk query returns `null` for the packages not found
+		var ret map[string]*point
+		if err := fetchJSON(npmDownloadsAPI+strings.Join(chunk, ","), &ret); err != nil {
+			log.Warnf("prefetch: %v", err)
+			continue
+		}
+		for name, p := range ret {
+			if p != nil {
+				downloads[name] = p.Downloads
+			}
+		}
+	}
+	return downloads
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParsePkgFromBuildID(t *testing.T) {
+	for id, name := range map[string]string{
+		"v57/react@17.0.2/es2021/react.js":                 "react",
+		"v57/@babel/core@7.16.0/es2021/core.js":            "@babel/core",
+		"v57/react-dom@17.0.2/X-ZHJlYWN0/esnext/server.js": "react-dom",
+	} {
+		ret, err := parsePkgFromBuildID(id)
+		if err != nil || ret != name {
+			t.Fatalf("unexpected package name of '%s': %s %v", id, ret, err)
+		}
+	}
+	for _, id := range []string{"v57", "v57/react/es2021/react.js", "v57/@babel@7.16.0/core.js"} {
+		if _, err := parsePkgFromBuildID(id); err == nil {
+			t.Fatalf("the build id '%s' should be invalid", id)
+		}
+	}
+}
@@ -3,13 +3,18 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"esm.sh/server/storage"
@@ -41,7 +46,7 @@ func query(devMode bool) rex.Handle {
 	return func(ctx *rex.Context) interface{} {
 		pathname := ctx.Path.String()
 		if strings.HasPrefix(pathname, ".") || strings.HasSuffix(pathname, ".php") {
-			return rex.Status(400, "Bad Request")
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Bad Request"})
 		}
 		if strings.ContainsRune(pathname, ':') {
 			pathname = regLocPath.ReplaceAllString(pathname, "$1")
@@ -95,28 +100,61 @@ func query(devMode bool) rex.Handle {
 			return map[string]interface{}{
 				"uptime": time.Since(startTime).String(),
 				"queue":  q[:i],
+				"metrics": map[string]int64{
+					"dts_copy_deduplicated_total": atomic.LoadInt64(&dtsCopyDeduplicatedTotal),
+				},
 			}
 
+		case "/status":
+			return serveStatus(startTime)
+
 		case "/error.js":
-			switch ctx.Form.Value("type") {
-			case "resolve":
-				return throwErrorJS(ctx, fmt.Errorf(
-					`Can't resolve "%s" (Imported by "%s")`,
-					ctx.Form.Value("name"),
-					ctx.Form.Value("importer"),
-				))
-			case "unsupported-nodejs-builtin-module":
-				return throwErrorJS(ctx, fmt.Errorf(
-					`Unsupported nodejs builtin module "%s" (Imported by "%s")`,
-					ctx.Form.Value("name"),
-					ctx.Form.Value("importer"),
-				))
+			errorType := ctx.Form.Value("type")
+			switch errorType {
+			case "resolve", "unsupported-nodejs-builtin-module":
 			default:
-				return throwErrorJS(ctx, fmt.Errorf("Unknown error"))
+				errorType = "unknown"
 			}
+			lang := matchErrorLocale(ctx.R.Header.Get("Accept-Language"))
+			ctx.SetHeader("Content-Language", lang)
+			addVary(ctx, "Accept-Language")
+			return throwErrorJS(ctx, errors.New(localeError(lang, errorType, map[string]string{
+				"name":     ctx.Form.Value("name"),
+				"importer": ctx.Form.Value("importer"),
+			})))
+
+		case "/ws":
+			return serveBuildEvents(ctx)
+
+		case "/_cache":
+			return serveCacheAdmin(ctx, devMode)
+
+		case "/admin/aliases":
+			return serveAliasesAdmin(ctx, devMode)
+
+		case "/admin/stats/stages":
+			return serveStageStatsAdmin(ctx, devMode)
+
+		case "/admin/build-errors":
+			return serveBuildErrorsAdmin(ctx, devMode)
+
+		case "/build-local":
+			// only available in dev mode
+			if !devMode {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "not found"})
+			}
+			return serveBuildLocal(ctx)
+
+		case "/robots.txt":
+			return serveRobotsTxt(ctx)
 
 		case "/favicon.ico":
-			return rex.Status(404, "not found")
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "not found"})
+		}
+
+		// redirect the alias URLs like `/alias/react-compat@1.0.0/index.js`
+		if strings.HasPrefix(pathname, "/alias/") {
+			return serveAlias(ctx, pathname)
 		}
 
 		// serve embed assets
@@ -145,9 +183,10 @@ func query(devMode bool) rex.Handle {
 					}
 					data, err = buildSync(pathname, string(data), opts)
 					if err != nil {
-						return rex.Status(500, err.Error())
+						return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 					}
 					ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
+					setJSContentType(ctx)
 					return rex.Content(pathname+".js", startTime, bytes.NewReader(data))
 				default:
 					ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
@@ -156,6 +195,9 @@ func query(devMode bool) rex.Handle {
 			}
 		}
 
+		// the build artifacts and the package APIs should not be indexed by the search engines
+		ctx.SetHeader("X-Robots-Tag", "noindex")
+
 		hasBuildVerPrefix := strings.HasPrefix(pathname, fmt.Sprintf("/v%d/", VERSION))
 		prevBuildVer := ""
 		if hasBuildVerPrefix {
@@ -182,17 +224,85 @@ func query(devMode bool) rex.Handle {
 			}
 		}
 
+		// check the types of the package without building
+		if hasBuildVerPrefix && pathname == "/+dts-check" {
+			return serveDTSCheck(ctx)
+		}
+		if hasBuildVerPrefix && pathname == "/+resolve" {
+			return serveResolve(ctx)
+		}
+		if hasBuildVerPrefix && pathname == "/+targets" {
+			return serveTargets(ctx)
+		}
+		if hasBuildVerPrefix && pathname == "/+loaders" {
+			return serveLoaders(ctx)
+		}
+
 		// get package info
 		reqPkg, err := parsePkg(pathname)
 		if err != nil {
-			status := 500
-			message := err.Error()
-			if message == "invalid path" {
-				status = 400
-			} else if strings.HasSuffix(message, "not found") {
-				status = 404
+			var parseErr *ParsePkgError
+			if errors.As(err, &parseErr) {
+				return throwAPIError(ctx, 400, APIError{Code: errCodeInvalidPackage, Message: err.Error(), Detail: parseErr.Specifier})
+			}
+			if err == ErrOffline {
+				return throwAPIError(ctx, http.StatusServiceUnavailable, APIError{Code: errCodeOffline, Message: err.Error()})
+			}
+			if strings.HasSuffix(err.Error(), "not found") {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: err.Error()})
 			}
-			return rex.Status(status, message)
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+
+		// redirect the version tag or range to the exact version like `/react@latest?redirect`
+		if !ctx.Form.IsNil("redirect") {
+			mode := ctx.Form.Value("redirect")
+			if mode != "" && mode != "latest" && mode != "major" && mode != "minor" {
+				return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid redirect query '%s', must be 'latest', 'major' or 'minor'", mode)})
+			}
+			buildVerPrefix := ""
+			if prevBuildVer != "" {
+				buildVerPrefix = "/" + prevBuildVer
+			} else if hasBuildVerPrefix {
+				buildVerPrefix = fmt.Sprintf("/v%d", VERSION)
+			}
+			url, err := versionRedirectURL(pathname, ctx.R.URL.RawQuery, buildVerPrefix, reqPkg, mode)
+			if err != nil {
+				if strings.HasSuffix(err.Error(), "not found") {
+					return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: err.Error()})
+				}
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			if url != "" {
+				ctx.SetHeader("Cache-Control", "public, max-age=300")
+				return rex.Redirect(url, http.StatusFound)
+			}
+		}
+
+		// the patch and benchmark APIs are only available in dev mode or with the admin token
+		if reqPkg.Submodule == "+patch" || reqPkg.Submodule == "+benchmark" {
+			if err := checkAdminRequest(ctx, devMode); err != nil {
+				return err
+			}
+			if reqPkg.Submodule == "+benchmark" {
+				return serveBenchmark(ctx, reqPkg)
+			}
+			return servePatchBuild(ctx, reqPkg)
+		}
+
+		// serve the `+check` API with the target in the path like `/v{VERSION}/react@17.0.2/es2021/+check`
+		if target, api := utils.SplitByLastByte(reqPkg.Submodule, '/'); api == "+check" && isValidTarget(target) {
+			return serveBuildCheck(ctx, reqPkg, target)
+		}
+
+		// serve package APIs like `/v{VERSION}/react@17.0.2/+dependents`
+		if hasBuildVerPrefix && strings.HasPrefix(reqPkg.Submodule, "+") {
+			return servePkgAPI(ctx, reqPkg, strings.TrimPrefix(reqPkg.Submodule, "+"))
+		}
+
+		// serve the original package file without building
+		if hasBuildVerPrefix && !ctx.Form.IsNil("raw") {
+			return serveRawFile(ctx, reqPkg, !ctx.Form.IsNil("dev"))
 		}
 
 		var storageType string
@@ -204,9 +314,9 @@ func query(devMode bool) rex.Handle {
 				}
 
 			// todo: transform ts/jsx/tsx for browser
-			case ".ts", ".jsx", ".tsx":
+			case ".ts", ".mts", ".cts", ".jsx", ".tsx":
 				if hasBuildVerPrefix {
-					if strings.HasSuffix(pathname, ".d.ts") {
+					if isDtsFile(pathname) {
 						storageType = "types"
 					}
 				} else if len(strings.Split(pathname, "/")) > 2 {
@@ -215,7 +325,7 @@ func query(devMode bool) rex.Handle {
 
 			case ".json", ".css", ".pcss", "postcss", ".less", ".sass", ".scss", ".stylus", ".styl", ".wasm", ".xml", ".yaml", ".svg", ".png", ".eot", ".ttf", ".woff", ".woff2":
 				if hasBuildVerPrefix {
-					if strings.HasSuffix(pathname, ".css") {
+					if strings.HasSuffix(pathname, ".css") || strings.HasSuffix(pathname, ".wasm") {
 						storageType = "builds"
 					}
 				} else if len(strings.Split(pathname, "/")) > 2 {
@@ -245,12 +355,12 @@ func query(devMode bool) rex.Handle {
 			savePath := path.Join("raw", reqPkg.String())
 			exists, modtime, err := fs.Exists(savePath)
 			if err != nil {
-				return rex.Status(500, err.Error())
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 			}
 			if exists {
 				r, err := fs.ReadFile(savePath)
 				if err != nil {
-					return rex.Status(500, err.Error())
+					return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 				}
 				if strings.HasSuffix(pathname, ".ts") {
 					ctx.SetHeader("Content-Type", "application/typescript")
@@ -264,14 +374,18 @@ func query(devMode bool) rex.Handle {
 			}
 			defer resp.Body.Close()
 			if resp.StatusCode >= 500 {
-				return rex.Status(http.StatusBadGateway, "Bad Gateway")
+				return throwAPIError(ctx, http.StatusBadGateway, APIError{Code: errCodeInternal, Message: "Bad Gateway"})
 			}
 			data, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
 				return err
 			}
 			if resp.StatusCode >= 400 {
-				return rex.Status(resp.StatusCode, string(data))
+				code := errCodeBadRequest
+				if resp.StatusCode == 404 {
+					code = errCodeNotFound
+				}
+				return throwAPIError(ctx, resp.StatusCode, APIError{Code: code, Message: string(data)})
 			}
 			err = fs.WriteData(savePath, data)
 			if err != nil {
@@ -295,30 +409,111 @@ func query(devMode bool) rex.Handle {
 				savePath = path.Join(storageType, fmt.Sprintf("v%d", VERSION), pathname)
 			}
 
+			// serve the build metadata instead of the JS by `?format=json` or `Accept: application/json`
+			if storageType == "builds" && strings.HasSuffix(pathname, ".js") {
+				addVary(ctx, "Accept")
+				if ctx.Form.Value("format") == "json" || strings.Contains(ctx.R.Header.Get("Accept"), "application/json") {
+					esm, err := findESM(strings.TrimPrefix(savePath, "builds/"))
+					if err != nil {
+						if buildErr, ok := err.(*FailedBuildError); ok {
+							return throwBuildError(ctx, buildErr)
+						}
+						if err == storage.ErrNotFound {
+							return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Build not found"})
+						}
+						return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+					}
+					// the metadata may be updated by the rebuild, unlike the immutable JS
+					ctx.SetHeader("Cache-Control", "public, max-age=600")
+					ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+					return esm
+				}
+			}
+
 			exists, modtime, err := fs.Exists(savePath)
 			if err != nil {
-				return rex.Status(500, err.Error())
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			// the files of the evicted builds (see `evictPackageBuilds`) are left in the fs,
+			// they are rebuilt instead of being served without the build records
+			if exists && storageType == "builds" && strings.HasSuffix(savePath, ".js") {
+				_, _, err = db.Get(strings.TrimPrefix(savePath, "builds/"))
+				if err == storage.ErrNotFound {
+					exists = false
+				} else if err != nil {
+					return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+				}
 			}
 
 			if exists {
 				r, err := fs.ReadFile(savePath)
 				if err != nil {
-					return rex.Status(500, err.Error())
+					return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 				}
 				if strings.HasSuffix(savePath, ".css") && !ctx.Form.IsNil("module") {
 					data, err := ioutil.ReadAll(r)
 					if err != nil {
-						return rex.Status(500, err.Error())
+						return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 					}
 					cssStr, _ := json.Marshal(string(data))
 					jsCode := fmt.Sprintf(cssLoaderTpl, strings.TrimPrefix(savePath, "builds"), cssStr)
 					ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+					setJSContentType(ctx)
 					return rex.Content(savePath+".js", modtime, bytes.NewReader([]byte(jsCode)))
 				}
 				if storageType == "types" {
 					ctx.SetHeader("Content-Type", "application/typescript; charset=utf-8")
 				}
+				// serve the pre-compressed build file, the byte ranges are served from the raw file
+				if storageType == "builds" && strings.HasSuffix(savePath, ".js") && !noCompress && ctx.R.Header.Get("Range") == "" {
+					if encoding := preferredEncoding(ctx.R.Header.Get("Accept-Encoding")); encoding != "" {
+						compressedPath := savePath + precompressedExts[encoding]
+						if exists, _, err := fs.Exists(compressedPath); err == nil && exists {
+							cr, err := fs.ReadFile(compressedPath)
+							if err == nil {
+								r.Close()
+								setJSContentType(ctx)
+								ctx.SetHeader("Content-Encoding", encoding)
+								addVary(ctx, "Accept-Encoding")
+								ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+								ctx.SetHeader("Access-Control-Expose-Headers", "Digest")
+								err = setDigestHeader(ctx, compressedPath, cr)
+								if err != nil {
+									cr.Close()
+									return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+								}
+								// the name of the content is not compressable, so it's not compressed again by rex
+								return rex.Content(compressedPath, modtime, cr)
+							}
+						}
+					}
+				}
+				if strings.HasSuffix(savePath, ".js") {
+					setJSContentType(ctx)
+				}
+				if strings.HasSuffix(savePath, ".wasm") {
+					// required by the `WebAssembly.compileStreaming` API
+					ctx.SetHeader("Content-Type", "application/wasm")
+				}
+				ctx.SetHeader("Accept-Ranges", "bytes")
 				ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+				ctx.SetHeader("Access-Control-Expose-Headers", "Digest")
+				err = setDigestHeader(ctx, savePath, r)
+				var size int64
+				if err == nil {
+					size, err = r.Seek(0, io.SeekEnd)
+				}
+				if err == nil {
+					_, err = r.Seek(0, io.SeekStart)
+				}
+				if err != nil {
+					r.Close()
+					return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+				}
+				if serveByteRange(ctx.W, ctx.R, savePath, r, size) {
+					r.Close()
+					return nil
+				}
 				return rex.Content(savePath, modtime, r)
 			}
 		}
@@ -330,10 +525,14 @@ func query(devMode bool) rex.Handle {
 			if p != "" {
 				m, err := parsePkg(p)
 				if err != nil {
+					var parseErr *ParsePkgError
+					if errors.As(err, &parseErr) {
+						return throwAPIError(ctx, 400, APIError{Code: errCodeInvalidPackage, Message: fmt.Sprintf("Invalid deps query: %v", err), Detail: parseErr.Specifier})
+					}
 					if strings.HasSuffix(err.Error(), "not found") {
 						continue
 					}
-					return rex.Status(400, fmt.Sprintf("Invalid deps query: %v not found", p))
+					return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid deps query: %v not found", p)})
 				}
 				if !deps.Has(m.Name) {
 					deps = append(deps, *m)
@@ -355,15 +554,86 @@ func query(devMode bool) rex.Handle {
 			}
 		}
 
+		// check `external` query
+		external := newStringSet()
+		for _, name := range strings.Split(ctx.Form.Value("external"), ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				if !isPackageName(name) {
+					return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid external query: %s is not a package name", name)})
+				}
+				external.Add(name)
+			}
+		}
+
+		// check `global-externals` query like `react:React,react-dom:ReactDOM`
+		globalExternals := map[string]string{}
+		for _, p := range strings.Split(ctx.Form.Value("global-externals"), ",") {
+			p = strings.TrimSpace(p)
+			if p != "" {
+				name, global := utils.SplitByFirstByte(p, ':')
+				name = strings.TrimSpace(name)
+				global = strings.TrimSpace(global)
+				if !isPackageName(name) || !isValidGlobalName(global) {
+					return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid global-externals query: %s", p)})
+				}
+				globalExternals[name] = global
+			}
+		}
+
+		// check `locale` query
+		locale := ctx.Form.Value("locale")
+		if locale != "" && !isValidLocale(locale) {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid locale query: %s", locale)})
+		}
+
+		// check `conditions` query
+		conditions := newStringSet()
+		for _, c := range strings.Split(ctx.Form.Value("conditions"), ",") {
+			c = strings.TrimSpace(c)
+			if c != "" {
+				if !validConditions[c] {
+					return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid conditions query: unsupported condition '%s'", c)})
+				}
+				conditions.Add(c)
+			}
+		}
+
+		// check `env.<KEY>` query like `?env.FEATURE_X=true`
+		env := map[string]string{}
+		for key, values := range ctx.R.URL.Query() {
+			if strings.HasPrefix(key, "env.") && len(values) > 0 {
+				key = strings.TrimPrefix(key, "env.")
+				if !isValidEnvKey(key) {
+					return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid env query: invalid key '%s'", key)})
+				}
+				env[key] = values[0]
+			}
+		}
+		if len(env) > maxEnvOverrides {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid env query: too many env overrides (max %d)", maxEnvOverrides)})
+		}
+
 		// determine build target
 		var target string
 		ua := ctx.R.UserAgent()
 		if strings.HasPrefix(ua, "Deno/") {
 			target = "deno"
 		} else {
-			target = strings.ToLower(ctx.Form.Value("target"))
-			if _, ok := targets[target]; !ok {
+			target = normalizeTarget(strings.ToLower(ctx.Form.Value("target")))
+			if target == "auto" {
+				target = getEngineTargetByUA(ua)
+				addVary(ctx, "User-Agent")
+			} else if target == "" {
 				target = getTargetByUA(ua)
+			} else if query, ok := parseBrowserslistTarget(target); ok {
+				_, err := resolveBrowserslistEngines(query)
+				if err != nil {
+					return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid target '%s'", target), Detail: err.Error()})
+				}
+				target = browserslistTargetPrefix + query
+			} else if !isValidTarget(target) {
+				return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid target '%s', valid targets are: %s", target, strings.Join(validTargetNames(), ", "))})
 			}
 		}
 
@@ -381,6 +651,37 @@ func query(devMode bool) rex.Handle {
 		isBare := false
 		isBundleMode := !ctx.Form.IsNil("bundle")
 		isDev := !ctx.Form.IsNil("dev")
+		isExportsOnly := !ctx.Form.IsNil("exports-only")
+		isCJSOnly := !ctx.Form.IsNil("cjs-only")
+		isAsyncExports := !ctx.Form.IsNil("async-exports")
+		isNodeESM := !ctx.Form.IsNil("node-esm")
+		isKeepCSS := !ctx.Form.IsNil("keep-css")
+		isWasmInstantiate := !ctx.Form.IsNil("wasm-instantiate")
+		isWasmStreaming := !ctx.Form.IsNil("wasm-streaming")
+		isModuleWorker := ctx.Form.Value("type") == "module-worker"
+		isNoTypes := !ctx.Form.IsNil("no-types")
+		isIgnoreAnnotations := !ctx.Form.IsNil("ignore-annotations")
+		isIgnoreLocks := !ctx.Form.IsNil("ignore-locks")
+		isDebugExternals := !ctx.Form.IsNil("debug-externals")
+		isNoMinifyIdentifiers := !ctx.Form.IsNil("no-minify-identifiers")
+		isNoMinifyWhitespace := !ctx.Form.IsNil("no-minify-whitespace")
+		isNoMinifySyntax := !ctx.Form.IsNil("no-minify-syntax")
+		isIncludeComments := !ctx.Form.IsNil("include-comments")
+		isTypesAuto := false
+		if types := ctx.Form.Value("types"); types == "auto" {
+			isTypesAuto = true
+		} else if types != "" {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid types query: %s", types)})
+		}
+		entrypoint := strings.TrimPrefix(strings.TrimSpace(ctx.Form.Value("entrypoint")), "./")
+		optimizeFor := ctx.Form.Value("optimize")
+		if optimizeFor != "" && !isValidOptimizeFor(optimizeFor) {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid optimize query: %s, should be 'size' or 'speed'", optimizeFor)})
+		}
+		sourcefile := ctx.Form.Value("sourcefile")
+		if sourcefile != "" && !isValidSourcefile(sourcefile) {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid sourcefile query: %s", sourcefile)})
+		}
 		isPined := !ctx.Form.IsNil("pin")
 		isWorkder := !ctx.Form.IsNil("worker")
 		noCheck := !ctx.Form.IsNil("no-check")
@@ -389,39 +690,95 @@ func query(devMode bool) rex.Handle {
 		if hasBuildVerPrefix {
 			a := strings.Split(reqPkg.Submodule, "/")
 			if len(a) > 1 && strings.HasPrefix(a[0], "X-") {
-				s, err := atobUrl(strings.TrimPrefix(a[0], "X-"))
-				if err == nil {
-					for _, p := range strings.Split(s, ",") {
-						if strings.HasPrefix(p, "alias:") {
-							for _, p := range strings.Split(strings.TrimPrefix(p, "alias:"), ",") {
-								p = strings.TrimSpace(p)
-								if p != "" {
-									name, to := utils.SplitByFirstByte(p, ':')
-									name = strings.TrimSpace(name)
-									to = strings.TrimSpace(to)
-									if name != "" && to != "" {
-										alias[name] = to
-									}
-								}
+				prefixAlias, prefixDeps, err := parseResolvePrefix(a[0])
+				if err != nil {
+					return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Invalid resolve prefix"})
+				}
+				for name, to := range prefixAlias {
+					alias[name] = to
+				}
+				for _, m := range prefixDeps {
+					if !deps.Has(m.Name) {
+						deps = append(deps, m)
+					}
+				}
+				segments, _ := decodeResolvePrefix(a[0])
+				for _, p := range segments {
+					if p == "exports-only" {
+						isExportsOnly = true
+					} else if p == "cjs-only" {
+						isCJSOnly = true
+					} else if p == "async-exports" {
+						isAsyncExports = true
+					} else if p == "node-esm" {
+						isNodeESM = true
+					} else if p == "keep-css" {
+						isKeepCSS = true
+					} else if p == "wasm-instantiate" {
+						isWasmInstantiate = true
+					} else if p == "wasm-streaming" {
+						isWasmStreaming = true
+					} else if p == "module-worker" {
+						isModuleWorker = true
+					} else if p == "no-types" {
+						isNoTypes = true
+					} else if p == "ignore-annotations" {
+						isIgnoreAnnotations = true
+					} else if p == "ignore-locks" {
+						isIgnoreLocks = true
+					} else if p == "debug-externals" {
+						isDebugExternals = true
+					} else if p == "no-minify-identifiers" {
+						isNoMinifyIdentifiers = true
+					} else if p == "no-minify-whitespace" {
+						isNoMinifyWhitespace = true
+					} else if p == "no-minify-syntax" {
+						isNoMinifySyntax = true
+					} else if p == "include-comments" {
+						isIncludeComments = true
+					} else if p == "types-auto" {
+						isTypesAuto = true
+					} else if strings.HasPrefix(p, "x:") {
+						for _, name := range strings.Split(strings.TrimPrefix(p, "x:"), ".") {
+							if name, err := atobUrl(name); err == nil && isPackageName(name) {
+								external.Add(name)
+							}
+						}
+					} else if strings.HasPrefix(p, "g:") {
+						for _, p := range strings.Split(strings.TrimPrefix(p, "g:"), ".") {
+							name, global := utils.SplitByFirstByte(p, ':')
+							name, _ = atobUrl(name)
+							global, _ = atobUrl(global)
+							if isPackageName(name) && isValidGlobalName(global) {
+								globalExternals[name] = global
 							}
-						} else if strings.HasPrefix(p, "deps:") {
-							for _, p := range strings.Split(strings.TrimPrefix(p, "deps:"), ",") {
-								p = strings.TrimSpace(p)
-								if p != "" {
-									if strings.HasPrefix(p, "@") {
-										scope, name := utils.SplitByFirstByte(p, '_')
-										p = scope + "/" + name
-									}
-									m, err := parsePkg(p)
-									if err != nil {
-										if strings.HasSuffix(err.Error(), "not found") {
-											continue
-										}
-										return throwErrorJS(ctx, err)
-									}
-									if !deps.Has(m.Name) {
-										deps = append(deps, *m)
-									}
+						}
+					} else if strings.HasPrefix(p, "c:") {
+						for _, c := range strings.Split(strings.TrimPrefix(p, "c:"), ".") {
+							if validConditions[c] {
+								conditions.Add(c)
+							}
+						}
+					} else if strings.HasPrefix(p, "e:") {
+						entrypoint, _ = atobUrl(strings.TrimPrefix(p, "e:"))
+					} else if strings.HasPrefix(p, "o:") {
+						if o := strings.TrimPrefix(p, "o:"); isValidOptimizeFor(o) {
+							optimizeFor = o
+						}
+					} else if strings.HasPrefix(p, "s:") {
+						if s := strings.TrimPrefix(p, "s:"); isValidSourcefile(s) {
+							sourcefile = s
+						}
+					} else if strings.HasPrefix(p, "l:") {
+						if l := strings.TrimPrefix(p, "l:"); isValidLocale(l) {
+							locale = l
+						}
+					} else if strings.HasPrefix(p, "env:") {
+						for _, s := range strings.Split(strings.TrimPrefix(p, "env:"), ".") {
+							if s, err := atobUrl(s); err == nil {
+								key, value := utils.SplitByFirstByte(s, '=')
+								if isValidEnvKey(key) && len(env) < maxEnvOverrides {
+									env[key] = value
 								}
 							}
 						}
@@ -430,12 +787,15 @@ func query(devMode bool) rex.Handle {
 				reqPkg.Submodule = strings.Join(a[1:], "/")
 			}
 		}
+		if entrypoint != "" && (path.IsAbs(entrypoint) || strings.HasPrefix(path.Clean(entrypoint), "..")) {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Invalid entrypoint"})
+		}
 
 		// check whether it is `bare` mode
 		if hasBuildVerPrefix && endsWith(pathname, ".js") {
 			a := strings.Split(reqPkg.Submodule, "/")
 			if len(a) > 1 {
-				if _, ok := targets[a[0]]; ok {
+				if isValidTarget(a[0]) {
 					submodule := strings.TrimSuffix(strings.Join(a[1:], "/"), ".js")
 					if endsWith(submodule, ".bundle") {
 						submodule = strings.TrimSuffix(submodule, ".bundle")
@@ -451,6 +811,9 @@ func query(devMode bool) rex.Handle {
 					}
 					reqPkg.Submodule = submodule
 					target = a[0]
+					if query, ok := parseBrowserslistTarget(target); ok {
+						target = browserslistTargetPrefix + query
+					}
 					isBare = true
 				}
 			}
@@ -493,8 +856,11 @@ func query(devMode bool) rex.Handle {
 				c := buildQueue.Add(task)
 				select {
 				case output := <-c.C:
+					if output.err == ErrQueueFull {
+						return throwQueueFullError(ctx)
+					}
 					if output.err != nil {
-						return rex.Status(500, "types: "+err.Error())
+						return throwAPIError(ctx, 500, APIError{Code: errCodeBuildFailed, Message: "types: " + err.Error()})
 					}
 					if output.esm.Dts != "" {
 						savePath = path.Join("types", output.esm.Dts)
@@ -502,18 +868,18 @@ func query(devMode bool) rex.Handle {
 					}
 				case <-time.After(time.Minute):
 					buildQueue.RemoveConsumer(task, c)
-					return rex.Status(http.StatusRequestTimeout, "timeout, we are transforming the types hardly, please try again later!")
+					return throwAPIError(ctx, http.StatusRequestTimeout, APIError{Code: errCodeTimeout, Message: "timeout, we are transforming the types hardly, please try again later!"})
 				}
 			}
 			if err != nil {
-				return rex.Status(500, err.Error())
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 			}
 			if !exists {
-				return rex.Status(404, "Types not found")
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Types not found"})
 			}
 			r, err := fs.ReadFile(savePath)
 			if err != nil {
-				return rex.Status(500, err.Error())
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 			}
 			ctx.SetHeader("Content-Type", "application/typescript; charset=utf-8")
 			ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
@@ -521,28 +887,123 @@ func query(devMode bool) rex.Handle {
 		}
 
 		task := &BuildTask{
-			BuildVersion: buildVersion,
-			Pkg:          *reqPkg,
-			Deps:         deps,
-			Alias:        alias,
-			Target:       target,
-			BundleMode:   isBundleMode,
-			DevMode:      isDev,
-			stage:        "init",
+			BuildVersion:        buildVersion,
+			Pkg:                 *reqPkg,
+			Deps:                deps,
+			Alias:               alias,
+			External:            external.Values(),
+			GlobalExternals:     globalExternals,
+			Conditions:          conditions.Values(),
+			Env:                 env,
+			Locale:              locale,
+			TypesAuto:           isTypesAuto,
+			Target:              target,
+			BundleMode:          isBundleMode || isExportsOnly,
+			DevMode:             isDev,
+			ExportsOnly:         isExportsOnly,
+			CJSOnly:             isCJSOnly,
+			AsyncExports:        isAsyncExports,
+			NodeESM:             isNodeESM && target == "node",
+			KeepCSS:             isKeepCSS,
+			WasmInstantiate:     isWasmInstantiate,
+			WasmStreaming:       isWasmStreaming,
+			ModuleWorker:        isModuleWorker,
+			NoTypes:             isNoTypes,
+			Entrypoint:          entrypoint,
+			Sourcefile:          sourcefile,
+			IgnoreAnnotations:   isIgnoreAnnotations,
+			IgnoreLocks:         isIgnoreLocks,
+			DebugExternals:      isDebugExternals,
+			NoMinifyIdentifiers: isNoMinifyIdentifiers,
+			NoMinifyWhitespace:  isNoMinifyWhitespace,
+			NoMinifySyntax:      isNoMinifySyntax,
+			OptimizeFor:         optimizeFor,
+			IncludeComments:     isIncludeComments,
+			stage:               "init",
+		}
+		// the `X-Build-Priority` header moves the build in the queue, the positive
+		// priority requires the admin token while the negative one is allowed for all
+		if v := ctx.R.Header.Get("X-Build-Priority"); v != "" {
+			p, err := strconv.Atoi(v)
+			if err != nil {
+				return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid X-Build-Priority header: %s", v)})
+			}
+			if p > 0 && !isAdminRequest(ctx) {
+				ctx.SetHeader("WWW-Authenticate", "Bearer")
+				return throwAPIError(ctx, http.StatusUnauthorized, APIError{Code: errCodeUnauthorized, Message: "The admin token is required for the positive build priority"})
+			}
+			task.Priority = p
+		}
+		if trackExportsCoverage && ctx.Form.Value("exports") != "" {
+			recordExportsUsage(*reqPkg, ctx.Form.Value("exports"))
+		}
+		if !ctx.Form.IsNil("dry-run") {
+			return serveDryRunBuild(ctx, task, devMode)
+		}
+		if !ctx.Form.IsNil("esm-check") {
+			return serveESMCheck(ctx, task)
 		}
 		taskID := task.ID()
 		esm, err := findESM(taskID)
+		if buildErr, ok := err.(*FailedBuildError); ok {
+			// the types may be available even if the JS build is failed
+			if ctx.Form.IsNil("dts-only") {
+				return throwBuildError(ctx, buildErr)
+			}
+			err = storage.ErrNotFound
+		}
 		if err != nil && err != storage.ErrNotFound {
-			return rex.Status(500, err.Error())
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 		}
+
+		// serve the types only without building the JS
+		if !ctx.Form.IsNil("dts-only") {
+			// use the types of the JS build if it exists
+			if esm != nil {
+				if esm.Dts == "" {
+					return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Types not found"})
+				}
+				return rex.Redirect(esm.Dts, http.StatusFound)
+			}
+			task := &BuildTask{
+				BuildVersion: buildVersion,
+				Pkg:          *reqPkg,
+				Deps:         deps,
+				Alias:        alias,
+				Target:       "types",
+				stage:        "init",
+			}
+			c := buildQueue.Add(task)
+			select {
+			case output := <-c.C:
+				if output.err == ErrQueueFull {
+					return throwQueueFullError(ctx)
+				}
+				if output.err != nil {
+					return throwAPIError(ctx, 500, APIError{Code: errCodeBuildFailed, Message: "types: " + output.err.Error()})
+				}
+				esm = output.esm
+			case <-time.After(time.Minute):
+				buildQueue.RemoveConsumer(task, c)
+				return throwAPIError(ctx, http.StatusRequestTimeout, APIError{Code: errCodeTimeout, Message: "timeout, we are transforming the types hardly, please try again later!"})
+			}
+			if esm.Dts == "" {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Types not found"})
+			}
+			return rex.Redirect(esm.Dts, http.StatusFound)
+		}
+
 		if err == storage.ErrNotFound {
 			if !isBare && !isPined {
 				// find previous build version
 				for i := 0; i < VERSION; i++ {
 					id := fmt.Sprintf("v%d/%s", VERSION-(i+1), taskID[len(fmt.Sprintf("v%d/", VERSION)):])
 					esm, err = findESM(id)
+					if _, ok := err.(*FailedBuildError); ok {
+						err = storage.ErrNotFound
+					}
 					if err != nil && err != storage.ErrNotFound {
-						return rex.Status(500, err.Error())
+						return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 					}
 					if err == nil {
 						taskID = id
@@ -555,22 +1016,65 @@ func query(devMode bool) rex.Handle {
 			// or wait the current build task for 30 seconds
 			if esm != nil {
 				// todo: maybe don't build?
-				buildQueue.Add(task)
+				buildQueue.tryAdd(task)
 			} else {
 				c := buildQueue.Add(task)
 				select {
 				case output := <-c.C:
+					if output.err == ErrQueueFull {
+						return throwQueueFullError(ctx)
+					}
+					if errors.Is(output.err, ErrExportBlocked) {
+						return throwAPIError(ctx, 403, APIError{Code: errCodeForbidden, Message: output.err.Error()})
+					}
+					if output.err == ErrNativeAddon {
+						return throwAPIError(ctx, 422, APIError{Code: errCodeNativeAddon, Message: output.err.Error()})
+					}
+					if output.err == ErrYarnTimeout {
+						return throwAPIError(ctx, http.StatusGatewayTimeout, APIError{Code: errCodeTimeout, Message: output.err.Error()})
+					}
+					if output.err == ErrOffline {
+						return throwAPIError(ctx, http.StatusServiceUnavailable, APIError{Code: errCodeOffline, Message: output.err.Error()})
+					}
 					if output.err != nil {
 						return throwErrorJS(ctx, output.err)
 					}
 					esm = output.esm
 				case <-time.After(time.Minute):
 					buildQueue.RemoveConsumer(task, c)
-					return rex.Status(http.StatusRequestTimeout, "timeout, we are building the package hardly, please try again later!")
+					return throwAPIError(ctx, http.StatusRequestTimeout, APIError{Code: errCodeTimeout, Message: "timeout, we are building the package hardly, please try again later!"})
 				}
 			}
 		}
 
+		if !ctx.Form.IsNil("optimize-deps") {
+			deps, err := optimizeDeps(task, esm)
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			origin := getOrigin(ctx)
+			urls := map[string]string{}
+			for name, id := range deps {
+				urls[name] = fmt.Sprintf("%s/%s", origin, id)
+			}
+			ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
+			return urls
+		}
+
+		if !ctx.Form.IsNil("deps-map") {
+			depsMap, err := readDepsMap(taskID)
+			if err == storage.ErrNotFound {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Dependencies map not found"})
+			}
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
+			return map[string]interface{}{
+				"dependencies": depsMap,
+			}
+		}
+
 		if css {
 			if esm.PackageCSS {
 				hostname := ctx.R.Host
@@ -588,7 +1092,7 @@ func query(devMode bool) rex.Handle {
 				}
 				return rex.Redirect(url, code)
 			}
-			return rex.Status(404, "Package CSS not found")
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Package CSS not found"})
 		}
 
 		if isBare {
@@ -598,16 +1102,26 @@ func query(devMode bool) rex.Handle {
 			)
 			exists, modtime, err := fs.Exists(savePath)
 			if err != nil {
-				return rex.Status(500, err.Error())
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 			}
 			if !exists {
-				return rex.Status(404, "File not found")
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "File not found"})
 			}
 			r, err := fs.ReadFile(savePath)
 			if err != nil {
-				return rex.Status(500, err.Error())
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 			}
+			err = setDigestHeader(ctx, savePath, r)
+			if err != nil {
+				r.Close()
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			exposedHeaders := append(setDebugExternalsHeaders(ctx, esm), "Digest")
+			ctx.SetHeader("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
 			ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+			if strings.HasSuffix(savePath, ".js") {
+				setJSContentType(ctx)
+			}
 			return rex.Content(savePath, modtime, r)
 		}
 
@@ -626,22 +1140,56 @@ func query(devMode bool) rex.Handle {
 			origin = fmt.Sprintf("%s://%s/", proto, hostname)
 		}
 
-		fmt.Fprintf(buf, `/* esm.sh - %v */%s`, reqPkg, "\n")
 		if isWorkder {
-			fmt.Fprintf(buf, `export default function WorkerWrapper() {%s  return new Worker('%s%s', { type: 'module' })%s}`, "\n", origin, taskID, "\n")
-		} else {
-			fmt.Fprintf(buf, `export * from "%s%s";%s`, origin, taskID, "\n")
-			if esm.ExportDefault {
-				fmt.Fprintf(
-					buf,
-					`export { default } from "%s%s";%s`,
-					origin,
-					taskID,
-					"\n",
-				)
+			savePath := path.Join("builds", strings.TrimSuffix(taskID, ".js")+".worker.js")
+			exists, modtime, err := fs.Exists(savePath)
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			if !exists {
+				r, err := fs.ReadFile(path.Join("builds", taskID))
+				if err != nil {
+					return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+				}
+				code, err := ioutil.ReadAll(r)
+				r.Close()
+				if err != nil {
+					return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+				}
+				err = fs.WriteData(savePath, wrapWorker(code, origin))
+				if err != nil {
+					return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+				}
+				modtime = time.Now()
+			}
+			r, err := fs.ReadFile(savePath)
+			if err != nil {
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
 			}
+			err = setDigestHeader(ctx, savePath, r)
+			if err != nil {
+				r.Close()
+				return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+			}
+			ctx.SetHeader("Access-Control-Expose-Headers", "Digest")
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
+			setJSContentType(ctx)
+			return rex.Content(savePath, modtime, r)
+		}
+
+		fmt.Fprintf(buf, `/* esm.sh - %v */%s`, reqPkg, "\n")
+		fmt.Fprintf(buf, `export * from "%s%s";%s`, origin, taskID, "\n")
+		if esm.ExportDefault {
+			fmt.Fprintf(
+				buf,
+				`export { default } from "%s%s";%s`,
+				origin,
+				taskID,
+				"\n",
+			)
 		}
 
+		var exposedHeaders []string
 		if esm.Dts != "" && !noCheck && !isWorkder {
 			value := fmt.Sprintf(
 				"%s%s",
@@ -649,15 +1197,64 @@ func query(devMode bool) rex.Handle {
 				strings.TrimPrefix(esm.Dts, "/"),
 			)
 			ctx.SetHeader("X-TypeScript-Types", value)
-			ctx.SetHeader("Access-Control-Expose-Headers", "X-TypeScript-Types")
+			exposedHeaders = append(exposedHeaders, "X-TypeScript-Types")
+		}
+		if esm.EngineWarning != "" {
+			ctx.SetHeader("X-ESM-Engine-Warning", esm.EngineWarning)
+			exposedHeaders = append(exposedHeaders, "X-ESM-Engine-Warning")
+		}
+		exposedHeaders = append(exposedHeaders, setDebugExternalsHeaders(ctx, esm)...)
+		if len(exposedHeaders) > 0 {
+			ctx.SetHeader("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
 		}
 		ctx.SetHeader("Cache-Tag", "entry")
-		ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
-		ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+		if hasBuildVerPrefix && regFullVersionPath.MatchString(pathname+"/") && taskID == task.ID() {
+			// the entry of the exact version with the build version prefix never changes,
+			// unless it falls back to the build of the previous build version
+			ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
+		}
+		setJSContentType(ctx)
 		return buf
 	}
 }
 
+// the max number of the `X-ESM-External-*` headers of the `debug-externals` query
+const maxDebugExternalsHeaders = 20
+
+var regInvalidHeaderChars = regexp.MustCompile(`[^A-Za-z0-9-]+`)
+
+// setDebugExternalsHeaders sets the import paths of the external modules recorded by
+// the `debug-externals` build as the `X-ESM-External-<name>` headers, and returns the
+// names of the headers.
+func setDebugExternalsHeaders(ctx *rex.Context, esm *ESM) (names []string) {
+	for _, header := range debugExternalsHeaders(esm.Externals) {
+		ctx.SetHeader(header[0], header[1])
+		names = append(names, header[0])
+	}
+	return
+}
+
+// debugExternalsHeaders returns the `X-ESM-External-<name>` headers sorted by the
+// module names, the headers are truncated to `maxDebugExternalsHeaders` entries.
+func debugExternalsHeaders(externals map[string]string) (headers [][2]string) {
+	names := make([]string, 0, len(externals))
+	for name := range externals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i == maxDebugExternalsHeaders {
+			headers = append(headers, [2]string{"X-ESM-External-Truncated", "true"})
+			break
+		}
+		key := "X-ESM-External-" + strings.Trim(regInvalidHeaderChars.ReplaceAllString(name, "_"), "_")
+		headers = append(headers, [2]string{key, externals[name]})
+	}
+	return
+}
+
 func throwErrorJS(ctx *rex.Context, err error) interface{} {
 	buf := bytes.NewBuffer(nil)
 	fmt.Fprintf(buf, "/* esm.sh - error */\n")
@@ -669,6 +1266,6 @@ func throwErrorJS(ctx *rex.Context, err error) interface{} {
 	)
 	fmt.Fprintf(buf, "export default null;\n")
 	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
-	ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+	setJSContentType(ctx)
 	return rex.Status(500, buf)
 }
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDebugExternalsHeaders(t *testing.T) {
+	headers := debugExternalsHeaders(map[string]string{
+		"react":                  "/v57/react@17.0.2/es2021/react.js",
+		"@babel/runtime/helpers": "/v57/@babel/runtime@7.16.0/es2021/helpers.js",
+	})
+	if len(headers) != 2 {
+		t.Fatalf("unexpected headers: %v", headers)
+	}
+	if headers[0][0] != "X-ESM-External-babel_runtime_helpers" || headers[0][1] != "/v57/@babel/runtime@7.16.0/es2021/helpers.js" {
+		t.Fatalf("unexpected header: %v", headers[0])
+	}
+	if headers[1][0] != "X-ESM-External-react" {
+		t.Fatalf("unexpected header: %v", headers[1])
+	}
+
+	externals := map[string]string{}
+	for i := 0; i < 30; i++ {
+		externals[fmt.Sprintf("pkg-%02d", i)] = fmt.Sprintf("/v57/pkg-%02d@1.0.0/es2021/pkg-%02d.js", i, i)
+	}
+	headers = debugExternalsHeaders(externals)
+	if len(headers) != maxDebugExternalsHeaders+1 {
+		t.Fatalf("the headers should be truncated: %d", len(headers))
+	}
+	if last := headers[len(headers)-1]; last[0] != "X-ESM-External-Truncated" || last[1] != "true" {
+		t.Fatalf("missing truncated header: %v", last)
+	}
+}
+
+func TestVersionRedirectURL(t *testing.T) {
+	pkg := &Pkg{Name: "react", Version: "18.2.0"}
+	for _, c := range []struct {
+		pathname string
+		rawQuery string
+		prefix   string
+		expected string
+	}{
+		{"/react@latest", "redirect", "/v58", "/v58/react@18.2.0"},
+		{"/react", "redirect=latest&dev", "", "/react@18.2.0?dev"},
+		{"/react@18/jsx-runtime", "target=es2020&redirect&bundle", "/v57", "/v57/react@18.2.0/jsx-runtime?target=es2020&bundle"},
+		{"/react@18.2.0", "redirect", "/v58", ""},
+	} {
+		url, err := versionRedirectURL(c.pathname, c.rawQuery, c.prefix, pkg, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != c.expected {
+			t.Fatalf("the redirect URL of '%s?%s' should be '%s', but got '%s'", c.pathname, c.rawQuery, c.expected, url)
+		}
+	}
+
+	for mode, expected := range map[string]string{"major": "18", "minor": "18.2"} {
+		if rng, err := redirectVersionRange("18.2.0", mode); err != nil || rng != expected {
+			t.Fatalf("unexpected range of the %s mode: %s %v", mode, rng, err)
+		}
+	}
+	if _, err := redirectVersionRange("18.2.0", "patch"); err == nil {
+		t.Fatal("the patch mode should be invalid")
+	}
+}
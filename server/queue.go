@@ -2,11 +2,21 @@ package server
 
 import (
 	"container/list"
-	"fmt"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// the default max number of the tasks in the build queue
+const defaultBuildQueueMaxDepth = 500
+
+// ErrQueueFull is returned when the build queue reaches the max depth
+var ErrQueueFull = errors.New("The build queue is full, please try again later.")
+
+// ErrBuildTimeout is returned when the build task runs more than 5 minutes
+var ErrBuildTimeout = errors.New("The build timed out, please try again later.")
+
 // A Queue for esbuild
 type BuildQueue struct {
 	lock         sync.RWMutex
@@ -14,6 +24,10 @@ type BuildQueue struct {
 	tasks        map[string]*queueTask
 	processes    []*queueTask
 	maxProcesses int
+	// the max number of the tasks in the queue, see `tryAdd`
+	maxDepth int
+	// persistent queue stores the tasks in the db, see `newPersistentBuildQueue`
+	persistent bool
 }
 
 type BuildQueueConsumer struct {
@@ -46,22 +60,26 @@ func (t *queueTask) run() BuildOutput {
 	case output = <-c:
 		if output.err == nil {
 			log.Infof("build %s done in %v", t.ID(), time.Since(t.startTime))
+			atomic.AddInt64(&buildsTotal, 1)
+			lastBuildAt.Store(time.Now())
 		} else {
 			log.Errorf("build %s error: %v", t.ID(), output.err)
 		}
 	case <-time.After(5 * time.Minute):
 		log.Errorf("build %s: timeout(%v)", t.ID(), time.Since(t.startTime))
-		output = BuildOutput{err: fmt.Errorf("build ")}
+		output = BuildOutput{err: ErrBuildTimeout}
 	}
+	recordBuildError(t.ID(), t.stage, output.err)
 
 	return output
 }
 
-func newBuildQueue(maxProcesses int) *BuildQueue {
+func newBuildQueue(maxProcesses int, maxDepth int) *BuildQueue {
 	q := &BuildQueue{
 		list:         list.New(),
 		tasks:        map[string]*queueTask{},
 		maxProcesses: maxProcesses,
+		maxDepth:     maxDepth,
 	}
 	return q
 }
@@ -74,33 +92,104 @@ func (q *BuildQueue) Len() int {
 	return q.list.Len()
 }
 
-// Add adds a new build task.
+// Stats returns the number of the pending tasks and the running tasks of the queue.
+func (q *BuildQueue) Stats() (pending int, running int) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	running = len(q.processes)
+	return q.list.Len() - running, running
+}
+
+// Add adds a new build task, the consumer receives `ErrQueueFull` if the queue is full.
 func (q *BuildQueue) Add(task *BuildTask) *BuildQueueConsumer {
 	c := &BuildQueueConsumer{make(chan BuildOutput, 1)}
+	if !q.add(task, c) {
+		c.C <- BuildOutput{err: ErrQueueFull}
+	}
+	return c
+}
+
+// tryAdd adds a new build task without a consumer for the background builds, it
+// returns false if the queue is full.
+func (q *BuildQueue) tryAdd(task *BuildTask) bool {
+	return q.add(task, nil)
+}
+
+// add adds the task with the consumer (can be nil), the new task is rejected if the
+// number of the tasks reaches the max depth, the existing task is always accepted.
+func (q *BuildQueue) add(task *BuildTask, c *BuildQueueConsumer) bool {
 	q.lock.Lock()
 	t, ok := q.tasks[task.ID()]
-	if ok {
+	if ok && c != nil {
 		t.consumers = append(t.consumers, c)
 	}
+	if ok && task.Priority > t.Priority {
+		q.reprioritize(t, task.Priority)
+	}
+	full := !ok && q.maxDepth > 0 && q.list.Len() >= q.maxDepth
 	q.lock.Unlock()
 
 	if ok {
-		return c
+		return true
+	}
+	if full {
+		return false
 	}
 
 	t = &queueTask{
 		BuildTask:  task,
 		createTime: time.Now(),
-		consumers:  []*BuildQueueConsumer{c},
+	}
+	if c != nil {
+		t.consumers = []*BuildQueueConsumer{c}
 	}
 	q.lock.Lock()
-	t.el = q.list.PushBack(t)
+	q.insert(t)
 	q.tasks[task.ID()] = t
 	q.lock.Unlock()
 
+	q.persist(task, queueStatusPending)
+	broadcastBuildEvent(task.ID(), map[string]interface{}{"event": "queued"})
 	q.next()
 
-	return c
+	return true
+}
+
+// Priority changes the priority of the pending task to move it in the queue, it
+// returns false if the task is not found or in process.
+func (q *BuildQueue) Priority(id string, p int) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	t, ok := q.tasks[id]
+	if !ok || t.inProcess {
+		return false
+	}
+	q.reprioritize(t, p)
+	return true
+}
+
+// insert inserts the task before the pending tasks with a lower priority, the tasks
+// with the same priority are built in FIFO order. The caller must hold the lock.
+func (q *BuildQueue) insert(t *queueTask) {
+	for el := q.list.Front(); el != nil; el = el.Next() {
+		if _t, ok := el.Value.(*queueTask); ok && !_t.inProcess && _t.Priority < t.Priority {
+			t.el = q.list.InsertBefore(t, el)
+			return
+		}
+	}
+	t.el = q.list.PushBack(t)
+}
+
+// reprioritize moves the pending task by the new priority, the caller must hold the lock.
+func (q *BuildQueue) reprioritize(t *queueTask, p int) {
+	if t.inProcess {
+		return
+	}
+	t.Priority = p
+	q.list.Remove(t.el)
+	q.insert(t)
 }
 
 func (q *BuildQueue) RemoveConsumer(task *BuildTask, c *BuildQueueConsumer) {
@@ -144,6 +233,8 @@ func (q *BuildQueue) next() {
 	q.processes = append(q.processes, nextTask)
 	q.lock.Unlock()
 
+	q.persist(nextTask.BuildTask, queueStatusRunning)
+
 	go q.wait(nextTask)
 }
 
@@ -166,10 +257,22 @@ func (q *BuildQueue) wait(t *queueTask) {
 	delete(q.tasks, t.ID())
 	q.lock.Unlock()
 
+	if output.err != nil {
+		q.persist(t.BuildTask, queueStatusFailed)
+	} else {
+		q.persist(t.BuildTask, queueStatusDone)
+	}
+
 	// call next task
 	q.next()
 
 	for _, c := range t.consumers {
 		c.C <- output
 	}
+
+	if output.err != nil {
+		broadcastBuildEvent(t.ID(), map[string]interface{}{"event": "error", "message": output.err.Error()})
+	} else {
+		broadcastBuildEvent(t.ID(), map[string]interface{}{"event": "done", "esm": output.esm})
+	}
 }
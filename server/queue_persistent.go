@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+)
+
+// the states of the tasks in the persistent build queue
+const (
+	queueStatusPending = "pending"
+	queueStatusRunning = "running"
+	queueStatusDone    = "done"
+	queueStatusFailed  = "failed"
+)
+
+// newPersistentBuildQueue creates a build queue backed by the db, the tasks are
+// stored as `queue:<id>` until they are processed, and the states of the pending and
+// running tasks are tracked as `queue:status:<id>`. The unprocessed tasks are
+// re-enqueued by `restore` after the server restarts.
+func newPersistentBuildQueue(maxProcesses int, maxDepth int) *BuildQueue {
+	q := newBuildQueue(maxProcesses, maxDepth)
+	q.persistent = true
+	return q
+}
+
+// persist stores the task and its state in the db, the task and its state are
+// removed from the db when it's done or failed.
+func (q *BuildQueue) persist(task *BuildTask, status string) {
+	if !q.persistent {
+		return
+	}
+	id := task.ID()
+	var err error
+	switch status {
+	case queueStatusPending:
+		err = db.Put("queue:"+id, "queue", storage.Store{
+			"id":   id,
+			"task": string(utils.MustEncodeJSON(task)),
+		})
+	case queueStatusDone, queueStatusFailed:
+		err = db.Delete("queue:" + id)
+		if err == nil {
+			err = db.Delete("queue:status:" + id)
+		}
+	}
+	if err == nil && (status == queueStatusPending || status == queueStatusRunning) {
+		err = db.Put("queue:status:"+id, "queue-status", storage.Store{
+			"id":     id,
+			"status": status,
+			"time":   time.Now().Format(time.RFC3339),
+		})
+	}
+	if err != nil {
+		log.Errorf("persist queue task(%s): %v", id, err)
+	}
+}
+
+// restore re-enqueues the unprocessed tasks of the current build version stored in
+// the db, and cleans up the states of the finished tasks that are left by the previous
+// versions of the server.
+func (q *BuildQueue) restore() {
+	if !q.persistent {
+		return
+	}
+
+	list, err := db.List("queue-status")
+	if err != nil {
+		log.Errorf("restore build queue: %v", err)
+		return
+	}
+	for _, item := range list {
+		status := item.Store["status"]
+		if status == queueStatusDone || status == queueStatusFailed {
+			db.Delete("queue:status:" + item.Store["id"])
+		}
+	}
+
+	list, err = db.List("queue")
+	if err != nil {
+		log.Errorf("restore build queue: %v", err)
+		return
+	}
+	n := 0
+	for _, item := range list {
+		id := item.Store["id"]
+		var task BuildTask
+		err := json.Unmarshal([]byte(item.Store["task"]), &task)
+		if err != nil || task.BuildVersion != VERSION {
+			// drop the broken tasks and the tasks of the previous build versions
+			db.Delete("queue:" + id)
+			db.Delete("queue:status:" + id)
+			continue
+		}
+		task.stage = "init"
+		if !q.tryAdd(&task) {
+			// the rest tasks are kept in the db for the next restart
+			log.Warnf("restore build queue: %v", ErrQueueFull)
+			break
+		}
+		n++
+	}
+	if n > 0 {
+		log.Infof("restore build queue: %d tasks re-enqueued", n)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"esm.sh/server/storage"
+)
+
+func TestPersistentBuildQueue(t *testing.T) {
+	var err error
+	db, err = storage.OpenDB(fmt.Sprintf("postdb:%s", path.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	q := newPersistentBuildQueue(1, defaultBuildQueueMaxDepth)
+	task := &BuildTask{
+		BuildVersion: VERSION,
+		Pkg:          Pkg{Name: "react", Version: "17.0.2"},
+		Target:       "es2021",
+	}
+	q.persist(task, queueStatusPending)
+	list, err := db.List("queue")
+	if err != nil || len(list) != 1 || list[0].Store["id"] != task.ID() {
+		t.Fatalf("the pending task should be stored: %v %v", list, err)
+	}
+	if store, _, err := db.Get("queue:status:" + task.ID()); err != nil || store["status"] != queueStatusPending {
+		t.Fatalf("unexpected task status: %v %v", store, err)
+	}
+
+	q.persist(task, queueStatusRunning)
+	if store, _, err := db.Get("queue:status:" + task.ID()); err != nil || store["status"] != queueStatusRunning {
+		t.Fatalf("unexpected task status: %v %v", store, err)
+	}
+
+	q.persist(task, queueStatusDone)
+	if _, _, err := db.Get("queue:" + task.ID()); err != storage.ErrNotFound {
+		t.Fatalf("the done task should be removed: %v", err)
+	}
+	if _, _, err := db.Get("queue:status:" + task.ID()); err != storage.ErrNotFound {
+		t.Fatalf("the status of the done task should be removed: %v", err)
+	}
+
+	// the states of the finished tasks left by the previous versions are cleaned up
+	db.Put("queue:status:"+task.ID(), "queue-status", storage.Store{"id": task.ID(), "status": queueStatusFailed})
+	q.restore()
+	if _, _, err := db.Get("queue:status:" + task.ID()); err != storage.ErrNotFound {
+		t.Fatalf("the status of the failed task should be removed: %v", err)
+	}
+}
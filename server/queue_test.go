@@ -0,0 +1,89 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildQueueMaxDepth(t *testing.T) {
+	// no processes to keep the tasks in the queue
+	q := newBuildQueue(0, 2)
+	newTask := func(name string) *BuildTask {
+		return &BuildTask{
+			BuildVersion: VERSION,
+			Pkg:          Pkg{Name: name, Version: "1.0.0"},
+			Target:       "es2021",
+		}
+	}
+
+	if !q.tryAdd(newTask("a")) || !q.tryAdd(newTask("b")) {
+		t.Fatal("the tasks should be added")
+	}
+	if q.tryAdd(newTask("c")) {
+		t.Fatal("the task should be rejected when the queue is full")
+	}
+	// the existing task is always accepted
+	if !q.tryAdd(newTask("a")) {
+		t.Fatal("the existing task should be accepted")
+	}
+	if q.Len() != 2 {
+		t.Fatalf("unexpected queue length: %d", q.Len())
+	}
+
+	c := q.Add(newTask("c"))
+	select {
+	case output := <-c.C:
+		if output.err != ErrQueueFull {
+			t.Fatalf("unexpected error: %v", output.err)
+		}
+	default:
+		t.Fatal("the consumer should receive the ErrQueueFull")
+	}
+
+	c = q.Add(newTask("b"))
+	select {
+	case output := <-c.C:
+		t.Fatalf("unexpected output: %v", output)
+	default:
+	}
+}
+
+func TestBuildQueuePriority(t *testing.T) {
+	// no processes to keep the tasks in the queue
+	q := newBuildQueue(0, 0)
+	newTask := func(name string, priority int) *BuildTask {
+		return &BuildTask{
+			BuildVersion: VERSION,
+			Pkg:          Pkg{Name: name, Version: "1.0.0"},
+			Target:       "es2021",
+			Priority:     priority,
+		}
+	}
+	order := func() (names []string) {
+		for el := q.list.Front(); el != nil; el = el.Next() {
+			names = append(names, el.Value.(*queueTask).Pkg.Name)
+		}
+		return
+	}
+
+	q.tryAdd(newTask("a", 0))
+	q.tryAdd(newTask("b", 0))
+	q.tryAdd(newTask("c", 1))
+	q.tryAdd(newTask("d", -1))
+	q.tryAdd(newTask("e", 1))
+	if ret := strings.Join(order(), ","); ret != "c,e,a,b,d" {
+		t.Fatalf("unexpected order: %s", ret)
+	}
+
+	if !q.Priority(newTask("d", 0).ID(), 2) {
+		t.Fatal("the task should be found")
+	}
+	// the existing task is boosted by the higher priority
+	q.tryAdd(newTask("b", 1))
+	if ret := strings.Join(order(), ","); ret != "d,c,e,b,a" {
+		t.Fatalf("unexpected order: %s", ret)
+	}
+	if q.Priority(newTask("f", 0).ID(), 1) {
+		t.Fatal("the task should not be found")
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// serveRawFile serves the original file of the npm package without building, the
+// package is installed and the file is read by `readPackageFile`, so the installed
+// packages and the files are shared with the `+file` API.
+func serveRawFile(ctx *rex.Context, pkg *Pkg, isDev bool) interface{} {
+	filename, err := resolveRawFile(pkg, isDev)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+
+	if hasDotfile(filename) {
+		return throwAPIError(ctx, 403, APIError{Code: errCodeForbidden, Message: fmt.Sprintf("File '%s' is not allowed", filename)})
+	}
+
+	file, err := readPackageFile(pkg, filename)
+	if err != nil {
+		return throwPackageFileError(ctx, filename, err)
+	}
+
+	ctx.SetHeader("Content-Type", rawContentType(file.name))
+	if regFullVersion.MatchString(pkg.Version) {
+		ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		ctx.SetHeader("Cache-Control", "public, max-age=600")
+	}
+	return file.content()
+}
+
+// resolveRawFile resolves the file of the `?raw` request in the installed package,
+// the main file is used if no submodule is specified and the dev/prod variant of the
+// file is chosen by `switchNodeEnvFile`. The result is cached for 24 hours.
+func resolveRawFile(pkg *Pkg, isDev bool) (filename string, err error) {
+	key := fmt.Sprintf("raw-file:%s@%s/%s?dev=%v", pkg.Name, pkg.Version, pkg.Submodule, isDev)
+	data, err := cache.Get(key)
+	if err == nil && len(data) > 0 {
+		return string(data), nil
+	}
+
+	err = withInstalledPackage(pkg, func(pkgDir string) error {
+		filename = pkg.Submodule
+		if filename == "" {
+			var p NpmPackage
+			err := utils.ParseJSONFile(path.Join(pkgDir, "package.json"), &p)
+			if err != nil {
+				return err
+			}
+			np := fixNpmPackage(p, pkgDir)
+			filename = np.Main
+			if filename == "" {
+				filename = "index.js"
+			}
+		}
+		filename = strings.TrimPrefix(utils.CleanPath(filename), "/")
+		if dirExists(path.Join(pkgDir, filename)) {
+			filename = path.Join(filename, "index.js")
+		} else if !fileExists(path.Join(pkgDir, filename)) && fileExists(path.Join(pkgDir, filename+".js")) {
+			filename += ".js"
+		}
+		filename = switchNodeEnvFile(pkgDir, filename, isDev)
+		return nil
+	})
+	if err == nil {
+		cache.Set(key, []byte(filename), 24*time.Hour)
+	}
+	return
+}
+
+// rawContentType returns the content type of the original file of the package
+func rawContentType(filename string) string {
+	var contentType string
+	switch ext := path.Ext(filename); ext {
+	case ".js", ".mjs", ".cjs":
+		contentType = "text/javascript; charset=utf-8"
+	case ".ts", ".mts", ".tsx":
+		contentType = "application/typescript; charset=utf-8"
+	default:
+		contentType = mime.TypeByExtension(ext)
+	}
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	return contentType
+}
+
+// switchNodeEnvFile switches the file like `cjs/react.production.min.js` to
+// `cjs/react.development.js` in dev mode, and vice versa.
+func switchNodeEnvFile(pkgDir string, filename string, isDev bool) string {
+	var candidates []string
+	if isDev {
+		for _, s := range []string{".production.min.", ".production."} {
+			if strings.Contains(filename, s) {
+				candidates = append(candidates, strings.Replace(filename, s, ".development.", 1))
+			}
+		}
+	} else if strings.Contains(filename, ".development.") {
+		for _, s := range []string{".production.min.", ".production."} {
+			candidates = append(candidates, strings.Replace(filename, ".development.", s, 1))
+		}
+	}
+	for _, candidate := range candidates {
+		if fileExists(path.Join(pkgDir, candidate)) {
+			return candidate
+		}
+	}
+	return filename
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// the ways how the specifiers are resolved by the `+resolve` API besides the ones of
+// `resolveDependency`
+const (
+	resolvedViaRemote    = "remote"
+	resolvedViaSubmodule = "submodule"
+	resolvedViaBuiltin   = "builtin"
+)
+
+type resolveResult struct {
+	Specifier string `json:"specifier"`
+	From      string `json:"from"`
+	Resolved  string `json:"resolved"`
+	Via       string `json:"via"`
+	Version   string `json:"version,omitempty"`
+}
+
+// serveResolve serves how the bare specifier imported by the package of the `from`
+// query is resolved by the build, like `/v87/+resolve?specifier=react&from=react-dom@18.2.0`.
+// The `deps`, `external` and `target` queries are applied as the build does. The
+// result is cached for 1 hour.
+func serveResolve(ctx *rex.Context) interface{} {
+	specifier := strings.TrimSpace(ctx.Form.Value("specifier"))
+	if specifier == "" {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing specifier query"})
+	}
+	from, err := parsePkg(ctx.Form.Value("from"))
+	if err != nil {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeInvalidPackage, Message: fmt.Sprintf("Invalid from query: %v", err)})
+	}
+	target := normalizeTarget(strings.ToLower(ctx.Form.Value("target")))
+	if target == "" {
+		target = getTargetByUA(ctx.R.UserAgent())
+	} else if !isValidTarget(target) {
+		return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: fmt.Sprintf("Invalid target: %s", target)})
+	}
+	deps := PkgSlice{}
+	for _, p := range strings.Split(ctx.Form.Value("deps"), ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			m, err := parsePkg(p)
+			if err != nil {
+				return throwAPIError(ctx, 400, APIError{Code: errCodeInvalidPackage, Message: fmt.Sprintf("Invalid deps query: %v", err)})
+			}
+			deps = append(deps, *m)
+		}
+	}
+	external := []string{}
+	for _, name := range strings.Split(ctx.Form.Value("external"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			external = append(external, name)
+		}
+	}
+
+	task := &BuildTask{
+		BuildVersion: VERSION,
+		Pkg:          *from,
+		Deps:         deps,
+		External:     external,
+		Target:       target,
+	}
+	key := fmt.Sprintf("resolve:%s:%s:%s:%s", specifier, task.ID(), deps.String(), strings.Join(external, ","))
+	data, err := cache.Get(key)
+	if err != nil {
+		ret, err := resolveSpecifier(task, specifier)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		if ret == nil {
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("Could not resolve \"%s\" (Imported by \"%s\")", specifier, from.Name)})
+		}
+		data = utils.MustEncodeJSON(ret)
+		cache.Set(key, data, time.Hour)
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=3600")
+	return data
+}
+
+// resolveSpecifier installs the package of the task in a temporary directory and
+// resolves the specifier like the build does, but the dependencies are not built.
+// It returns nil if the specifier can't be resolved.
+func resolveSpecifier(task *BuildTask, specifier string) (*resolveResult, error) {
+	ret := &resolveResult{
+		Specifier: specifier,
+		From:      fmt.Sprintf("%s@%s", task.Pkg.Name, task.Pkg.Version),
+	}
+	if isRemoteImport(specifier) {
+		ret.Resolved, ret.Via = specifier, resolvedViaRemote
+		return ret, nil
+	}
+	if strings.HasPrefix(specifier, task.Pkg.Name+"/") {
+		ret.Resolved = task.getImportPath(Pkg{
+			Name:      task.Pkg.Name,
+			Version:   task.Pkg.Version,
+			Submodule: strings.TrimPrefix(specifier, task.Pkg.Name+"/"),
+		}, true)
+		ret.Via, ret.Version = resolvedViaSubmodule, task.Pkg.Version
+		return ret, nil
+	}
+	if builtInNodeModules[specifier] {
+		importPath, version, err := task.getBuiltInPolyfillPath(specifier)
+		if err != nil || importPath == "" {
+			return nil, err
+		}
+		ret.Resolved, ret.Via, ret.Version = importPath, resolvedViaBuiltin, version
+		return ret, nil
+	}
+
+	task.wd = tempDir(fmt.Sprintf("esm-resolve-%s", rs.Hex.String(16)))
+	err := ensureDir(task.wd)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(task.wd)
+
+	err = retryYarnAdd(task.wd, fmt.Sprintf("%s@%s", task.Pkg.Name, task.Pkg.Version), 3, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	packageFile, err := findPackageJSON(task.wd, task.Pkg.Name)
+	if err != nil {
+		return nil, err
+	}
+	var p NpmPackage
+	err = utils.ParseJSONFile(packageFile, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	dep, err := task.resolveDependency(specifier, &ESM{NpmPackage: &p})
+	if err != nil || dep == nil {
+		return nil, err
+	}
+	ret.Via = dep.Via
+	if dep.Pkg == nil {
+		ret.Resolved = specifier
+	} else {
+		ret.Resolved = task.getImportPath(*dep.Pkg, false)
+		ret.Version = dep.Pkg.Version
+	}
+	return ret, nil
+}
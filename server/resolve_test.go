@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestResolveSpecifier(t *testing.T) {
+	task := &BuildTask{
+		BuildVersion: 87,
+		Pkg:          Pkg{Name: "react-dom", Version: "18.2.0"},
+		Target:       "es2022",
+	}
+	for _, c := range []struct {
+		specifier string
+		resolved  string
+		via       string
+	}{
+		{"https://cdn.skypack.dev/react", "https://cdn.skypack.dev/react", resolvedViaRemote},
+		{"react-dom/client", "/v87/react-dom@18.2.0/es2022/client.js", resolvedViaSubmodule},
+		{"buffer", "/v87/node_buffer.js", resolvedViaBuiltin},
+	} {
+		ret, err := resolveSpecifier(task, c.specifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret == nil || ret.Resolved != c.resolved || ret.Via != c.via {
+			t.Fatalf("unexpected result of '%s': %v", c.specifier, ret)
+		}
+	}
+}
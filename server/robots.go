@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ije/rex"
+)
+
+// robotsAllowedPaths are the paths allowed to be crawled by the search engines, the
+// landing page and the assets of the documentation.
+var robotsAllowedPaths = []string{
+	"/$",
+	"/embed/",
+}
+
+// robotsTxt returns the `robots.txt` that guides the crawlers away from the build
+// artifacts, the paths of the current build version are disallowed explicitly.
+func robotsTxt() string {
+	buf := strings.Builder{}
+	buf.WriteString("User-agent: *\n")
+	for _, p := range robotsAllowedPaths {
+		fmt.Fprintf(&buf, "Allow: %s\n", p)
+	}
+	fmt.Fprintf(&buf, "Disallow: /v%d/\n", VERSION)
+	buf.WriteString("Disallow: /v*/\n")
+	buf.WriteString("Disallow: /\n")
+	return buf.String()
+}
+
+// serveRobotsTxt serves the `/robots.txt`
+func serveRobotsTxt(ctx *rex.Context) interface{} {
+	ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return robotsTxt()
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRobotsTxt(t *testing.T) {
+	txt := robotsTxt()
+	if !strings.HasPrefix(txt, "User-agent: *\n") {
+		t.Fatalf("unexpected robots.txt: %s", txt)
+	}
+	for _, line := range []string{"Allow: /$", "Allow: /embed/", fmt.Sprintf("Disallow: /v%d/", VERSION), "Disallow: /v*/", "Disallow: /"} {
+		if !strings.Contains(txt, line+"\n") {
+			t.Fatalf("missing '%s' in robots.txt: %s", line, txt)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+type securityPolicy struct {
+	Contact string `json:"contact,omitempty"`
+	Policy  string `json:"policy,omitempty"`
+}
+
+var regEmail = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+
+// serveSecurityPolicy serves the security contact and the `SECURITY.md` of the package,
+// the result is cached in the fs at `security/<pkg>@<version>.json` for 24 hours.
+func serveSecurityPolicy(ctx *rex.Context, pkg *Pkg) interface{} {
+	savePath := path.Join("security", pkg.Name+"@"+pkg.Version+".json")
+	exists, modtime, err := fs.Exists(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if !exists || time.Since(modtime) > 24*time.Hour {
+		policy, err := readSecurityPolicy(pkg)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		err = fs.WriteData(savePath, utils.MustEncodeJSON(policy))
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		modtime = time.Now()
+	}
+	r, err := fs.ReadFile(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if string(data) == "{}" {
+		return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: fmt.Sprintf("No security policy found in %s@%s", pkg.Name, pkg.Version)})
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return rex.Content(savePath, modtime, bytes.NewReader(data))
+}
+
+// readSecurityPolicy installs the package and reads the `SECURITY.md` file and the
+// non-standard `security` field of package.json, the field can be a string like
+// `security@example.com` or an object with the `email` or `url` field. The first
+// email address in the policy is used as the contact if the field is not defined.
+func readSecurityPolicy(pkg *Pkg) (policy securityPolicy, err error) {
+	wd := tempDir(fmt.Sprintf("esm-security-%s", rs.Hex.String(16)))
+	err = ensureDir(wd)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(wd)
+
+	err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version), 3, time.Second)
+	if err != nil {
+		return
+	}
+
+	packageFile, err := findPackageJSON(wd, pkg.Name)
+	if err != nil {
+		return
+	}
+	var p struct {
+		Security interface{} `json:"security"`
+	}
+	if utils.ParseJSONFile(packageFile, &p) == nil {
+		policy.Contact = securityContact(p.Security)
+	}
+
+	pkgDir := path.Dir(packageFile)
+	entries, err := ioutil.ReadDir(pkgDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if !entry.IsDir() && (name == "security.md" || name == "security.txt" || name == "security") {
+			var content []byte
+			content, err = ioutil.ReadFile(path.Join(pkgDir, entry.Name()))
+			if err != nil {
+				return
+			}
+			policy.Policy = strings.TrimSpace(string(content))
+			break
+		}
+	}
+	if policy.Contact == "" && policy.Policy != "" {
+		policy.Contact = regEmail.FindString(policy.Policy)
+	}
+	return
+}
+
+// securityContact returns the contact of the `security` field of package.json
+func securityContact(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return strings.TrimSpace(s)
+	case map[string]interface{}:
+		for _, key := range []string{"email", "url", "contact"} {
+			if v, ok := s[key].(string); ok && strings.TrimSpace(v) != "" {
+				return strings.TrimSpace(v)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSecurityContact(t *testing.T) {
+	for field, expected := range map[string]string{
+		`"security@example.com"`:                            "security@example.com",
+		`{"email":"security@example.com"}`:                  "security@example.com",
+		`{"url":"https://example.com/security"}`:            "https://example.com/security",
+		`{"email":"","url":"https://example.com/security"}`: "https://example.com/security",
+		`null`:                     "",
+		`["security@example.com"]`: "",
+	} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(field), &v); err != nil {
+			t.Fatal(err)
+		}
+		if contact := securityContact(v); contact != expected {
+			t.Fatalf("securityContact(%s) should be %q, but got %q", field, expected, contact)
+		}
+	}
+	if email := regEmail.FindString("Please report to <security@example.com>."); email != "security@example.com" {
+		t.Fatalf("unexpected email: %s", email)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var regSemverComparator = regexp.MustCompile(`^(\^|~|>=|<=|>|<|=)?\s*v?([0-9x*]+)(?:\.([0-9x*]+))?(?:\.([0-9x*]+))?(?:[-+].*)?$`)
+
+// satisfiesVersion checks whether the version satisfies the semver range like
+// `>=14`, `^12.20.0 || >=14.13.1` or `10 - 14`, see https://github.com/npm/node-semver#ranges.
+// The prerelease tags are ignored, and it returns true if the range is invalid since
+// we can't tell it's unsatisfied.
+func satisfiesVersion(version string, rng string) bool {
+	v, ok := parseVersionTuple(version)
+	if !ok {
+		return true
+	}
+	for _, set := range strings.Split(rng, "||") {
+		set = strings.TrimSpace(set)
+		if set == "" || set == "*" || set == "x" {
+			return true
+		}
+		// hyphen range `a - b`
+		if a := strings.Split(set, " - "); len(a) == 2 {
+			set = ">=" + strings.TrimSpace(a[0]) + " <=" + strings.TrimSpace(a[1])
+		}
+		// join the operator and the version like `>= 14`
+		for _, op := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			set = strings.ReplaceAll(set, op+" ", op)
+		}
+		matched := true
+		for _, comparator := range strings.Fields(set) {
+			ok, valid := satisfiesComparator(v, comparator)
+			if !valid {
+				return true
+			}
+			if !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func satisfiesComparator(v [3]int, comparator string) (ok bool, valid bool) {
+	m := regSemverComparator.FindStringSubmatch(comparator)
+	if m == nil {
+		return false, false
+	}
+	op := m[1]
+	// the number of the specified parts, the `x` and `*` are wildcards
+	var c [3]int
+	n := 0
+	for _, s := range m[2:5] {
+		if s == "" || s == "x" || s == "*" {
+			break
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return false, false
+		}
+		c[n] = i
+		n++
+	}
+	if n == 0 {
+		// `*` or `>=x`
+		return op != "<" && op != ">", true
+	}
+
+	cmp := compareVersionTuple(v, c, n)
+	switch op {
+	case "", "=":
+		return cmp == 0, true
+	case ">":
+		return cmp > 0, true
+	case ">=":
+		return cmp >= 0, true
+	case "<":
+		return cmp < 0, true
+	case "<=":
+		return cmp <= 0, true
+	case "~":
+		// `~1.2.3` := `>=1.2.3 <1.3.0`, `~1` := `>=1.0.0 <2.0.0`
+		if n == 1 {
+			return compareVersionTuple(v, c, 1) == 0, true
+		}
+		return cmp >= 0 && compareVersionTuple(v, c, 2) == 0, true
+	case "^":
+		// `^1.2.3` := `>=1.2.3 <2.0.0`, `^0.2.3` := `>=0.2.3 <0.3.0`, `^0.0.3` := `>=0.0.3 <0.0.4`
+		fixed := 1
+		if c[0] == 0 && n > 1 {
+			fixed = 2
+			if c[1] == 0 && n > 2 {
+				fixed = 3
+			}
+		}
+		return cmp >= 0 && compareVersionTuple(v, c, fixed) == 0, true
+	}
+	return false, false
+}
+
+// compareVersionTuple compares the first n parts of the versions
+func compareVersionTuple(a [3]int, b [3]int, n int) int {
+	for i := 0; i < n; i++ {
+		if a[i] > b[i] {
+			return 1
+		}
+		if a[i] < b[i] {
+			return -1
+		}
+	}
+	return 0
+}
+
+func parseVersionTuple(version string) (v [3]int, ok bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i > 0 {
+		version = version[:i]
+	}
+	a := strings.Split(version, ".")
+	if len(a) > 3 {
+		return
+	}
+	for i, s := range a {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return
+		}
+		v[i] = n
+	}
+	return v, true
+}
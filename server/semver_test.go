@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestSatisfiesVersion(t *testing.T) {
+	for _, c := range []struct {
+		version string
+		rng     string
+		ok      bool
+	}{
+		{"16.13.0", ">=14", true},
+		{"12.22.1", ">=14", false},
+		{"16.13.0", ">= 14.13.1", true},
+		{"14.13.0", "^12.20.0 || >=14.13.1", false},
+		{"12.22.1", "^12.20.0 || >=14.13.1", true},
+		{"16.13.0", "^14", false},
+		{"0.2.5", "^0.2.3", true},
+		{"0.3.0", "^0.2.3", false},
+		{"14.18.1", "~14.17", false},
+		{"14.17.6", "~14.17", true},
+		{"16.13.0", "10 - 14", false},
+		{"14.18.1", "10 - 14", true},
+		{"16.13.0", ">=12 <16", false},
+		{"16.13.0", "16.x", true},
+		{"16.13.0", "*", true},
+		{"16.13.0", "<=16", true},
+		{"16.13.0", ">16", false},
+		{"16.13.0", "invalid", true},
+	} {
+		if satisfiesVersion(c.version, c.rng) != c.ok {
+			t.Fatalf("satisfiesVersion(%q, %q) should be %v", c.version, c.rng, c.ok)
+		}
+	}
+}
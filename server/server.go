@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"embed"
 	"flag"
 	"fmt"
@@ -10,7 +11,9 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"esm.sh/server/storage"
 
@@ -19,14 +22,23 @@ import (
 )
 
 var (
-	cdnDomain  string
-	cache      storage.Cache
-	db         storage.DB
-	fs         storage.FS
-	buildQueue *BuildQueue
-	log        *logx.Logger
-	node       *Node
-	embedFS    EmbedFS
+	cdnDomain                  string
+	typescriptVersion          string
+	typescriptModuleResolution string
+	packageManager             string
+	yarnTimeout                time.Duration
+	verifyBuilds               bool
+	adminToken                 string
+	noCompress                 bool
+	trackExportsCoverage       bool
+	offlineMode                bool
+	cache                      storage.Cache
+	db                         storage.DB
+	fs                         storage.FS
+	buildQueue                 *BuildQueue
+	log                        *logx.Logger
+	node                       *Node
+	embedFS                    EmbedFS
 )
 
 type EmbedFS interface {
@@ -39,41 +51,67 @@ func Serve(efs EmbedFS) {
 		port             int
 		httpsPort        int
 		buildConcurrency int
+		queueMaxDepth    int
 		etcDir           string
 		cacheUrl         string
 		dbUrl            string
 		fsUrl            string
 		queueUrl         string
 		nodeServices     string
+		extraBuiltIn     string
+		extraPolyfilled  string
+		prefetchCount    int
 		logLevel         string
 		logDir           string
-		noCompress       bool
 		isDev            bool
 	)
 
 	flag.IntVar(&port, "port", 80, "http server port")
 	flag.IntVar(&httpsPort, "https-port", 0, "https(autotls) server port, default is disabled")
 	flag.StringVar(&cdnDomain, "cdn-domain", "", "cdn domain")
+	flag.StringVar(&typescriptVersion, "typescript-version", "*", "typescript version to resolve the `typesVersions` of package.json")
+	flag.StringVar(&typescriptModuleResolution, "typescript-module-resolution", "node", "typescript module resolution to resolve the imports of the declaration files, 'node', 'node16' or 'bundler' to follow the `exports` of package.json")
 	flag.StringVar(&etcDir, "etc-dir", ".esmd", "etc dir")
 	flag.StringVar(&cacheUrl, "cache", "", "cache config, default is 'memory:default'")
 	flag.StringVar(&dbUrl, "db", "", "database config, default is 'postdb:[etc-dir]/esm.db'")
 	flag.StringVar(&fsUrl, "fs", "", "filesystem config, default is 'local:[etc-dir]/storage'")
-	flag.StringVar(&queueUrl, "queue", "", "bulid queue config, default is 'chan:memory'")
+	flag.StringVar(&queueUrl, "queue", "", "bulid queue config, 'chan:memory' or 'db' to persist the tasks in the database, default is 'chan:memory'")
 	flag.IntVar(&buildConcurrency, "build-concurrency", runtime.NumCPU(), "maximum number of concurrent build task")
+	flag.IntVar(&queueMaxDepth, "build-queue-max-depth", defaultBuildQueueMaxDepth, "maximum number of tasks in the build queue, the new builds are rejected with 503 when the queue is full, 0 for no limit")
 	flag.StringVar(&nodeServices, "node-services", "", "node services")
+	flag.StringVar(&extraBuiltIn, "extra-builtin-modules", "", "custom built-in modules resolved to the CDN URLs, like 'my-global=https://cdn.example.com/my-global.js'")
+	flag.StringVar(&extraPolyfilled, "extra-polyfilled-builtin-modules", "", "custom polyfill packages of the built-in modules, like 'fs=memfs'")
+	flag.StringVar(&packageManager, "package-manager", "", "package manager to install packages, 'yarn', 'npm' or 'pnpm', default is detected")
+	flag.DurationVar(&yarnTimeout, "yarn-timeout", defaultYarnTimeout, "timeout of installing the packages by the package manager, the build fails with 504 when it's exceeded, 0 for no timeout")
 	flag.StringVar(&logDir, "log-dir", "", "log dir")
 	flag.StringVar(&logLevel, "log-level", "info", "log level")
+	flag.IntVar(&prefetchCount, "prefetch-popular-packages", 100, "number of the most popular packages to pre-build on startup, 0 to disable")
+	flag.BoolVar(&verifyBuilds, "verify-builds", false, "verify the checksum of the build files when reading them from the fs")
+	flag.StringVar(&adminToken, "admin-token", "", "the token to authorize the admin APIs like '+patch' in the 'Authorization' header, the '+patch' API is only available in dev mode if not set")
 	flag.BoolVar(&noCompress, "no-compress", false, "disable compression for text content")
+	flag.BoolVar(&trackExportsCoverage, "exports-coverage", false, "track the exports accessed by the '?exports=' query of the module requests in memory for the '+coverage' API, a developer aid")
+	flag.BoolVar(&offlineMode, "offline", false, "install the packages from the local yarn cache only without network access, the packages that are not cached fail with 503")
 	flag.BoolVar(&isDev, "dev", false, "run server in development mode")
+	err := envVarConfig(flag.CommandLine, os.LookupEnv)
+	if err != nil {
+		fmt.Printf("bad environment variable: %v\n", err)
+		os.Exit(1)
+	}
 	flag.Parse()
 
-	var err error
 	etcDir, err = filepath.Abs(etcDir)
 	if err != nil {
 		fmt.Printf("bad etc dir: %v\n", err)
 		os.Exit(1)
 	}
 
+	switch typescriptModuleResolution {
+	case "node", "node16", "bundler":
+	default:
+		fmt.Printf("bad typescript module resolution: %s\n", typescriptModuleResolution)
+		os.Exit(1)
+	}
+
 	if cacheUrl == "" {
 		cacheUrl = "memory:default"
 	}
@@ -124,7 +162,7 @@ func Serve(efs EmbedFS) {
 	if err != nil {
 		log.Fatalf("check nodejs env: %v", err)
 	}
-	log.Debugf("nodejs v%s installed, registry: %s, yarn: %s", node.version, node.npmRegistry, node.yarn)
+	log.Debugf("nodejs v%s installed, registry: %s, %s: %s", node.version, node.npmRegistry, node.packageManager, node.pmVersion)
 
 	storage.SetLogger(log)
 	storage.SetIsDev(isDev)
@@ -144,7 +182,21 @@ func Serve(efs EmbedFS) {
 		log.Fatalf("init storage(fs,%s): %v", fsUrl, err)
 	}
 
-	buildQueue = newBuildQueue(buildConcurrency)
+	if queueUrl == "db" {
+		buildQueue = newPersistentBuildQueue(buildConcurrency, queueMaxDepth)
+	} else {
+		buildQueue = newBuildQueue(buildConcurrency, queueMaxDepth)
+	}
+
+	extraBuiltInMap, err := parseModulesMap(extraBuiltIn)
+	if err != nil {
+		log.Fatalf("parse extra built-in modules: %v", err)
+	}
+	extraPolyfilledMap, err := parseModulesMap(extraPolyfilled)
+	if err != nil {
+		log.Fatalf("parse extra polyfilled built-in modules: %v", err)
+	}
+	registerBuiltInModules(extraBuiltInMap, extraPolyfilledMap)
 
 	var accessLogger *logx.Logger
 	if logDir == "" {
@@ -180,6 +232,27 @@ func Serve(efs EmbedFS) {
 		}
 	}()
 
+	// sync the latest version of deno std daily
+	go syncDenoStdVersion(24 * time.Hour)
+
+	// retry to store the build records failed to store in the db
+	go reconcilePendingDBRecords(time.Minute)
+
+	// monitor the queue depth of the node services
+	go pollNodeServiceQueueDepth(5 * time.Second)
+
+	// when the node services process is ready, re-enqueue the unprocessed tasks of
+	// the persistent build queue, and pre-build the popular packages except in dev mode
+	go func() {
+		for atomic.LoadInt32(&nsHealthy) == 0 {
+			time.Sleep(time.Second)
+		}
+		buildQueue.restore()
+		if prefetchCount > 0 && !isDev {
+			prefetchPopularPackages(context.Background(), prefetchCount)
+		}
+	}()
+
 	if !noCompress {
 		rex.Use(rex.AutoCompress())
 	}
@@ -193,6 +266,7 @@ func Serve(efs EmbedFS) {
 			AllowHeaders:    []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding"},
 			MaxAge:          3600,
 		}),
+		limitRequestBody(),
 		query(isDev),
 	)
 
@@ -230,4 +304,6 @@ func Serve(efs EmbedFS) {
 func init() {
 	embedFS = &embed.FS{}
 	log = &logx.Logger{}
+	packageManager = "yarn"
+	yarnTimeout = defaultYarnTimeout
 }
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"esm.sh/server/storage"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// BuildSizes defines the raw and gzip sizes of a build
+type BuildSizes struct {
+	Raw  int `json:"raw"`
+	Gzip int `json:"gzip"`
+}
+
+// writeBuildSizes stores the sizes of the build code as `builds/<id>.sizes.json`
+func writeBuildSizes(task *BuildTask, code []byte) error {
+	buf := bytes.NewBuffer(nil)
+	gw, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	_, err = gw.Write(code)
+	if err == nil {
+		err = gw.Close()
+	}
+	if err != nil {
+		return err
+	}
+	sizes := BuildSizes{Raw: len(code), Gzip: buf.Len()}
+	return task.writeFile(path.Join("builds", strings.TrimSuffix(task.ID(), ".js")+".sizes.json"), utils.MustEncodeJSON(sizes))
+}
+
+func readBuildSizes(task *BuildTask) (sizes BuildSizes, err error) {
+	savePath := path.Join("builds", strings.TrimSuffix(task.ID(), ".js")+".sizes.json")
+	exists, _, err := fs.Exists(savePath)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = storage.ErrNotFound
+		return
+	}
+	r, err := fs.ReadFile(savePath)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &sizes)
+	return
+}
+
+// serveSizeCompare serves the `+compare?from=<version>&to=<version>` requests, it
+// compares the sizes of the builds of two versions. The builds are queued if they
+// are not found, then a 202 response is returned to retry later.
+func serveSizeCompare(ctx *rex.Context, pkg *Pkg) interface{} {
+	versions := [2]string{ctx.Form.Value("from"), ctx.Form.Value("to")}
+	sizes := [2]BuildSizes{}
+	pending := false
+	for i, version := range versions {
+		if version == "" {
+			return throwAPIError(ctx, 400, APIError{Code: errCodeBadRequest, Message: "Missing the `from` or `to` query"})
+		}
+		info, _, _, err := getPackageInfo("", pkg.Name, version)
+		if err != nil {
+			if strings.HasSuffix(err.Error(), "not found") {
+				return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: err.Error()})
+			}
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		task := newPkgAPIBuildTask(ctx, Pkg{Name: pkg.Name, Version: info.Version})
+		sizes[i], err = readBuildSizes(task)
+		if err == storage.ErrNotFound {
+			if !buildQueue.tryAdd(task) {
+				return throwQueueFullError(ctx)
+			}
+			pending = true
+		} else if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		versions[i] = info.Version
+	}
+	if pending {
+		ctx.SetHeader("Retry-After", "30")
+		return rex.Status(http.StatusAccepted, fmt.Sprintf("Building %s@%s and %s@%s, please retry later", pkg.Name, versions[0], pkg.Name, versions[1]))
+	}
+
+	ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", pkgCacheTimeout))
+	return map[string]interface{}{
+		"from":     sizes[0],
+		"to":       sizes[1],
+		"rawDiff":  sizes[1].Raw - sizes[0].Raw,
+		"gzipDiff": sizes[1].Gzip - sizes[0].Gzip,
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// the number of the successful builds since the server started
+	buildsTotal int64 = 0
+	// the time of the last successful build
+	lastBuildAt atomic.Value
+	// the number of the pending tasks in the node services channel, polled by
+	// `pollNodeServiceQueueDepth`
+	nsQueueDepth int64 = 0
+	// the duration from the task enqueued to the output received of the node
+	// service invocations
+	nsInvokeDuration = newHistogram([]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+)
+
+// pollNodeServiceQueueDepth updates the `nsQueueDepth` gauge by the interval, the
+// high queue depth indicates the node services are the bottleneck of the builds.
+func pollNodeServiceQueueDepth(interval time.Duration) {
+	for {
+		atomic.StoreInt64(&nsQueueDepth, int64(len(nsChannel)))
+		time.Sleep(interval)
+	}
+}
+
+// histogram counts the observed durations in the buckets like the prometheus
+// histogram, the bucket counts are cumulative.
+type histogram struct {
+	buckets []float64
+	counts  []int64
+	count   int64
+	// the sum of the observed durations in microseconds
+	sum int64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)),
+	}
+}
+
+// Observe records the duration
+func (h *histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	for i, le := range h.buckets {
+		if seconds <= le {
+			atomic.AddInt64(&h.counts[i], 1)
+		}
+	}
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, d.Microseconds())
+}
+
+// Snapshot returns the count, the sum in seconds and the cumulative bucket counts
+// keyed by the upper bounds like `0.5` and `+Inf`.
+func (h *histogram) Snapshot() map[string]interface{} {
+	count := atomic.LoadInt64(&h.count)
+	buckets := map[string]int64{"+Inf": count}
+	for i, le := range h.buckets {
+		buckets[strconv.FormatFloat(le, 'f', -1, 64)] = atomic.LoadInt64(&h.counts[i])
+	}
+	return map[string]interface{}{
+		"count":   count,
+		"sum":     float64(atomic.LoadInt64(&h.sum)) / 1e6,
+		"buckets": buckets,
+	}
+}
+
+// serveStatus serves the operational metrics of the server as JSON for the
+// monitoring tools.
+func serveStatus(startTime time.Time) interface{} {
+	pending, running := buildQueue.Stats()
+	storageAvailable := true
+	if err := fs.Ping(); err != nil {
+		log.Warnf("fs ping: %v", err)
+		storageAvailable = false
+	}
+	status := map[string]interface{}{
+		"version": fmt.Sprintf("v%d", VERSION),
+		"uptime":  int64(time.Since(startTime).Seconds()),
+		"buildQueue": map[string]int{
+			"pending": pending,
+			"running": running,
+		},
+		"nodeServiceHealthy": atomic.LoadInt32(&nsHealthy) == 1,
+		"nodeService": map[string]interface{}{
+			"queueDepth":     atomic.LoadInt64(&nsQueueDepth),
+			"invokeDuration": nsInvokeDuration.Snapshot(),
+		},
+		"storageAvailable": storageAvailable,
+		"totalBuilds":      atomic.LoadInt64(&buildsTotal),
+		"lastBuildAt":      nil,
+	}
+	if t, ok := lastBuildAt.Load().(time.Time); ok {
+		status["lastBuildAt"] = t.Format(time.RFC3339)
+	}
+	return status
+}
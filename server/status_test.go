@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{0.01, 0.1, 1})
+	for _, d := range []time.Duration{
+		5 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		2 * time.Second,
+	} {
+		h.Observe(d)
+	}
+	snapshot := h.Snapshot()
+	if snapshot["count"] != int64(4) {
+		t.Fatalf("unexpected count: %v", snapshot["count"])
+	}
+	if sum := snapshot["sum"].(float64); sum < 2.154 || sum > 2.156 {
+		t.Fatalf("unexpected sum: %v", sum)
+	}
+	buckets := snapshot["buckets"].(map[string]int64)
+	for le, n := range map[string]int64{"0.01": 1, "0.1": 3, "1": 3, "+Inf": 4} {
+		if buckets[le] != n {
+			t.Fatalf("bucket %s should be %d, but got %d", le, n, buckets[le])
+		}
+	}
+}
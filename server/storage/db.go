@@ -24,6 +24,9 @@ type DB interface {
 	Get(id string) (store Store, modtime time.Time, err error)
 	Put(id string, category string, store Store) error
 	List(category string) ([]ListItem, error)
+	// ListIDs returns the IDs of the records in the category that start with the prefix,
+	// the stores of the records are not read
+	ListIDs(category string, prefix string) ([]string, error)
 	Delete(id string) error
 	Close() error
 }
@@ -2,9 +2,11 @@ package storage
 
 import (
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/postui/postdb"
+	"github.com/postui/postdb/post"
 	"github.com/postui/postdb/q"
 )
 
@@ -68,6 +70,19 @@ func (i *postDB) List(category string) (list []ListItem, err error) {
 	return
 }
 
+func (i *postDB) ListIDs(category string, prefix string) (ids []string, err error) {
+	posts, err := i.db.List(q.Tags(category), q.Filter(func(p post.Post) bool {
+		return strings.HasPrefix(p.Alias, prefix)
+	}))
+	if err != nil {
+		return
+	}
+	for _, post := range posts {
+		ids = append(ids, post.Alias)
+	}
+	return
+}
+
 func (i *postDB) Delete(id string) error {
 	_, err := i.db.Delete(q.Alias(id))
 	return err
@@ -19,6 +19,8 @@ type FS interface {
 	ReadFile(path string) (content io.ReadSeekCloser, err error)
 	WriteFile(path string, r io.Reader) (written int64, err error)
 	WriteData(path string, data []byte) error
+	// Ping checks whether the storage is available
+	Ping() error
 }
 
 var fsDrivers = sync.Map{}
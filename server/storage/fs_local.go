@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"io"
 	"net/url"
 	"os"
@@ -70,6 +71,17 @@ func (fs *localFSLayer) WriteData(name string, data []byte) error {
 	return os.WriteFile(fullPath, data, 0666)
 }
 
+func (fs *localFSLayer) Ping() error {
+	fi, err := os.Stat(fs.root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", fs.root)
+	}
+	return nil
+}
+
 func ensureDir(dir string) (err error) {
 	_, err = os.Stat(dir)
 	if err != nil && os.IsNotExist(err) {
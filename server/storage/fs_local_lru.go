@@ -183,6 +183,10 @@ func (fs *localLRUFSLayer) WriteData(name string, data []byte) (err error) {
 	return
 }
 
+func (fs *localLRUFSLayer) Ping() error {
+	return fs.backingFS.Ping()
+}
+
 func init() {
 	RegisterFS("localLRU", &LocalLRUFS{})
 }
@@ -119,6 +119,10 @@ func (fs *s3FSLayer) WriteData(name string, data []byte) error {
 	return nil
 }
 
+func (fs *s3FSLayer) Ping() error {
+	return fs.s3Client.HeadBucket()
+}
+
 func init() {
 	RegisterFS("s3", &s3FS{})
 }
@@ -18,6 +18,7 @@ type SimpleS3Client interface {
 	Head(key *string) (*s3.HeadObjectOutput, error)
 	Get(key *string) (*s3.GetObjectOutput, error)
 	Put(key *string, body io.ReadSeeker) (*s3.PutObjectOutput, error)
+	HeadBucket() error
 }
 
 type SimpleS3ClientConfig struct {
@@ -120,3 +121,11 @@ func (c *simpleS3ClientImpl) Put(key *string, body io.ReadSeeker) (*s3.PutObject
 		Body:   body,
 	})
 }
+
+func (c *simpleS3ClientImpl) HeadBucket() error {
+	_, err := c.s3Client.HeadBucket(&s3.HeadBucketInput{
+		Bucket:              c.config.Bucket,
+		ExpectedBucketOwner: c.config.AccountId,
+	})
+	return err
+}
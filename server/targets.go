@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/esbuild-internal/compat"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// TargetInfo defines the build target in the `+targets` API
+type TargetInfo struct {
+	EsbuildTarget string `json:"esbuildTarget"`
+	Description   string `json:"description"`
+}
+
+var esbuildTargetNames = map[api.Target]string{
+	api.ES2015: "ES2015",
+	api.ES2016: "ES2016",
+	api.ES2017: "ES2017",
+	api.ES2018: "ES2018",
+	api.ES2019: "ES2019",
+	api.ES2020: "ES2020",
+	api.ES2021: "ES2021",
+	api.ESNext: "ESNext",
+}
+
+// the browsers listed in the descriptions of the targets
+var targetBrowsers = []struct {
+	name   string
+	engine compat.Engine
+}{
+	{"Chrome", compat.Chrome},
+	{"Edge", compat.Edge},
+	{"Firefox", compat.Firefox},
+	{"Safari", compat.Safari},
+	{"iOS", compat.IOS},
+}
+
+// the default loaders of esbuild by the file extension
+var esbuildDefaultLoaders = map[string]api.Loader{
+	".js":   api.LoaderJS,
+	".mjs":  api.LoaderJS,
+	".cjs":  api.LoaderJS,
+	".jsx":  api.LoaderJSX,
+	".ts":   api.LoaderTS,
+	".cts":  api.LoaderTS,
+	".mts":  api.LoaderTS,
+	".tsx":  api.LoaderTSX,
+	".css":  api.LoaderCSS,
+	".json": api.LoaderJSON,
+	".txt":  api.LoaderText,
+}
+
+var loaderNames = map[api.Loader]string{
+	api.LoaderJS:      "js",
+	api.LoaderJSX:     "jsx",
+	api.LoaderTS:      "ts",
+	api.LoaderTSX:     "tsx",
+	api.LoaderJSON:    "json",
+	api.LoaderText:    "text",
+	api.LoaderBase64:  "base64",
+	api.LoaderDataURL: "dataurl",
+	api.LoaderFile:    "file",
+	api.LoaderBinary:  "binary",
+	api.LoaderCSS:     "css",
+}
+
+var targetsJSON []byte
+var targetsOnce sync.Once
+
+// serveTargets serves the `/v{VERSION}/+targets` requests, it lists the build targets
+// with the minimum browser versions that support the syntax of the target by the
+// compat table of esbuild. The targets don't change without a new build version, so
+// the response is cached permanently.
+func serveTargets(ctx *rex.Context) interface{} {
+	targetsOnce.Do(func() {
+		infos := make(map[string]TargetInfo, len(targets))
+		for name, target := range targets {
+			info := TargetInfo{EsbuildTarget: esbuildTargetNames[target]}
+			switch name {
+			case "node":
+				info.Description = fmt.Sprintf("Node.js %s+, the built-in modules are not polyfilled", minEngineVersion(compat.Node, target))
+			case "deno":
+				info.Description = "Deno, the Node.js built-in modules are polyfilled by the Deno std library"
+			default:
+				browsers := make([]string, 0, len(targetBrowsers))
+				for _, b := range targetBrowsers {
+					if version := minEngineVersion(b.engine, target); version != "" {
+						browsers = append(browsers, b.name+" "+version+"+")
+					}
+				}
+				info.Description = strings.Join(browsers, ", ")
+			}
+			infos[name] = info
+		}
+		targetsJSON = utils.MustEncodeJSON(map[string]interface{}{"targets": infos})
+	})
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	return targetsJSON
+}
+
+// minEngineVersion returns the minimum version of the engine that supports all the
+// JS features of the target, the features without the data of the engine in the
+// compat table are ignored. It returns an empty string if the engine doesn't support
+// the target.
+func minEngineVersion(engine compat.Engine, target api.Target) string {
+	var required compat.JSFeature
+	for _, f := range jsFeatures {
+		required |= f
+	}
+	required &^= compat.UnsupportedJSFeatures(esConstraints(target))
+	required &^= compat.UnsupportedJSFeatures(map[compat.Engine][]int{engine: {1000}})
+
+	isSupported := func(version ...int) bool {
+		return compat.UnsupportedJSFeatures(map[compat.Engine][]int{engine: version})&required == 0
+	}
+	for major := 1; major < 1000; major++ {
+		if isSupported(major) {
+			// the versions in the compat table are at most `major.minor`, like `safari11.1`
+			for minor := 1; major > 1 && minor < 100; minor++ {
+				if isSupported(major-1, minor) {
+					return fmt.Sprintf("%d.%d", major-1, minor)
+				}
+			}
+			return fmt.Sprintf("%d", major)
+		}
+	}
+	return ""
+}
+
+// serveLoaders serves the `/v{VERSION}/+loaders` requests, it lists the loaders of
+// esbuild by the file extension used by the package builds.
+func serveLoaders(ctx *rex.Context) interface{} {
+	loaders := make(map[string]string, len(esbuildDefaultLoaders)+len(pkgLoaders))
+	for ext, loader := range esbuildDefaultLoaders {
+		loaders[ext] = loaderNames[loader]
+	}
+	for ext, loader := range pkgLoaders {
+		loaders[ext] = loaderNames[loader]
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	return utils.MustEncodeJSON(map[string]interface{}{"loaders": loaders})
+}
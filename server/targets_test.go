@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/esbuild-internal/compat"
+)
+
+func TestMinEngineVersion(t *testing.T) {
+	for _, c := range []struct {
+		engine   compat.Engine
+		target   api.Target
+		expected string
+	}{
+		{compat.Chrome, api.ES2015, "63"},
+		{compat.Safari, api.ES2015, "11.1"},
+		{compat.Node, api.ES2015, "13.2"},
+		{compat.Safari, api.ES2018, "12"},
+		{compat.Edge, api.ES2021, "85"},
+	} {
+		if version := minEngineVersion(c.engine, c.target); version != c.expected {
+			t.Fatalf("unexpected min version of %s for %s: %s", c.engine, esbuildTargetNames[c.target], version)
+		}
+	}
+}
+
+func TestLoaderNames(t *testing.T) {
+	for _, m := range []map[string]api.Loader{esbuildDefaultLoaders, pkgLoaders} {
+		for ext, loader := range m {
+			if loaderNames[loader] == "" {
+				t.Fatalf("missing name of the loader of '%s'", ext)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// tsconfigPaths defines the `compilerOptions.paths` of the `tsconfig.json` in the
+// package root, the targets are relative to the `baseUrl` (the package root by default).
+// see https://www.typescriptlang.org/tsconfig#paths
+type tsconfigPaths struct {
+	BaseURL string
+	Paths   map[string][]string
+}
+
+// readTsconfigPaths reads the `paths` of the `tsconfig.json` in the package root,
+// it returns nil if the package has no `tsconfig.json` or the `paths` is empty.
+func readTsconfigPaths(pkgDir string) (*tsconfigPaths, error) {
+	data, err := ioutil.ReadFile(path.Join(pkgDir, "tsconfig.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var tsconfig struct {
+		CompilerOptions struct {
+			BaseURL string              `json:"baseUrl"`
+			Paths   map[string][]string `json:"paths"`
+		} `json:"compilerOptions"`
+	}
+	err = json.Unmarshal(stripJSONComments(data), &tsconfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(tsconfig.CompilerOptions.Paths) == 0 {
+		return nil, nil
+	}
+	return &tsconfigPaths{
+		BaseURL: tsconfig.CompilerOptions.BaseURL,
+		Paths:   tsconfig.CompilerOptions.Paths,
+	}, nil
+}
+
+// match returns the candidate paths (relative to the package root) of the specifier
+// like `@utils/types` -> `src/utils/types` for `"@utils/*": ["src/utils/*"]`, the
+// pattern with the longest prefix wins like TypeScript does.
+func (c *tsconfigPaths) match(specifier string) []string {
+	var matched string
+	var wildcard string
+	for pattern := range c.Paths {
+		if pattern == specifier {
+			matched, wildcard = pattern, ""
+			break
+		}
+		a := strings.Split(pattern, "*")
+		if len(a) != 2 || !strings.HasPrefix(specifier, a[0]) || !strings.HasSuffix(specifier, a[1]) || len(specifier) < len(a[0])+len(a[1]) {
+			continue
+		}
+		if matched == "" || len(a[0]) > len(strings.Split(matched, "*")[0]) {
+			matched, wildcard = pattern, specifier[len(a[0]):len(specifier)-len(a[1])]
+		}
+	}
+	if matched == "" {
+		return nil
+	}
+	candidates := make([]string, 0, len(c.Paths[matched]))
+	for _, target := range c.Paths[matched] {
+		candidates = append(candidates, path.Join(c.BaseURL, strings.Replace(target, "*", wildcard, 1)))
+	}
+	return candidates
+}
+
+// stripJSONComments removes the comments and the trailing commas of the JSONC
+// content like `tsconfig.json`.
+func stripJSONComments(data []byte) []byte {
+	ret := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			ret = append(ret, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				ret = append(ret, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			ret = append(ret, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i+1 < len(data) && !(data[i] == '*' && data[i+1] == '/') {
+				i++
+			}
+			i++
+		case c == '}' || c == ']':
+			// remove the trailing comma
+			j := len(ret) - 1
+			for j >= 0 && (ret[j] == ' ' || ret[j] == '\t' || ret[j] == '\n' || ret[j] == '\r') {
+				j--
+			}
+			if j >= 0 && ret[j] == ',' {
+				ret = append(ret[:j], ret[j+1:]...)
+			}
+			ret = append(ret, c)
+		default:
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestTsconfigPaths(t *testing.T) {
+	pkgDir := t.TempDir()
+	tsconfig := `{
+  // the path aliases
+  "compilerOptions": {
+    "baseUrl": "./src", /* the sources */
+    "paths": {
+      "@utils/*": ["utils/*", "shared/*"],
+      "@utils/types/*": ["types/*"],
+      "~config": ["config.ts"],
+    },
+  },
+}`
+	err := ioutil.WriteFile(path.Join(pkgDir, "tsconfig.json"), []byte(tsconfig), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsPaths, err := readTsconfigPaths(pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for specifier, expected := range map[string][]string{
+		"@utils/fetch":      {"src/utils/fetch", "src/shared/fetch"},
+		"@utils/types/user": {"src/types/user"},
+		"~config":           {"src/config.ts"},
+		"@other/fetch":      nil,
+	} {
+		if candidates := tsPaths.match(specifier); !reflect.DeepEqual(candidates, expected) {
+			t.Fatalf("unexpected candidates of '%s': %v", specifier, candidates)
+		}
+	}
+
+	err = os.MkdirAll(path.Join(pkgDir, "src/shared"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(pkgDir, "src/shared/fetch.d.ts"), []byte("export {}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dtsFile, ok := resolveTsconfigPathsDts(pkgDir, tsPaths, "@utils/fetch", ".d.ts"); !ok || dtsFile != path.Join(pkgDir, "src/shared/fetch.d.ts") {
+		t.Fatalf("unexpected declaration file: %s", dtsFile)
+	}
+	if _, ok := resolveTsconfigPathsDts(pkgDir, tsPaths, "~config", ".d.ts"); ok {
+		t.Fatal("the declaration file of '~config' should not be found")
+	}
+
+	// no tsconfig.json
+	if tsPaths, err = readTsconfigPaths(t.TempDir()); tsPaths != nil || err != nil {
+		t.Fatalf("unexpected tsconfig paths: %v, %v", tsPaths, err)
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// the max number of the nodes of the `+types-graph` response
+const maxTypesGraphNodes = 2000
+
+type typesGraphNode struct {
+	Path  string `json:"path"`
+	Bytes int    `json:"bytes"`
+}
+
+type typesGraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type typesGraph struct {
+	Nodes     []typesGraphNode `json:"nodes"`
+	Edges     []typesGraphEdge `json:"edges"`
+	Truncated bool             `json:"truncated,omitempty"`
+}
+
+// serveTypesGraph serves the dependency graph of the declaration files of the package,
+// the graph is cached in the fs at `builds/v{VERSION}/<pkg>@<version>.types-graph.json`.
+func serveTypesGraph(ctx *rex.Context, pkg *Pkg) interface{} {
+	savePath := path.Join("builds", fmt.Sprintf("v%d/%s@%s.types-graph.json", VERSION, pkg.Name, pkg.Version))
+	exists, modtime, err := fs.Exists(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if !exists {
+		graph, err := buildTypesGraph(pkg)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		if graph == nil {
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Types not found"})
+		}
+		err = fs.WriteData(savePath, utils.MustEncodeJSON(graph))
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		modtime = time.Now()
+	}
+	r, err := fs.ReadFile(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	return rex.Content(savePath, modtime, r)
+}
+
+// buildTypesGraph installs the package and walks the declaration files from the types
+// entry of the package (or the `@types/<pkg>` package). It returns nil if no types found.
+func buildTypesGraph(pkg *Pkg) (*typesGraph, error) {
+	wd := tempDir(fmt.Sprintf("esm-types-graph-%s", rs.Hex.String(16)))
+	err := ensureDir(wd)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(wd)
+
+	err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version), 3, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := installedTypesPackage(wd, pkg.Name)
+	if !ok {
+		if strings.HasPrefix(pkg.Name, "@types/") {
+			return nil, nil
+		}
+		typesPkgName := toTypesPackageName(pkg.Name)
+		if pkgManagerAdd(packageManager, wd, typesPkgName) != nil {
+			return nil, nil
+		}
+		p, ok = installedTypesPackage(wd, typesPkgName)
+		if !ok {
+			return nil, nil
+		}
+	}
+
+	entry := toTypesPath(wd, p, "", true)
+	if !isDtsFile(entry) || strings.HasSuffix(entry, "~.d.ts") {
+		return nil, nil
+	}
+	return walkTypesGraph(wd, entry), nil
+}
+
+// walkTypesGraph walks the declaration files like `react@17.0.2/index.d.ts` in the
+// `node_modules` of the wd by the breadth-first order, the walking stops when the
+// number of the nodes exceeds `maxTypesGraphNodes`.
+func walkTypesGraph(wd string, entry string) *typesGraph {
+	graph := &typesGraph{Nodes: []typesGraphNode{}, Edges: []typesGraphEdge{}}
+	visited := newStringSet()
+	queue := []string{entry}
+	for len(queue) > 0 {
+		dts := queue[0]
+		queue = queue[1:]
+		if visited.Has(dts) {
+			continue
+		}
+		if len(graph.Nodes) >= maxTypesGraphNodes {
+			graph.Truncated = true
+			break
+		}
+		visited.Add(dts)
+
+		data, err := ioutil.ReadFile(path.Join(wd, "node_modules", regFullVersionPath.ReplaceAllString(dts, "$1/")))
+		if err != nil {
+			log.Warnf("types graph(%s): %v", dts, err)
+			continue
+		}
+		graph.Nodes = append(graph.Nodes, typesGraphNode{Path: dts, Bytes: len(data)})
+		for _, dep := range resolveTypesGraphDeps(wd, dts, data) {
+			graph.Edges = append(graph.Edges, typesGraphEdge{From: dts, To: dep})
+			queue = append(queue, dep)
+		}
+	}
+	return graph
+}
+
+// resolveTypesGraphDeps resolves the imports and the triple-slash references of the
+// declaration file to the installed declaration files, the unresolvable ones and the
+// modules declared in the file are ignored.
+func resolveTypesGraphDeps(wd string, dts string, data []byte) []string {
+	dtsDir := path.Join(wd, "node_modules", regFullVersionPath.ReplaceAllString(path.Dir(dts)+"/", "$1/"))
+	declareModules := newStringSet()
+	imports := [][2]string{}
+	walkDts(bytes.NewReader(data), bytes.NewBuffer(nil), func(importPath string, kind string, position int) string {
+		if kind == "declare module" {
+			declareModules.Add(importPath)
+		} else {
+			imports = append(imports, [2]string{importPath, kind})
+		}
+		return importPath
+	})
+
+	deps := newStringSet()
+	for _, item := range imports {
+		importPath, kind := item[0], item[1]
+		if declareModules.Has(importPath) {
+			continue
+		}
+		if strings.HasPrefix(kind, "reference ") {
+			isPath := kind == "reference path"
+			refWd := wd
+			if isPath {
+				refWd = dtsDir
+			}
+			ref, err := resolveTripleSlashReference(refWd, importPath, isPath)
+			if err != nil {
+				continue
+			}
+			importPath = ref
+		}
+
+		if isLocalImport(importPath) {
+			if importPath == "." || importPath == ".." {
+				importPath += "/index.d.ts"
+			}
+			if ext := path.Ext(importPath); ext == ".js" || ext == ".mjs" || ext == ".cjs" {
+				importPath = strings.TrimSuffix(importPath, ext)
+			}
+			if !isDtsFile(importPath) {
+				dtsFile, ok := findDtsFile(dtsDir, importPath, dtsExtname(dts))
+				if !ok {
+					continue
+				}
+				importPath = dtsFile
+			}
+			if fileExists(path.Join(dtsDir, importPath)) {
+				deps.Add(path.Join(path.Dir(dts), importPath))
+			}
+			continue
+		}
+
+		name, subpath := splitPkgPath(strings.TrimPrefix(importPath, "node:"))
+		if _, ok := builtInNodeModules[name]; ok || strings.HasPrefix(importPath, "node:") || name == "node" {
+			name, subpath = "@types/node", strings.TrimPrefix(importPath, "node:")
+			if subpath == "node" {
+				subpath = ""
+			}
+		}
+		p, ok := installedTypesPackage(wd, name)
+		if !ok {
+			p, ok = installedTypesPackage(wd, toTypesPackageName(name))
+		}
+		if !ok {
+			continue
+		}
+		if dep := toTypesPath(wd, p, subpath, true); isDtsFile(dep) && !strings.HasSuffix(dep, "~.d.ts") {
+			deps.Add(dep)
+		}
+	}
+	values := deps.Values()
+	sort.Strings(values)
+	return values
+}
+
+// installedTypesPackage returns the package.json of the installed package if the
+// package has types, the `index.d.ts` in the package root is used as the types
+// if the package.json doesn't specify it.
+func installedTypesPackage(wd string, name string) (p NpmPackage, ok bool) {
+	packageFile, err := findPackageJSON(wd, name)
+	if err != nil {
+		return
+	}
+	if utils.ParseJSONFile(packageFile, &p) != nil {
+		return
+	}
+	p = *fixNpmPackage(p, path.Dir(packageFile))
+	if p.Types == "" && p.Typings == "" {
+		if !fileExists(path.Join(path.Dir(packageFile), "index.d.ts")) {
+			return
+		}
+		p.Types = "index.d.ts"
+	}
+	return p, true
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestWalkTypesGraph(t *testing.T) {
+	wd := t.TempDir()
+	for name, content := range map[string]string{
+		"foo/package.json":        `{"name":"foo","version":"1.0.0","types":"index.d.ts"}`,
+		"foo/index.d.ts":          "/// <reference types=\"baz\" />\nimport { Bar } from \"bar\";\nexport * from \"./lib\";\ndeclare module \"virtual\" {}\nimport \"virtual\";\n",
+		"foo/lib/index.d.ts":      "export * from \"../index.js\";\nexport declare const a: string;\n",
+		"bar/package.json":        `{"name":"bar","version":"2.0.0"}`,
+		"bar/index.d.ts":          "export declare class Bar {}\n",
+		"@types/baz/package.json": `{"name":"@types/baz","version":"3.0.0","types":"index.d.ts"}`,
+		"@types/baz/index.d.ts":   "declare const baz: string;\n",
+	} {
+		filename := path.Join(wd, "node_modules", name)
+		ensureDir(path.Dir(filename))
+		ioutil.WriteFile(filename, []byte(content), 0644)
+	}
+
+	graph := walkTypesGraph(wd, "foo@1.0.0/index.d.ts")
+	if len(graph.Nodes) != 4 || graph.Truncated {
+		t.Fatalf("unexpected nodes: %v", graph.Nodes)
+	}
+	if graph.Nodes[0].Path != "foo@1.0.0/index.d.ts" || graph.Nodes[0].Bytes == 0 {
+		t.Fatalf("unexpected entry node: %v", graph.Nodes[0])
+	}
+	edges := map[typesGraphEdge]bool{}
+	for _, e := range graph.Edges {
+		edges[e] = true
+	}
+	for _, e := range []typesGraphEdge{
+		{"foo@1.0.0/index.d.ts", "@types/baz@3.0.0/index.d.ts"},
+		{"foo@1.0.0/index.d.ts", "bar@2.0.0/index.d.ts"},
+		{"foo@1.0.0/index.d.ts", "foo@1.0.0/lib/index.d.ts"},
+		{"foo@1.0.0/lib/index.d.ts", "foo@1.0.0/index.d.ts"},
+	} {
+		if !edges[e] {
+			t.Fatalf("missing edge %v in %v", e, graph.Edges)
+		}
+	}
+	if len(graph.Edges) != 4 {
+		t.Fatalf("unexpected edges: %v", graph.Edges)
+	}
+}
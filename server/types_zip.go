@@ -0,0 +1,134 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/crypto/rs"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// serveTypesZip serves all the declaration files of the package as a ZIP archive,
+// the archive is cached in the fs at `builds/v{VERSION}/<pkg>@<version>.types.zip`.
+func serveTypesZip(ctx *rex.Context, pkg *Pkg) interface{} {
+	savePath := path.Join("builds", fmt.Sprintf("v%d/%s@%s.types.zip", VERSION, pkg.Name, pkg.Version))
+	exists, modtime, err := fs.Exists(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	if !exists {
+		data, err := buildTypesZip(pkg)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		if data == nil {
+			return throwAPIError(ctx, 404, APIError{Code: errCodeNotFound, Message: "Types not found"})
+		}
+		err = fs.WriteData(savePath, data)
+		if err != nil {
+			return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+		}
+		modtime = time.Now()
+	}
+	r, err := fs.ReadFile(savePath)
+	if err != nil {
+		return throwAPIError(ctx, 500, APIError{Code: errCodeInternal, Message: err.Error()})
+	}
+	ctx.SetHeader("Content-Type", "application/zip")
+	ctx.SetHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="%s@%s.types.zip"`, strings.ReplaceAll(pkg.Name, "/", "__"), pkg.Version))
+	ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	return rex.Content(savePath, modtime, r)
+}
+
+// buildTypesZip creates a ZIP archive of the declaration files under the type root
+// of the package, the `@types/<pkg>` package is used if the package has no types.
+// The files keep the layout of the type root so the relative import paths between
+// them still work within the archive. It returns nil if no types found.
+func buildTypesZip(pkg *Pkg) ([]byte, error) {
+	wd := tempDir(fmt.Sprintf("esm-types-%s", rs.Hex.String(16)))
+	err := ensureDir(wd)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(wd)
+
+	err = retryYarnAdd(wd, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version), 3, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	packageFile, err := findPackageJSON(wd, pkg.Name)
+	if err != nil {
+		return nil, err
+	}
+	var p NpmPackage
+	err = utils.ParseJSONFile(packageFile, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Types == "" && p.Typings == "" && !fileExists(path.Join(path.Dir(packageFile), "index.d.ts")) {
+		if strings.HasPrefix(pkg.Name, "@types/") {
+			return nil, nil
+		}
+		typesPkgName := toTypesPackageName(pkg.Name)
+		if pkgManagerAdd(packageManager, wd, typesPkgName) != nil {
+			return nil, nil
+		}
+		packageFile, err = findPackageJSON(wd, typesPkgName)
+		if err != nil {
+			return nil, nil
+		}
+	}
+
+	typeRoot := path.Dir(packageFile)
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	count := 0
+	err = filepath.Walk(typeRoot, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := strings.TrimPrefix(filename, typeRoot+"/")
+		if !isDtsFile(name) && name != "package.json" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		if err == nil && name != "package.json" {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"crypto/sha1"
 	"encoding/base64"
+	"encoding/hex"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/ije/gox/utils"
 	"github.com/ije/gox/valid"
@@ -20,8 +25,45 @@ var (
 	regBuildVersionPath = regexp.MustCompile(`^/v\d+/`)
 	regLocPath      = regexp.MustCompile(`(\.[a-z]+):\d+:\d+$`)
 	npmNaming           = valid.Validator{valid.FromTo{'a', 'z'}, valid.FromTo{'0', '9'}, valid.Eq('.'), valid.Eq('_'), valid.Eq('-')}
+	npmVersioning       = valid.Validator{valid.FromTo{'a', 'z'}, valid.FromTo{'A', 'Z'}, valid.FromTo{'0', '9'}, valid.Eq('.'), valid.Eq('_'), valid.Eq('+'), valid.Eq('~'), valid.Eq('^'), valid.Eq('-')}
 )
 
+// singleFlight deduplicates the concurrent calls with the same key, the later
+// calls wait for the first call and share its result, like `x/sync/singleflight`.
+type singleFlight struct {
+	lock  sync.Mutex
+	calls map[string]*singleFlightCall
+}
+
+type singleFlightCall struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+func (g *singleFlight) Do(key string, fn func() error) (err error, shared bool) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*singleFlightCall{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		c.wg.Wait()
+		return c.err, true
+	}
+	c := &singleFlightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.lock.Unlock()
+
+	c.err = fn()
+	c.wg.Done()
+
+	g.lock.Lock()
+	delete(g.calls, key)
+	g.lock.Unlock()
+	return c.err, false
+}
+
 type stringSet struct {
 	lock sync.RWMutex
 	m    map[string]struct{}
@@ -97,17 +139,64 @@ func (s versionSlice) Less(i, j int) bool {
 	return a0 > b0
 }
 
+// jsReservedWords are the reserved words of JavaScript that can't be used as identifiers
+var jsReservedWords = map[string]bool{
+	"arguments": true, "await": true, "break": true, "case": true, "catch": true,
+	"class": true, "const": true, "continue": true, "debugger": true, "default": true,
+	"delete": true, "do": true, "else": true, "enum": true, "eval": true,
+	"export": true, "extends": true, "false": true, "finally": true, "for": true,
+	"function": true, "if": true, "implements": true, "import": true, "in": true,
+	"instanceof": true, "interface": true, "let": true, "new": true, "null": true,
+	"package": true, "private": true, "protected": true, "public": true, "return": true,
+	"static": true, "super": true, "switch": true, "this": true, "throw": true,
+	"true": true, "try": true, "typeof": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true,
+}
+
+// identify converts the import path to a valid JS identifier, e.g. `@scope/name` ->
+// `scope__name`, `some.pkg` -> `some_pkg` and `react/jsx-runtime` -> `react__jsx_runtime`.
+// The identifier is prefixed with `_` if it starts with a digit or it's a reserved word.
 func identify(importPath string) string {
-	p := []byte(importPath)
-	for i, c := range p {
-		switch c {
-		case '/', '-', '@', '.':
-			p[i] = '_'
+	buf := strings.Builder{}
+	for _, c := range strings.TrimPrefix(importPath, "@") {
+		switch {
+		case c == '/':
+			buf.WriteString("__")
+		case c == '_' || c == '$' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			buf.WriteRune(c)
 		default:
-			p[i] = c
+			buf.WriteByte('_')
 		}
 	}
-	return string(p)
+	identifier := buf.String()
+	if c, _ := utf8.DecodeRuneInString(identifier); identifier == "" || unicode.IsDigit(c) || jsReservedWords[identifier] {
+		identifier = "_" + identifier
+	}
+	return identifier
+}
+
+// identifyUnique converts the import path to an identifier like `identify`, a
+// suffix of the name hash is added if the identifier is already used by another
+// import path, e.g. `a-b` and `a.b` are both identified as `a_b`.
+func identifyUnique(importPath string, used map[string]string) string {
+	identifier := identify(importPath)
+	if name, ok := used[identifier]; ok && name != importPath {
+		hasher := sha1.New()
+		hasher.Write([]byte(importPath))
+		identifier += "_" + hex.EncodeToString(hasher.Sum(nil))[:8]
+	}
+	used[identifier] = importPath
+	return identifier
+}
+
+// splitPkgPath splits the import path like `@babel/core/lib/index` to the package
+// name and the submodule.
+func splitPkgPath(importPath string) (pkgName string, submodule string) {
+	a := strings.Split(importPath, "/")
+	if strings.HasPrefix(importPath, "@") && len(a) > 1 {
+		return strings.Join(a[:2], "/"), strings.Join(a[2:], "/")
+	}
+	return a[0], strings.Join(a[1:], "/")
 }
 
 func isRemoteImport(importPath string) bool {
@@ -154,6 +243,12 @@ func fileExists(filepath string) bool {
 	return err == nil && !fi.IsDir()
 }
 
+// tempDir returns the path of the named dir in the temp dir, the path is slash
+// separated on windows as well since the build paths are joined by `path.Join`.
+func tempDir(name string) string {
+	return path.Join(filepath.ToSlash(os.TempDir()), name)
+}
+
 func ensureDir(dir string) (err error) {
 	_, err = os.Stat(dir)
 	if err != nil && os.IsNotExist(err) {
@@ -162,15 +257,14 @@ func ensureDir(dir string) (err error) {
 	return
 }
 
+// btoaUrl encodes the UTF-8 bytes of the string with the unpadded base64-URL encoding.
 func btoaUrl(s string) string {
-	return strings.TrimRight(base64.URLEncoding.EncodeToString([]byte(s)), "=")
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
 }
 
+// atobUrl decodes the string encoded by `btoaUrl`, the padded form is accepted as well.
 func atobUrl(s string) (string, error) {
-	if l := len(s) % 4; l > 0 {
-		s += strings.Repeat("=", 4-l)
-	}
-	data, err := base64.URLEncoding.DecodeString(s)
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
 	if err != nil {
 		return "", err
 	}
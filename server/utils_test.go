@@ -0,0 +1,132 @@
+package server
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBtoaUrl(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"react:preact/compat",
+		"别名:包/子模块",
+		"🦕:🐢@1.0.0",
+		"a:b,c:d?e=f&g=h",
+	} {
+		encoded := btoaUrl(s)
+		if strings.ContainsAny(encoded, "+/=") {
+			t.Fatalf("btoaUrl(%q) is not URL safe: %s", s, encoded)
+		}
+		decoded, err := atobUrl(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != s {
+			t.Fatalf("atobUrl(btoaUrl(%q)) should be %q, but got %q", s, s, decoded)
+		}
+	}
+
+	// padded input
+	decoded, err := atobUrl("8J-mlQ==")
+	if err != nil || decoded != "🦕" {
+		t.Fatalf("atobUrl(8J-mlQ==) should be 🦕, but got %q (%v)", decoded, err)
+	}
+
+	task := &BuildTask{
+		Alias: map[string]string{"反应": "🦕/compat"},
+	}
+	alias, _, err := parseResolvePrefix(task.resolvePrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias["反应"] != "🦕/compat" {
+		t.Fatalf("alias mismatch: %v", alias)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	for _, c := range []struct {
+		importPath string
+		identifier string
+	}{
+		{"react", "react"},
+		{"react-dom", "react_dom"},
+		{"@scope/name", "scope__name"},
+		{"some.pkg", "some_pkg"},
+		{"react/jsx-runtime", "react__jsx_runtime"},
+		{"@babel/core/lib/index.js", "babel__core__lib__index_js"},
+		{"3d-view", "_3d_view"},
+		{"class", "_class"},
+		{"import", "_import"},
+		{"$jquery", "$jquery"},
+		{"反应", "反应"},
+		{"a~b!c", "a_b_c"},
+	} {
+		if identifier := identify(c.importPath); identifier != c.identifier {
+			t.Fatalf("identify(%q) should be %q, but got %q", c.importPath, c.identifier, identifier)
+		}
+	}
+}
+
+func TestIdentifyUnique(t *testing.T) {
+	used := map[string]string{}
+	a := identifyUnique("@mui/icons-material", used)
+	b := identifyUnique("@material-ui/icons", used)
+	if a == b {
+		t.Fatalf("identifiers of '@mui/icons-material' and '@material-ui/icons' should be different: %s", a)
+	}
+	if a != "mui__icons_material" || b != "material_ui__icons" {
+		t.Fatalf("unexpected identifiers: %s, %s", a, b)
+	}
+
+	c := identifyUnique("@mui/icons.material", used)
+	if c == a || !strings.HasPrefix(c, "mui__icons_material_") {
+		t.Fatalf("unexpected identifier of '@mui/icons.material': %s", c)
+	}
+	// deterministic
+	if identifyUnique("@mui/icons-material", used) != a || identifyUnique("@mui/icons.material", used) != c {
+		t.Fatal("identifyUnique should be deterministic")
+	}
+}
+
+func TestSingleFlight(t *testing.T) {
+	var g singleFlight
+	var calls int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+	results := make(chan bool, 3)
+
+	go func() {
+		_, shared := g.Do("key", func() error {
+			atomic.AddInt32(&calls, 1)
+			close(started)
+			<-release
+			return nil
+		})
+		results <- shared
+	}()
+	<-started
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, shared := g.Do("key", func() error {
+				atomic.AddInt32(&calls, 1)
+				return nil
+			})
+			results <- shared
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	sharedN := 0
+	for i := 0; i < 3; i++ {
+		if <-results {
+			sharedN++
+		}
+	}
+	if calls != 1 || sharedN != 2 {
+		t.Fatalf("should call once and share twice, but called %d times and shared %d times", calls, sharedN)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+// versionRedirectURL returns the URL of the exact version for the `redirect` query
+// like `/v58/react@latest?redirect` -> `/v58/react@18.2.0`, the `redirect` query can
+// be `latest` (the default, follows the version tag or range in the URL), `major`
+// (the latest patch of the current major) or `minor` (the latest patch of the current
+// minor). It returns an empty string if the URL is already of the exact version.
+func versionRedirectURL(pathname string, rawQuery string, buildVerPrefix string, pkg *Pkg, mode string) (string, error) {
+	name, version, submodule, err := splitPkgSpecifier(pathname)
+	if err != nil {
+		return "", err
+	}
+	exactVersion, err := redirectVersion(pkg, mode)
+	if err != nil {
+		return "", err
+	}
+	if version == exactVersion {
+		return "", nil
+	}
+
+	url := fmt.Sprintf("%s/%s@%s", buildVerPrefix, name, exactVersion)
+	if submodule != "" {
+		url += "/" + submodule
+	}
+	if query := trimQuery(rawQuery, "redirect"); query != "" {
+		url += "?" + query
+	}
+	return url, nil
+}
+
+// redirectVersion resolves the exact version of the package for the `redirect` query
+func redirectVersion(pkg *Pkg, mode string) (string, error) {
+	if mode == "" || mode == "latest" {
+		return pkg.Version, nil
+	}
+	rng, err := redirectVersionRange(pkg.Version, mode)
+	if err != nil {
+		return "", err
+	}
+	info, _, _, err := getPackageInfo("", pkg.Name, rng)
+	if err != nil {
+		return "", err
+	}
+	return info.Version, nil
+}
+
+// redirectVersionRange returns the version prefix like `18` (major) or `18.2` (minor)
+// of the version that is resolved to the latest patch by `getPackageInfo`.
+func redirectVersionRange(version string, mode string) (string, error) {
+	a := strings.SplitN(version, ".", 3)
+	if len(a) != 3 {
+		return "", fmt.Errorf("invalid version '%s'", version)
+	}
+	switch mode {
+	case "major":
+		return a[0], nil
+	case "minor":
+		return a[0] + "." + a[1], nil
+	}
+	return "", fmt.Errorf("invalid redirect mode '%s'", mode)
+}
+
+// trimQuery removes the key from the raw query, the other parameters are kept
+// as they are since the flags like `?bundle` have no value.
+func trimQuery(rawQuery string, key string) string {
+	var params []string
+	for _, p := range strings.Split(rawQuery, "&") {
+		k, _ := utils.SplitByFirstByte(p, '=')
+		if p != "" && k != key {
+			params = append(params, p)
+		}
+	}
+	return strings.Join(params, "&")
+}
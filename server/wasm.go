@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+)
+
+// the wasm files smaller than the threshold are embedded in the module with the
+// `?wasm-streaming` query, which saves a request
+const wasmStreamingThreshold = 4 * 1024
+
+// loadWasm generates a module that loads the wasm file. With the `?wasm-instantiate`
+// query the module instantiates the wasm file by the `WebAssembly.instantiate` API,
+// otherwise it compiles the wasm file by the `WebAssembly.compile` API. For the `node`
+// target the wasm file is stored as a separate asset alongside the build and read by
+// `fs.readFileSync`, since the ES module has no `__dirname` the file path is resolved
+// by `import.meta.url`. With the `?wasm-streaming` query the wasm file is stored as
+// well, and fetched by the `WebAssembly.instantiateStreaming` (or `compileStreaming`)
+// API. The default export is the promise of the result instead of being awaited, since
+// the top-level await is an error for the targets older than es2022. It returns the
+// code and the path of the stored wasm file if any.
+func loadWasm(task *BuildTask, filename string) (code string, wasmFile string, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	fn := "compile"
+	imports := ""
+	if task.WasmInstantiate {
+		fn = "instantiate"
+		imports = ", {}"
+	}
+
+	if task.Target == "node" || (task.WasmStreaming && len(data) >= wasmStreamingThreshold) {
+		hasher := sha1.New()
+		hasher.Write(data)
+		wasmFile = path.Join(path.Dir(task.ID()), hex.EncodeToString(hasher.Sum(nil))[:16]+".wasm")
+		err = task.writeFile(path.Join("builds", wasmFile), data)
+		if err != nil {
+			return
+		}
+		if task.Target == "node" {
+			code = fmt.Sprintf(
+				`import { readFileSync } from "fs";%sexport default WebAssembly.%s(readFileSync(new URL("./%s", import.meta.url))%s);`,
+				"\n",
+				fn,
+				path.Base(wasmFile),
+				imports,
+			)
+		} else {
+			code = fmt.Sprintf(
+				`export default WebAssembly.%sStreaming(fetch(new URL("./%s", import.meta.url))%s);`,
+				fn,
+				path.Base(wasmFile),
+				imports,
+			)
+		}
+		return
+	}
+
+	buf := bytes.NewBufferString(fmt.Sprintf("export default WebAssembly.%s(new Uint8Array([", fn))
+	for i, b := range data {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Itoa(int(b)))
+	}
+	buf.WriteString("])" + imports + ");")
+	code = buf.String()
+	return
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+var regAbsoluteImportPath = regexp.MustCompile(`("|')(/v\d+/|/error\.js)`)
+
+const workerWrapperTpl = `/* esm.sh - worker */
+const code = %s;
+export default function createWorker() {
+  return new Worker(URL.createObjectURL(new Blob([code], { type: "text/javascript" })), { type: "module" });
+}
+`
+
+// wrapWorker wraps the module code in a factory function that creates a web worker
+// from a blob URL, the absolute import paths are prefixed with the origin since
+// blob URLs can't resolve them.
+func wrapWorker(code []byte, origin string) []byte {
+	code = regAbsoluteImportPath.ReplaceAll(code, []byte("${1}"+strings.TrimSuffix(origin, "/")+"${2}"))
+	codeStrLit := strings.TrimSpace(string(utils.MustEncodeJSON(string(code))))
+	return []byte(fmt.Sprintf(workerWrapperTpl, codeStrLit))
+}
+
+const moduleWorkerScaffold = `
+/* esm.sh - module worker */
+if (typeof WorkerGlobalScope !== "undefined" && self instanceof WorkerGlobalScope) {
+  self.onmessage = async (e) => {
+    const { default: fn } = await import(import.meta.url);
+    self.postMessage(await fn(e.data));
+  };
+}
+`
+
+// appendModuleWorkerScaffold appends a message handler to the module that invokes
+// the default export with the message data and posts the result back, then the
+// module can be used by `new Worker(url, { type: "module" })`. The handler is only
+// installed in the worker scope, so importing the module in the main thread doesn't
+// override the `onmessage` of the window.
+func appendModuleWorkerScaffold(code []byte) []byte {
+	return append(code, moduleWorkerScaffold...)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ije/esbuild-internal/js_ast"
+	"github.com/ije/esbuild-internal/js_parser"
+	"github.com/ije/esbuild-internal/logger"
+	"github.com/ije/esbuild-internal/test"
+)
+
+func TestWrapWorker(t *testing.T) {
+	code := `import{a as b}from"/v58/a@1.0.0/es2021/a.js";self.onmessage=e=>self.postMessage(b(e.data));`
+	wrapped := string(wrapWorker([]byte(code), "https://esm.sh/"))
+
+	ast, pass := js_parser.Parse(logger.NewDeferLog(logger.DeferLogNoVerboseOrDebug), test.SourceForTest(wrapped), js_parser.Options{})
+	if !pass {
+		t.Fatalf("invalid wrapper:\n%s", wrapped)
+	}
+	if ast.ExportsKind != js_ast.ExportsESM {
+		t.Fatal("wrapper should be an es module")
+	}
+	if _, ok := ast.NamedExports["default"]; !ok {
+		t.Fatal("missing default export")
+	}
+	if !strings.Contains(wrapped, "export default function createWorker()") {
+		t.Fatal("missing createWorker factory")
+	}
+
+	var inner string
+	for _, line := range strings.Split(wrapped, "\n") {
+		if strings.HasPrefix(line, "const code = ") {
+			err := json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(line, "const code = "), ";")), &inner)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !strings.Contains(inner, `from"https://esm.sh/v58/a@1.0.0/es2021/a.js"`) {
+		t.Fatalf("absolute import path should be prefixed with the origin: %s", inner)
+	}
+	_, pass = js_parser.Parse(logger.NewDeferLog(logger.DeferLogNoVerboseOrDebug), test.SourceForTest(inner), js_parser.Options{})
+	if !pass {
+		t.Fatalf("invalid worker code: %s", inner)
+	}
+}
+
+func TestAppendModuleWorkerScaffold(t *testing.T) {
+	code := `var a=e=>e*2;export{a as default};`
+	output := string(appendModuleWorkerScaffold([]byte(code)))
+
+	ast, pass := js_parser.Parse(logger.NewDeferLog(logger.DeferLogNoVerboseOrDebug), test.SourceForTest(output), js_parser.Options{})
+	if !pass {
+		t.Fatalf("invalid module worker:\n%s", output)
+	}
+	if _, ok := ast.NamedExports["default"]; !ok {
+		t.Fatal("missing default export")
+	}
+	if !strings.HasPrefix(output, code) || !strings.Contains(output, "if (typeof WorkerGlobalScope !== \"undefined\" && self instanceof WorkerGlobalScope) {\n  self.onmessage = async (e) => {") {
+		t.Fatalf("missing self.onmessage boilerplate:\n%s", output)
+	}
+	if !strings.Contains(output, "self.postMessage(await fn(e.data));") {
+		t.Fatalf("missing postMessage:\n%s", output)
+	}
+}
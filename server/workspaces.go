@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"esm.sh/pkg/esmmeta"
+	"github.com/ije/gox/utils"
+)
+
+// NpmWorkspaces defines the `workspaces` field of package.json
+type NpmWorkspaces = esmmeta.NpmWorkspaces
+
+// resolveWorkspaceDep returns the local path of the workspace package that is
+// matched by the workspace globs of the monorepo in wd.
+func resolveWorkspaceDep(wd string, workspaceGlobs []string, depName string) (string, error) {
+	for _, glob := range workspaceGlobs {
+		// the negated patterns like `!packages/internal` are used to exclude packages
+		if strings.HasPrefix(glob, "!") {
+			continue
+		}
+		matches, err := filepath.Glob(path.Join(wd, glob))
+		if err != nil {
+			return "", err
+		}
+		for _, dir := range matches {
+			packageFile := path.Join(dir, "package.json")
+			if !fileExists(packageFile) {
+				continue
+			}
+			var p NpmPackage
+			if utils.ParseJSONFile(packageFile, &p) == nil && p.Name == depName {
+				return dir, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("workspace package %s not found", depName)
+}
@@ -0,0 +1,12 @@
+import { assert, assertEquals } from 'https://deno.land/std@0.106.0/testing/asserts.ts'
+
+Deno.test('check the dry-run build', async () => {
+	const res = await fetch('http://localhost:8080/react@17.0.2?target=es2021&dry-run')
+	assertEquals(res.status, 200)
+	assertEquals(res.headers.get('Cache-Control'), 'private, no-store, no-cache, must-revalidate')
+	const ret = await res.json()
+	assertEquals(ret.dryRun, true)
+	assertEquals(ret.name, 'react')
+	assertEquals(ret.version, '17.0.2')
+	assert(ret.exportDefault)
+})